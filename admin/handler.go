@@ -0,0 +1,343 @@
+// Package admin exposes authenticated HTTP endpoints for managing the shop catalog.
+//
+// Routes are relative to wherever the Handler is mounted (use http.StripPrefix when mounting under a prefix):
+//
+// - GET /items/deleted lists soft-deleted items, newest first, paginated with "per_page" and the "before" and "after"
+// cursors returned in mop_shop.PaginationResponse like the storefront listing
+//
+// - POST /items creates an item from mop_shop.ShopItemCreate
+//
+// - GET /items/{id} returns an item unless it's soft-deleted
+//
+// - PUT /items/{id} updates an item from mop_shop.ShopItemUpdate
+//
+// - PATCH /items/{id} changes only the fields given in mop_shop.ShopItemPatch
+//
+// - DELETE /items/{id} soft-deletes an item
+//
+// - POST /items/{id}/restore restores a soft-deleted item
+//
+// - POST /items/{id}/images uploads an image sent as multipart form field "file", with optional "alt_text", and
+// appends it to the item's images. It needs a mop_shop.BlobStore configured on the shop.
+//
+// Responses with a single item carry its version as ETag. Sending it back in If-Match makes PUT and PATCH fail with
+// 412 Precondition Failed when the item changed in the meantime. If-Match compares strongly, so weak tags never
+// match and fail the same way.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/croatiangrn/mop-shop"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Authenticator reports whether the request was made by an authenticated admin.
+type Authenticator func(req *http.Request) bool
+
+type Handler struct {
+	shop         *mop_shop.Shop
+	authenticate Authenticator
+	now          func() time.Time
+}
+
+func NewHandler(shop *mop_shop.Shop, authenticate Authenticator) *Handler {
+	return &Handler{shop: shop, authenticate: authenticate, now: time.Now}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h.authenticate == nil || !h.authenticate(req) {
+		h.writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+		return
+	}
+
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if segments[0] != "items" {
+		h.writeError(w, http.StatusNotFound, ErrRouteNotFound)
+		return
+	}
+
+	switch {
+	case len(segments) == 1:
+		h.route(w, req, map[string]http.HandlerFunc{http.MethodPost: h.createItem})
+	case len(segments) == 2 && segments[1] == "deleted":
+		h.route(w, req, map[string]http.HandlerFunc{http.MethodGet: h.listDeletedItems})
+	case len(segments) == 2:
+		h.routeWithID(w, req, segments[1], map[string]itemHandlerFunc{
+			http.MethodGet:    h.getItem,
+			http.MethodPut:    h.updateItem,
+			http.MethodPatch:  h.patchItem,
+			http.MethodDelete: h.deleteItem,
+		})
+	case len(segments) == 3 && segments[2] == "restore":
+		h.routeWithID(w, req, segments[1], map[string]itemHandlerFunc{http.MethodPost: h.restoreItem})
+	case len(segments) == 3 && segments[2] == "images":
+		h.routeWithID(w, req, segments[1], map[string]itemHandlerFunc{http.MethodPost: h.uploadImage})
+	default:
+		h.writeError(w, http.StatusNotFound, ErrRouteNotFound)
+	}
+}
+
+// maxUploadRequestSize is the largest multipart request accepted for image uploads
+const maxUploadRequestSize = 32 << 20
+
+type itemHandlerFunc func(w http.ResponseWriter, req *http.Request, shopItemID int)
+
+func (h *Handler) route(w http.ResponseWriter, req *http.Request, handlers map[string]http.HandlerFunc) {
+	handler, ok := handlers[req.Method]
+	if !ok {
+		h.writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+		return
+	}
+
+	handler(w, req)
+}
+
+func (h *Handler) routeWithID(w http.ResponseWriter, req *http.Request, rawID string, handlers map[string]itemHandlerFunc) {
+	handler, ok := handlers[req.Method]
+	if !ok {
+		h.writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+		return
+	}
+
+	shopItemID, err := strconv.Atoi(rawID)
+	if err != nil || shopItemID <= 0 {
+		h.writeError(w, http.StatusBadRequest, mop_shop.ErrInvalidItemID)
+		return
+	}
+
+	handler(w, req, shopItemID)
+}
+
+func (h *Handler) createItem(w http.ResponseWriter, req *http.Request) {
+	data := mop_shop.NewShopItemCreate()
+	if err := json.NewDecoder(req.Body).Decode(data); err != nil {
+		h.writeError(w, http.StatusBadRequest, ErrInvalidRequestBody)
+		return
+	}
+
+	item := h.shop.NewShopItem()
+	if err := item.CreateContext(req.Context(), data, h.now()); err != nil {
+		h.writeServiceError(w, req, err)
+		return
+	}
+
+	h.writeItem(w, http.StatusCreated, item)
+}
+
+func (h *Handler) getItem(w http.ResponseWriter, req *http.Request, shopItemID int) {
+	item := h.shop.NewShopItem()
+	if err := item.FindOneByIDContext(req.Context(), shopItemID); err != nil {
+		h.writeServiceError(w, req, err)
+		return
+	}
+
+	h.writeItem(w, http.StatusOK, item)
+}
+
+func (h *Handler) updateItem(w http.ResponseWriter, req *http.Request, shopItemID int) {
+	item := h.shop.NewShopItem()
+	if err := item.FindOneByIDContext(req.Context(), shopItemID); err != nil {
+		h.writeServiceError(w, req, err)
+		return
+	}
+
+	data := mop_shop.NewShopItemUpdate(item.StripeProductApiID)
+	if err := json.NewDecoder(req.Body).Decode(data); err != nil {
+		h.writeError(w, http.StatusBadRequest, ErrInvalidRequestBody)
+		return
+	}
+
+	if version, ok, err := ifMatchVersion(req); err != nil {
+		h.writeIfMatchError(w, err)
+		return
+	} else if ok {
+		item.Version = version
+	}
+
+	item.UpdatedAt = h.now()
+	if err := item.UpdateContext(req.Context(), data); err != nil {
+		h.writeServiceError(w, req, err)
+		return
+	}
+
+	h.writeItem(w, http.StatusOK, item)
+}
+
+func (h *Handler) patchItem(w http.ResponseWriter, req *http.Request, shopItemID int) {
+	data := mop_shop.NewShopItemPatch()
+	if err := json.NewDecoder(req.Body).Decode(data); err != nil {
+		h.writeError(w, http.StatusBadRequest, ErrInvalidRequestBody)
+		return
+	}
+
+	if version, ok, err := ifMatchVersion(req); err != nil {
+		h.writeIfMatchError(w, err)
+		return
+	} else if ok {
+		data.Version = &version
+	}
+
+	item := h.shop.NewShopItem()
+	if err := item.PatchContext(req.Context(), shopItemID, data, h.now()); err != nil {
+		h.writeServiceError(w, req, err)
+		return
+	}
+
+	h.writeItem(w, http.StatusOK, item)
+}
+
+func (h *Handler) deleteItem(w http.ResponseWriter, req *http.Request, shopItemID int) {
+	item := h.shop.NewShopItem()
+	if err := item.DeleteContext(req.Context(), shopItemID, h.now()); err != nil {
+		h.writeServiceError(w, req, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) restoreItem(w http.ResponseWriter, req *http.Request, shopItemID int) {
+	item := h.shop.NewShopItem()
+	if err := item.RestoreContext(req.Context(), shopItemID, h.now()); err != nil {
+		h.writeServiceError(w, req, err)
+		return
+	}
+
+	h.writeItem(w, http.StatusOK, item)
+}
+
+func (h *Handler) uploadImage(w http.ResponseWriter, req *http.Request, shopItemID int) {
+	// Larger files are rejected by the shop, the limit only stops reading bodies which can't be valid uploads
+	req.Body = http.MaxBytesReader(w, req.Body, maxUploadRequestSize)
+
+	file, header, err := req.FormFile("file")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, ErrInvalidRequestBody)
+		return
+	}
+	defer file.Close()
+
+	upload := &mop_shop.ImageUpload{ContentType: header.Header.Get("Content-Type"), Data: file}
+	if altText := req.FormValue("alt_text"); len(altText) > 0 {
+		upload.AltText = &altText
+	}
+
+	image, err := h.shop.UploadShopItemImageContext(req.Context(), shopItemID, upload)
+	if err != nil {
+		h.writeServiceError(w, req, err)
+		return
+	}
+
+	h.writeJSON(w, http.StatusCreated, image)
+}
+
+func (h *Handler) listDeletedItems(w http.ResponseWriter, req *http.Request) {
+	paginationParams := mop_shop.PaginationParams{}
+	paginationParams.PerPage, _ = strconv.Atoi(req.URL.Query().Get("per_page"))
+	paginationParams.After, _ = strconv.Atoi(req.URL.Query().Get("after"))
+
+	paginationParams.Before, _ = strconv.Atoi(req.URL.Query().Get("before"))
+
+	items, pages, err := h.shop.FindDeletedShopItemsContext(req.Context(), paginationParams, req)
+	if err != nil {
+		h.writeServiceError(w, req, err)
+		return
+	}
+
+	response := DeletedShopItemsResponse{Items: make([]ShopItemResponse, 0, len(items)), Pagination: pages}
+	for i := range items {
+		response.Items = append(response.Items, newShopItemResponse(&items[i]))
+	}
+
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) writeServiceError(w http.ResponseWriter, req *http.Request, err error) {
+	var validationErrors mop_shop.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		h.writeJSON(w, http.StatusUnprocessableEntity, ValidationErrorResponse{Errors: validationErrors})
+		return
+	}
+
+	if mop_shop.IsNotFound(err) {
+		h.writeError(w, http.StatusNotFound, ErrShopItemNotFound)
+		return
+	}
+
+	if errors.Is(err, mop_shop.ErrVersionConflict) {
+		h.writeError(w, http.StatusPreconditionFailed, ErrShopItemChanged)
+		return
+	}
+
+	if mop_shop.IsConflict(err) {
+		h.writeError(w, http.StatusConflict, ErrShopItemConflict)
+		return
+	}
+
+	h.shop.Logger().Log(mop_shop.LevelError, "admin: error while handling shop item request", "request_id", requestID(req), "path", req.URL.Path, "error", err)
+	h.writeError(w, http.StatusInternalServerError, mop_shop.ErrInternal)
+}
+
+// writeItem writes the item with its version as ETag
+func (h *Handler) writeItem(w http.ResponseWriter, status int, item *mop_shop.ShopItem) {
+	w.Header().Set("ETag", `"`+strconv.Itoa(item.Version)+`"`)
+	h.writeJSON(w, status, newShopItemResponse(item))
+}
+
+// ifMatchVersion returns the item version from the If-Match header, ok is false when the header is missing or "*".
+// Weak tags fail with ErrShopItemChanged since If-Match uses the strong comparison of RFC 9110.
+func ifMatchVersion(req *http.Request) (version int, ok bool, err error) {
+	ifMatch := strings.TrimSpace(req.Header.Get("If-Match"))
+	if len(ifMatch) == 0 || ifMatch == "*" {
+		return 0, false, nil
+	}
+
+	if strings.HasPrefix(ifMatch, "W/") {
+		return 0, false, ErrShopItemChanged
+	}
+
+	if len(ifMatch) < 2 || !strings.HasPrefix(ifMatch, `"`) || !strings.HasSuffix(ifMatch, `"`) {
+		return 0, false, ErrInvalidIfMatch
+	}
+
+	version, err = strconv.Atoi(ifMatch[1 : len(ifMatch)-1])
+	if err != nil || version <= 0 {
+		return 0, false, ErrInvalidIfMatch
+	}
+
+	return version, true, nil
+}
+
+func (h *Handler) writeIfMatchError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrShopItemChanged) {
+		h.writeError(w, http.StatusPreconditionFailed, err)
+		return
+	}
+
+	h.writeError(w, http.StatusBadRequest, err)
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, status int, err error) {
+	h.writeJSON(w, status, ErrorResponse{Error: err.Error()})
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		h.shop.Logger().Log(mop_shop.LevelError, "admin: error while encoding response", "error", err)
+	}
+}
+
+func requestID(req *http.Request) string {
+	if requestID := mop_shop.RequestIDFromContext(req.Context()); len(requestID) > 0 {
+		return requestID
+	}
+
+	return req.Header.Get(mop_shop.RequestIDHeader)
+}
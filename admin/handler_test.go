@@ -0,0 +1,350 @@
+package admin
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/croatiangrn/mop-shop"
+	"github.com/croatiangrn/mop-shop/stripetest"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	dbTest, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer dbTest.Close()
+
+	dialector := mysql.New(mysql.Config{
+		DSN:                       "sqlmock_db_0",
+		DriverName:                "postgres",
+		Conn:                      dbTest,
+		SkipInitializeWithVersion: true,
+	})
+
+	database, _ := gorm.Open(dialector, &gorm.Config{})
+
+	stripeServer := stripetest.Start(t)
+	stripeServer.AddProduct(stripetest.Product{ID: "prod_mop", Name: "Mop", Active: true})
+	stripeServer.AddPrice(stripetest.Price{Product: "prod_mop", Currency: "eur", UnitAmount: 1000, LookupKey: "mop", Active: true})
+
+	currentTime := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	findQuery := "SELECT * FROM `shop_items` WHERE id = ? AND deleted_at IS NULL LIMIT 1"
+	findDeletedQuery := "SELECT * FROM `shop_items` WHERE id = ? AND deleted_at IS NOT NULL LIMIT 1"
+	deleteQuery := "UPDATE `shop_items` SET `deleted_at`=?,`updated_at`=? WHERE id = ?"
+	restoreQuery := "UPDATE `shop_items` SET `deleted_at`=?,`updated_at`=? WHERE id = ? AND deleted_at IS NOT NULL"
+	patchQuery := "UPDATE `shop_items` SET `item_picture`=?,`updated_at`=?,`version`=version + 1 WHERE id = ? AND version = ? AND deleted_at IS NULL"
+	updateQuery := "UPDATE `shop_items` SET `item_description`=?,`item_name`=?,`item_picture`=?,`item_price`=?,`item_sale_price`=?," +
+		"`quantity`=?,`shippable`=?,`updated_at`=?,`version`=version + 1 WHERE id = ? AND deleted_at IS NULL"
+	insertPriceQuery := "INSERT INTO `shop_item_price_history`"
+	deletedQuery := "SELECT * FROM `shop_items` WHERE deleted_at IS NOT NULL AND id <= ? ORDER BY id DESC LIMIT 2"
+
+	tests := []struct {
+		name          string
+		authenticated bool
+		method        string
+		path          string
+		body          string
+		ifMatch       string
+		expectMock    func()
+		wantStatus    int
+		wantBody      string
+		wantETag      string
+	}{
+		{
+			name:          "Unauthenticated request",
+			authenticated: false,
+			method:        http.MethodGet,
+			path:          "/items/deleted",
+			expectMock:    func() {},
+			wantStatus:    http.StatusUnauthorized,
+			wantBody:      `{"error":"unauthorized"}`,
+		},
+		{
+			name:          "Unknown route",
+			authenticated: true,
+			method:        http.MethodGet,
+			path:          "/orders",
+			expectMock:    func() {},
+			wantStatus:    http.StatusNotFound,
+			wantBody:      `{"error":"route_not_found"}`,
+		},
+		{
+			name:          "Method not allowed",
+			authenticated: true,
+			method:        http.MethodGet,
+			path:          "/items",
+			expectMock:    func() {},
+			wantStatus:    http.StatusMethodNotAllowed,
+			wantBody:      `{"error":"method_not_allowed"}`,
+		},
+		{
+			name:          "Invalid item ID",
+			authenticated: true,
+			method:        http.MethodDelete,
+			path:          "/items/abc",
+			expectMock:    func() {},
+			wantStatus:    http.StatusBadRequest,
+			wantBody:      `{"error":"item_id_cannot_be_less_or_equal_than_zero"}`,
+		},
+		{
+			name:          "Invalid create body",
+			authenticated: true,
+			method:        http.MethodPost,
+			path:          "/items",
+			body:          `{"item_name":`,
+			expectMock:    func() {},
+			wantStatus:    http.StatusBadRequest,
+			wantBody:      `{"error":"invalid_request_body"}`,
+		},
+		{
+			name:          "Create validation error",
+			authenticated: true,
+			method:        http.MethodPost,
+			path:          "/items",
+			body:          `{"item_name":"","item_price":100,"quantity":0}`,
+			expectMock:    func() {},
+			wantStatus:    http.StatusUnprocessableEntity,
+			wantBody: `{"errors":[{"field":"item_name","error":"item_name_cannot_be_blank"},` +
+				`{"field":"quantity","error":"quantity_cannot_be_zero_or_negative"}]}`,
+		},
+		{
+			name:          "Restore missing item",
+			authenticated: true,
+			method:        http.MethodPost,
+			path:          "/items/7/restore",
+			expectMock: func() {
+				mock.ExpectQuery(regexp.QuoteMeta(findDeletedQuery)).WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			},
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"error":"shop_item_not_found"}`,
+		},
+		{
+			name:          "Delete item",
+			authenticated: true,
+			method:        http.MethodDelete,
+			path:          "/items/7",
+			expectMock: func() {
+				rows := sqlmock.NewRows([]string{"id", "item_name", "stripe_product_api_id", "unique_stripe_price_lookup_key"}).AddRow(7, "Mop", "prod_mop", "mop")
+				mock.ExpectQuery(regexp.QuoteMeta(findQuery)).WithArgs(7).WillReturnRows(rows)
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(deleteQuery)).WithArgs(currentTime, currentTime, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:          "Patch item picture",
+			authenticated: true,
+			method:        http.MethodPatch,
+			path:          "/items/7",
+			body:          `{"item_picture":"mop.png"}`,
+			ifMatch:       `"2"`,
+			expectMock: func() {
+				rows := sqlmock.NewRows([]string{"id", "item_name", "item_price", "quantity", "stripe_product_api_id", "unique_stripe_price_lookup_key", "version"}).
+					AddRow(7, "Mop", 1000, 2, "prod_mop", "mop", 2)
+				mock.ExpectQuery(regexp.QuoteMeta(findQuery)).WithArgs(7).WillReturnRows(rows)
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(patchQuery)).WithArgs("mop.png", currentTime, 7, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `{"id":7,"sku":null,"gtin":null,"item_name":"Mop","item_picture":"mop.png","item_price":1000,"item_sale_price":null,` +
+				`"item_description":null,"shippable":false,"weight_grams":null,"tax_class":"","quantity":2,"stripe_product_api_id":"prod_mop",` +
+				`"unique_stripe_price_lookup_key":"mop","version":3,"created_at":"0001-01-01T00:00:00Z",` +
+				`"updated_at":"2021-09-01T12:00:00Z","deleted_at":null}`,
+			wantETag: `"3"`,
+		},
+		{
+			name:          "Patch item with stale version",
+			authenticated: true,
+			method:        http.MethodPatch,
+			path:          "/items/7",
+			body:          `{"item_picture":"mop.png"}`,
+			ifMatch:       `"1"`,
+			expectMock: func() {
+				rows := sqlmock.NewRows([]string{"id", "item_name", "item_price", "quantity", "version"}).AddRow(7, "Mop", 1000, 2, 2)
+				mock.ExpectQuery(regexp.QuoteMeta(findQuery)).WithArgs(7).WillReturnRows(rows)
+			},
+			wantStatus: http.StatusPreconditionFailed,
+			wantBody:   `{"error":"shop_item_changed"}`,
+		},
+		{
+			name:          "Patch item with invalid If-Match",
+			authenticated: true,
+			method:        http.MethodPatch,
+			path:          "/items/7",
+			body:          `{"item_picture":"mop.png"}`,
+			ifMatch:       `"abc"`,
+			expectMock:    func() {},
+			wantStatus:    http.StatusBadRequest,
+			wantBody:      `{"error":"invalid_if_match_header"}`,
+		},
+		{
+			name:          "Update item without sku keeps it",
+			authenticated: true,
+			method:        http.MethodPut,
+			path:          "/items/7",
+			body:          `{"item_name":"Mop","item_price":1200,"quantity":2}`,
+			expectMock: func() {
+				rows := sqlmock.NewRows([]string{"id", "sku", "gtin", "item_name", "item_price", "weight_grams", "tax_class", "quantity", "stripe_product_api_id", "unique_stripe_price_lookup_key"}).
+					AddRow(7, "MOP-1", "4006381333931", "Mop", 1000, 800, "reduced", 2, "prod_mop", "mop")
+				mock.ExpectQuery(regexp.QuoteMeta(findQuery)).WithArgs(7).WillReturnRows(rows)
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(updateQuery)).
+					WithArgs(nil, "Mop", nil, 1200, nil, 2, false, currentTime, 7).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(insertPriceQuery)).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `{"id":7,"sku":"MOP-1","gtin":"4006381333931","item_name":"Mop","item_picture":null,"item_price":1200,"item_sale_price":null,` +
+				`"item_description":null,"shippable":false,"weight_grams":800,"tax_class":"reduced","quantity":2,"stripe_product_api_id":"prod_mop",` +
+				`"unique_stripe_price_lookup_key":"mop","version":0,"created_at":"0001-01-01T00:00:00Z",` +
+				`"updated_at":"2021-09-01T12:00:00Z","deleted_at":null}`,
+		},
+		{
+			name:          "Patch item with weak If-Match",
+			authenticated: true,
+			method:        http.MethodPatch,
+			path:          "/items/7",
+			body:          `{"item_picture":"mop.png"}`,
+			ifMatch:       `W/"2"`,
+			expectMock:    func() {},
+			wantStatus:    http.StatusPreconditionFailed,
+			wantBody:      `{"error":"shop_item_changed"}`,
+		},
+		{
+			name:          "Get item",
+			authenticated: true,
+			method:        http.MethodGet,
+			path:          "/items/7",
+			expectMock: func() {
+				rows := sqlmock.NewRows([]string{"id", "item_name", "item_price", "quantity", "stripe_product_api_id", "unique_stripe_price_lookup_key", "version"}).
+					AddRow(7, "Mop", 1000, 2, "prod_mop", "mop", 4)
+				mock.ExpectQuery(regexp.QuoteMeta(findQuery)).WithArgs(7).WillReturnRows(rows)
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `{"id":7,"sku":null,"gtin":null,"item_name":"Mop","item_picture":null,"item_price":1000,"item_sale_price":null,` +
+				`"item_description":null,"shippable":false,"weight_grams":null,"tax_class":"","quantity":2,"stripe_product_api_id":"prod_mop",` +
+				`"unique_stripe_price_lookup_key":"mop","version":4,"created_at":"0001-01-01T00:00:00Z",` +
+				`"updated_at":"0001-01-01T00:00:00Z","deleted_at":null}`,
+			wantETag: `"4"`,
+		},
+		{
+			name:          "Get missing item",
+			authenticated: true,
+			method:        http.MethodGet,
+			path:          "/items/8",
+			expectMock: func() {
+				mock.ExpectQuery(regexp.QuoteMeta(findQuery)).WithArgs(8).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			},
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"error":"shop_item_not_found"}`,
+		},
+		{
+			name:          "Upload image without file",
+			authenticated: true,
+			method:        http.MethodPost,
+			path:          "/items/7/images",
+			body:          `{"url":"mop.png"}`,
+			expectMock:    func() {},
+			wantStatus:    http.StatusBadRequest,
+			wantBody:      `{"error":"invalid_request_body"}`,
+		},
+		{
+			name:          "Restore item",
+			authenticated: true,
+			method:        http.MethodPost,
+			path:          "/items/7/restore",
+			expectMock: func() {
+				rows := sqlmock.NewRows([]string{"id", "item_name", "stripe_product_api_id", "unique_stripe_price_lookup_key", "deleted_at"}).
+					AddRow(7, "Mop", "prod_mop", "mop", currentTime)
+				mock.ExpectQuery(regexp.QuoteMeta(findDeletedQuery)).WithArgs(7).WillReturnRows(rows)
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(restoreQuery)).WithArgs(nil, currentTime, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `{"id":7,"sku":null,"gtin":null,"item_name":"Mop","item_picture":null,"item_price":0,"item_sale_price":null,` +
+				`"item_description":null,"shippable":false,"weight_grams":null,"tax_class":"","quantity":0,"stripe_product_api_id":"prod_mop",` +
+				`"unique_stripe_price_lookup_key":"mop","version":0,"created_at":"0001-01-01T00:00:00Z",` +
+				`"updated_at":"2021-09-01T12:00:00Z","deleted_at":null}`,
+		},
+		{
+			name:          "List deleted items",
+			authenticated: true,
+			method:        http.MethodGet,
+			path:          "/items/deleted?after=10&per_page=1",
+			expectMock: func() {
+				rows := sqlmock.NewRows([]string{"id", "item_name", "deleted_at"}).AddRow(10, "Mop", currentTime).AddRow(9, "Broom", currentTime)
+				mock.ExpectQuery(regexp.QuoteMeta(deletedQuery)).WithArgs(10).WillReturnRows(rows)
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `{"items":[{"id":10,"sku":null,"gtin":null,"item_name":"Mop","item_picture":null,"item_price":0,"item_sale_price":null,` +
+				`"item_description":null,"shippable":false,"weight_grams":null,"tax_class":"","quantity":0,"stripe_product_api_id":"",` +
+				`"unique_stripe_price_lookup_key":"","version":0,"created_at":"0001-01-01T00:00:00Z",` +
+				`"updated_at":"0001-01-01T00:00:00Z","deleted_at":"2021-09-01T12:00:00Z"}],` +
+				`"pagination":{"cursor_before":"/items/deleted?before=11\u0026per_page=1","cursor_after":"/items/deleted?after=9\u0026per_page=1",` +
+				`"before":"11","after":"9"}}`,
+		},
+		{
+			name:          "List the last page of deleted items",
+			authenticated: true,
+			method:        http.MethodGet,
+			path:          "/items/deleted?after=9&per_page=1",
+			expectMock: func() {
+				rows := sqlmock.NewRows([]string{"id", "item_name", "deleted_at"}).AddRow(9, "Broom", currentTime)
+				mock.ExpectQuery(regexp.QuoteMeta(deletedQuery)).WithArgs(9).WillReturnRows(rows)
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `{"items":[{"id":9,"sku":null,"gtin":null,"item_name":"Broom","item_picture":null,"item_price":0,"item_sale_price":null,` +
+				`"item_description":null,"shippable":false,"weight_grams":null,"tax_class":"","quantity":0,"stripe_product_api_id":"",` +
+				`"unique_stripe_price_lookup_key":"","version":0,"created_at":"0001-01-01T00:00:00Z",` +
+				`"updated_at":"0001-01-01T00:00:00Z","deleted_at":"2021-09-01T12:00:00Z"}],` +
+				`"pagination":{"cursor_before":"/items/deleted?before=10\u0026per_page=1","cursor_after":null,"before":"10","after":null}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(mop_shop.NewShop(database, "sk_test"), func(req *http.Request) bool {
+				return tt.authenticated
+			})
+			h.now = func() time.Time {
+				return currentTime
+			}
+
+			tt.expectMock()
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if len(tt.ifMatch) > 0 {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			rec := httptest.NewRecorder()
+
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if len(tt.wantBody) > 0 {
+				assert.JSONEq(t, tt.wantBody, rec.Body.String())
+			} else {
+				assert.Empty(t, rec.Body.String())
+			}
+			if len(tt.wantETag) > 0 {
+				assert.Equal(t, tt.wantETag, rec.Header().Get("ETag"))
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"errors"
+	"github.com/croatiangrn/mop-shop"
+	"time"
+)
+
+var (
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrRouteNotFound      = errors.New("route_not_found")
+	ErrMethodNotAllowed   = errors.New("method_not_allowed")
+	ErrInvalidRequestBody = errors.New("invalid_request_body")
+	ErrShopItemNotFound   = errors.New("shop_item_not_found")
+	ErrShopItemConflict   = errors.New("shop_item_conflict")
+	ErrShopItemChanged    = errors.New("shop_item_changed")
+	ErrInvalidIfMatch     = errors.New("invalid_if_match_header")
+)
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type ValidationErrorResponse struct {
+	Errors mop_shop.ValidationErrors `json:"errors"`
+}
+
+// ShopItemResponse is mop_shop.ShopItem as seen by admins, which unlike the storefront includes deletion time
+type ShopItemResponse struct {
+	ID                         int        `json:"id"`
+	SKU                        *string    `json:"sku"`
+	GTIN                       *string    `json:"gtin"`
+	ItemName                   string     `json:"item_name"`
+	ItemPicture                *string    `json:"item_picture"`
+	ItemPrice                  int64      `json:"item_price"`
+	ItemSalePrice              *int64     `json:"item_sale_price"`
+	ItemDescription            *string    `json:"item_description"`
+	Shippable                  bool       `json:"shippable"`
+	WeightGrams                *int       `json:"weight_grams"`
+	TaxClass                   string     `json:"tax_class"`
+	Quantity                   int        `json:"quantity"`
+	StripeProductApiID         string     `json:"stripe_product_api_id"`
+	UniqueStripePriceLookupKey string     `json:"unique_stripe_price_lookup_key"`
+	Version                    int        `json:"version"`
+	CreatedAt                  time.Time  `json:"created_at"`
+	UpdatedAt                  time.Time  `json:"updated_at"`
+	DeletedAt                  *time.Time `json:"deleted_at"`
+}
+
+// DeletedShopItemsResponse is a page of soft-deleted items
+type DeletedShopItemsResponse struct {
+	Items      []ShopItemResponse           `json:"items"`
+	Pagination *mop_shop.PaginationResponse `json:"pagination"`
+}
+
+func newShopItemResponse(item *mop_shop.ShopItem) ShopItemResponse {
+	return ShopItemResponse{
+		ID:                         item.ID,
+		SKU:                        item.SKU,
+		GTIN:                       item.GTIN,
+		ItemName:                   item.ItemName,
+		ItemPicture:                item.ItemPicture,
+		ItemPrice:                  item.ItemPrice,
+		ItemSalePrice:              item.ItemSalePrice,
+		ItemDescription:            item.ItemDescription,
+		Shippable:                  item.Shippable,
+		WeightGrams:                item.WeightGrams,
+		TaxClass:                   string(item.TaxClass),
+		Quantity:                   item.Quantity,
+		StripeProductApiID:         item.StripeProductApiID,
+		UniqueStripePriceLookupKey: item.UniqueStripePriceLookupKey,
+		Version:                    item.Version,
+		CreatedAt:                  item.CreatedAt,
+		UpdatedAt:                  item.UpdatedAt,
+		DeletedAt:                  item.DeletedAt,
+	}
+}
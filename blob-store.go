@@ -0,0 +1,101 @@
+package mop_shop
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// BlobStore keeps uploaded files such as shop item images. Keys are slash separated relative paths like
+// shop-items/4/9d1c.../original.jpg, the returned URL is what gets stored on shop items so it has to be reachable by
+// shop visitors. An S3-compatible store only needs these two methods, LocalBlobStore is included for single server
+// setups and tests.
+type BlobStore interface {
+	// Put stores data under key, replacing an existing blob, and returns its public URL
+	Put(ctx context.Context, key, contentType string, data io.Reader) (string, error)
+	// Delete removes the blob, deleting a missing blob isn't an error
+	Delete(ctx context.Context, key string) error
+}
+
+// LocalBlobStore keeps blobs as files below a directory, which the application serves at baseURL, e.g. through
+// http.FileServer. The baseURL has to be absolute like https://shop.example.com/media since shop item images are.
+type LocalBlobStore struct {
+	dir     string
+	baseURL string
+}
+
+func NewLocalBlobStore(dir, baseURL string) *LocalBlobStore {
+	return &LocalBlobStore{dir: dir, baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// path returns the file path of key, keys escaping the directory are rejected
+func (s *LocalBlobStore) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || cleaned != "/"+key {
+		return "", ErrInvalidBlobKey
+	}
+
+	return filepath.Join(s.dir, filepath.FromSlash(cleaned[1:])), nil
+}
+
+// Put writes data to a temporary file first, so readers never see partially written blobs
+func (s *LocalBlobStore) Put(ctx context.Context, key, contentType string, data io.Reader) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	filePath, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return "", err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(filePath), ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := io.Copy(file, data); err != nil {
+		file.Close()
+		return "", err
+	}
+
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(file.Name(), filePath); err != nil {
+		return "", err
+	}
+
+	return s.baseURL + "/" + key, nil
+}
+
+func (s *LocalBlobStore) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package mop_shop
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"gorm.io/gorm"
+	"io"
+	"strconv"
+)
+
+func ExportShopItems(writer io.Writer, format CatalogFormat, db *gorm.DB) error {
+	return defaultShop(db).ExportShopItems(writer, format)
+}
+
+func ExportShopItemsContext(ctx context.Context, writer io.Writer, format CatalogFormat, db *gorm.DB) error {
+	return defaultShop(db).ExportShopItemsContext(ctx, writer, format)
+}
+
+// ExportShopItems works like the package-level ExportShopItems
+func (s *Shop) ExportShopItems(writer io.Writer, format CatalogFormat) error {
+	return s.ExportShopItemsContext(context.Background(), writer, format)
+}
+
+// ExportShopItemsContext writes every shop item which isn't soft-deleted, newest first, in a form ImportShopItems
+// reads back
+func (s *Shop) ExportShopItemsContext(ctx context.Context, writer io.Writer, format CatalogFormat) error {
+	if s.items == nil {
+		return ErrShopItemNotInitializedProperly
+	}
+
+	var write func(item *ShopItem) error
+	var flush func() error
+
+	switch format {
+	case CatalogCSV:
+		csvWriter := csv.NewWriter(writer)
+		if err := csvWriter.Write(CatalogColumns); err != nil {
+			return err
+		}
+
+		write = func(item *ShopItem) error {
+			return csvWriter.Write(catalogRecord(item))
+		}
+		flush = func() error {
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+	case CatalogJSONLines:
+		encoder := json.NewEncoder(writer)
+		write = func(item *ShopItem) error {
+			return encoder.Encode(catalogEntry(item))
+		}
+		flush = func() error {
+			return nil
+		}
+	default:
+		return ErrUnsupportedCatalogFormat
+	}
+
+	paginationParams := PaginationParams{PerPage: 100}
+	for {
+		items, err := s.items.FindAll(ctx, paginationParams)
+		if err != nil {
+			s.log(ctx, LevelError, "error while getting shop items", "error", err)
+			return wrapInternal("ExportShopItems: query shop items", err)
+		}
+
+		// the extra item FindAll returns starts the next page
+		next := 0
+		if len(items) > paginationParams.PerPage {
+			next, items = items[paginationParams.PerPage].ID, items[:paginationParams.PerPage]
+		}
+
+		for i := range items {
+			if items[i].DeletedAt != nil {
+				continue
+			}
+
+			if err := write(&items[i]); err != nil {
+				return err
+			}
+		}
+
+		if next == 0 {
+			break
+		}
+
+		paginationParams.After = next
+	}
+
+	return flush()
+}
+
+func catalogEntry(item *ShopItem) *ShopItemCreate {
+	return &ShopItemCreate{
+		SKU:             item.SKU,
+		GTIN:            item.GTIN,
+		ItemName:        item.ItemName,
+		ItemPicture:     item.ItemPicture,
+		ItemPrice:       item.ItemPrice,
+		ItemSalePrice:   item.ItemSalePrice,
+		ItemDescription: item.ItemDescription,
+		Shippable:       item.Shippable,
+		WeightGrams:     item.WeightGrams,
+		TaxClass:        item.TaxClass,
+		Quantity:        item.Quantity,
+	}
+}
+
+// catalogRecord returns the CSV record of the item in the order of CatalogColumns
+func catalogRecord(item *ShopItem) []string {
+	optional := func(value *string) string {
+		if value == nil {
+			return ""
+		}
+
+		return *value
+	}
+
+	salePrice := ""
+	if item.ItemSalePrice != nil {
+		salePrice = strconv.FormatInt(*item.ItemSalePrice, 10)
+	}
+
+	weight := ""
+	if item.WeightGrams != nil {
+		weight = strconv.Itoa(*item.WeightGrams)
+	}
+
+	return []string{
+		optional(item.SKU),
+		optional(item.GTIN),
+		item.ItemName,
+		optional(item.ItemPicture),
+		strconv.FormatInt(item.ItemPrice, 10),
+		salePrice,
+		optional(item.ItemDescription),
+		strconv.FormatBool(item.Shippable),
+		weight,
+		string(item.TaxClass),
+		strconv.Itoa(item.Quantity),
+	}
+}
@@ -0,0 +1,460 @@
+package mop_shop
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CatalogFormat is the encoding used by ImportShopItems and ExportShopItems
+type CatalogFormat string
+
+const (
+	// CatalogCSV is CSV with a header row naming the columns, see CatalogColumns
+	CatalogCSV CatalogFormat = "csv"
+	// CatalogJSONLines is one JSON object per line with the fields of ShopItemCreate
+	CatalogJSONLines CatalogFormat = "jsonl"
+)
+
+// CatalogColumns are the CSV columns in the order ExportShopItems writes them. Imports only require sku, item_name,
+// item_price and quantity, columns may come in any order and unknown ones are ignored. Existing items only get the
+// columns which are given, the same goes for keys of JSON lines.
+var CatalogColumns = []string{"sku", "gtin", "item_name", "item_picture", "item_price", "item_sale_price", "item_description", "shippable", "weight_grams", "tax_class", "quantity"}
+
+var requiredCatalogColumns = []string{"sku", "item_name", "item_price", "quantity"}
+
+type ImportAction string
+
+const (
+	ImportCreated   ImportAction = "created"
+	ImportUpdated   ImportAction = "updated"
+	ImportUnchanged ImportAction = "unchanged"
+	ImportFailed    ImportAction = "failed"
+)
+
+// ImportRowResult is the outcome of a single imported item, Row counts items from 1 skipping the CSV header and
+// blank lines
+type ImportRowResult struct {
+	Row        int          `json:"row"`
+	SKU        string       `json:"sku"`
+	ShopItemID int          `json:"shop_item_id,omitempty"`
+	Action     ImportAction `json:"action"`
+	Err        error        `json:"-"`
+}
+
+func (r ImportRowResult) MarshalJSON() ([]byte, error) {
+	type row ImportRowResult
+
+	message := ""
+	if r.Err != nil {
+		message = r.Err.Error()
+	}
+
+	return json.Marshal(struct {
+		row
+		Error string `json:"error,omitempty"`
+	}{
+		row:   row(r),
+		Error: message,
+	})
+}
+
+// ImportReport has a result for every item of the input, in input order
+type ImportReport struct {
+	Created   int               `json:"created"`
+	Updated   int               `json:"updated"`
+	Unchanged int               `json:"unchanged"`
+	Failed    int               `json:"failed"`
+	Rows      []ImportRowResult `json:"rows"`
+}
+
+type importConfig struct {
+	concurrency int
+}
+
+type ImportOption func(c *importConfig)
+
+// ImportConcurrency sets how many items are written to Stripe and the database at once, 4 by default
+func ImportConcurrency(concurrency int) ImportOption {
+	return func(c *importConfig) {
+		c.concurrency = concurrency
+	}
+}
+
+// importRow is a parsed item of the input, err holds parsing failures
+type importRow struct {
+	row  int
+	data *ShopItemCreate
+	// fields are the columns or keys given for the item, named like CatalogColumns
+	fields map[string]bool
+	err    error
+}
+
+func ImportShopItems(reader io.Reader, format CatalogFormat, currentTime time.Time, db *gorm.DB, options ...ImportOption) (*ImportReport, error) {
+	return defaultShop(db).ImportShopItems(reader, format, currentTime, options...)
+}
+
+func ImportShopItemsContext(ctx context.Context, reader io.Reader, format CatalogFormat, currentTime time.Time, db *gorm.DB, options ...ImportOption) (*ImportReport, error) {
+	return defaultShop(db).ImportShopItemsContext(ctx, reader, format, currentTime, options...)
+}
+
+// ImportShopItems works like the package-level ImportShopItems
+func (s *Shop) ImportShopItems(reader io.Reader, format CatalogFormat, currentTime time.Time, options ...ImportOption) (*ImportReport, error) {
+	return s.ImportShopItemsContext(context.Background(), reader, format, currentTime, options...)
+}
+
+// ImportShopItemsContext creates or updates a shop item for every item of the input, matching existing items by
+// SKU. Every item is validated like ShopItemCreate, new items are created like ShopItem.Create and existing ones
+// patched like ShopItem.Patch with the fields the input gives, so Stripe is only called for what changed. Existing
+// items may be imported with a quantity of 0, as ExportShopItems writes sold out items. Items whose SKU belongs to a
+// soft-deleted item fail with ErrSKUOfDeletedShopItem and the ID of that item.
+//
+// Failures of single items are reported in ImportReport and don't stop the import, an error is only returned when
+// the input can't be read at all.
+func (s *Shop) ImportShopItemsContext(ctx context.Context, reader io.Reader, format CatalogFormat, currentTime time.Time, options ...ImportOption) (*ImportReport, error) {
+	if s.items == nil {
+		return nil, ErrShopItemNotInitializedProperly
+	}
+
+	config := importConfig{concurrency: 4}
+	for _, option := range options {
+		option(&config)
+	}
+
+	if config.concurrency < 1 {
+		config.concurrency = 1
+	}
+
+	rows, err := readCatalog(reader, format)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ImportRowResult, len(rows))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < config.concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = s.importRow(ctx, rows[i], currentTime)
+			}
+		}()
+	}
+
+	for i := range rows {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	report := &ImportReport{Rows: results}
+	for i := range results {
+		switch results[i].Action {
+		case ImportCreated:
+			report.Created++
+		case ImportUpdated:
+			report.Updated++
+		case ImportUnchanged:
+			report.Unchanged++
+		default:
+			report.Failed++
+		}
+	}
+
+	return report, nil
+}
+
+func (s *Shop) importRow(ctx context.Context, row importRow, currentTime time.Time) ImportRowResult {
+	result := ImportRowResult{Row: row.row, Action: ImportFailed}
+	if row.data != nil && row.data.SKU != nil {
+		result.SKU = *row.data.SKU
+	}
+
+	var validationErrors ValidationErrors
+	if row.err != nil && !errors.As(row.err, &validationErrors) {
+		result.Err = row.err
+		return result
+	}
+
+	if err := ctx.Err(); err != nil {
+		result.Err = err
+		return result
+	}
+
+	var existing *ShopItem
+	if len(result.SKU) > 0 {
+		var err error
+		if existing, err = s.items.FindOneBySKU(ctx, result.SKU); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			s.log(ctx, LevelError, "error while getting shop item by sku", "sku", result.SKU, "error", err)
+			result.Err = wrapInternal("ImportShopItems: query shop item", err)
+			return result
+		}
+	}
+
+	// Existing items may have sold out, only new ones need stock
+	if existing != nil && row.data.Quantity == 0 {
+		validationErrors = validationErrors.without("quantity", ErrShopItemQuantityZeroOrNegative)
+	}
+
+	if result.Err = validationErrors.errOrNil(); result.Err != nil {
+		return result
+	}
+
+	if existing == nil {
+		deleted, err := s.items.FindOneDeletedBySKU(ctx, result.SKU)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			s.log(ctx, LevelError, "error while getting deleted shop item by sku", "sku", result.SKU, "error", err)
+			result.Err = wrapInternal("ImportShopItems: query deleted shop item", err)
+			return result
+		}
+
+		// The SKU can't be taken by a new item, restoring the deleted one is left to the admin
+		if deleted != nil {
+			result.ShopItemID, result.Err = deleted.ID, ErrSKUOfDeletedShopItem
+			return result
+		}
+	}
+
+	item := s.NewShopItem()
+	if existing == nil {
+		if result.Err = item.CreateContext(ctx, row.data, currentTime); result.Err == nil {
+			result.ShopItemID, result.Action = item.ID, ImportCreated
+		}
+
+		return result
+	}
+
+	patch := row.data.patch(row.fields)
+	patch.Version = &existing.Version
+	if result.Err = item.PatchContext(ctx, existing.ID, patch, currentTime); result.Err != nil {
+		return result
+	}
+
+	result.ShopItemID, result.Action = item.ID, ImportUpdated
+	if item.Version == existing.Version {
+		result.Action = ImportUnchanged
+	}
+
+	return result
+}
+
+// readCatalog parses every item of the input and validates it, items repeating an SKU seen before fail
+func readCatalog(reader io.Reader, format CatalogFormat) ([]importRow, error) {
+	var rows []importRow
+	var err error
+
+	switch format {
+	case CatalogCSV:
+		rows, err = readCatalogCSV(reader)
+	case CatalogJSONLines:
+		rows, err = readCatalogJSONLines(reader)
+	default:
+		return nil, ErrUnsupportedCatalogFormat
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for i := range rows {
+		// Parsing failures other than invalid fields leave nothing to validate
+		var validationErrors ValidationErrors
+		if rows[i].err != nil && !errors.As(rows[i].err, &validationErrors) {
+			continue
+		}
+
+		invalid := map[string]bool{}
+		for _, fieldErr := range validationErrors {
+			invalid[fieldErr.Field] = true
+		}
+
+		sku := rows[i].data.SKU
+		switch {
+		case sku == nil || len(*sku) == 0:
+			validationErrors.add("sku", ErrSKUBlank)
+		case seen[*sku]:
+			validationErrors.add("sku", ErrSKUDuplicatedInImport)
+		default:
+			seen[*sku] = true
+		}
+
+		var itemErrors ValidationErrors
+		errors.As(rows[i].data.Validate(), &itemErrors)
+		for _, fieldErr := range itemErrors {
+			if !invalid[fieldErr.Field] {
+				validationErrors = append(validationErrors, fieldErr)
+			}
+		}
+
+		rows[i].err = validationErrors.errOrNil()
+	}
+
+	return rows, nil
+}
+
+func readCatalogCSV(reader io.Reader) ([]importRow, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+
+	header, err := csvReader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, column := range header {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+
+	fields := map[string]bool{}
+	for _, column := range CatalogColumns {
+		_, fields[column] = columns[column]
+	}
+
+	for _, column := range requiredCatalogColumns {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrCatalogColumnMissing, column)
+		}
+	}
+
+	var rows []importRow
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+
+		row := importRow{row: len(rows) + 1, fields: fields}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, err
+			}
+
+			row.err = err
+			rows = append(rows, row)
+			continue
+		}
+
+		row.data, row.err = parseCatalogRecord(record, columns)
+		rows = append(rows, row)
+	}
+}
+
+// parseCatalogRecord reads a CSV record, empty cells of optional columns are nil
+func parseCatalogRecord(record []string, columns map[string]int) (*ShopItemCreate, error) {
+	cell := func(column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+
+		return strings.TrimSpace(record[i])
+	}
+
+	optional := func(column string) *string {
+		if value := cell(column); len(value) > 0 {
+			return &value
+		}
+
+		return nil
+	}
+
+	var validationErrors ValidationErrors
+	data := &ShopItemCreate{
+		SKU:             optional("sku"),
+		GTIN:            optional("gtin"),
+		ItemName:        cell("item_name"),
+		ItemPicture:     optional("item_picture"),
+		ItemDescription: optional("item_description"),
+		TaxClass:        TaxClass(cell("tax_class")),
+	}
+
+	var err error
+	if data.ItemPrice, err = strconv.ParseInt(cell("item_price"), 10, 64); err != nil {
+		validationErrors.add("item_price", ErrInvalidNumber)
+	}
+
+	if salePrice := cell("item_sale_price"); len(salePrice) > 0 {
+		parsed, err := strconv.ParseInt(salePrice, 10, 64)
+		if err != nil {
+			validationErrors.add("item_sale_price", ErrInvalidNumber)
+		}
+
+		data.ItemSalePrice = &parsed
+	}
+
+	if shippable := cell("shippable"); len(shippable) > 0 {
+		if data.Shippable, err = strconv.ParseBool(shippable); err != nil {
+			validationErrors.add("shippable", ErrInvalidBoolean)
+		}
+	}
+
+	if weight := cell("weight_grams"); len(weight) > 0 {
+		parsed, err := strconv.Atoi(weight)
+		if err != nil {
+			validationErrors.add("weight_grams", ErrInvalidNumber)
+		}
+
+		data.WeightGrams = &parsed
+	}
+
+	if data.Quantity, err = strconv.Atoi(cell("quantity")); err != nil {
+		validationErrors.add("quantity", ErrInvalidNumber)
+	}
+
+	return data, validationErrors.errOrNil()
+}
+
+func readCatalogJSONLines(reader io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []importRow
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		row := importRow{row: len(rows) + 1, data: &ShopItemCreate{}, fields: map[string]bool{}}
+		if err := json.Unmarshal(line, row.data); err != nil {
+			row.err = err
+		}
+
+		// decoding into the struct succeeded, so the line is an object or null
+		var keys map[string]json.RawMessage
+		if row.err == nil && json.Unmarshal(line, &keys) == nil {
+			for _, column := range CatalogColumns {
+				_, row.fields[column] = keys[column]
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
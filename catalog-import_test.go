@@ -0,0 +1,187 @@
+package mop_shop
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/croatiangrn/mop-shop/stripetest"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShop_ImportShopItemsContext(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	stripeServer := stripetest.Start(t)
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(NewMemoryStorage()))
+
+	input := "sku,item_name,item_price,item_sale_price,quantity,shippable\n" +
+		"MOP-1,Mop,2000,1500,3,true\n" +
+		"BKT-1,Bucket,900,,5,\n" +
+		"MOP-1,Mop again,2000,,3,\n" +
+		"BRM-1,Broom,-1,,0,maybe\n"
+
+	report, err := shop.ImportShopItemsContext(ctx, strings.NewReader(input), CatalogCSV, now, ImportConcurrency(2))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, 2, report.Created)
+	assert.Equal(t, 2, report.Failed)
+	assert.Equal(t, ImportCreated, report.Rows[0].Action)
+	assert.Equal(t, ImportCreated, report.Rows[1].Action)
+	assert.True(t, errors.Is(report.Rows[2].Err, ErrSKUDuplicatedInImport))
+	assert.Equal(t, ValidationErrors{
+		{Field: "shippable", Err: ErrInvalidBoolean},
+		{Field: "item_price", Err: ErrShopItemPriceNegative},
+		{Field: "quantity", Err: ErrShopItemQuantityZeroOrNegative},
+	}, report.Rows[3].Err)
+	assert.Len(t, stripeServer.Prices(), 2)
+
+	input = `{"sku":"MOP-1","item_name":"Mop","item_price":2000,"item_sale_price":1500,"shippable":true,"quantity":3}` + "\n\n" +
+		`{"sku":"BKT-1","gtin":null,"item_name":"Bucket","item_price":900,"quantity":8}` + "\n" +
+		`{"sku":"SPG-1","item_name":"Sponge","item_price":300,"quantity":20}` + "\n" +
+		`{"sku":` + "\n"
+
+	report, err = shop.ImportShopItemsContext(ctx, strings.NewReader(input), CatalogJSONLines, now.Add(time.Hour))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, &ImportReport{Created: 1, Updated: 1, Unchanged: 1, Failed: 1, Rows: report.Rows}, report)
+	assert.Equal(t, []ImportAction{ImportUnchanged, ImportUpdated, ImportCreated, ImportFailed}, []ImportAction{
+		report.Rows[0].Action, report.Rows[1].Action, report.Rows[2].Action, report.Rows[3].Action,
+	})
+	assert.Equal(t, 4, report.Rows[3].Row)
+	assert.Len(t, stripeServer.Prices(), 3, "only the new item needs a stripe price")
+
+	bucket, err := shop.items.FindOneBySKU(ctx, "BKT-1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 8, bucket.Quantity)
+		assert.Equal(t, report.Rows[1].ShopItemID, bucket.ID)
+	}
+
+	var output bytes.Buffer
+	if assert.NoError(t, shop.ExportShopItemsContext(ctx, &output, CatalogCSV)) {
+		lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+		assert.Equal(t, "sku,gtin,item_name,item_picture,item_price,item_sale_price,item_description,shippable,weight_grams,tax_class,quantity", lines[0])
+		assert.ElementsMatch(t, []string{
+			"MOP-1,,Mop,,2000,1500,,true,,,3",
+			"BKT-1,,Bucket,,900,,,false,,,8",
+			"SPG-1,,Sponge,,300,,,false,,,20",
+		}, lines[1:])
+	}
+
+	exported := output.String()
+	report, err = shop.ImportShopItemsContext(ctx, strings.NewReader(exported), CatalogCSV, now)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, report.Unchanged)
+	}
+
+	output.Reset()
+	if assert.NoError(t, shop.ExportShopItemsContext(ctx, &output, CatalogJSONLines)) {
+		assert.Equal(t, 3, strings.Count(output.String(), "\n"))
+		assert.Contains(t, output.String(), `{"sku":"BKT-1","gtin":null,"item_name":"Bucket","item_picture":null,"item_price":900,"item_sale_price":null,"item_description":null,"shippable":false,"weight_grams":null,"tax_class":"","quantity":8}`)
+	}
+
+	sponge, err := shop.items.FindOneBySKU(ctx, "SPG-1")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, shop.NewShopItem().DeleteContext(ctx, sponge.ID, now))
+	products := len(stripeServer.Products())
+
+	report, err = shop.ImportShopItemsContext(ctx, strings.NewReader("sku,item_name,item_price,quantity\nSPG-1,Sponge,300,20\n"), CatalogCSV, now)
+	if assert.NoError(t, err) && assert.Len(t, report.Rows, 1) {
+		assert.Equal(t, ImportFailed, report.Rows[0].Action)
+		assert.True(t, errors.Is(report.Rows[0].Err, ErrSKUOfDeletedShopItem))
+		assert.Equal(t, sponge.ID, report.Rows[0].ShopItemID)
+	}
+	assert.Len(t, stripeServer.Products(), products, "no stripe product should be created for the SKU of a deleted item")
+}
+
+func TestShop_ImportShopItemsContext_existingItems(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	stripetest.Start(t)
+	storage := NewMemoryStorage()
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(storage))
+
+	input := "sku,gtin,item_name,item_picture,item_price,item_sale_price,item_description,shippable,weight_grams,tax_class,quantity\n" +
+		"MOP-1,4006381333931,Mop,mop.png,2000,1500,Wooden mop,true,800,reduced,5\n"
+	report, err := shop.ImportShopItemsContext(ctx, strings.NewReader(input), CatalogCSV, now)
+	if !assert.NoError(t, err) || !assert.Equal(t, 1, report.Created) {
+		return
+	}
+
+	created, err := storage.ShopItems().FindOneBySKU(ctx, "MOP-1")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	report, err = shop.ImportShopItemsContext(ctx, strings.NewReader("sku,item_name,item_price,quantity\nMOP-1,Wet mop,2000,4\n"), CatalogCSV, now)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, report.Updated)
+	}
+
+	report, err = shop.ImportShopItemsContext(ctx, strings.NewReader(`{"sku":"MOP-1","item_name":"Wet mop","item_price":2000,"quantity":7}`), CatalogJSONLines, now)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, report.Updated)
+	}
+
+	updated, err := storage.ShopItems().FindOneBySKU(ctx, "MOP-1")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "Wet mop", updated.ItemName)
+	assert.Equal(t, 7, updated.Quantity)
+	assert.Equal(t, created.GTIN, updated.GTIN, "columns missing from the input must be left alone")
+	assert.Equal(t, created.ItemPicture, updated.ItemPicture)
+	assert.Equal(t, created.ItemSalePrice, updated.ItemSalePrice)
+	assert.Equal(t, created.ItemDescription, updated.ItemDescription)
+	assert.Equal(t, created.WeightGrams, updated.WeightGrams)
+	assert.Equal(t, TaxClassReduced, updated.TaxClass)
+	assert.True(t, updated.Shippable)
+
+	// orders sell the item out
+	updated.Quantity = 0
+	assert.NoError(t, storage.ShopItems().UpdateColumns(ctx, updated, []string{"quantity"}))
+
+	for _, format := range []CatalogFormat{CatalogCSV, CatalogJSONLines} {
+		var output bytes.Buffer
+		if !assert.NoError(t, shop.ExportShopItemsContext(ctx, &output, format)) {
+			return
+		}
+
+		report, err = shop.ImportShopItemsContext(ctx, &output, format, now)
+		if assert.NoError(t, err, format) && assert.Len(t, report.Rows, 1, format) {
+			assert.NoError(t, report.Rows[0].Err, format)
+			assert.Equal(t, ImportUnchanged, report.Rows[0].Action, format)
+		}
+	}
+
+	report, err = shop.ImportShopItemsContext(ctx, strings.NewReader("sku,item_name,item_price,quantity\nBKT-1,Bucket,900,0\n"), CatalogCSV, now)
+	if assert.NoError(t, err) && assert.Len(t, report.Rows, 1) {
+		assert.Equal(t, ValidationErrors{{Field: "quantity", Err: ErrShopItemQuantityZeroOrNegative}}, report.Rows[0].Err, "new items need stock")
+	}
+}
+
+func TestShop_ImportShopItemsContext_invalidInput(t *testing.T) {
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(NewMemoryStorage()))
+
+	_, err := shop.ImportShopItemsContext(context.Background(), strings.NewReader(""), "xml", time.Now())
+	assert.Equal(t, ErrUnsupportedCatalogFormat, err)
+
+	_, err = shop.ImportShopItemsContext(context.Background(), strings.NewReader("sku,item_name,quantity\n"), CatalogCSV, time.Now())
+	assert.True(t, errors.Is(err, ErrCatalogColumnMissing))
+	assert.EqualError(t, err, "catalog_column_missing: item_price")
+
+	report, err := shop.ImportShopItemsContext(context.Background(), strings.NewReader(""), CatalogCSV, time.Now())
+	if assert.NoError(t, err) {
+		assert.Empty(t, report.Rows)
+	}
+}
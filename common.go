@@ -1,25 +1,14 @@
 package mop_shop
 
 import (
-	"gorm.io/gorm"
-	"log"
+	"net/http"
+	"net/url"
+	"strconv"
 )
 
 const ItemsPerPageMax = 50
 const ItemsPerPageDefault = 20
 
-func getLastInsertedID(db *gorm.DB) (int, error) {
-	lastInsertedID := 0
-	lastInsertIDQuery := `SELECT LAST_INSERT_ID()`
-
-	if err := db.Debug().Raw(lastInsertIDQuery).Scan(&lastInsertedID).Error; err != nil {
-		log.Printf("error occurred while fetching last insert ID: %v\n", err)
-		return 0, ErrInternal
-	}
-
-	return lastInsertedID, nil
-}
-
 type PaginationParams struct {
 	PerPage int
 	Before  int
@@ -50,3 +39,51 @@ type PaginationResponse struct {
 	Before       *string `json:"before"`
 	After        *string `json:"after"`
 }
+
+// pageCursors returns the cursors around a page of rows fetched by paginate, given the IDs of the rows in the order
+// they were fetched. Only rows from index from up to to belong to the page, the extra row just tells there is
+// another one.
+func pageCursors(req *http.Request, paginationParams PaginationParams, ids []int) (pages *PaginationResponse, from, to int, err error) {
+	parsedURL, err := url.Parse(getCurrentURL(req))
+	if err != nil {
+		return nil, 0, 0, ErrParsingURL
+	}
+
+	// cursor returns the current URL with param set to id and the opposite cursor removed
+	cursor := func(param, opposite string, id int) (*string, *string) {
+		query := parsedURL.Query()
+		query.Del(opposite)
+		query.Set(param, strconv.Itoa(id))
+
+		cursorURL := *parsedURL
+		cursorURL.RawQuery = query.Encode()
+
+		location, value := cursorURL.String(), strconv.Itoa(id)
+		return &location, &value
+	}
+
+	pages, from, to = &PaginationResponse{}, 0, len(ids)
+
+	switch {
+	case len(ids) == paginationParams.PerPage+1:
+		if paginationParams.After > 0 {
+			pages.CursorBefore, pages.Before = cursor("before", "after", ids[0]+1)
+		} else if paginationParams.Before > 0 {
+			pages.CursorBefore, pages.Before = cursor("before", "after", ids[0])
+		}
+
+		if paginationParams.Before > 0 {
+			pages.CursorAfter, pages.After = cursor("after", "before", ids[len(ids)-1]-1)
+			from = 1
+		} else {
+			pages.CursorAfter, pages.After = cursor("after", "before", ids[len(ids)-1])
+			to = len(ids) - 1
+		}
+	case len(ids) > 0 && paginationParams.Before > 0:
+		pages.CursorAfter, pages.After = cursor("after", "before", ids[len(ids)-1]-1)
+	case len(ids) > 0 && paginationParams.After > 0:
+		pages.CursorBefore, pages.Before = cursor("before", "after", ids[0]+1)
+	}
+
+	return pages, from, to, nil
+}
@@ -1,89 +1,11 @@
 package mop_shop
 
 import (
-	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/assert"
-	"gorm.io/driver/mysql"
-	"gorm.io/gorm"
-	"regexp"
 	"testing"
 )
 
-func Test_getLastInsertedID(t *testing.T) {
-	dbTest, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-	//goland:noinspection GoUnhandledErrorResult
-	defer dbTest.Close()
-
-	dialector := mysql.New(mysql.Config{
-		DSN:                       "sqlmock_db_0",
-		DriverName:                "postgres",
-		Conn:                      dbTest,
-		SkipInitializeWithVersion: true,
-	})
-
-	database, _ := gorm.Open(dialector, &gorm.Config{})
-
-	type args struct {
-		db *gorm.DB
-	}
-
-	type expectedMock struct {
-		expectedDBError error
-	}
-
-	tests := []struct {
-		name         string
-		args         args
-		expectedMock expectedMock
-		want         int
-		wantErr      error
-	}{
-		{
-			name: "All is good",
-			args: args{
-				db: database,
-			},
-			expectedMock: expectedMock{
-				expectedDBError: nil,
-			},
-			want:    0,
-			wantErr: nil,
-		},
-		{
-			name: "Internal error handle",
-			args: args{
-				db: database,
-			},
-			expectedMock: expectedMock{
-				expectedDBError: ErrInternal,
-			},
-			want:    0,
-			wantErr: ErrInternal,
-		},
-	}
-
-	query := `SELECT LAST_INSERT_ID()`
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			getRows := sqlmock.NewRows([]string{"LAST_INSERT_ID()"}).AddRow(tt.want)
-
-			mock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(getRows).WillReturnError(tt.expectedMock.expectedDBError)
-
-			got, validationErr := getLastInsertedID(tt.args.db)
-			assert.Equal(t, tt.wantErr, validationErr, "Validate() error = %v, wantErr %v", validationErr, tt.wantErr)
-
-			if got != tt.want {
-				t.Errorf("getLastInsertedID() got = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
 func TestPaginationParams_normalize(t *testing.T) {
 	type fields struct {
 		PerPage int
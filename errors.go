@@ -1,6 +1,19 @@
 package mop_shop
 
-import "errors"
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stripe/stripe-go/v72"
+	"gorm.io/gorm"
+	"net"
+	"net/http"
+	"strings"
+)
 
 var (
 	ErrItemNameBlank                         = errors.New("item_name_cannot_be_blank")
@@ -22,4 +35,319 @@ var (
 	ErrParsingURL                            = errors.New("could_not_parse_current_url")
 	ErrInsufficientProductStockAmount        = errors.New("insufficient_product_stock_amount")
 	ErrShopItemNotInitializedProperly        = errors.New("shop_item_is_not_initialized_via_constructor_or_invalid_db_provided")
+	ErrOrderNotInitializedProperly           = errors.New("user_order_is_not_initialized_via_constructor_or_invalid_db_provided")
+	ErrUnsupportedDialect                    = errors.New("unsupported_database_dialect")
+	ErrDuplicateKey                          = errors.New("duplicate_key")
+	ErrSKUInvalid                            = errors.New("sku_is_invalid")
+	ErrGTINInvalid                           = errors.New("gtin_is_invalid")
+	ErrGTINCheckDigit                        = errors.New("gtin_check_digit_mismatch")
+	ErrSKUBlank                              = errors.New("sku_cannot_be_blank")
+	ErrSKUDuplicatedInImport                 = errors.New("sku_is_duplicated_in_import")
+	ErrSKUOfDeletedShopItem                  = errors.New("sku_belongs_to_deleted_shop_item")
+	ErrInvalidNumber                         = errors.New("invalid_number")
+	ErrInvalidBoolean                        = errors.New("invalid_boolean")
+	ErrUnsupportedCatalogFormat              = errors.New("unsupported_catalog_format")
+	ErrCatalogColumnMissing                  = errors.New("catalog_column_missing")
+	ErrTooManyImages                         = errors.New("too_many_images")
+	ErrImageURLInvalid                       = errors.New("image_url_is_invalid")
+	ErrImageAltTextTooLong                   = errors.New("image_alt_text_too_long")
+	ErrImageDimensionsInvalid                = errors.New("image_dimensions_must_be_positive")
+	ErrBlobStoreNotConfigured                = errors.New("blob_store_is_not_configured")
+	ErrInvalidBlobKey                        = errors.New("invalid_blob_key")
+	ErrUploadEmpty                           = errors.New("upload_is_empty")
+	ErrUploadTooLarge                        = errors.New("upload_is_too_large")
+	ErrUploadContentTypeNotAllowed           = errors.New("upload_content_type_not_allowed")
+	ErrUploadContentTypeMismatch             = errors.New("upload_content_type_does_not_match_content")
+	ErrImageCorrupt                          = errors.New("image_cannot_be_decoded")
+	ErrImageResolutionTooHigh                = errors.New("image_resolution_too_high")
+	ErrLocaleInvalid                         = errors.New("locale_is_invalid")
+	ErrDefaultLocaleTranslation              = errors.New("default_locale_translation_cannot_be_deleted")
+	ErrShopItemWeightNegative                = errors.New("weight_cannot_be_negative")
+	ErrAddressFieldBlank                     = errors.New("address_field_cannot_be_blank")
+	ErrCountryInvalid                        = errors.New("country_is_invalid")
+	ErrShippingAddressRequired               = errors.New("shipping_address_is_required")
+	ErrShippingMethodRequired                = errors.New("shipping_method_is_required")
+	ErrShippingMethodUnknown                 = errors.New("shipping_method_is_unknown")
+	ErrShippingNotAvailable                  = errors.New("shipping_method_does_not_ship_to_country")
+	ErrShippingCountriesUnknown              = errors.New("shipping_countries_are_unknown")
+	ErrTaxClassUnknown                       = errors.New("tax_class_is_unknown")
+	ErrVATIDInvalid                          = errors.New("vat_id_is_invalid")
+	ErrInvoicingNotConfigured                = errors.New("invoicing_is_not_configured")
+	ErrTransactionsNotConfigured             = errors.New("transactions_are_not_configured")
+	// ErrVersionConflict is returned by repositories when a versioned write found the row changed or gone
+	ErrVersionConflict = errors.New("shop_item_version_conflict")
+)
+
+// FieldError is a validation failure of a single field, Field being a path such as items[3].quantity
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e FieldError) Unwrap() error {
+	return e.Err
+}
+
+func (e FieldError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Field string `json:"field"`
+		Error string `json:"error"`
+	}{
+		Field: e.Field,
+		Error: e.Err.Error(),
+	})
+}
+
+// ValidationErrors collects every field that failed validation. It matches each of the underlying errors
+// via errors.Is, so checks such as errors.Is(err, ErrItemNameBlank) keep working.
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, 0, len(v))
+	for i := range v {
+		messages = append(messages, v[i].Error())
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+func (v ValidationErrors) Is(target error) bool {
+	for i := range v {
+		if errors.Is(v[i].Err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *ValidationErrors) add(field string, err error) {
+	*v = append(*v, FieldError{Field: field, Err: err})
+}
+
+// without returns the errors other than err of field
+func (v ValidationErrors) without(field string, err error) ValidationErrors {
+	var kept ValidationErrors
+	for i := range v {
+		if v[i].Field != field || v[i].Err != err {
+			kept = append(kept, v[i])
+		}
+	}
+
+	return kept
+}
+
+// errOrNil is needed so that an empty ValidationErrors is returned as an untyped nil error
+func (v ValidationErrors) errOrNil() error {
+	if len(v) == 0 {
+		return nil
+	}
+
+	return v
+}
+
+// OpError wraps a failure of the database or Stripe with the operation that caused it. It matches its Kind
+// (ErrInternal unless stated otherwise) via errors.Is, while errors.As and errors.Unwrap reach the cause.
+type OpError struct {
+	Op   string
+	Kind error
+	Err  error
+}
+
+func (e *OpError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+func (e *OpError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func wrapInternal(op string, err error) error {
+	return &OpError{Op: op, Kind: ErrInternal, Err: err}
+}
+
+// StripeLeftover is a Stripe object which stayed active because archiving it after a failed operation failed too
+type StripeLeftover struct {
+	// Object is either "product" or "price"
+	Object string `json:"object"`
+	ID     string `json:"id"`
+	Err    error  `json:"-"`
+}
+
+// CompensationError is returned when an operation failed after creating Stripe objects and some of them couldn't
+// be archived. Err is the original failure, Leftovers have to be archived manually or through ReconcileCatalog.
+type CompensationError struct {
+	Err       error
+	Leftovers []StripeLeftover
+}
+
+func (e *CompensationError) Error() string {
+	ids := make([]string, 0, len(e.Leftovers))
+	for _, leftover := range e.Leftovers {
+		ids = append(ids, leftover.Object+" "+leftover.ID)
+	}
+
+	return e.Err.Error() + " (left active in stripe: " + strings.Join(ids, ", ") + ")"
+}
+
+func (e *CompensationError) Unwrap() error {
+	return e.Err
+}
+
+// VersionConflictError is returned when a shop item changed since the given version was read, it matches
+// ErrVersionConflict via errors.Is. CurrentVersion is 0 when the item was deleted in the meantime.
+type VersionConflictError struct {
+	ShopItemID      int
+	ExpectedVersion int
+	CurrentVersion  int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("shop item %d has version %d, expected %d", e.ShopItemID, e.CurrentVersion, e.ExpectedVersion)
+}
+
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
+// MySQL server error numbers, see https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+const (
+	mysqlErrDuplicateEntry   = 1062
+	mysqlErrLockWaitTimeout  = 1205
+	mysqlErrDeadlock         = 1213
+	mysqlErrQueryInterrupted = 1317
 )
+
+// PostgreSQL SQLSTATE codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	postgresUniqueViolation      = "23505"
+	postgresSerializationFailure = "40001"
+	postgresDeadlockDetected     = "40P01"
+	postgresLockNotAvailable     = "55P03"
+	postgresQueryCanceled        = "57014"
+)
+
+// sqlStateError is implemented by the errors of both PostgreSQL drivers, pgconn.PgError and pq.Error, so they
+// are recognised without importing either
+type sqlStateError interface {
+	SQLState() string
+}
+
+func postgresCode(err error) (string, bool) {
+	var stateErr sqlStateError
+	if errors.As(err, &stateErr) {
+		return stateErr.SQLState(), true
+	}
+
+	return "", false
+}
+
+// sqliteMessage reports whether err reads like an SQLite error starting with one of prefixes. SQLite drivers
+// share nothing but the messages of sqlite3_errmsg, and importing one would force cgo onto every user.
+func sqliteMessage(err error, prefixes ...string) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(err.Error(), prefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// IsRetryable reports whether err is transient, such as a deadlock, a lock wait or connection timeout or
+// Stripe rate limiting, so that the operation may succeed if tried again
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case mysqlErrDeadlock, mysqlErrLockWaitTimeout, mysqlErrQueryInterrupted:
+			return true
+		}
+	}
+
+	if code, ok := postgresCode(err); ok {
+		switch code {
+		case postgresSerializationFailure, postgresDeadlockDetected, postgresLockNotAvailable, postgresQueryCanceled:
+			return true
+		}
+	}
+
+	if sqliteMessage(err, "database is locked", "database table is locked") {
+		return true
+	}
+
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) {
+		return stripeErr.Type == stripe.ErrorTypeAPIConnection ||
+			stripeErr.Code == stripe.ErrorCodeRateLimit ||
+			stripeErr.HTTPStatusCode == http.StatusTooManyRequests ||
+			stripeErr.HTTPStatusCode >= http.StatusInternalServerError
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+
+	return false
+}
+
+// IsConflict reports whether err was caused by a duplicate key, a version conflict or an already existing Stripe
+// resource
+func IsConflict(err error) bool {
+	if errors.Is(err, ErrDuplicateKey) || errors.Is(err, ErrVersionConflict) {
+		return true
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrDuplicateEntry
+	}
+
+	if code, ok := postgresCode(err); ok {
+		return code == postgresUniqueViolation
+	}
+
+	if sqliteMessage(err, "UNIQUE constraint failed", "PRIMARY KEY must be unique") {
+		return true
+	}
+
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) {
+		return stripeErr.Code == stripe.ErrorCodeResourceAlreadyExists || stripeErr.HTTPStatusCode == http.StatusConflict
+	}
+
+	return false
+}
+
+// IsNotFound reports whether err means that the requested row or Stripe resource doesn't exist
+func IsNotFound(err error) bool {
+	if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, sql.ErrNoRows) {
+		return true
+	}
+
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) {
+		return stripeErr.Code == stripe.ErrorCodeResourceMissing || stripeErr.HTTPStatusCode == http.StatusNotFound
+	}
+
+	return false
+}
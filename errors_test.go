@@ -0,0 +1,129 @@
+package mop_shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v72"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func TestOpError(t *testing.T) {
+	cause := &mysql.MySQLError{Number: mysqlErrDeadlock, Message: "Deadlock found when trying to get lock"}
+	err := wrapInternal("ShopItem.Update: update shop item", cause)
+
+	assert.True(t, errors.Is(err, ErrInternal))
+	assert.False(t, errors.Is(err, ErrCommittingTransaction))
+	assert.Equal(t, "ShopItem.Update: update shop item: Error 1213: Deadlock found when trying to get lock", err.Error())
+
+	var mysqlErr *mysql.MySQLError
+	assert.True(t, errors.As(err, &mysqlErr))
+	assert.Equal(t, cause, mysqlErr)
+}
+
+// testSQLStateError mimics pgconn.PgError and pq.Error
+type testSQLStateError struct {
+	code string
+}
+
+func (e *testSQLStateError) Error() string {
+	return "ERROR: SQLSTATE " + e.code
+}
+
+func (e *testSQLStateError) SQLState() string {
+	return e.code
+}
+
+func TestErrorClassification(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantRetryable bool
+		wantConflict  bool
+		wantNotFound  bool
+	}{
+		{
+			name: "Nil error",
+			err:  nil,
+		},
+		{
+			name: "Plain internal error",
+			err:  ErrInternal,
+		},
+		{
+			name:          "MySQL deadlock",
+			err:           wrapInternal("op", &mysql.MySQLError{Number: mysqlErrDeadlock}),
+			wantRetryable: true,
+		},
+		{
+			name:          "MySQL lock wait timeout",
+			err:           wrapInternal("op", &mysql.MySQLError{Number: mysqlErrLockWaitTimeout}),
+			wantRetryable: true,
+		},
+		{
+			name:         "MySQL duplicate entry",
+			err:          wrapInternal("op", &mysql.MySQLError{Number: mysqlErrDuplicateEntry}),
+			wantConflict: true,
+		},
+		{
+			name:         "Postgres unique violation",
+			err:          wrapInternal("op", &testSQLStateError{code: postgresUniqueViolation}),
+			wantConflict: true,
+		},
+		{
+			name:          "Postgres deadlock",
+			err:           wrapInternal("op", &testSQLStateError{code: postgresDeadlockDetected}),
+			wantRetryable: true,
+		},
+		{
+			name: "Postgres foreign key violation",
+			err:  wrapInternal("op", &testSQLStateError{code: "23503"}),
+		},
+		{
+			name:         "SQLite unique constraint",
+			err:          wrapInternal("op", errors.New("UNIQUE constraint failed: shop_items.sku")),
+			wantConflict: true,
+		},
+		{
+			name:          "SQLite busy",
+			err:           wrapInternal("op", errors.New("database is locked")),
+			wantRetryable: true,
+		},
+		{
+			name:          "Context deadline",
+			err:           wrapInternal("op", fmt.Errorf("query: %w", context.DeadlineExceeded)),
+			wantRetryable: true,
+		},
+		{
+			name:         "Record not found",
+			err:          gorm.ErrRecordNotFound,
+			wantNotFound: true,
+		},
+		{
+			name:          "Stripe rate limit",
+			err:           wrapInternal("op", &stripe.Error{Code: stripe.ErrorCodeRateLimit, HTTPStatusCode: 429}),
+			wantRetryable: true,
+		},
+		{
+			name:         "Stripe resource missing",
+			err:          wrapInternal("op", &stripe.Error{Code: stripe.ErrorCodeResourceMissing, HTTPStatusCode: 404}),
+			wantNotFound: true,
+		},
+		{
+			name:         "Stripe resource already exists",
+			err:          wrapInternal("op", &stripe.Error{Code: stripe.ErrorCodeResourceAlreadyExists, HTTPStatusCode: 400}),
+			wantConflict: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantRetryable, IsRetryable(tt.err), "IsRetryable(%v)", tt.err)
+			assert.Equal(t, tt.wantConflict, IsConflict(tt.err), "IsConflict(%v)", tt.err)
+			assert.Equal(t, tt.wantNotFound, IsNotFound(tt.err), "IsNotFound(%v)", tt.err)
+		})
+	}
+}
@@ -3,12 +3,15 @@ module github.com/croatiangrn/mop-shop
 go 1.16
 
 require (
-	github.com/DATA-DOG/go-sqlmock v1.5.0 // indirect
-	github.com/google/go-cmp v0.5.6 // indirect
-	github.com/google/uuid v1.3.0 // indirect
-	github.com/shopspring/decimal v1.2.0 // indirect
-	github.com/stretchr/testify v1.7.0 // indirect
-	github.com/stripe/stripe-go/v72 v72.64.1 // indirect
-	gorm.io/driver/mysql v1.1.2 // indirect
-	gorm.io/gorm v1.21.14 // indirect
+	github.com/DATA-DOG/go-sqlmock v1.5.0
+	github.com/go-sql-driver/mysql v1.6.0
+	github.com/google/go-cmp v0.5.6
+	github.com/google/uuid v1.3.0
+	github.com/mattn/go-sqlite3 v1.14.6 // indirect
+	github.com/shopspring/decimal v1.2.0
+	github.com/stretchr/testify v1.7.0
+	github.com/stripe/stripe-go/v72 v72.64.1
+	gorm.io/driver/mysql v1.1.2
+	gorm.io/driver/sqlite v1.1.4
+	gorm.io/gorm v1.21.14
 )
@@ -0,0 +1,321 @@
+package mop_shop
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v72"
+	"gorm.io/gorm"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// DefaultMaxUploadSize is the largest image upload accepted unless changed via WithMaxUploadSize
+const DefaultMaxUploadSize int64 = 10 << 20
+
+// MaxUploadImagePixels limits width times height of uploaded images, since small files can decode into huge images
+const MaxUploadImagePixels = 40 * 1000 * 1000
+
+// DefaultThumbnailSizes are the longer sides of thumbnails generated unless changed via WithThumbnailSizes
+var DefaultThumbnailSizes = []int{160, 480, 1024}
+
+// uploadImageFormats maps allowed content types to the extension of stored originals
+var uploadImageFormats = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+type uploadConfig struct {
+	maxSize        int64
+	thumbnailSizes []int
+}
+
+func defaultUploadConfig() uploadConfig {
+	return uploadConfig{maxSize: DefaultMaxUploadSize, thumbnailSizes: append([]int{}, DefaultThumbnailSizes...)}
+}
+
+// ImageUpload is an image file uploaded for a shop item
+type ImageUpload struct {
+	// ContentType is the type declared by the client, e.g. the Content-Type of a multipart file. When set it has to
+	// match the type detected from Data.
+	ContentType string
+	AltText     *string
+	Data        io.Reader
+}
+
+func UploadShopItemImage(shopItemID int, upload *ImageUpload, blobs BlobStore, db *gorm.DB) (*ShopItemImage, error) {
+	return NewShop(db, stripe.Key, WithBlobStore(blobs)).UploadShopItemImage(shopItemID, upload)
+}
+
+func UploadShopItemImageContext(ctx context.Context, shopItemID int, upload *ImageUpload, blobs BlobStore, db *gorm.DB) (*ShopItemImage, error) {
+	return NewShop(db, stripe.Key, WithBlobStore(blobs)).UploadShopItemImageContext(ctx, shopItemID, upload)
+}
+
+// UploadShopItemImage works like the package-level UploadShopItemImage
+func (s *Shop) UploadShopItemImage(shopItemID int, upload *ImageUpload) (*ShopItemImage, error) {
+	return s.UploadShopItemImageContext(context.Background(), shopItemID, upload)
+}
+
+// UploadShopItemImageContext validates the uploaded JPEG, PNG or GIF, stores it in the BlobStore together with
+// thumbnails smaller than the image and appends it after the item's last image. Concurrent uploads to the same item
+// are appended one after another, none of them replaces the others. Problems with the upload are returned as
+// ValidationErrors for the fields file and alt_text, a full item as a ValidationError for the field images.
+//
+// Blobs stored by a failed upload are deleted again, blobs of images later removed via SetShopItemImages are kept.
+// The first image of an item also becomes the image of its Stripe product. When that fails the upload still
+// succeeds, the failure is only logged like archiving failures of ShopItem.Delete.
+func (s *Shop) UploadShopItemImageContext(ctx context.Context, shopItemID int, upload *ImageUpload) (*ShopItemImage, error) {
+	if s.items == nil || s.images == nil {
+		return nil, ErrShopItemNotInitializedProperly
+	}
+
+	if s.blobs == nil {
+		return nil, ErrBlobStoreNotConfigured
+	}
+
+	if upload == nil || upload.Data == nil {
+		return nil, ValidationErrors{{Field: "file", Err: ErrUploadEmpty}}
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(upload.Data, s.uploads.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	contentType, src, err := s.decodeUpload(upload, data)
+	if err != nil {
+		return nil, err
+	}
+
+	item := s.NewShopItem()
+	if err := item.FindOneByIDContext(ctx, shopItemID); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.images.FindByShopItemIDs(ctx, []int{shopItemID})
+	if err != nil {
+		s.log(ctx, LevelError, "error while getting shop item images", "shop_item_id", shopItemID, "error", err)
+		return nil, wrapInternal("UploadShopItemImage: query images", err)
+	}
+
+	// checked again by Append, this only avoids storing blobs for an item that is already full
+	if len(existing) >= MaxShopItemImages {
+		return nil, ValidationErrors{{Field: "images", Err: ErrTooManyImages}}
+	}
+
+	stored, keys, err := s.storeUpload(ctx, shopItemID, contentType, data, src)
+	if err != nil {
+		s.deleteBlobs(keys)
+		s.log(ctx, LevelError, "error while storing uploaded image", "shop_item_id", shopItemID, "error", err)
+		return nil, wrapInternal("UploadShopItemImage: store blob", err)
+	}
+	stored.AltText = upload.AltText
+
+	if err := s.images.Append(ctx, shopItemID, stored, MaxShopItemImages); err != nil {
+		s.deleteBlobs(keys)
+		if errors.Is(err, ErrTooManyImages) {
+			return nil, ValidationErrors{{Field: "images", Err: ErrTooManyImages}}
+		}
+
+		s.log(ctx, LevelError, "error while appending shop item image", "shop_item_id", shopItemID, "error", err)
+		return nil, wrapInternal("UploadShopItemImage: append image", err)
+	}
+
+	// The image is stored already, failing now would only make clients upload it again
+	if stored.Position == 0 {
+		if err := updateStripeProductImage(ctx, item.StripeProductApiID, stored.URL); err != nil {
+			s.log(ctx, LevelError, "error while updating stripe product image, keeping the uploaded image", "shop_item_id", shopItemID, "stripe_product_id", item.StripeProductApiID, "error", err)
+		}
+	}
+
+	return stored, nil
+}
+
+// decodeUpload checks size, content type and resolution of the upload and decodes it
+func (s *Shop) decodeUpload(upload *ImageUpload, data []byte) (string, image.Image, error) {
+	var validationErrors ValidationErrors
+	if upload.AltText != nil && len(*upload.AltText) > 255 {
+		validationErrors.add("alt_text", ErrImageAltTextTooLong)
+	}
+
+	contentType := http.DetectContentType(data)
+	switch {
+	case len(data) == 0:
+		validationErrors.add("file", ErrUploadEmpty)
+	case int64(len(data)) > s.uploads.maxSize:
+		validationErrors.add("file", ErrUploadTooLarge)
+	case len(uploadImageFormats[contentType]) == 0:
+		validationErrors.add("file", ErrUploadContentTypeNotAllowed)
+	case len(upload.ContentType) > 0 && declaredContentType(upload.ContentType) != contentType:
+		validationErrors.add("file", ErrUploadContentTypeMismatch)
+	}
+
+	if len(validationErrors) > 0 {
+		return "", nil, validationErrors
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width <= 0 || config.Height <= 0 {
+		return "", nil, ValidationErrors{{Field: "file", Err: ErrImageCorrupt}}
+	}
+
+	if int64(config.Width)*int64(config.Height) > MaxUploadImagePixels {
+		return "", nil, ValidationErrors{{Field: "file", Err: ErrImageResolutionTooHigh}}
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", nil, ValidationErrors{{Field: "file", Err: ErrImageCorrupt}}
+	}
+
+	return contentType, src, nil
+}
+
+// declaredContentType returns the media type without parameters, image/jpg is accepted as image/jpeg
+func declaredContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	if mediaType == "image/jpg" || mediaType == "image/pjpeg" {
+		return "image/jpeg"
+	}
+
+	return mediaType
+}
+
+// storeUpload puts the original and its thumbnails into the BlobStore under a new prefix, keys stored so far are
+// returned even on failure so they can be deleted
+func (s *Shop) storeUpload(ctx context.Context, shopItemID int, contentType string, data []byte, src image.Image) (*ShopItemImage, []string, error) {
+	prefix := "shop-items/" + strconv.Itoa(shopItemID) + "/" + uuid.New().String() + "/"
+
+	var keys []string
+	put := func(key, contentType string, data []byte) (string, error) {
+		url, err := s.blobs.Put(ctx, key, contentType, bytes.NewReader(data))
+		if err == nil {
+			keys = append(keys, key)
+		}
+
+		return url, err
+	}
+
+	url, err := put(prefix+"original."+uploadImageFormats[contentType], contentType, data)
+	if err != nil {
+		return nil, keys, err
+	}
+
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	stored := &ShopItemImage{URL: url, Width: &width, Height: &height}
+
+	sizes := append([]int{}, s.uploads.thumbnailSizes...)
+	sort.Ints(sizes)
+	for i, size := range sizes {
+		if size <= 0 || size >= width && size >= height || (i > 0 && sizes[i-1] == size) {
+			continue
+		}
+
+		thumbnail := scaleImage(src, size)
+
+		// JPEGs stay JPEGs, PNGs and GIFs may be transparent
+		var encoded bytes.Buffer
+		thumbnailType, extension := "image/png", "png"
+		if contentType == "image/jpeg" {
+			thumbnailType, extension = "image/jpeg", "jpg"
+			err = jpeg.Encode(&encoded, thumbnail, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&encoded, thumbnail)
+		}
+
+		if err != nil {
+			return nil, keys, err
+		}
+
+		url, err := put(prefix+strconv.Itoa(size)+"."+extension, thumbnailType, encoded.Bytes())
+		if err != nil {
+			return nil, keys, err
+		}
+
+		stored.Thumbnails = append(stored.Thumbnails, ImageThumbnail{
+			Size:   size,
+			URL:    url,
+			Width:  thumbnail.Bounds().Dx(),
+			Height: thumbnail.Bounds().Dy(),
+		})
+	}
+
+	return stored, keys, nil
+}
+
+// deleteBlobs removes blobs of a failed upload, it doesn't use the request's context since that may be the reason
+// the upload failed
+func (s *Shop) deleteBlobs(keys []string) {
+	for _, key := range keys {
+		if err := s.blobs.Delete(context.Background(), key); err != nil {
+			s.logger.Log(LevelError, "error while deleting blob of failed upload", "key", key, "error", err)
+		}
+	}
+}
+
+// scaleImage scales src down so its longer side is size pixels, every target pixel is the average of the source
+// pixels it covers
+func scaleImage(src image.Image, size int) *image.RGBA {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	targetWidth, targetHeight := size, size
+	if width >= height {
+		targetHeight = int(math.Max(1, math.Round(float64(height)*float64(size)/float64(width))))
+	} else {
+		targetWidth = int(math.Max(1, math.Round(float64(width)*float64(size)/float64(height))))
+	}
+
+	// Premultiplied alpha makes averaging transparent pixels correct
+	source := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(source, source.Bounds(), src, bounds.Min, draw.Src)
+
+	target := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	for y := 0; y < targetHeight; y++ {
+		y0, y1 := y*height/targetHeight, (y+1)*height/targetHeight
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+
+		for x := 0; x < targetWidth; x++ {
+			x0, x1 := x*width/targetWidth, (x+1)*width/targetWidth
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := source.Pix[sy*source.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+
+			count := (y1 - y0) * (x1 - x0)
+			offset := y*target.Stride + x*4
+			for c := 0; c < 4; c++ {
+				target.Pix[offset+c] = uint8((sum[c] + count/2) / count)
+			}
+		}
+	}
+
+	return target
+}
@@ -0,0 +1,244 @@
+package mop_shop
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/croatiangrn/mop-shop/stripetest"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func encodeTestPNG(t *testing.T, width, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestShop_UploadShopItemImageContext(t *testing.T) {
+	ctx := context.Background()
+	stripeServer := stripetest.Start(t)
+	dir := t.TempDir()
+	shop := NewShop(nil, "sk_test",
+		WithMemoryStorage(NewMemoryStorage()),
+		WithBlobStore(NewLocalBlobStore(dir, "https://shop.example.com/media/")),
+		WithThumbnailSizes(480, 160, 1024),
+	)
+
+	item := shop.NewShopItem()
+	if !assert.NoError(t, item.CreateContext(ctx, &ShopItemCreate{ItemName: "Mop", ItemPrice: 2000, Quantity: 3}, time.Now())) {
+		return
+	}
+
+	altText := "Blue mop"
+	uploaded, err := shop.UploadShopItemImageContext(ctx, item.ID, &ImageUpload{ContentType: "image/png", AltText: &altText, Data: bytes.NewReader(encodeTestPNG(t, 800, 400))})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, strings.HasPrefix(uploaded.URL, "https://shop.example.com/media/shop-items/1/"))
+	assert.True(t, strings.HasSuffix(uploaded.URL, "/original.png"))
+	assert.Equal(t, 800, *uploaded.Width)
+	assert.Equal(t, 400, *uploaded.Height)
+	assert.Equal(t, &altText, uploaded.AltText)
+	if assert.Len(t, uploaded.Thumbnails, 2) {
+		assert.Equal(t, 160, uploaded.Thumbnails[0].Size)
+		assert.Equal(t, 160, uploaded.Thumbnails[0].Width)
+		assert.Equal(t, 80, uploaded.Thumbnails[0].Height)
+		assert.Equal(t, 480, uploaded.Thumbnails[1].Size)
+		assert.Equal(t, 240, uploaded.Thumbnails[1].Height)
+
+		file, err := os.Open(filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(uploaded.Thumbnails[0].URL, "https://shop.example.com/media/"))))
+		if assert.NoError(t, err) {
+			config, format, err := image.DecodeConfig(file)
+			file.Close()
+			assert.NoError(t, err)
+			assert.Equal(t, "png", format)
+			assert.Equal(t, 160, config.Width)
+		}
+	}
+
+	product, _ := stripeServer.Product(item.StripeProductApiID)
+	assert.Equal(t, []string{uploaded.URL}, product.Images)
+
+	images, err := shop.FindShopItemImagesContext(ctx, item.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []ShopItemImage{*uploaded}, images)
+
+	t.Run("Invalid uploads", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			upload *ImageUpload
+			want   error
+		}{
+			{name: "Empty", upload: &ImageUpload{Data: strings.NewReader("")}, want: ErrUploadEmpty},
+			{name: "Not an image", upload: &ImageUpload{Data: strings.NewReader("<html></html>")}, want: ErrUploadContentTypeNotAllowed},
+			{name: "Declared type mismatch", upload: &ImageUpload{ContentType: "image/jpeg", Data: bytes.NewReader(encodeTestPNG(t, 10, 10))}, want: ErrUploadContentTypeMismatch},
+			{name: "Truncated", upload: &ImageUpload{Data: bytes.NewReader(encodeTestPNG(t, 10, 10)[:40])}, want: ErrImageCorrupt},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := shop.UploadShopItemImageContext(ctx, item.ID, tt.upload)
+
+				var validationErrors ValidationErrors
+				if assert.True(t, errors.As(err, &validationErrors)) {
+					assert.Equal(t, ValidationErrors{{Field: "file", Err: tt.want}}, validationErrors)
+				}
+			})
+		}
+
+		limited := NewShop(nil, "sk_test", WithMemoryStorage(NewMemoryStorage()), WithBlobStore(NewLocalBlobStore(dir, "https://shop.example.com/media")), WithMaxUploadSize(64))
+		_, err := limited.UploadShopItemImageContext(ctx, item.ID, &ImageUpload{Data: bytes.NewReader(encodeTestPNG(t, 100, 100))})
+		assert.True(t, errors.Is(err, ErrUploadTooLarge))
+	})
+
+	t.Run("Stripe failure keeps the stored image", func(t *testing.T) {
+		other := shop.NewShopItem()
+		if !assert.NoError(t, other.CreateContext(ctx, &ShopItemCreate{ItemName: "Bucket", ItemPrice: 900, Quantity: 3}, time.Now())) {
+			return
+		}
+
+		stripeServer.FailNext(http.MethodPost, "/v1/products/"+other.StripeProductApiID, http.StatusServiceUnavailable, "")
+		uploaded, err := shop.UploadShopItemImageContext(ctx, other.ID, &ImageUpload{Data: bytes.NewReader(encodeTestPNG(t, 10, 10))})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		images, err := shop.FindShopItemImagesContext(ctx, other.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, []ShopItemImage{*uploaded}, images)
+
+		product, _ := stripeServer.Product(other.StripeProductApiID)
+		assert.Empty(t, product.Images)
+	})
+
+	t.Run("Missing item leaves no blobs", func(t *testing.T) {
+		before, _ := filepath.Glob(filepath.Join(dir, "shop-items", "*", "*"))
+		_, err := shop.UploadShopItemImageContext(ctx, 99, &ImageUpload{Data: bytes.NewReader(encodeTestPNG(t, 10, 10))})
+		assert.True(t, IsNotFound(err))
+
+		after, _ := filepath.Glob(filepath.Join(dir, "shop-items", "*", "*"))
+		assert.Equal(t, before, after)
+	})
+}
+
+// barrierBlobStore holds every Put until n of them are waiting, so all uploads read the item's images before any
+// of them is appended
+type barrierBlobStore struct {
+	BlobStore
+	arrived sync.WaitGroup
+}
+
+func (b *barrierBlobStore) Put(ctx context.Context, key, contentType string, data io.Reader) (string, error) {
+	b.arrived.Done()
+	b.arrived.Wait()
+	return b.BlobStore.Put(ctx, key, contentType, data)
+}
+
+func TestShop_UploadShopItemImageContext_concurrent(t *testing.T) {
+	ctx := context.Background()
+	stripeServer := stripetest.Start(t)
+	dir := t.TempDir()
+	blobs := &barrierBlobStore{BlobStore: NewLocalBlobStore(dir, "https://shop.example.com/media/")}
+	blobs.arrived.Add(MaxShopItemImages + 1)
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(NewMemoryStorage()), WithBlobStore(blobs))
+
+	item := shop.NewShopItem()
+	if !assert.NoError(t, item.CreateContext(ctx, &ShopItemCreate{ItemName: "Mop", ItemPrice: 2000, Quantity: 3}, time.Now())) {
+		return
+	}
+
+	data := encodeTestPNG(t, 10, 10)
+	var wg sync.WaitGroup
+	errs := make([]error, MaxShopItemImages+1)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = shop.UploadShopItemImageContext(ctx, item.ID, &ImageUpload{Data: bytes.NewReader(data)})
+		}(i)
+	}
+	wg.Wait()
+
+	var full int
+	for _, err := range errs {
+		var validationErrors ValidationErrors
+		if errors.As(err, &validationErrors) {
+			assert.Equal(t, ValidationErrors{{Field: "images", Err: ErrTooManyImages}}, validationErrors)
+			full++
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+	assert.Equal(t, 1, full)
+
+	images, err := shop.FindShopItemImagesContext(ctx, item.ID)
+	if assert.NoError(t, err) && assert.Len(t, images, MaxShopItemImages) {
+		for i, image := range images {
+			assert.Equal(t, i, image.Position)
+		}
+
+		product, _ := stripeServer.Product(item.StripeProductApiID)
+		assert.Equal(t, []string{images[0].URL}, product.Images)
+	}
+
+	stored, _ := filepath.Glob(filepath.Join(dir, "shop-items", "*", "*", "original.png"))
+	assert.Len(t, stored, MaxShopItemImages)
+}
+
+func TestLocalBlobStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := NewLocalBlobStore(dir, "https://shop.example.com/media")
+
+	url, err := store.Put(ctx, "a/b.txt", "text/plain", strings.NewReader("hello"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "https://shop.example.com/media/a/b.txt", url)
+		data, _ := ioutil.ReadFile(filepath.Join(dir, "a", "b.txt"))
+		assert.Equal(t, "hello", string(data))
+	}
+
+	for _, key := range []string{"", "../escape.txt", "a/../../escape.txt", "/absolute.txt", "a//b.txt"} {
+		_, err := store.Put(ctx, key, "text/plain", strings.NewReader("x"))
+		assert.Equal(t, ErrInvalidBlobKey, err, key)
+	}
+
+	assert.NoError(t, store.Delete(ctx, "a/b.txt"))
+	assert.NoError(t, store.Delete(ctx, "a/b.txt"))
+	_, err = os.Stat(filepath.Join(dir, "a", "b.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_scaleImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		src.Set(x, 0, color.RGBA{R: 200, A: 255})
+		src.Set(x, 1, color.RGBA{B: 100, A: 255})
+	}
+
+	scaled := scaleImage(src, 2)
+	assert.Equal(t, image.Rect(0, 0, 2, 1), scaled.Bounds())
+	assert.Equal(t, color.RGBA{R: 100, B: 50, A: 255}, scaled.RGBAAt(0, 0))
+}
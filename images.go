@@ -0,0 +1,225 @@
+package mop_shop
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"net/url"
+	"strconv"
+)
+
+// MaxShopItemImages is the number of images a shop item may have, Stripe products accept up to 8 images too
+const MaxShopItemImages = 8
+
+// ShopItemImage is a picture of a shop item, images are shown ordered by Position and the first one is also used
+// by the Stripe product so checkout shows it
+type ShopItemImage struct {
+	ID         int     `gorm:"primaryKey" json:"id"`
+	ShopItemID int     `gorm:"not null;index:ix_shop_item_images_shop_item_id;" json:"shop_item_id"`
+	URL        string  `gorm:"not null;type:varchar(2048);" json:"url"`
+	Position   int     `gorm:"not null;index:ix_shop_item_images_shop_item_id;" json:"position"`
+	AltText    *string `gorm:"type:varchar(255);default:null;" json:"alt_text"`
+	Width      *int    `gorm:"default:null;" json:"width"`
+	Height     *int    `gorm:"default:null;" json:"height"`
+	// Thumbnails are set for uploaded images, see Shop.UploadShopItemImage
+	Thumbnails ImageThumbnails `gorm:"type:text;default:null;" json:"thumbnails"`
+}
+
+func (i *ShopItemImage) TableName() string {
+	return "shop_item_images"
+}
+
+// ImageThumbnail is a scaled down copy of an image, Size being the length of its longer side the image was scaled to
+type ImageThumbnail struct {
+	Size   int    `json:"size"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// ImageThumbnails is stored as a JSON array, ordered by Size ascending
+type ImageThumbnails []ImageThumbnail
+
+func (t ImageThumbnails) Value() (driver.Value, error) {
+	if len(t) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal([]ImageThumbnail(t))
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func (t *ImageThumbnails) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(data, t)
+	case string:
+		return json.Unmarshal([]byte(data), t)
+	default:
+		return fmt.Errorf("unsupported thumbnails type %T", value)
+	}
+}
+
+// validateShopItemImages returns ValidationErrors with fields such as images[2].url, or nil
+func validateShopItemImages(images []ShopItemImage) error {
+	var validationErrors ValidationErrors
+
+	if len(images) > MaxShopItemImages {
+		validationErrors.add("images", ErrTooManyImages)
+	}
+
+	for i := range images {
+		field := "images[" + strconv.Itoa(i) + "]"
+
+		if parsed, err := url.Parse(images[i].URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 {
+			validationErrors.add(field+".url", ErrImageURLInvalid)
+		}
+
+		if images[i].AltText != nil && len(*images[i].AltText) > 255 {
+			validationErrors.add(field+".alt_text", ErrImageAltTextTooLong)
+		}
+
+		if (images[i].Width != nil && *images[i].Width <= 0) || (images[i].Height != nil && *images[i].Height <= 0) {
+			validationErrors.add(field, ErrImageDimensionsInvalid)
+		}
+	}
+
+	return validationErrors.errOrNil()
+}
+
+func SetShopItemImages(shopItemID int, images []ShopItemImage, db *gorm.DB) ([]ShopItemImage, error) {
+	return defaultShop(db).SetShopItemImages(shopItemID, images)
+}
+
+func SetShopItemImagesContext(ctx context.Context, shopItemID int, images []ShopItemImage, db *gorm.DB) ([]ShopItemImage, error) {
+	return defaultShop(db).SetShopItemImagesContext(ctx, shopItemID, images)
+}
+
+// SetShopItemImages works like the package-level SetShopItemImages
+func (s *Shop) SetShopItemImages(shopItemID int, images []ShopItemImage) ([]ShopItemImage, error) {
+	return s.SetShopItemImagesContext(context.Background(), shopItemID, images)
+}
+
+// SetShopItemImagesContext replaces all images of a not soft-deleted item with images, positions follow their order
+// in the slice. The Stripe product gets the first image, or none, whenever the first image changes. Stored images
+// are returned with IDs set.
+func (s *Shop) SetShopItemImagesContext(ctx context.Context, shopItemID int, images []ShopItemImage) ([]ShopItemImage, error) {
+	if s.items == nil || s.images == nil {
+		return nil, ErrShopItemNotInitializedProperly
+	}
+
+	if err := validateShopItemImages(images); err != nil {
+		return nil, err
+	}
+
+	item := s.NewShopItem()
+	if err := item.FindOneByIDContext(ctx, shopItemID); err != nil {
+		return nil, err
+	}
+
+	previous, err := s.images.FindByShopItemIDs(ctx, []int{shopItemID})
+	if err != nil {
+		s.log(ctx, LevelError, "error while getting shop item images", "shop_item_id", shopItemID, "error", err)
+		return nil, wrapInternal("SetShopItemImages: query images", err)
+	}
+
+	stored := make([]ShopItemImage, len(images))
+	for i := range images {
+		stored[i] = images[i]
+		stored[i].ID, stored[i].ShopItemID, stored[i].Position = 0, shopItemID, i
+	}
+
+	if err := s.images.Replace(ctx, shopItemID, stored); err != nil {
+		s.log(ctx, LevelError, "error while replacing shop item images", "shop_item_id", shopItemID, "error", err)
+		return nil, wrapInternal("SetShopItemImages: replace images", err)
+	}
+
+	if firstImageURL(previous) != firstImageURL(stored) {
+		if err := updateStripeProductImage(ctx, item.StripeProductApiID, firstImageURL(stored)); err != nil {
+			s.log(ctx, LevelError, "error while updating stripe product image", "shop_item_id", shopItemID, "stripe_product_id", item.StripeProductApiID, "error", err)
+			return nil, wrapInternal("SetShopItemImages: update stripe product", err)
+		}
+	}
+
+	return stored, nil
+}
+
+func firstImageURL(images []ShopItemImage) string {
+	if len(images) == 0 {
+		return ""
+	}
+
+	return images[0].URL
+}
+
+func FindShopItemImages(shopItemID int, db *gorm.DB) ([]ShopItemImage, error) {
+	return defaultShop(db).FindShopItemImages(shopItemID)
+}
+
+func FindShopItemImagesContext(ctx context.Context, shopItemID int, db *gorm.DB) ([]ShopItemImage, error) {
+	return defaultShop(db).FindShopItemImagesContext(ctx, shopItemID)
+}
+
+// FindShopItemImages works like the package-level FindShopItemImages
+func (s *Shop) FindShopItemImages(shopItemID int) ([]ShopItemImage, error) {
+	return s.FindShopItemImagesContext(context.Background(), shopItemID)
+}
+
+// FindShopItemImagesContext returns images of the item ordered by position
+func (s *Shop) FindShopItemImagesContext(ctx context.Context, shopItemID int) ([]ShopItemImage, error) {
+	if s.images == nil {
+		return nil, ErrShopItemNotInitializedProperly
+	}
+
+	data, err := s.images.FindByShopItemIDs(ctx, []int{shopItemID})
+	if err != nil {
+		s.log(ctx, LevelError, "error while getting shop item images", "shop_item_id", shopItemID, "error", err)
+		return nil, wrapInternal("FindShopItemImages: query images", err)
+	}
+
+	if len(data) == 0 {
+		data = []ShopItemImage{}
+	}
+
+	return data, nil
+}
+
+// attachImages sets Images of every item in data, items without images get an empty list
+func (s *Shop) attachImages(ctx context.Context, data []ShopItemForResponse) error {
+	if s.images == nil || len(data) == 0 {
+		return nil
+	}
+
+	ids := make([]int, 0, len(data))
+	for i := range data {
+		ids = append(ids, data[i].ID)
+	}
+
+	images, err := s.images.FindByShopItemIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	byShopItemID := make(map[int][]ShopItemImage, len(data))
+	for _, image := range images {
+		byShopItemID[image.ShopItemID] = append(byShopItemID[image.ShopItemID], image)
+	}
+
+	for i := range data {
+		data[i].Images = byShopItemID[data[i].ID]
+		if data[i].Images == nil {
+			data[i].Images = []ShopItemImage{}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package mop_shop
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/croatiangrn/mop-shop/stripetest"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShop_SetShopItemImagesContext(t *testing.T) {
+	ctx := context.Background()
+	stripeServer := stripetest.Start(t)
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(NewMemoryStorage()))
+
+	item := shop.NewShopItem()
+	if !assert.NoError(t, item.CreateContext(ctx, &ShopItemCreate{ItemName: "Mop", ItemPrice: 2000, Quantity: 3}, time.Now())) {
+		return
+	}
+
+	productImages := func() []string {
+		product, _ := stripeServer.Product(item.StripeProductApiID)
+		return product.Images
+	}
+
+	altText, width, height := "Blue mop", 800, 600
+	images, err := shop.SetShopItemImagesContext(ctx, item.ID, []ShopItemImage{
+		{URL: "https://cdn.example.com/mop-front.jpg", AltText: &altText, Width: &width, Height: &height},
+		{URL: "https://cdn.example.com/mop-side.jpg"},
+	})
+	if !assert.NoError(t, err) || !assert.Len(t, images, 2) {
+		return
+	}
+	assert.Equal(t, []string{"https://cdn.example.com/mop-front.jpg"}, productImages())
+
+	stored, err := shop.FindShopItemImagesContext(ctx, item.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, images, stored)
+	assert.Equal(t, 0, stored[0].Position)
+	assert.Equal(t, 1, stored[1].Position)
+	assert.Equal(t, &altText, stored[0].AltText)
+
+	t.Run("Reordering pushes the new first image", func(t *testing.T) {
+		_, err := shop.SetShopItemImagesContext(ctx, item.ID, []ShopItemImage{stored[1], stored[0]})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"https://cdn.example.com/mop-side.jpg"}, productImages())
+
+		data, _, err := shop.GetShopItemsForFrontendContext(ctx, false, "EUR", "", PaginationParams{}, httptest.NewRequest("GET", "/items", nil))
+		if assert.NoError(t, err) && assert.Len(t, data, 1) && assert.Len(t, data[0].Images, 2) {
+			assert.Equal(t, "https://cdn.example.com/mop-side.jpg", data[0].Images[0].URL)
+			assert.Equal(t, "https://cdn.example.com/mop-front.jpg", data[0].Images[1].URL)
+		}
+	})
+
+	t.Run("Keeping the first image doesn't call Stripe", func(t *testing.T) {
+		requests := len(stripeServer.Requests())
+		_, err := shop.SetShopItemImagesContext(ctx, item.ID, []ShopItemImage{{URL: "https://cdn.example.com/mop-side.jpg"}})
+		assert.NoError(t, err)
+		assert.Len(t, stripeServer.Requests(), requests)
+	})
+
+	t.Run("Removing all images clears the product", func(t *testing.T) {
+		_, err := shop.SetShopItemImagesContext(ctx, item.ID, nil)
+		assert.NoError(t, err)
+		assert.Empty(t, productImages())
+
+		stored, err := shop.FindShopItemImagesContext(ctx, item.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, []ShopItemImage{}, stored)
+	})
+
+	t.Run("Invalid images", func(t *testing.T) {
+		zero := 0
+		_, err := shop.SetShopItemImagesContext(ctx, item.ID, []ShopItemImage{{URL: "/relative.jpg"}, {URL: "https://cdn.example.com/a.jpg", Width: &zero}})
+
+		var validationErrors ValidationErrors
+		if assert.True(t, errors.As(err, &validationErrors)) {
+			assert.Equal(t, ValidationErrors{
+				{Field: "images[0].url", Err: ErrImageURLInvalid},
+				{Field: "images[1]", Err: ErrImageDimensionsInvalid},
+			}, validationErrors)
+		}
+
+		tooMany := make([]ShopItemImage, MaxShopItemImages+1)
+		for i := range tooMany {
+			tooMany[i].URL = "https://cdn.example.com/" + strings.Repeat("a", i+1) + ".jpg"
+		}
+		_, err = shop.SetShopItemImagesContext(ctx, item.ID, tooMany)
+		assert.True(t, errors.Is(err, ErrTooManyImages))
+	})
+}
+
+func TestGormShopItemImageRepository_Replace(t *testing.T) {
+	database, mock := newGormForTest(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `shop_item_images` WHERE shop_item_id = ?")).
+		WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `shop_item_images` (`shop_item_id`,`url`,`position`) VALUES (?,?,?),(?,?,?)")).
+		WithArgs(4, "https://cdn.example.com/a.jpg", 0, 4, "https://cdn.example.com/b.jpg", 1).
+		WillReturnResult(sqlmock.NewResult(7, 2))
+	mock.ExpectCommit()
+
+	images := []ShopItemImage{
+		{ShopItemID: 4, URL: "https://cdn.example.com/a.jpg", Position: 0},
+		{ShopItemID: 4, URL: "https://cdn.example.com/b.jpg", Position: 1},
+	}
+	if assert.NoError(t, NewGormShopItemImageRepository(database).Replace(context.Background(), 4, images)) {
+		assert.Equal(t, 7, images[0].ID)
+		assert.Equal(t, 8, images[1].ID)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGormShopItemImageRepository_Append(t *testing.T) {
+	database, mock := newGormForTest(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id` FROM `shop_items` WHERE id = ? FOR UPDATE")).
+		WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `shop_item_images` WHERE shop_item_id = ?")).
+		WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `shop_item_images` (`shop_item_id`,`url`,`position`) VALUES (?,?,?)")).
+		WithArgs(4, "https://cdn.example.com/c.jpg", 2).
+		WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectCommit()
+
+	image := ShopItemImage{URL: "https://cdn.example.com/c.jpg"}
+	if assert.NoError(t, NewGormShopItemImageRepository(database).Append(context.Background(), 4, &image, 3)) {
+		assert.Equal(t, ShopItemImage{ID: 9, ShopItemID: 4, URL: "https://cdn.example.com/c.jpg", Position: 2}, image)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id` FROM `shop_items` WHERE id = ? FOR UPDATE")).
+		WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `shop_item_images` WHERE shop_item_id = ?")).
+		WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectRollback()
+
+	err := NewGormShopItemImageRepository(database).Append(context.Background(), 4, &ShopItemImage{URL: "https://cdn.example.com/d.jpg"}, 3)
+	assert.True(t, errors.Is(err, ErrTooManyImages))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
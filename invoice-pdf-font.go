@@ -0,0 +1,227 @@
+package mop_shop
+
+import (
+	"bytes"
+	"compress/zlib"
+	_ "embed"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// DejaVu Sans covers Latin, Greek and Cyrillic, so names and addresses print as they are. See fonts/LICENSE.
+var (
+	//go:embed fonts/DejaVuSans.ttf
+	dejaVuSans []byte
+	//go:embed fonts/DejaVuSans-Bold.ttf
+	dejaVuSansBold []byte
+
+	// pdfFonts are the regular and the bold font, parsed by loadPDFFonts when the first PDF is rendered
+	pdfFonts     [2]*trueTypeFont
+	pdfFontsErr  error
+	pdfFontsOnce sync.Once
+)
+
+// loadPDFFonts returns the regular and the bold font, parsing them the first time it is called
+func loadPDFFonts() ([2]*trueTypeFont, error) {
+	pdfFontsOnce.Do(func() {
+		if pdfFonts[0], pdfFontsErr = parseTrueType("DejaVuSans", dejaVuSans); pdfFontsErr != nil {
+			return
+		}
+		pdfFonts[1], pdfFontsErr = parseTrueType("DejaVuSans-Bold", dejaVuSansBold)
+	})
+
+	return pdfFonts, pdfFontsErr
+}
+
+// trueTypeFont is what a PDF needs of a TrueType font to show text in it: glyph IDs of runes, their advance widths
+// and metrics for the font descriptor, all in font units
+type trueTypeFont struct {
+	name       string
+	data       []byte
+	unitsPerEm int
+	bbox       [4]int
+	ascent     int
+	descent    int
+	capHeight  int
+	// advances are indexed by glyph ID, glyphs past the end have the last advance
+	advances []int
+	// glyphs maps runes of the Basic Multilingual Plane to glyph IDs, missing runes show glyph 0
+	glyphs map[rune]uint16
+
+	compressOnce sync.Once
+	compressed   []byte
+}
+
+// parseTrueType reads the head, hhea, hmtx, OS/2 and cmap tables of the font, only cmap format 4 is supported
+func parseTrueType(name string, data []byte) (*trueTypeFont, error) {
+	tables, err := trueTypeTables(data)
+	if err != nil {
+		return nil, fmt.Errorf("font %s: %w", name, err)
+	}
+
+	head, hhea, hmtx := tables["head"], tables["hhea"], tables["hmtx"]
+	if len(head) < 54 || len(hhea) < 36 {
+		return nil, fmt.Errorf("font %s: head or hhea table missing", name)
+	}
+
+	font := &trueTypeFont{
+		name:       name,
+		data:       data,
+		unitsPerEm: ttfUint16(head, 18),
+		bbox:       [4]int{ttfInt16(head, 36), ttfInt16(head, 38), ttfInt16(head, 40), ttfInt16(head, 42)},
+		ascent:     ttfInt16(hhea, 4),
+		descent:    ttfInt16(hhea, 6),
+	}
+
+	if font.unitsPerEm == 0 {
+		return nil, fmt.Errorf("font %s: units per em are zero", name)
+	}
+
+	// sCapHeight was added in version 2 of the OS/2 table
+	font.capHeight = font.ascent
+	if os2 := tables["OS/2"]; len(os2) >= 90 && ttfUint16(os2, 0) >= 2 {
+		font.capHeight = ttfInt16(os2, 88)
+	}
+
+	metrics := ttfUint16(hhea, 34)
+	if metrics == 0 || len(hmtx) < 4*metrics {
+		return nil, fmt.Errorf("font %s: hmtx table too short", name)
+	}
+
+	font.advances = make([]int, metrics)
+	for i := range font.advances {
+		font.advances[i] = ttfUint16(hmtx, 4*i)
+	}
+
+	if font.glyphs, err = trueTypeGlyphs(tables["cmap"]); err != nil {
+		return nil, fmt.Errorf("font %s: %w", name, err)
+	}
+
+	return font, nil
+}
+
+// trueTypeTables returns the tables of the font by tag
+func trueTypeTables(data []byte) (map[string][]byte, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("table directory missing")
+	}
+
+	tables := map[string][]byte{}
+	for i := 0; i < ttfUint16(data, 4); i++ {
+		record := 12 + 16*i
+		if record+16 > len(data) {
+			return nil, fmt.Errorf("table directory too short")
+		}
+
+		offset, length := int(binary.BigEndian.Uint32(data[record+8:])), int(binary.BigEndian.Uint32(data[record+12:]))
+		if offset+length > len(data) {
+			return nil, fmt.Errorf("table %s out of range", data[record:record+4])
+		}
+
+		tables[string(data[record:record+4])] = data[offset : offset+length]
+	}
+
+	return tables, nil
+}
+
+// trueTypeGlyphs maps runes to glyph IDs following the Unicode BMP subtable of cmap, which has to be of format 4
+func trueTypeGlyphs(cmap []byte) (map[rune]uint16, error) {
+	if len(cmap) < 4 {
+		return nil, fmt.Errorf("cmap table missing")
+	}
+
+	var subtable []byte
+	for i := 0; i < ttfUint16(cmap, 2) && 4+8*i+8 <= len(cmap); i++ {
+		record := 4 + 8*i
+		platform, encoding, offset := ttfUint16(cmap, record), ttfUint16(cmap, record+2), int(binary.BigEndian.Uint32(cmap[record+4:]))
+		if (platform == 0 || platform == 3 && encoding == 1) && offset+14 <= len(cmap) && ttfUint16(cmap, offset) == 4 {
+			subtable = cmap[offset:]
+			break
+		}
+	}
+
+	if subtable == nil {
+		return nil, fmt.Errorf("cmap subtable of format 4 missing")
+	}
+
+	if length := ttfUint16(subtable, 2); length <= len(subtable) {
+		subtable = subtable[:length]
+	}
+
+	segments := ttfUint16(subtable, 6) / 2
+	if 16+8*segments > len(subtable) {
+		return nil, fmt.Errorf("cmap subtable too short")
+	}
+
+	ends, starts, deltas, rangeOffsets := 14, 16+2*segments, 16+4*segments, 16+6*segments
+
+	glyphs := map[rune]uint16{}
+	for s := 0; s < segments; s++ {
+		end, start := ttfUint16(subtable, ends+2*s), ttfUint16(subtable, starts+2*s)
+		delta, rangeOffset := ttfUint16(subtable, deltas+2*s), ttfUint16(subtable, rangeOffsets+2*s)
+
+		for c := start; c <= end && c != 0xffff; c++ {
+			glyph := c + delta
+			if rangeOffset != 0 {
+				// the offset is relative to where it's stored and points into the glyph ID array
+				at := rangeOffsets + 2*s + rangeOffset + 2*(c-start)
+				if at+2 > len(subtable) {
+					return nil, fmt.Errorf("cmap glyph ID out of range")
+				}
+
+				if glyph = ttfUint16(subtable, at); glyph == 0 {
+					continue
+				}
+				glyph += delta
+			}
+
+			if glyph&0xffff != 0 {
+				glyphs[rune(c)] = uint16(glyph)
+			}
+		}
+	}
+
+	return glyphs, nil
+}
+
+func ttfUint16(b []byte, offset int) int {
+	return int(binary.BigEndian.Uint16(b[offset:]))
+}
+
+func ttfInt16(b []byte, offset int) int {
+	return int(int16(binary.BigEndian.Uint16(b[offset:])))
+}
+
+// glyph returns the glyph ID of r, 0 being the glyph of missing characters
+func (f *trueTypeFont) glyph(r rune) uint16 {
+	return f.glyphs[r]
+}
+
+func (f *trueTypeFont) advance(glyph uint16) int {
+	if int(glyph) < len(f.advances) {
+		return f.advances[glyph]
+	}
+
+	return f.advances[len(f.advances)-1]
+}
+
+// pdfUnits converts font units to the thousandths of text space PDF font metrics are given in
+func (f *trueTypeFont) pdfUnits(units int) int {
+	return int(math.Round(float64(units) * 1000 / float64(f.unitsPerEm)))
+}
+
+// compressedData returns the font file compressed for a FlateDecode stream, compressing it only once
+func (f *trueTypeFont) compressedData() []byte {
+	f.compressOnce.Do(func() {
+		var out bytes.Buffer
+		w := zlib.NewWriter(&out)
+		// writing to a bytes.Buffer doesn't fail
+		_, _ = w.Write(f.data)
+		_ = w.Close()
+		f.compressed = out.Bytes()
+	})
+
+	return f.compressed
+}
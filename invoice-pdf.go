@@ -0,0 +1,234 @@
+package mop_shop
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// A4 in points
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+	pdfMargin     = 50.0
+)
+
+// pdfDocument lays text out on pages from the top down, keeping just enough of PDF to print invoices. Text is shown
+// in the embedded DejaVu Sans fonts by glyph ID, so any character they have prints as it is.
+type pdfDocument struct {
+	pages []*bytes.Buffer
+	// y is the baseline of the next line on the last page
+	y float64
+	// fonts are the regular and the bold font
+	fonts [2]*trueTypeFont
+	// used maps glyph IDs shown in the regular and the bold font to the runes they stand for
+	used [2]map[uint16]rune
+}
+
+// newPDFDocument returns a document with one empty page, failing when the embedded fonts can't be parsed
+func newPDFDocument() (*pdfDocument, error) {
+	fonts, err := loadPDFFonts()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &pdfDocument{fonts: fonts, used: [2]map[uint16]rune{{}, {}}}
+	d.addPage()
+	return d, nil
+}
+
+func (d *pdfDocument) addPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pdfPageHeight - pdfMargin
+}
+
+// ensure starts a new page unless height fits above the footer, telling whether it did
+func (d *pdfDocument) ensure(height float64) bool {
+	if d.y-height >= pdfMargin {
+		return false
+	}
+
+	d.addPage()
+	return true
+}
+
+func (d *pdfDocument) text(bold bool, size, x, y float64, s string) {
+	d.textOn(d.pages[len(d.pages)-1], bold, size, x, y, s)
+}
+
+func (d *pdfDocument) textOn(page *bytes.Buffer, bold bool, size, x, y float64, s string) {
+	font, n := d.font(bold)
+
+	fmt.Fprintf(page, "BT /F%d %s Tf %s %s Td <%s> Tj ET\n", n+1, pdfNumber(size), pdfNumber(x), pdfNumber(y), d.glyphIDs(n, font, s))
+}
+
+// glyphIDs encodes s as the hex glyph IDs of font for Identity-H, remembering the glyphs used
+func (d *pdfDocument) glyphIDs(n int, font *trueTypeFont, s string) string {
+	var out strings.Builder
+	for _, r := range s {
+		if r < 0x20 {
+			r = ' '
+		}
+
+		glyph := font.glyph(r)
+		if _, ok := d.used[n][glyph]; !ok {
+			d.used[n][glyph] = r
+		}
+
+		fmt.Fprintf(&out, "%04X", glyph)
+	}
+
+	return out.String()
+}
+
+// font returns the regular or bold font and its index in fonts and used
+func (d *pdfDocument) font(bold bool) (*trueTypeFont, int) {
+	if bold {
+		return d.fonts[1], 1
+	}
+
+	return d.fonts[0], 0
+}
+
+// textRight prints s ending at right
+func (d *pdfDocument) textRight(bold bool, size, right, y float64, s string) {
+	d.text(bold, size, right-d.textWidth(bold, s, size), y, s)
+}
+
+// rule draws a horizontal line between the margins
+func (d *pdfDocument) rule(y float64) {
+	fmt.Fprintf(d.pages[len(d.pages)-1], "0.5 w %s %s m %s %s l S\n", pdfNumber(pdfMargin), pdfNumber(y), pdfNumber(pdfPageWidth-pdfMargin), pdfNumber(y))
+}
+
+// writeTo writes the catalog and the page tree as objects 1 and 2, the regular and the bold font as objects 3 to 7
+// and 8 to 12, followed by every page and its content stream
+func (d *pdfDocument) writeTo(w io.Writer) error {
+	var out bytes.Buffer
+	var offsets []int
+
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	kids := make([]string, 0, len(d.pages))
+	for n := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 13+2*n))
+	}
+
+	// the comment of bytes above 127 tells tools the file is binary
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+
+	for n, bold := range []bool{false, true} {
+		font, _ := d.font(bold)
+		d.writeFont(object, 3+5*n, font, d.used[n])
+	}
+
+	for n, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 3 0 R /F2 8 0 R >> >> /Contents %d 0 R >>",
+			pdfNumber(pdfPageWidth), pdfNumber(pdfPageHeight), 14+2*n))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", page.Len(), page.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// writeFont writes font as the objects first to first+4: the Type0 font, its CIDFontType2 descendant, the font
+// descriptor, the font file and the ToUnicode CMap. Widths and the CMap only cover the glyphs used.
+func (d *pdfDocument) writeFont(object func(body string), first int, font *trueTypeFont, used map[uint16]rune) {
+	glyphs := make([]int, 0, len(used))
+	for glyph := range used {
+		glyphs = append(glyphs, int(glyph))
+	}
+	sort.Ints(glyphs)
+
+	var widths strings.Builder
+	for _, glyph := range glyphs {
+		fmt.Fprintf(&widths, "%d [%d] ", glyph, font.pdfUnits(font.advance(uint16(glyph))))
+	}
+
+	object(fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		font.name, first+1, first+4))
+	object(fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> "+
+		"/FontDescriptor %d 0 R /DW %d /W [%s] /CIDToGIDMap /Identity >>",
+		font.name, first+2, font.pdfUnits(font.advance(0)), strings.TrimSpace(widths.String())))
+	object(fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		font.name, font.pdfUnits(font.bbox[0]), font.pdfUnits(font.bbox[1]), font.pdfUnits(font.bbox[2]), font.pdfUnits(font.bbox[3]),
+		font.pdfUnits(font.ascent), font.pdfUnits(font.descent), font.pdfUnits(font.capHeight), first+3))
+
+	data := font.compressedData()
+	object(fmt.Sprintf("<< /Length %d /Length1 %d /Filter /FlateDecode >>\nstream\n%s\nendstream", len(data), len(font.data), data))
+
+	cmap := pdfToUnicodeCMap(glyphs, used)
+	object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(cmap), cmap))
+}
+
+// pdfToUnicodeCMap maps glyphs to the runes they were shown for, so text can be copied and searched. The missing
+// glyph stands for no rune in particular and is left out.
+func pdfToUnicodeCMap(glyphs []int, used map[uint16]rune) string {
+	var mappings []string
+	for _, glyph := range glyphs {
+		if glyph == 0 {
+			continue
+		}
+
+		var code strings.Builder
+		for _, unit := range utf16.Encode([]rune{used[uint16(glyph)]}) {
+			fmt.Fprintf(&code, "%04X", unit)
+		}
+		mappings = append(mappings, fmt.Sprintf("<%04X> <%s>", glyph, code.String()))
+	}
+
+	var out strings.Builder
+	out.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+
+	// bfchar sections hold up to 100 mappings
+	for len(mappings) > 0 {
+		n := len(mappings)
+		if n > 100 {
+			n = 100
+		}
+
+		fmt.Fprintf(&out, "%d beginbfchar\n%s\nendbfchar\n", n, strings.Join(mappings[:n], "\n"))
+		mappings = mappings[n:]
+	}
+
+	out.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend")
+	return out.String()
+}
+
+func pdfNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// textWidth measures s in the regular or bold font of size using the advance widths of its glyphs
+func (d *pdfDocument) textWidth(bold bool, s string, size float64) float64 {
+	font, _ := d.font(bold)
+
+	var units int
+	for _, r := range s {
+		if r < 0x20 {
+			r = ' '
+		}
+
+		units += font.advance(font.glyph(r))
+	}
+
+	return float64(units) * size / float64(font.unitsPerEm)
+}
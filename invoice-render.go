@@ -0,0 +1,198 @@
+package mop_shop
+
+import (
+	_ "embed"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+)
+
+//go:embed templates/invoice.html
+var invoiceHTML string
+
+var invoiceTemplate = template.Must(template.New("invoice").Parse(invoiceHTML))
+
+// invoiceView is what both the HTML and the PDF of an invoice print, amounts formatted already
+type invoiceView struct {
+	Number   string
+	IssuedAt string
+	Currency string
+	Seller   invoicePartyView
+	Buyer    invoicePartyView
+	Lines    []invoiceLineView
+	Totals   []invoiceTotalView
+	Notes    []string
+}
+
+type invoicePartyView struct {
+	Name  string
+	Lines []string
+}
+
+type invoiceLineView struct {
+	Description string
+	Quantity    string
+	UnitPrice   string
+	Amount      string
+}
+
+type invoiceTotalView struct {
+	Label  string
+	Amount string
+	Bold   bool
+}
+
+func (p InvoiceParty) view() invoicePartyView {
+	lines := formatInvoiceAddress(p.Address)
+	if len(p.VATID) > 0 {
+		lines = append(lines, "VAT ID: "+p.VATID)
+	}
+
+	if len(p.Email) > 0 {
+		lines = append(lines, p.Email)
+	}
+
+	return invoicePartyView{Name: p.Name, Lines: lines}
+}
+
+func (i *Invoice) view() invoiceView {
+	view := invoiceView{
+		Number:   i.Number,
+		IssuedAt: i.IssuedAt.Format("2006-01-02"),
+		Currency: strings.ToUpper(i.Currency),
+		Seller:   i.Seller.view(),
+		Buyer:    i.Buyer.view(),
+	}
+
+	for _, line := range i.Lines {
+		description := line.Description
+		if len(line.SKU) > 0 {
+			description += " (" + line.SKU + ")"
+		}
+
+		view.Lines = append(view.Lines, invoiceLineView{
+			Description: description,
+			Quantity:    strconv.Itoa(line.Quantity),
+			UnitPrice:   formatInvoiceAmount(line.UnitPrice),
+			Amount:      formatInvoiceAmount(line.Amount),
+		})
+	}
+
+	view.Totals = append(view.Totals, invoiceTotalView{Label: "Net amount", Amount: formatInvoiceAmount(i.NetAmount)})
+	for _, line := range i.TaxLines {
+		if line.Rate > 0 {
+			label := "VAT " + formatInvoiceRate(line.Rate) + " of " + formatInvoiceAmount(line.NetAmount)
+			view.Totals = append(view.Totals, invoiceTotalView{Label: label, Amount: formatInvoiceAmount(line.TaxAmount)})
+		}
+	}
+	view.Totals = append(view.Totals, invoiceTotalView{Label: "Total (" + view.Currency + ")", Amount: formatInvoiceAmount(i.TotalAmount), Bold: true})
+
+	if i.ReverseCharge {
+		view.Notes = append(view.Notes, "Reverse charge: VAT is to be accounted for by the recipient under Article 196 of Council Directive 2006/112/EC.")
+	}
+
+	if i.PricesIncludeTax && i.TaxAmount > 0 {
+		view.Notes = append(view.Notes, "Prices include VAT.")
+	}
+
+	return view
+}
+
+// RenderHTML writes the invoice as a standalone HTML page. It loads no external resources, so it can be emailed
+// or saved as it is.
+func (i *Invoice) RenderHTML(w io.Writer) error {
+	return invoiceTemplate.Execute(w, i.view())
+}
+
+// RenderPDF writes the invoice as an A4 PDF. It embeds the DejaVu Sans fonts, which print Latin, Greek and Cyrillic
+// text as it is and keep it searchable. The fonts are parsed on the first call, which fails if they can't be.
+func (i *Invoice) RenderPDF(w io.Writer) error {
+	doc, err := newPDFDocument()
+	if err != nil {
+		return err
+	}
+
+	view := i.view()
+
+	doc.text(true, 20, pdfMargin, doc.y-20, "Invoice "+view.Number)
+	doc.y -= 44
+	doc.text(false, 10, pdfMargin, doc.y, "Issue date: "+view.IssuedAt)
+	doc.y -= 32
+
+	sellerBottom := doc.party(pdfMargin, doc.y, "Seller", view.Seller)
+	buyerBottom := doc.party(pdfPageWidth/2, doc.y, "Buyer", view.Buyer)
+	doc.y = sellerBottom
+	if buyerBottom < doc.y {
+		doc.y = buyerBottom
+	}
+	doc.y -= 24
+
+	header := func() {
+		doc.text(true, 10, pdfMargin, doc.y, "Description")
+		doc.textRight(true, 10, 370, doc.y, "Quantity")
+		doc.textRight(true, 10, 460, doc.y, "Unit price ("+view.Currency+")")
+		doc.textRight(true, 10, pdfPageWidth-pdfMargin, doc.y, "Amount ("+view.Currency+")")
+		doc.rule(doc.y - 5)
+		doc.y -= 20
+	}
+
+	header()
+	for _, line := range view.Lines {
+		if doc.ensure(16) {
+			header()
+		}
+
+		doc.text(false, 10, pdfMargin, doc.y, truncateRunes(line.Description, 48))
+		doc.textRight(false, 10, 370, doc.y, line.Quantity)
+		doc.textRight(false, 10, 460, doc.y, line.UnitPrice)
+		doc.textRight(false, 10, pdfPageWidth-pdfMargin, doc.y, line.Amount)
+		doc.y -= 16
+	}
+
+	doc.rule(doc.y + 11)
+	doc.y -= 8
+	for _, total := range view.Totals {
+		doc.ensure(16)
+		doc.textRight(total.Bold, 10, 460, doc.y, total.Label)
+		doc.textRight(total.Bold, 10, pdfPageWidth-pdfMargin, doc.y, total.Amount)
+		doc.y -= 16
+	}
+
+	doc.y -= 16
+	for _, note := range view.Notes {
+		doc.ensure(14)
+		doc.text(false, 8, pdfMargin, doc.y, note)
+		doc.y -= 14
+	}
+
+	for n, page := range doc.pages {
+		footer := "Invoice " + view.Number + ", page " + strconv.Itoa(n+1) + " of " + strconv.Itoa(len(doc.pages))
+		doc.textOn(page, false, 8, pdfMargin, pdfMargin/2, footer)
+	}
+
+	return doc.writeTo(w)
+}
+
+// party prints a party with its heading at the top left corner x, y and returns the y below it
+func (d *pdfDocument) party(x, y float64, heading string, party invoicePartyView) float64 {
+	d.text(true, 8, x, y, strings.ToUpper(heading))
+	y -= 16
+	d.text(true, 10, x, y, party.Name)
+
+	for _, line := range party.Lines {
+		y -= 13
+		d.text(false, 10, x, y, truncateRunes(line, 40))
+	}
+
+	return y
+}
+
+func truncateRunes(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+
+	return string(runes[:max-3]) + "..."
+}
@@ -0,0 +1,368 @@
+package mop_shop
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// DefaultInvoiceCurrency is the currency of invoices unless InvoiceConfig.Currency is set
+const DefaultInvoiceCurrency = "eur"
+
+// InvoiceParty is the seller or the buyer on an invoice, stored as a JSON object
+type InvoiceParty struct {
+	Name    string        `json:"name"`
+	Address *OrderAddress `json:"address,omitempty"`
+	VATID   string        `json:"vat_id,omitempty"`
+	Email   string        `json:"email,omitempty"`
+}
+
+func (p InvoiceParty) Value() (driver.Value, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func (p *InvoiceParty) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case nil:
+		*p = InvoiceParty{}
+		return nil
+	case []byte:
+		return json.Unmarshal(data, p)
+	case string:
+		return json.Unmarshal([]byte(data), p)
+	default:
+		return fmt.Errorf("unsupported invoice party type %T", value)
+	}
+}
+
+// InvoiceLine is an ordered item or the shipping of an order, amounts are in cents
+type InvoiceLine struct {
+	Description string `json:"description"`
+	SKU         string `json:"sku,omitempty"`
+	Quantity    int    `json:"quantity"`
+	UnitPrice   int64  `json:"unit_price"`
+	Amount      int64  `json:"amount"`
+}
+
+// InvoiceLines is stored as a JSON array
+type InvoiceLines []InvoiceLine
+
+func (l InvoiceLines) Value() (driver.Value, error) {
+	data, err := json.Marshal([]InvoiceLine(l))
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func (l *InvoiceLines) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(data, l)
+	case string:
+		return json.Unmarshal([]byte(data), l)
+	default:
+		return fmt.Errorf("unsupported invoice lines type %T", value)
+	}
+}
+
+// Invoice of a completed order. Everything printed on it is a snapshot taken when it was issued, so later changes
+// to the seller, the order or its items don't change it. Amounts are in cents.
+type Invoice struct {
+	ID          int `gorm:"primaryKey;" json:"id"`
+	UserOrderID int `gorm:"not null;uniqueIndex:ux_invoices_user_order_id;" json:"user_order_id"`
+	UserID      int `gorm:"not null;index:ix_invoices_user_id;" json:"user_id"`
+	// Year and Sequence make up Number, sequences start at 1 every year and have no gaps
+	Year     int           `gorm:"not null;uniqueIndex:ux_invoices_year_sequence;" json:"year"`
+	Sequence int           `gorm:"not null;uniqueIndex:ux_invoices_year_sequence;" json:"sequence"`
+	Number   string        `gorm:"type:varchar(64);not null;uniqueIndex:ux_invoices_number;" json:"number"`
+	Currency string        `gorm:"type:varchar(3);not null;" json:"currency"`
+	Seller   InvoiceParty  `gorm:"type:text;not null;" json:"seller"`
+	Buyer    InvoiceParty  `gorm:"type:text;not null;" json:"buyer"`
+	Lines    InvoiceLines  `gorm:"column:line_items;type:text;not null;" json:"lines"`
+	TaxLines OrderTaxLines `gorm:"type:text;default:null;" json:"tax_lines"`
+	// PricesIncludeTax tells whether prices of Lines are gross, otherwise TaxAmount is added on top of them
+	PricesIncludeTax bool      `gorm:"not null;default:false;" json:"prices_include_tax"`
+	ReverseCharge    bool      `gorm:"not null;default:false;" json:"reverse_charge"`
+	NetAmount        int64     `gorm:"not null;" json:"net_amount"`
+	TaxAmount        int64     `gorm:"not null;" json:"tax_amount"`
+	TotalAmount      int64     `gorm:"not null;" json:"total_amount"`
+	IssuedAt         time.Time `gorm:"not null;" json:"issued_at"`
+}
+
+func (i *Invoice) TableName() string {
+	return "invoices"
+}
+
+// InvoiceConfig describes how invoices are issued, see WithInvoices
+type InvoiceConfig struct {
+	Seller InvoiceParty
+	// NumberPrefix precedes the year and sequence of invoice numbers, e.g. INV- gives INV-2026-000042
+	NumberPrefix string
+	// Currency is the currency orders are paid in, DefaultInvoiceCurrency by default
+	Currency string
+	// Location is where the year of invoices is counted, UTC by default
+	Location *time.Location
+}
+
+// WithInvoices issues an invoice for every order completed by UserOrder.UpdateEmptyOrderAfterCheckout. Without it
+// no invoices are issued and Shop.IssueInvoice fails with ErrInvoicingNotConfigured.
+func WithInvoices(config InvoiceConfig) Option {
+	return func(s *Shop) {
+		if len(config.Currency) == 0 {
+			config.Currency = DefaultInvoiceCurrency
+		}
+
+		if config.Location == nil {
+			config.Location = time.UTC
+		}
+
+		s.invoiceConfig = &config
+	}
+}
+
+func (c *InvoiceConfig) formatNumber(year, sequence int) string {
+	return fmt.Sprintf("%s%d-%06d", c.NumberPrefix, year, sequence)
+}
+
+// invoiceIssuer issues invoices for Shop and UserOrder alike
+type invoiceIssuer struct {
+	config          InvoiceConfig
+	invoices        InvoiceRepository
+	orders          OrderRepository
+	shippingMethods []ShippingMethod
+	taxes           *TaxConfig
+	now             func() time.Time
+}
+
+// invoiceIssuer returns nil unless invoicing is configured
+func (s *Shop) invoiceIssuer() *invoiceIssuer {
+	if s.invoiceConfig == nil || s.invoices == nil || s.orders == nil {
+		return nil
+	}
+
+	return &invoiceIssuer{config: *s.invoiceConfig, invoices: s.invoices, orders: s.orders, shippingMethods: s.shippingMethods, taxes: s.taxes, now: time.Now}
+}
+
+// issue returns the invoice of the completed order, issuing it unless it was issued before. Errors are returned
+// unwrapped.
+func (i *invoiceIssuer) issue(ctx context.Context, orderID, userID int) (*Invoice, error) {
+	invoice, err := i.invoices.FindOneByUserOrderID(ctx, orderID)
+	if err == nil {
+		if invoice.UserID != userID {
+			return nil, gorm.ErrRecordNotFound
+		}
+
+		return invoice, nil
+	}
+
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	order, err := i.orders.FindOneByIDAndUserID(ctx, orderID, userID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	invoice = i.newInvoice(order, userID)
+	if err := i.invoices.Create(ctx, invoice, i.config.formatNumber); err != nil {
+		return nil, err
+	}
+
+	return invoice, nil
+}
+
+// newInvoice snapshots order as returned by OrderRepository.FindOneByIDAndUserID, before prices are converted
+func (i *invoiceIssuer) newInvoice(order *UserOrderFrontResponse, userID int) *Invoice {
+	issuedAt := i.now().In(i.config.Location)
+
+	invoice := &Invoice{
+		UserOrderID:   order.ID,
+		UserID:        userID,
+		Year:          issuedAt.Year(),
+		Currency:      i.config.Currency,
+		Seller:        i.config.Seller,
+		TaxLines:      taxLinesFromFrontResponse(order.TaxLines),
+		ReverseCharge: order.ReverseCharge,
+		IssuedAt:      issuedAt,
+	}
+
+	invoice.Seller.Address = cloneOrderAddress(i.config.Seller.Address)
+	invoice.PricesIncludeTax = i.taxes != nil && i.taxes.PricesIncludeTax
+
+	address := order.BillingAddress
+	if address == nil {
+		address = order.ShippingAddress
+	}
+
+	if address != nil {
+		invoice.Buyer = InvoiceParty{Name: address.Name, Address: cloneOrderAddress(address)}
+	}
+
+	if order.VATID != nil {
+		invoice.Buyer.VATID = *order.VATID
+	}
+
+	invoice.Lines = make(InvoiceLines, 0, len(order.Items)+1)
+	for _, item := range order.Items {
+		line := InvoiceLine{Description: item.ItemName, Quantity: item.Quantity, UnitPrice: int64Value(item.ItemPriceInt64)}
+		if item.SKU != nil {
+			line.SKU = *item.SKU
+		}
+
+		line.Amount = line.UnitPrice * int64(line.Quantity)
+		invoice.Lines = append(invoice.Lines, line)
+	}
+
+	if order.ShippingMethod != nil {
+		name := *order.ShippingMethod
+		if method, ok := findShippingMethod(i.shippingMethods, name); ok {
+			name = method.Name
+		}
+
+		price := int64Value(order.ShippingPriceInt64)
+		invoice.Lines = append(invoice.Lines, InvoiceLine{Description: "Shipping: " + name, Quantity: 1, UnitPrice: price, Amount: price})
+	}
+
+	invoice.TotalAmount = int64Value(order.TotalPriceInt64)
+	invoice.TaxAmount = invoice.TaxLines.TaxAmount()
+	invoice.NetAmount = invoice.TotalAmount - invoice.TaxAmount
+	return invoice
+}
+
+func int64Value(value *int64) int64 {
+	if value == nil {
+		return 0
+	}
+
+	return *value
+}
+
+// taxLinesFromFrontResponse turns rates and amounts of UserOrderFrontResponse back to basis points and cents
+func taxLinesFromFrontResponse(lines []UserOrderTaxLineFrontResponse) OrderTaxLines {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	cents := func(value float64) int64 {
+		return decimal.NewFromFloat(value).Shift(2).Round(0).IntPart()
+	}
+
+	data := make(OrderTaxLines, 0, len(lines))
+	for _, line := range lines {
+		data = append(data, OrderTaxLine{
+			TaxClass:  line.TaxClass,
+			Country:   line.Country,
+			Rate:      cents(line.Rate),
+			NetAmount: cents(line.NetAmount),
+			TaxAmount: cents(line.TaxAmount),
+		})
+	}
+
+	return data
+}
+
+func (s *Shop) IssueInvoice(orderID, userID int) (*Invoice, error) {
+	return s.IssueInvoiceContext(context.Background(), orderID, userID)
+}
+
+// IssueInvoiceContext returns the invoice of the completed order of the user, issuing it unless it was issued
+// before. UserOrder.UpdateEmptyOrderAfterCheckout issues invoices already, this is for retrying failures and for
+// orders completed before invoicing was configured.
+func (s *Shop) IssueInvoiceContext(ctx context.Context, orderID, userID int) (*Invoice, error) {
+	issuer := s.invoiceIssuer()
+	if issuer == nil {
+		return nil, ErrInvoicingNotConfigured
+	}
+
+	invoice, err := issuer.issue(ctx, orderID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		s.log(ctx, LevelError, "error while issuing invoice", "user_order_id", orderID, "user_id", userID, "error", err)
+		return nil, wrapInternal("IssueInvoice: issue invoice", err)
+	}
+
+	return invoice, nil
+}
+
+func (s *Shop) FindInvoiceByOrderIDAndUserID(orderID, userID int) (*Invoice, error) {
+	return s.FindInvoiceByOrderIDAndUserIDContext(context.Background(), orderID, userID)
+}
+
+// FindInvoiceByOrderIDAndUserIDContext returns the invoice of the order of an active user, or
+// gorm.ErrRecordNotFound when none was issued
+func (s *Shop) FindInvoiceByOrderIDAndUserIDContext(ctx context.Context, orderID, userID int) (*Invoice, error) {
+	if s.invoices == nil {
+		return nil, ErrInvoicingNotConfigured
+	}
+
+	active, err := s.userIsActive(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !active {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	invoice, err := s.invoices.FindOneByUserOrderID(ctx, orderID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		s.log(ctx, LevelError, "error while getting invoice", "user_order_id", orderID, "user_id", userID, "error", err)
+		return nil, wrapInternal("FindInvoiceByOrderIDAndUserID: query invoice", err)
+	}
+
+	if invoice.UserID != userID {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return invoice, nil
+}
+
+// formatInvoiceAmount formats cents such as 123456 as 1234.56
+func formatInvoiceAmount(cents int64) string {
+	return decimal.New(cents, -2).StringFixed(2)
+}
+
+// formatInvoiceRate formats basis points such as 550 as 5.5%
+func formatInvoiceRate(rate int64) string {
+	return decimal.New(rate, -2).String() + "%"
+}
+
+// formatInvoiceAddress returns the lines of a printed address without empty ones
+func formatInvoiceAddress(address *OrderAddress) []string {
+	if address == nil {
+		return nil
+	}
+
+	var lines []string
+	for _, line := range []string{address.Line1, address.Line2, strings.TrimSpace(address.PostalCode + " " + address.City), address.State, address.Country} {
+		if len(strings.TrimSpace(line)) > 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
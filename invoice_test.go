@@ -0,0 +1,342 @@
+package mop_shop
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/croatiangrn/mop-shop/stripetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/checkout/session"
+	"gorm.io/gorm"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testInvoiceConfig = InvoiceConfig{
+	Seller:       InvoiceParty{Name: "Mop d.o.o.", Address: &OrderAddress{Line1: "Ilica 1", City: "Zagreb", PostalCode: "10000", Country: "HR"}, VATID: "HR12345678901"},
+	NumberPrefix: "INV-",
+}
+
+// completeTestOrder stores a completed order of a mop and shipping, the way UpdateEmptyOrderAfterCheckout would
+func completeTestOrder(t *testing.T, storage *MemoryStorage, userID int, clientReferenceID string) int {
+	ctx := context.Background()
+	shippingMethod, vatID := "standard", "ATU12345678"
+	order := &UserOrder{
+		UserID:                  userID,
+		TotalPrice:              4500,
+		StripeClientReferenceID: clientReferenceID,
+		ShippingMethod:          &shippingMethod,
+		ShippingPrice:           500,
+		ShippingAddress:         &OrderAddress{Name: "Ana", Line1: "Ring 1", City: "Wien", PostalCode: "1010", Country: "AT"},
+		BillingAddress:          &OrderAddress{Name: "Ana GmbH", Line1: "Ring 2", City: "Wien", PostalCode: "1010", Country: "AT"},
+		TaxLines:                OrderTaxLines{{TaxClass: TaxClassStandard, Country: "AT", NetAmount: 4500}},
+		VATID:                   &vatID,
+		ReverseCharge:           true,
+	}
+	assert.NoError(t, storage.Orders().CreateEmptyOrder(ctx, order))
+
+	sku := "MOP-1"
+	assert.NoError(t, storage.Orders().CompleteOrder(ctx, OrderCompletion{
+		ClientReferenceID: clientReferenceID,
+		StripeSessionID:   "cs_" + clientReferenceID,
+		TotalPrice:        4500,
+		CompletedAt:       time.Now(),
+		Items:             []UserOrderItem{{UserOrderID: order.ID, ShopItemID: 1, SKU: &sku, ItemName: "Mop", ItemPrice: 2000, Quantity: 2}},
+	}))
+
+	return order.ID
+}
+
+func TestShop_IssueInvoiceContext(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	shop := NewShop(nil, "", WithMemoryStorage(storage), WithShippingMethods(testShippingMethods...), WithInvoices(testInvoiceConfig))
+	year := time.Now().UTC().Year()
+
+	firstOrderID := completeTestOrder(t, storage, 3, "first")
+	secondOrderID := completeTestOrder(t, storage, 3, "second")
+
+	invoice, err := shop.IssueInvoiceContext(ctx, firstOrderID, 3)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "INV-"+strconv.Itoa(year)+"-000001", invoice.Number)
+	assert.Equal(t, InvoiceParty{Name: "Ana GmbH", Address: &OrderAddress{Name: "Ana GmbH", Line1: "Ring 2", City: "Wien", PostalCode: "1010", Country: "AT"}, VATID: "ATU12345678"}, invoice.Buyer)
+	assert.Equal(t, testInvoiceConfig.Seller, invoice.Seller)
+	assert.Equal(t, InvoiceLines{
+		{Description: "Mop", SKU: "MOP-1", Quantity: 2, UnitPrice: 2000, Amount: 4000},
+		{Description: "Shipping: Standard", Quantity: 1, UnitPrice: 500, Amount: 500},
+	}, invoice.Lines)
+	assert.Equal(t, OrderTaxLines{{TaxClass: TaxClassStandard, Country: "AT", NetAmount: 4500}}, invoice.TaxLines)
+	assert.True(t, invoice.ReverseCharge)
+	assert.Equal(t, []int64{4500, 0, 4500}, []int64{invoice.NetAmount, invoice.TaxAmount, invoice.TotalAmount})
+	assert.Equal(t, "eur", invoice.Currency)
+
+	again, err := shop.IssueInvoiceContext(ctx, firstOrderID, 3)
+	if assert.NoError(t, err) {
+		assert.Equal(t, invoice.Number, again.Number)
+	}
+
+	second, err := shop.IssueInvoiceContext(ctx, secondOrderID, 3)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, second.Sequence)
+	}
+
+	found, err := shop.FindInvoiceByOrderIDAndUserIDContext(ctx, secondOrderID, 3)
+	if assert.NoError(t, err) {
+		assert.Equal(t, second.Number, found.Number)
+	}
+
+	_, err = shop.IssueInvoiceContext(ctx, firstOrderID, 4)
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+
+	_, err = shop.FindInvoiceByOrderIDAndUserIDContext(ctx, firstOrderID, 4)
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+
+	_, err = NewShop(nil, "", WithMemoryStorage(storage)).IssueInvoiceContext(ctx, firstOrderID, 3)
+	assert.True(t, errors.Is(err, ErrInvoicingNotConfigured))
+
+	t.Run("Pending orders have no invoice", func(t *testing.T) {
+		order := &UserOrder{UserID: 3, TotalPrice: 100, StripeClientReferenceID: "pending"}
+		assert.NoError(t, storage.Orders().CreateEmptyOrder(ctx, order))
+
+		_, err := shop.IssueInvoiceContext(ctx, order.ID, 3)
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	})
+}
+
+func TestMemoryInvoiceRepository_Create(t *testing.T) {
+	ctx := context.Background()
+	invoices := NewMemoryStorage().Invoices()
+	config := &InvoiceConfig{}
+
+	first := &Invoice{UserOrderID: 1, Year: 2026}
+	assert.NoError(t, invoices.Create(ctx, first, config.formatNumber))
+	assert.Equal(t, ErrDuplicateKey, invoices.Create(ctx, &Invoice{UserOrderID: 1, Year: 2026}, config.formatNumber))
+
+	second := &Invoice{UserOrderID: 2, Year: 2026}
+	assert.NoError(t, invoices.Create(ctx, second, config.formatNumber))
+
+	nextYear := &Invoice{UserOrderID: 3, Year: 2027}
+	assert.NoError(t, invoices.Create(ctx, nextYear, config.formatNumber))
+
+	assert.Equal(t, []string{"2026-000001", "2026-000002", "2027-000001"}, []string{first.Number, second.Number, nextYear.Number})
+}
+
+func TestGormInvoiceRepository_Create(t *testing.T) {
+	updateSequence := "UPDATE `invoice_sequences` SET `last_sequence`=last_sequence + 1 WHERE year = ?"
+	insertSequence := "INSERT INTO `invoice_sequences` (`year`,`last_sequence`) VALUES (?,?)"
+	selectSequence := "SELECT * FROM `invoice_sequences` WHERE year = ? LIMIT 1"
+	insertInvoice := "INSERT INTO `invoices` (`user_order_id`,`user_id`,`year`,`sequence`,`number`,"
+
+	t.Run("Starts the sequence of a year", func(t *testing.T) {
+		database, mock := newGormForTest(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(updateSequence)).WithArgs(2026).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(insertSequence)).WithArgs(2026, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(selectSequence)).WithArgs(2026).WillReturnRows(sqlmock.NewRows([]string{"year", "last_sequence"}).AddRow(2026, 1))
+		mock.ExpectExec(regexp.QuoteMeta(insertInvoice)).WillReturnResult(sqlmock.NewResult(7, 1))
+		mock.ExpectCommit()
+
+		invoice := &Invoice{UserOrderID: 8, UserID: 3, Year: 2026, IssuedAt: time.Now()}
+		assert.NoError(t, NewGormInvoiceRepository(database).Create(context.Background(), invoice, testInvoiceConfig.formatNumber))
+		assert.Equal(t, 7, invoice.ID)
+		assert.Equal(t, "INV-2026-000001", invoice.Number)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Failed inserts leave no gaps", func(t *testing.T) {
+		database, mock := newGormForTest(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(updateSequence)).WithArgs(2026).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(selectSequence)).WithArgs(2026).WillReturnRows(sqlmock.NewRows([]string{"year", "last_sequence"}).AddRow(2026, 42))
+		mock.ExpectExec(regexp.QuoteMeta(insertInvoice)).WillReturnError(errors.New("duplicate entry"))
+		mock.ExpectRollback()
+
+		invoice := &Invoice{UserOrderID: 8, UserID: 3, Year: 2026, IssuedAt: time.Now()}
+		assert.Error(t, NewGormInvoiceRepository(database).Create(context.Background(), invoice, testInvoiceConfig.formatNumber))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestInvoice_Render(t *testing.T) {
+	invoice := &Invoice{
+		Number:   "INV-2026-000042",
+		Currency: "eur",
+		Seller:   testInvoiceConfig.Seller,
+		Buyer:    InvoiceParty{Name: "Ana <Čorić>", Address: &OrderAddress{Line1: "Ring 1", City: "Wien", PostalCode: "1010", Country: "AT"}},
+		Lines: InvoiceLines{
+			{Description: "Mop (wooden)", SKU: "MOP-1", Quantity: 2, UnitPrice: 2500, Amount: 5000},
+			{Description: "Book", Quantity: 1, UnitPrice: 1130, Amount: 1130},
+		},
+		TaxLines: OrderTaxLines{
+			{TaxClass: TaxClassStandard, Country: "HR", Rate: 2500, NetAmount: 4000, TaxAmount: 1000},
+			{TaxClass: TaxClassReduced, Country: "HR", Rate: 1300, NetAmount: 1000, TaxAmount: 130},
+		},
+		PricesIncludeTax: true,
+		NetAmount:        5000,
+		TaxAmount:        1130,
+		TotalAmount:      6130,
+		IssuedAt:         time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC),
+	}
+
+	t.Run("HTML", func(t *testing.T) {
+		var out bytes.Buffer
+		if !assert.NoError(t, invoice.RenderHTML(&out)) {
+			return
+		}
+
+		html := out.String()
+		assert.True(t, strings.HasPrefix(html, "<!DOCTYPE html>"))
+		assert.Contains(t, html, "<h1>Invoice INV-2026-000042</h1>")
+		assert.Contains(t, html, "Ana &lt;Čorić&gt;")
+		assert.Contains(t, html, "<td>Mop (wooden) (MOP-1)</td>")
+		assert.Contains(t, html, "<td>VAT 13% of 10.00</td><td class=\"number\">1.30</td>")
+		assert.Contains(t, html, "<td>Total (EUR)</td><td class=\"number\">61.30</td>")
+		assert.Contains(t, html, "Prices include VAT.")
+		assert.NotContains(t, html, "http")
+	})
+
+	t.Run("PDF", func(t *testing.T) {
+		var out bytes.Buffer
+		if !assert.NoError(t, invoice.RenderPDF(&out)) {
+			return
+		}
+
+		pdf, pdfRegularFont, pdfBoldFont := out.String(), pdfFonts[0], pdfFonts[1]
+		assert.True(t, strings.HasPrefix(pdf, "%PDF-1.4\n"))
+		assert.True(t, strings.HasSuffix(pdf, "%%EOF\n"))
+		assert.Contains(t, pdf, "/Subtype /Type0 /BaseFont /DejaVuSans /Encoding /Identity-H")
+		assert.Contains(t, pdf, "/Subtype /Type0 /BaseFont /DejaVuSans-Bold /Encoding /Identity-H")
+		assert.Contains(t, pdf, "/Subtype /CIDFontType2")
+		assert.Contains(t, pdf, "/FontFile2 ")
+		assert.Contains(t, pdf, "/ToUnicode ")
+		assert.Contains(t, pdf, pdfShownText(t, pdfBoldFont, "Invoice INV-2026-000042"))
+		assert.Contains(t, pdf, pdfShownText(t, pdfBoldFont, "Ana <Čorić>"))
+		assert.Contains(t, pdf, pdfShownText(t, pdfRegularFont, "Mop (wooden) (MOP-1)"))
+		assert.Contains(t, pdf, pdfShownText(t, pdfRegularFont, "Invoice INV-2026-000042, page 1 of 1"))
+		assert.Contains(t, pdf, fmt.Sprintf("<%04X> <010C>", pdfBoldFont.glyph('Č')), "ToUnicode maps Č back")
+
+		assertPDFCrossReferences(t, pdf)
+	})
+
+	t.Run("PDF pages", func(t *testing.T) {
+		long := *invoice
+		long.Lines = nil
+		for i := 0; i < 60; i++ {
+			long.Lines = append(long.Lines, InvoiceLine{Description: "Brush", Quantity: 1, UnitPrice: 100, Amount: 100})
+		}
+
+		var out bytes.Buffer
+		if assert.NoError(t, long.RenderPDF(&out)) {
+			assert.Contains(t, out.String(), "/Count 2")
+			assert.Contains(t, out.String(), pdfShownText(t, pdfFonts[0], "Invoice INV-2026-000042, page 2 of 2"))
+			assertPDFCrossReferences(t, out.String())
+		}
+	})
+}
+
+func TestParseTrueType(t *testing.T) {
+	doc, err := newPDFDocument()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	pdfRegularFont, pdfBoldFont := doc.fonts[0], doc.fonts[1]
+	assert.Equal(t, 2048, pdfRegularFont.unitsPerEm)
+	assert.Equal(t, uint16(19), pdfRegularFont.glyph('0'))
+	assert.Equal(t, uint16(3), pdfRegularFont.glyph(' '))
+	assert.Zero(t, pdfRegularFont.glyph('\U0001F9F9'), "runes outside of the BMP have no glyph")
+	assert.InDelta(t, 1303.0*10/2048, doc.textWidth(false, "0", 10), 1e-9)
+	assert.InDelta(t, (1303.0+651)*10/2048, doc.textWidth(false, "0\n", 10), 1e-9, "control characters print as spaces")
+	for _, r := range "ČčĆćĐđŠšŽžÄÖÜßŁłŐőΩЖ€" {
+		assert.NotZero(t, pdfRegularFont.glyph(r), "glyph of %q", r)
+		assert.NotZero(t, pdfBoldFont.glyph(r), "bold glyph of %q", r)
+	}
+
+	_, err = parseTrueType("Broken", pdfRegularFont.data[:1000])
+	assert.Error(t, err)
+}
+
+// pdfShownText returns the Tj operator showing s in font, checking every rune of s has a glyph
+func pdfShownText(t *testing.T, font *trueTypeFont, s string) string {
+	var glyphs strings.Builder
+	for _, r := range s {
+		assert.NotZero(t, font.glyph(r), "glyph of %q", r)
+		fmt.Fprintf(&glyphs, "%04X", font.glyph(r))
+	}
+
+	return "<" + glyphs.String() + "> Tj"
+}
+
+// assertPDFCrossReferences checks every offset of the cross-reference table points at its object
+func assertPDFCrossReferences(t *testing.T, pdf string) {
+	startxref := strings.LastIndex(pdf, "startxref\n")
+	xref, err := strconv.Atoi(strings.TrimSuffix(pdf[startxref+len("startxref\n"):], "\n%%EOF\n"))
+	if !assert.NoError(t, err) || !assert.True(t, strings.HasPrefix(pdf[xref:], "xref\n")) {
+		return
+	}
+
+	entries := strings.Split(pdf[xref:startxref], "\n")[3:]
+	for n, entry := range entries {
+		if len(entry) == 0 || strings.HasPrefix(entry, "trailer") {
+			break
+		}
+
+		offset, err := strconv.Atoi(entry[:10])
+		if assert.NoError(t, err) {
+			assert.True(t, strings.HasPrefix(pdf[offset:], strconv.Itoa(n+1)+" 0 obj\n"), "object %d", n+1)
+		}
+	}
+}
+
+func TestUserOrder_UpdateEmptyOrderAfterCheckoutContext_invoice(t *testing.T) {
+	ctx := context.Background()
+	stripeServer := stripetest.Start(t)
+	storage := NewMemoryStorage()
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(storage), WithInvoices(testInvoiceConfig))
+
+	productID := stripeServer.AddProduct(stripetest.Product{Name: "Book", Active: true})
+	priceID := stripeServer.AddPrice(stripetest.Price{Product: productID, Currency: "eur", UnitAmount: 1000, LookupKey: "book", Active: true})
+
+	book := &ShopItem{ItemName: "Book", ItemPrice: 1000, Quantity: 5, StripeProductApiID: productID, UniqueStripePriceLookupKey: "book"}
+	assert.NoError(t, storage.ShopItems().Create(ctx, book))
+
+	order := shop.NewUserOrder()
+	data := NewCreateUserOrder(3)
+	data.Items = []CreateUserOrderItem{{ItemID: book.ID, Quantity: 3}}
+	if !assert.NoError(t, order.PrepareForOrderContext(ctx, data)) {
+		return
+	}
+
+	cs, err := session.New(&stripe.CheckoutSessionParams{
+		ClientReferenceID: stripe.String("ref"),
+		Mode:              stripe.String(string(stripe.CheckoutSessionModePayment)),
+		LineItems:         []*stripe.CheckoutSessionLineItemParams{{Price: stripe.String(priceID), Quantity: stripe.Int64(3)}},
+	})
+	if !assert.NoError(t, err) || !assert.NoError(t, order.CreateEmptyOrderContext(ctx, 3, "ref")) {
+		return
+	}
+
+	completed := shop.NewUserOrder()
+	if !assert.NoError(t, completed.FindOneByClientReferenceIDContext(ctx, "ref", false)) {
+		return
+	}
+	if !assert.NoError(t, completed.UpdateEmptyOrderAfterCheckoutContext(ctx, cs.ID, "ref", float32(cs.AmountTotal))) {
+		return
+	}
+
+	invoice, err := shop.FindInvoiceByOrderIDAndUserIDContext(ctx, order.ID, 3)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, invoice.Sequence)
+		assert.Equal(t, InvoiceLines{{Description: "Book", Quantity: 3, UnitPrice: 1000, Amount: 3000}}, invoice.Lines)
+		assert.Equal(t, int64(3000), invoice.TotalAmount)
+	}
+}
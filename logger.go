@@ -0,0 +1,114 @@
+package mop_shop
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelError:
+		return "ERROR"
+	}
+
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// Logger receives log entries as a message followed by alternating keys and values,
+// e.g. Log(LevelError, "could not update user order", "user_order_id", 12, "error", err)
+type Logger interface {
+	Log(level Level, msg string, keysAndValues ...interface{})
+}
+
+// NopLogger discards every entry, it's used when no Logger is configured
+type NopLogger struct{}
+
+func (NopLogger) Log(Level, string, ...interface{}) {}
+
+// StructuredLogger is the method set of log/slog's *slog.Logger, which satisfies it as is
+type StructuredLogger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type structuredLogger struct {
+	l StructuredLogger
+}
+
+// NewStructuredLogger adapts slog-style loggers such as *slog.Logger to Logger
+func NewStructuredLogger(l StructuredLogger) Logger {
+	return structuredLogger{l: l}
+}
+
+func (s structuredLogger) Log(level Level, msg string, keysAndValues ...interface{}) {
+	switch level {
+	case LevelDebug:
+		s.l.Debug(msg, keysAndValues...)
+	case LevelInfo:
+		s.l.Info(msg, keysAndValues...)
+	default:
+		s.l.Error(msg, keysAndValues...)
+	}
+}
+
+type stdLogger struct {
+	l *log.Logger
+}
+
+// NewStdLogger writes entries to l as "LEVEL msg key=value ..." lines
+func NewStdLogger(l *log.Logger) Logger {
+	return stdLogger{l: l}
+}
+
+func (s stdLogger) Log(level Level, msg string, keysAndValues ...interface{}) {
+	var line strings.Builder
+	line.WriteString(level.String())
+	line.WriteString(" ")
+	line.WriteString(msg)
+
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 < len(keysAndValues) {
+			line.WriteString(fmt.Sprintf(" %v=%v", keysAndValues[i], keysAndValues[i+1]))
+		} else {
+			line.WriteString(fmt.Sprintf(" %v", keysAndValues[i]))
+		}
+	}
+
+	s.l.Println(line.String())
+}
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of ctx carrying requestID, which is then attached to every log entry
+// written while handling ctx
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+func logWithContext(ctx context.Context, logger Logger, level Level, msg string, keysAndValues ...interface{}) {
+	if requestID := RequestIDFromContext(ctx); len(requestID) > 0 {
+		keysAndValues = append([]interface{}{"request_id", requestID}, keysAndValues...)
+	}
+
+	logger.Log(level, msg, keysAndValues...)
+}
@@ -0,0 +1,68 @@
+package mop_shop
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"testing"
+)
+
+type recordedEntry struct {
+	level         string
+	msg           string
+	keysAndValues []interface{}
+}
+
+type structuredLoggerTest struct {
+	entries []recordedEntry
+}
+
+func (s *structuredLoggerTest) Debug(msg string, args ...interface{}) {
+	s.entries = append(s.entries, recordedEntry{level: "debug", msg: msg, keysAndValues: args})
+}
+
+func (s *structuredLoggerTest) Info(msg string, args ...interface{}) {
+	s.entries = append(s.entries, recordedEntry{level: "info", msg: msg, keysAndValues: args})
+}
+
+func (s *structuredLoggerTest) Error(msg string, args ...interface{}) {
+	s.entries = append(s.entries, recordedEntry{level: "error", msg: msg, keysAndValues: args})
+}
+
+func TestNewStructuredLogger(t *testing.T) {
+	structured := &structuredLoggerTest{}
+	logger := NewStructuredLogger(structured)
+
+	logger.Log(LevelDebug, "debug entry", "key", 1)
+	logger.Log(LevelInfo, "info entry")
+	logger.Log(LevelError, "error entry", "user_order_id", 12)
+
+	assert.Equal(t, []recordedEntry{
+		{level: "debug", msg: "debug entry", keysAndValues: []interface{}{"key", 1}},
+		{level: "info", msg: "info entry", keysAndValues: nil},
+		{level: "error", msg: "error entry", keysAndValues: []interface{}{"user_order_id", 12}},
+	}, structured.entries)
+}
+
+func TestNewStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0))
+
+	logger.Log(LevelError, "could not update user order", "user_order_id", 12, "dangling")
+
+	assert.Equal(t, "ERROR could not update user order user_order_id=12 dangling\n", buf.String())
+}
+
+func TestShopItem_logAttachesIDs(t *testing.T) {
+	structured := &structuredLoggerTest{}
+	i := &ShopItem{ID: 5, logger: NewStructuredLogger(structured)}
+
+	i.log(context.Background(), LevelError, "error while updating shop item", "error", ErrInternal)
+	i.log(ContextWithRequestID(context.Background(), "req-1"), LevelInfo, "shop item updated")
+
+	assert.Equal(t, []recordedEntry{
+		{level: "error", msg: "error while updating shop item", keysAndValues: []interface{}{"shop_item_id", 5, "error", ErrInternal}},
+		{level: "info", msg: "shop item updated", keysAndValues: []interface{}{"request_id", "req-1", "shop_item_id", 5}},
+	}, structured.entries)
+}
@@ -0,0 +1,300 @@
+package mop_shop
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// MigrationsTable records versions applied by Migrate
+const MigrationsTable = "shop_schema_migrations"
+
+// migrationDialect holds column types which differ between databases, migrations use them as template fields
+type migrationDialect struct {
+	ID           string
+	DateTime     string
+	Text         string
+	Float        string
+	TableOptions string
+}
+
+var migrationDialects = map[string]migrationDialect{
+	"mysql": {
+		ID:           "BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY",
+		DateTime:     "DATETIME(3)",
+		Text:         "LONGTEXT",
+		Float:        "FLOAT",
+		TableOptions: " ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+	},
+	"postgres": {
+		ID:       "BIGSERIAL PRIMARY KEY",
+		DateTime: "TIMESTAMPTZ",
+		Text:     "TEXT",
+		Float:    "REAL",
+	},
+	"sqlite": {
+		ID:       "INTEGER PRIMARY KEY AUTOINCREMENT",
+		DateTime: "DATETIME",
+		Text:     "TEXT",
+		Float:    "REAL",
+	},
+}
+
+type Migration struct {
+	Version int
+	Name    string
+	// Statements are rendered for the dialect of the DB passed to Migrate
+	Statements []string
+}
+
+type migrationRecord struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"type:varchar(255);not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (migrationRecord) TableName() string {
+	return MigrationsTable
+}
+
+type migrateConfig struct {
+	dryRun   io.Writer
+	baseline int
+	now      func() time.Time
+}
+
+type MigrateOption func(c *migrateConfig)
+
+// MigrateDryRun makes Migrate write SQL of pending migrations to w instead of executing it
+func MigrateDryRun(w io.Writer) MigrateOption {
+	return func(c *migrateConfig) {
+		c.dryRun = w
+	}
+}
+
+// MigrateBaseline makes Migrate record migrations up to version as applied without running them, for databases whose
+// tables existed before Migrate was used. Version 1 matches tables created by earlier releases of the package, which
+// are those of 0001_create_shop_tables. The option only has an effect while MigrationsTable has no records.
+func MigrateBaseline(version int) MigrateOption {
+	return func(c *migrateConfig) {
+		c.baseline = version
+	}
+}
+
+// Migrate creates and upgrades every table the package uses. Each migration is recorded in MigrationsTable once its
+// statements ran, so calling Migrate again only applies migrations added since. The users table order queries join
+// is left to the host application, see DefaultUserResolver.
+//
+// Statements of a migration run in one transaction, but databases which commit schema changes implicitly, like MySQL,
+// can't roll them back. When a migration fails there halfway, the statements before the failing one stay applied
+// while the migration isn't recorded, and calling Migrate again fails on them. The returned error names the
+// migration, whose remaining statements have to be applied by hand and recorded in MigrationsTable, or the applied
+// ones reverted, before Migrate can continue.
+func Migrate(db *gorm.DB, options ...MigrateOption) error {
+	config := migrateConfig{now: time.Now}
+	for _, option := range options {
+		option(&config)
+	}
+
+	migrations, err := LoadMigrations(db.Dialector.Name())
+	if err != nil {
+		return err
+	}
+
+	applied, err := appliedMigrationVersions(db, config.dryRun == nil)
+	if err != nil {
+		return err
+	}
+
+	if config.baseline > 0 && len(applied) == 0 {
+		if err := recordBaseline(db, migrations, applied, config); err != nil {
+			return err
+		}
+	}
+
+	for _, migration := range migrations {
+		if applied[migration.Version] {
+			continue
+		}
+
+		if config.dryRun != nil {
+			if _, err := fmt.Fprintf(config.dryRun, "-- %04d_%s\n%s;\n\n", migration.Version, migration.Name, strings.Join(migration.Statements, ";\n\n")); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for _, statement := range migration.Statements {
+				if err := tx.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+
+			return tx.Create(&migrationRecord{Version: migration.Version, Name: migration.Name, AppliedAt: config.now()}).Error
+		})
+
+		if err != nil {
+			return wrapInternal(fmt.Sprintf("Migrate: apply migration %04d_%s", migration.Version, migration.Name), err)
+		}
+	}
+
+	return nil
+}
+
+// recordBaseline records migrations up to config.baseline as applied and marks them in applied, in a dry run it only
+// writes which migrations would be recorded
+func recordBaseline(db *gorm.DB, migrations []Migration, applied map[int]bool, config migrateConfig) error {
+	var records []migrationRecord
+	for _, migration := range migrations {
+		if migration.Version > config.baseline {
+			break
+		}
+
+		applied[migration.Version] = true
+		records = append(records, migrationRecord{Version: migration.Version, Name: migration.Name, AppliedAt: config.now()})
+	}
+
+	if config.dryRun != nil {
+		for i := range records {
+			if _, err := fmt.Fprintf(config.dryRun, "-- %04d_%s recorded as applied\n\n", records[i].Version, records[i].Name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	if err := db.Create(&records).Error; err != nil {
+		return wrapInternal("Migrate: record baseline migrations", err)
+	}
+
+	return nil
+}
+
+func appliedMigrationVersions(db *gorm.DB, createTable bool) (map[int]bool, error) {
+	applied := map[int]bool{}
+
+	if !db.Migrator().HasTable(&migrationRecord{}) {
+		if !createTable {
+			return applied, nil
+		}
+
+		if err := db.Migrator().CreateTable(&migrationRecord{}); err != nil {
+			return nil, wrapInternal("Migrate: create migrations table", err)
+		}
+	}
+
+	var records []migrationRecord
+	if err := db.Find(&records).Error; err != nil {
+		return nil, wrapInternal("Migrate: query applied migrations", err)
+	}
+
+	for i := range records {
+		applied[records[i].Version] = true
+	}
+
+	return applied, nil
+}
+
+// LoadMigrations returns all migrations rendered for the given GORM dialect name, ordered by version
+func LoadMigrations(dialectName string) ([]Migration, error) {
+	dialect, ok := migrationDialects[dialectName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDialect, dialectName)
+	}
+
+	fileNames, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(fileNames)
+
+	migrations := make([]Migration, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		migration, err := loadMigration(fileName, dialect)
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration)
+	}
+
+	return migrations, nil
+}
+
+func loadMigration(fileName string, dialect migrationDialect) (Migration, error) {
+	baseName := strings.TrimSuffix(path.Base(fileName), ".sql")
+	separator := strings.Index(baseName, "_")
+	if separator < 0 {
+		return Migration{}, fmt.Errorf("migration %s is not named <version>_<name>.sql", fileName)
+	}
+
+	version, err := strconv.Atoi(baseName[:separator])
+	if err != nil {
+		return Migration{}, fmt.Errorf("migration %s is not named <version>_<name>.sql", fileName)
+	}
+
+	content, err := migrationFiles.ReadFile(fileName)
+	if err != nil {
+		return Migration{}, err
+	}
+
+	tmpl, err := template.New(baseName).Parse(string(content))
+	if err != nil {
+		return Migration{}, err
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, dialect); err != nil {
+		return Migration{}, err
+	}
+
+	return Migration{Version: version, Name: baseName[separator+1:], Statements: splitStatements(rendered.String())}, nil
+}
+
+// splitStatements splits SQL on semicolons ending a line and drops comment lines, which is all the
+// embedded migrations need
+func splitStatements(sql string) []string {
+	var statements []string
+	var current strings.Builder
+
+	for _, line := range strings.Split(sql, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+
+		current.WriteString(line)
+		current.WriteString("\n")
+
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+
+	if rest := strings.TrimSpace(current.String()); len(rest) > 0 {
+		statements = append(statements, rest)
+	}
+
+	return statements
+}
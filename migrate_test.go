@@ -0,0 +1,134 @@
+package mop_shop
+
+import (
+	"bytes"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	for _, dialect := range []string{"mysql", "postgres", "sqlite"} {
+		migrations, err := LoadMigrations(dialect)
+		assert.NoError(t, err, dialect)
+
+		for i, migration := range migrations {
+			assert.Equal(t, i+1, migration.Version, dialect)
+			assert.NotEmpty(t, migration.Statements, dialect)
+
+			for _, statement := range migration.Statements {
+				assert.NotContains(t, statement, "{{", dialect)
+				assert.False(t, strings.HasSuffix(statement, ";"), dialect)
+			}
+		}
+	}
+
+	_, err := LoadMigrations("sqlserver")
+	assert.ErrorIs(t, err, ErrUnsupportedDialect)
+}
+
+func expectMigrationsTable(mock sqlmock.Sqlmock, exists bool) {
+	count := 0
+	if exists {
+		count = 1
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT DATABASE()")).WillReturnRows(sqlmock.NewRows([]string{"DATABASE()"}).AddRow("shop"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM information_schema.tables WHERE table_schema = ? AND table_name = ? AND table_type = ?")).
+		WithArgs("shop", MigrationsTable, "BASE TABLE").
+		WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(count))
+}
+
+func TestMigrate(t *testing.T) {
+	appliedAt := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	selectApplied := "SELECT * FROM `shop_schema_migrations`"
+
+	migrations, err := LoadMigrations("mysql")
+	assert.NoError(t, err)
+
+	t.Run("Applies pending migrations", func(t *testing.T) {
+		db, mock := newGormForTest(t)
+		expectMigrationsTable(mock, true)
+		mock.ExpectQuery(regexp.QuoteMeta(selectApplied)).WillReturnRows(sqlmock.NewRows([]string{"version", "name", "applied_at"}))
+
+		for _, migration := range migrations {
+			mock.ExpectBegin()
+			for _, statement := range migration.Statements {
+				mock.ExpectExec(regexp.QuoteMeta(statement)).WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `shop_schema_migrations` (`version`,`name`,`applied_at`) VALUES (?,?,?)")).
+				WithArgs(migration.Version, migration.Name, appliedAt).
+				WillReturnResult(sqlmock.NewResult(int64(migration.Version), 1))
+			mock.ExpectCommit()
+		}
+
+		err := Migrate(db, func(c *migrateConfig) { c.now = func() time.Time { return appliedAt } })
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Skips applied migrations", func(t *testing.T) {
+		db, mock := newGormForTest(t)
+		expectMigrationsTable(mock, true)
+
+		rows := sqlmock.NewRows([]string{"version", "name", "applied_at"})
+		for _, migration := range migrations {
+			rows.AddRow(migration.Version, migration.Name, appliedAt)
+		}
+		mock.ExpectQuery(regexp.QuoteMeta(selectApplied)).WillReturnRows(rows)
+
+		assert.NoError(t, Migrate(db))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Dry run only writes SQL", func(t *testing.T) {
+		db, mock := newGormForTest(t)
+		expectMigrationsTable(mock, false)
+
+		var out bytes.Buffer
+		assert.NoError(t, Migrate(db, MigrateDryRun(&out)))
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Contains(t, out.String(), "-- 0001_create_shop_tables\n")
+		assert.Contains(t, out.String(), "CREATE TABLE shop_items")
+	})
+}
+
+func TestMigrate_baseline(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "shop.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening an SQLite database", err)
+	}
+
+	migrations, err := LoadMigrations("sqlite")
+	assert.NoError(t, err)
+
+	// tables of a deployment which predates Migrate
+	for _, statement := range migrations[0].Statements {
+		assert.NoError(t, db.Exec(statement).Error)
+	}
+
+	assert.Error(t, Migrate(db), "creating existing tables should fail")
+
+	var out bytes.Buffer
+	assert.NoError(t, Migrate(db, MigrateBaseline(1), MigrateDryRun(&out)))
+	assert.Contains(t, out.String(), "-- 0001_create_shop_tables recorded as applied\n")
+	assert.NotContains(t, out.String(), "CREATE TABLE shop_items")
+	assert.Contains(t, out.String(), "-- 0002_create_shop_item_price_history\n")
+
+	assert.NoError(t, Migrate(db, MigrateBaseline(1)))
+	assert.True(t, db.Migrator().HasColumn(&UserOrder{}, "item_snapshots"))
+
+	var records []migrationRecord
+	assert.NoError(t, db.Order("version").Find(&records).Error)
+	assert.Len(t, records, len(migrations))
+
+	// the baseline is ignored once migrations are recorded
+	assert.NoError(t, Migrate(db, MigrateBaseline(len(migrations)+1)))
+}
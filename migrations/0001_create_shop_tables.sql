@@ -0,0 +1,46 @@
+CREATE TABLE shop_items (
+    id {{.ID}},
+    item_name VARCHAR(255) NOT NULL,
+    item_picture VARCHAR(255) NULL,
+    item_price BIGINT NOT NULL,
+    item_sale_price BIGINT NULL,
+    item_description {{.Text}} NULL,
+    shippable BOOLEAN NOT NULL DEFAULT FALSE,
+    quantity BIGINT NOT NULL DEFAULT 0,
+    stripe_product_api_id VARCHAR(255) NOT NULL,
+    unique_stripe_price_lookup_key VARCHAR(36) NULL,
+    created_at {{.DateTime}} NOT NULL,
+    updated_at {{.DateTime}} NOT NULL,
+    deleted_at {{.DateTime}} NULL
+){{.TableOptions}};
+
+CREATE UNIQUE INDEX ux_stripe_product_api_id ON shop_items (stripe_product_api_id);
+
+CREATE INDEX ix_shop_items_deleted_at ON shop_items (deleted_at);
+
+CREATE TABLE user_orders (
+    id {{.ID}},
+    user_id BIGINT NOT NULL,
+    total_price {{.Float}} NOT NULL,
+    stripe_session_id VARCHAR(255) NULL,
+    created_at {{.DateTime}} NOT NULL,
+    updated_at {{.DateTime}} NULL,
+    stripe_client_reference_id VARCHAR(36) NULL,
+    is_completed BOOLEAN NOT NULL DEFAULT FALSE
+){{.TableOptions}};
+
+CREATE INDEX ix_user_order_id ON user_orders (user_id);
+
+CREATE INDEX ix_stripe_session_id ON user_orders (stripe_session_id);
+
+CREATE INDEX ix_stripe_client_reference_id ON user_orders (stripe_client_reference_id);
+
+CREATE TABLE user_order_items (
+    id {{.ID}},
+    user_order_id BIGINT NOT NULL,
+    shop_item_id BIGINT NOT NULL,
+    item_price {{.Float}} NOT NULL,
+    quantity BIGINT NOT NULL
+){{.TableOptions}};
+
+CREATE INDEX ix_user_order_item_order_id ON user_order_items (user_order_id);
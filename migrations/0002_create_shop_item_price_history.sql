@@ -0,0 +1,11 @@
+CREATE TABLE shop_item_price_history (
+    id {{.ID}},
+    shop_item_id BIGINT NOT NULL,
+    item_price BIGINT NOT NULL,
+    item_sale_price BIGINT NULL,
+    stripe_price_api_id VARCHAR(255) NULL,
+    effective_from {{.DateTime}} NOT NULL,
+    changed_by VARCHAR(255) NULL
+){{.TableOptions}};
+
+CREATE INDEX ix_price_history_shop_item_id ON shop_item_price_history (shop_item_id, effective_from);
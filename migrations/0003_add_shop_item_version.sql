@@ -0,0 +1 @@
+ALTER TABLE shop_items ADD COLUMN version INT NOT NULL DEFAULT 1;
@@ -0,0 +1,3 @@
+ALTER TABLE shop_items ADD COLUMN sku VARCHAR(64) NULL;
+
+CREATE UNIQUE INDEX ux_shop_items_sku ON shop_items (sku);
@@ -0,0 +1,3 @@
+ALTER TABLE shop_items ADD COLUMN gtin VARCHAR(14) NULL;
+
+ALTER TABLE user_order_items ADD COLUMN sku VARCHAR(64) NULL;
@@ -0,0 +1,11 @@
+CREATE TABLE shop_item_images (
+    id {{.ID}},
+    shop_item_id BIGINT NOT NULL,
+    url VARCHAR(2048) NOT NULL,
+    position INT NOT NULL,
+    alt_text VARCHAR(255) NULL,
+    width INT NULL,
+    height INT NULL
+){{.TableOptions}};
+
+CREATE INDEX ix_shop_item_images_shop_item_id ON shop_item_images (shop_item_id, position);
@@ -0,0 +1 @@
+ALTER TABLE shop_item_images ADD COLUMN thumbnails {{.Text}} NULL;
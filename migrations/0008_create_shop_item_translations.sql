@@ -0,0 +1,9 @@
+CREATE TABLE shop_item_translations (
+    id {{.ID}},
+    shop_item_id BIGINT NOT NULL,
+    locale VARCHAR(35) NOT NULL,
+    item_name VARCHAR(255) NOT NULL,
+    item_description {{.Text}} NULL
+){{.TableOptions}};
+
+CREATE UNIQUE INDEX ux_shop_item_translations_item_locale ON shop_item_translations (shop_item_id, locale);
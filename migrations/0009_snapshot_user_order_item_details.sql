@@ -0,0 +1,10 @@
+ALTER TABLE user_order_items ADD COLUMN item_name VARCHAR(255) NULL;
+
+ALTER TABLE user_order_items ADD COLUMN item_picture VARCHAR(255) NULL;
+
+ALTER TABLE user_order_items ADD COLUMN item_description {{.Text}} NULL;
+
+UPDATE user_order_items SET
+    item_name = (SELECT si.item_name FROM shop_items si WHERE si.id = user_order_items.shop_item_id),
+    item_picture = (SELECT si.item_picture FROM shop_items si WHERE si.id = user_order_items.shop_item_id),
+    item_description = (SELECT si.item_description FROM shop_items si WHERE si.id = user_order_items.shop_item_id);
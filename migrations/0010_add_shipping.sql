@@ -0,0 +1,9 @@
+ALTER TABLE shop_items ADD COLUMN weight_grams BIGINT NULL;
+
+ALTER TABLE user_orders ADD COLUMN shipping_method VARCHAR(64) NULL;
+
+ALTER TABLE user_orders ADD COLUMN shipping_price {{.Float}} NOT NULL DEFAULT 0;
+
+ALTER TABLE user_orders ADD COLUMN shipping_address {{.Text}} NULL;
+
+ALTER TABLE user_orders ADD COLUMN billing_address {{.Text}} NULL;
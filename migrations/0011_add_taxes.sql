@@ -0,0 +1,9 @@
+ALTER TABLE shop_items ADD COLUMN tax_class VARCHAR(32) NOT NULL DEFAULT '';
+
+ALTER TABLE user_orders ADD COLUMN tax_amount {{.Float}} NOT NULL DEFAULT 0;
+
+ALTER TABLE user_orders ADD COLUMN tax_lines {{.Text}} NULL;
+
+ALTER TABLE user_orders ADD COLUMN vat_id VARCHAR(20) NULL;
+
+ALTER TABLE user_orders ADD COLUMN reverse_charge BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,32 @@
+CREATE TABLE invoice_sequences (
+    year INT NOT NULL PRIMARY KEY,
+    last_sequence INT NOT NULL
+){{.TableOptions}};
+
+CREATE TABLE invoices (
+    id {{.ID}},
+    user_order_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    year INT NOT NULL,
+    sequence INT NOT NULL,
+    number VARCHAR(64) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    seller {{.Text}} NOT NULL,
+    buyer {{.Text}} NOT NULL,
+    line_items {{.Text}} NOT NULL,
+    tax_lines {{.Text}} NULL,
+    prices_include_tax BOOLEAN NOT NULL DEFAULT FALSE,
+    reverse_charge BOOLEAN NOT NULL DEFAULT FALSE,
+    net_amount BIGINT NOT NULL,
+    tax_amount BIGINT NOT NULL,
+    total_amount BIGINT NOT NULL,
+    issued_at {{.DateTime}} NOT NULL
+){{.TableOptions}};
+
+CREATE UNIQUE INDEX ux_invoices_user_order_id ON invoices (user_order_id);
+
+CREATE UNIQUE INDEX ux_invoices_year_sequence ON invoices (year, sequence);
+
+CREATE UNIQUE INDEX ux_invoices_number ON invoices (number);
+
+CREATE INDEX ix_invoices_user_id ON invoices (user_id);
@@ -0,0 +1,3 @@
+ALTER TABLE user_orders ADD COLUMN item_snapshots {{.Text}} NULL;
+
+ALTER TABLE user_order_items ADD COLUMN item_translations {{.Text}} NULL;
@@ -0,0 +1,144 @@
+package mop_shop
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// ShopItemPriceHistory is a price of a shop item, in effect from EffectiveFrom until the next entry of the same item
+type ShopItemPriceHistory struct {
+	ID               int       `gorm:"primaryKey" json:"id"`
+	ShopItemID       int       `gorm:"not null;index:ix_price_history_shop_item_id;" json:"shop_item_id"`
+	ItemPrice        int64     `gorm:"not null;" json:"item_price"`
+	ItemSalePrice    *int64    `gorm:"default:null;" json:"item_sale_price"`
+	StripePriceApiID string    `gorm:"type:varchar(255);" json:"stripe_price_api_id"`
+	EffectiveFrom    time.Time `gorm:"not null;index:ix_price_history_shop_item_id;" json:"effective_from"`
+	ChangedBy        *string   `gorm:"type:varchar(255);default:null;" json:"changed_by"`
+}
+
+func (h *ShopItemPriceHistory) TableName() string {
+	return "shop_item_price_history"
+}
+
+type authorKey struct{}
+
+// ContextWithAuthor returns a copy of ctx carrying the author of changes, e.g. an admin's email, which is recorded
+// in the price history
+func ContextWithAuthor(ctx context.Context, author string) context.Context {
+	return context.WithValue(ctx, authorKey{}, author)
+}
+
+func AuthorFromContext(ctx context.Context) string {
+	author, _ := ctx.Value(authorKey{}).(string)
+	return author
+}
+
+// recordPrice adds the price the item has now to its history. The price change already happened in Stripe and the
+// database at this point, so failures are only logged.
+func (i *ShopItem) recordPrice(ctx context.Context, stripePriceApiID string, effectiveFrom time.Time) {
+	if i.priceHistory == nil {
+		return
+	}
+
+	entry := &ShopItemPriceHistory{
+		ShopItemID:       i.ID,
+		ItemPrice:        i.ItemPrice,
+		ItemSalePrice:    i.ItemSalePrice,
+		StripePriceApiID: stripePriceApiID,
+		EffectiveFrom:    effectiveFrom,
+	}
+
+	if author := AuthorFromContext(ctx); len(author) > 0 {
+		entry.ChangedBy = &author
+	}
+
+	if err := i.priceHistory.Add(ctx, entry); err != nil {
+		i.log(ctx, LevelError, "error while recording shop item price history", "stripe_price_id", stripePriceApiID, "error", err)
+	}
+}
+
+// stripePriceApiIDAt returns the Stripe price recorded last before at, it's used when the item's prices change
+// without changing the price charged in Stripe
+func (i *ShopItem) stripePriceApiIDAt(ctx context.Context, at time.Time) string {
+	if i.priceHistory == nil {
+		return ""
+	}
+
+	entry, err := i.priceHistory.FindInEffectAt(ctx, i.ID, at)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			i.log(ctx, LevelError, "error while getting shop item price history", "error", err)
+		}
+
+		return ""
+	}
+
+	return entry.StripePriceApiID
+}
+
+func FindShopItemPriceHistory(shopItemID int, db *gorm.DB) ([]ShopItemPriceHistory, error) {
+	return defaultShop(db).FindShopItemPriceHistory(shopItemID)
+}
+
+func FindShopItemPriceHistoryContext(ctx context.Context, shopItemID int, db *gorm.DB) ([]ShopItemPriceHistory, error) {
+	return defaultShop(db).FindShopItemPriceHistoryContext(ctx, shopItemID)
+}
+
+// FindShopItemPriceHistory works like the package-level FindShopItemPriceHistory
+func (s *Shop) FindShopItemPriceHistory(shopItemID int) ([]ShopItemPriceHistory, error) {
+	return s.FindShopItemPriceHistoryContext(context.Background(), shopItemID)
+}
+
+// FindShopItemPriceHistoryContext returns every price the item had, oldest first
+func (s *Shop) FindShopItemPriceHistoryContext(ctx context.Context, shopItemID int) ([]ShopItemPriceHistory, error) {
+	if s.priceHistory == nil {
+		return nil, ErrShopItemNotInitializedProperly
+	}
+
+	data, err := s.priceHistory.FindByShopItemID(ctx, shopItemID)
+	if err != nil {
+		s.log(ctx, LevelError, "error while getting shop item price history", "shop_item_id", shopItemID, "error", err)
+		return nil, wrapInternal("FindShopItemPriceHistory: query price history", err)
+	}
+
+	if len(data) == 0 {
+		data = []ShopItemPriceHistory{}
+	}
+
+	return data, nil
+}
+
+func FindShopItemPriceAt(shopItemID int, at time.Time, db *gorm.DB) (*ShopItemPriceHistory, error) {
+	return defaultShop(db).FindShopItemPriceAt(shopItemID, at)
+}
+
+func FindShopItemPriceAtContext(ctx context.Context, shopItemID int, at time.Time, db *gorm.DB) (*ShopItemPriceHistory, error) {
+	return defaultShop(db).FindShopItemPriceAtContext(ctx, shopItemID, at)
+}
+
+// FindShopItemPriceAt works like the package-level FindShopItemPriceAt
+func (s *Shop) FindShopItemPriceAt(shopItemID int, at time.Time) (*ShopItemPriceHistory, error) {
+	return s.FindShopItemPriceAtContext(context.Background(), shopItemID, at)
+}
+
+// FindShopItemPriceAtContext returns the price in effect at the given moment, gorm.ErrRecordNotFound is returned
+// when the item had no price yet
+func (s *Shop) FindShopItemPriceAtContext(ctx context.Context, shopItemID int, at time.Time) (*ShopItemPriceHistory, error) {
+	if s.priceHistory == nil {
+		return nil, ErrShopItemNotInitializedProperly
+	}
+
+	entry, err := s.priceHistory.FindInEffectAt(ctx, shopItemID, at)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		s.log(ctx, LevelError, "error while getting shop item price", "shop_item_id", shopItemID, "error", err)
+		return nil, wrapInternal("FindShopItemPriceAt: query price history", err)
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,74 @@
+package mop_shop
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/croatiangrn/mop-shop/stripetest"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestShop_FindShopItemPriceAtContext(t *testing.T) {
+	createdAt := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	ctx := ContextWithAuthor(context.Background(), "admin@example.com")
+	stripeServer := stripetest.Start(t)
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(NewMemoryStorage()))
+
+	salePrice := int64(1500)
+	item := shop.NewShopItem()
+	if !assert.NoError(t, item.CreateContext(ctx, &ShopItemCreate{ItemName: "Mop", ItemPrice: 2000, ItemSalePrice: &salePrice, Quantity: 3}, createdAt)) {
+		return
+	}
+
+	update := NewShopItemUpdate(item.StripeProductApiID)
+	update.ItemName, update.ItemPrice, update.Quantity = "Mop", 2500, 3
+	assert.NoError(t, shop.NewShopItemForUpdate(item.ID, item.StripeProductApiID, item.UniqueStripePriceLookupKey).UpdateContext(ctx, update))
+
+	history, err := shop.FindShopItemPriceHistoryContext(ctx, item.ID)
+	if !assert.NoError(t, err) || !assert.Len(t, history, 2) {
+		return
+	}
+
+	prices := stripeServer.Prices()
+	assert.Equal(t, prices[0].ID, history[0].StripePriceApiID)
+	assert.Equal(t, &salePrice, history[0].ItemSalePrice)
+	assert.Equal(t, prices[1].ID, history[1].StripePriceApiID)
+	assert.Equal(t, int64(2500), history[1].ItemPrice)
+	assert.Nil(t, history[1].ItemSalePrice)
+	if assert.NotNil(t, history[1].ChangedBy) {
+		assert.Equal(t, "admin@example.com", *history[1].ChangedBy)
+	}
+
+	_, err = shop.FindShopItemPriceAtContext(ctx, item.ID, createdAt.Add(-time.Second))
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+
+	inEffect, err := shop.FindShopItemPriceAtContext(ctx, item.ID, createdAt.AddDate(0, 1, 0))
+	if assert.NoError(t, err) {
+		assert.Equal(t, history[0].ID, inEffect.ID)
+	}
+
+	inEffect, err = shop.FindShopItemPriceAtContext(ctx, item.ID, time.Now())
+	if assert.NoError(t, err) {
+		assert.Equal(t, history[1].ID, inEffect.ID)
+	}
+}
+
+func TestGormPriceHistoryRepository_FindInEffectAt(t *testing.T) {
+	database, mock := newGormForTest(t)
+	at := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	query := "SELECT * FROM `shop_item_price_history` WHERE shop_item_id = ? AND effective_from <= ? ORDER BY effective_from DESC, id DESC LIMIT 1"
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(4, at).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "shop_item_id", "item_price", "stripe_price_api_id", "effective_from"}).
+			AddRow(2, 4, 1000, "price_1", at))
+
+	entry, err := NewGormPriceHistoryRepository(database).FindInEffectAt(context.Background(), 4, at)
+	if assert.NoError(t, err) {
+		assert.Equal(t, &ShopItemPriceHistory{ID: 2, ShopItemID: 4, ItemPrice: 1000, StripePriceApiID: "price_1", EffectiveFrom: at}, entry)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,334 @@
+package mop_shop
+
+import (
+	"context"
+	"fmt"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/price"
+	"github.com/stripe/stripe-go/v72/product"
+	"gorm.io/gorm"
+	"time"
+)
+
+type CatalogMismatchKind string
+
+const (
+	// MismatchMissingProduct means the Stripe product of an active shop item doesn't exist, it can't be repaired
+	// automatically
+	MismatchMissingProduct CatalogMismatchKind = "missing_product"
+	// MismatchInactiveProduct means the Stripe product of an active shop item is archived
+	MismatchInactiveProduct CatalogMismatchKind = "inactive_product"
+	// MismatchProductDetails means name or description of the Stripe product differ from the shop item
+	MismatchProductDetails CatalogMismatchKind = "product_details"
+	// MismatchWrongPrice means there's no active Stripe price with the lookup key of the shop item and its
+	// effective price
+	MismatchWrongPrice CatalogMismatchKind = "wrong_price"
+	// MismatchDeletedButActive means the shop item is soft-deleted but its Stripe product is still active
+	MismatchDeletedButActive CatalogMismatchKind = "deleted_but_active"
+	// MismatchOrphanedProduct means an active Stripe product created by this package isn't referenced by any shop
+	// item, usually left behind by a failed ShopItem.Create. Products younger than the grace period of
+	// ReconcileOrphanGracePeriod aren't reported, their shop items may still be being created.
+	MismatchOrphanedProduct CatalogMismatchKind = "orphaned_product"
+	// MismatchMissingLookupKey means an active shop item has no Stripe price lookup key, so its price can't be
+	// checked nor repaired automatically
+	MismatchMissingLookupKey CatalogMismatchKind = "missing_lookup_key"
+)
+
+// DefaultOrphanGracePeriod is how old Stripe products have to be before ReconcileCatalog reports them as orphaned
+const DefaultOrphanGracePeriod = time.Hour
+
+type CatalogMismatch struct {
+	Kind               CatalogMismatchKind `json:"kind"`
+	ShopItemID         int                 `json:"shop_item_id,omitempty"`
+	StripeProductApiID string              `json:"stripe_product_api_id"`
+	Detail             string              `json:"detail"`
+	Repaired           bool                `json:"repaired"`
+	RepairErr          error               `json:"-"`
+}
+
+// CatalogReport lists every mismatch ReconcileCatalog found, Repaired is only set when repairing was requested
+type CatalogReport struct {
+	CheckedShopItems      int               `json:"checked_shop_items"`
+	CheckedStripeProducts int               `json:"checked_stripe_products"`
+	Mismatches            []CatalogMismatch `json:"mismatches"`
+}
+
+type reconcileConfig struct {
+	repair            bool
+	perPage           int
+	orphanGracePeriod time.Duration
+	now               func() time.Time
+}
+
+type ReconcileOption func(c *reconcileConfig)
+
+// ReconcileRepair makes ReconcileCatalog fix the mismatches it finds in Stripe. Without it the catalog is only
+// checked, which should always be done first.
+func ReconcileRepair() ReconcileOption {
+	return func(c *reconcileConfig) {
+		c.repair = true
+	}
+}
+
+// ReconcileOrphanGracePeriod replaces DefaultOrphanGracePeriod. It should be longer than ShopItem.Create can take
+// between creating the Stripe product and storing the shop item.
+func ReconcileOrphanGracePeriod(gracePeriod time.Duration) ReconcileOption {
+	return func(c *reconcileConfig) {
+		c.orphanGracePeriod = gracePeriod
+	}
+}
+
+// ReconcileCatalog compares shop items with the Stripe products and prices they reference, see Shop.ReconcileCatalog
+func ReconcileCatalog(ctx context.Context, db *gorm.DB, options ...ReconcileOption) (*CatalogReport, error) {
+	return defaultShop(db).ReconcileCatalog(ctx, options...)
+}
+
+// ReconcileCatalog walks all shop items, soft-deleted ones included, together with all Stripe products and prices
+// and reports where they drifted apart. Stripe is treated as the side to repair, shop items are never changed.
+func (s *Shop) ReconcileCatalog(ctx context.Context, options ...ReconcileOption) (*CatalogReport, error) {
+	if s.items == nil {
+		return nil, ErrShopItemNotInitializedProperly
+	}
+
+	config := reconcileConfig{perPage: 100, orphanGracePeriod: DefaultOrphanGracePeriod, now: time.Now}
+	for _, option := range options {
+		option(&config)
+	}
+
+	catalog, err := loadStripeCatalog(ctx)
+	if err != nil {
+		s.log(ctx, LevelError, "error while loading stripe catalog", "error", err)
+		return nil, wrapInternal("ReconcileCatalog: list stripe catalog", err)
+	}
+
+	report := &CatalogReport{CheckedStripeProducts: len(catalog.products)}
+	referenced := map[string]bool{}
+
+	paginationParams := PaginationParams{PerPage: config.perPage}
+	for {
+		items, err := s.items.FindAll(ctx, paginationParams)
+		if err != nil {
+			s.log(ctx, LevelError, "error while getting shop items", "error", err)
+			return nil, wrapInternal("ReconcileCatalog: query shop items", err)
+		}
+
+		// the extra item FindAll returns starts the next page
+		next := 0
+		if len(items) > paginationParams.PerPage {
+			next, items = items[paginationParams.PerPage].ID, items[:paginationParams.PerPage]
+		}
+
+		for i := range items {
+			referenced[items[i].StripeProductApiID] = true
+			report.Mismatches = append(report.Mismatches, catalog.check(&items[i])...)
+		}
+
+		report.CheckedShopItems += len(items)
+		if next == 0 {
+			break
+		}
+
+		paginationParams.After = next
+	}
+
+	orphanedBefore := config.now().Add(-config.orphanGracePeriod)
+	for _, p := range catalog.products {
+		if _, ours := p.Metadata[StripeLookupKeyMetadata]; ours && p.Active && !referenced[p.ID] && time.Unix(p.Created, 0).Before(orphanedBefore) {
+			report.Mismatches = append(report.Mismatches, CatalogMismatch{
+				Kind:               MismatchOrphanedProduct,
+				StripeProductApiID: p.ID,
+				Detail:             fmt.Sprintf("product %s isn't referenced by any shop item", p.ID),
+			})
+		}
+	}
+
+	if config.repair {
+		for i := range report.Mismatches {
+			mismatch := &report.Mismatches[i]
+			mismatch.RepairErr = catalog.repair(ctx, mismatch)
+			mismatch.Repaired = mismatch.RepairErr == nil && mismatch.Kind != MismatchMissingProduct && mismatch.Kind != MismatchMissingLookupKey
+
+			if mismatch.RepairErr != nil {
+				s.log(ctx, LevelError, "error while repairing stripe catalog", "shop_item_id", mismatch.ShopItemID, "stripe_product_id", mismatch.StripeProductApiID, "kind", mismatch.Kind, "error", mismatch.RepairErr)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// stripeCatalog holds every Stripe product and every price having a lookup key
+type stripeCatalog struct {
+	products         map[string]*stripe.Product
+	priceByLookupKey map[string]*stripe.Price
+	// shopItems holds checked shop items which aren't soft-deleted, repairs read their wanted state from here
+	shopItems map[int]*ShopItem
+}
+
+func loadStripeCatalog(ctx context.Context) (*stripeCatalog, error) {
+	catalog := &stripeCatalog{
+		products:         map[string]*stripe.Product{},
+		priceByLookupKey: map[string]*stripe.Price{},
+		shopItems:        map[int]*ShopItem{},
+	}
+
+	productParams := &stripe.ProductListParams{}
+	productParams.Context = ctx
+	products := product.List(productParams)
+	for products.Next() {
+		catalog.products[products.Product().ID] = products.Product()
+	}
+
+	if err := products.Err(); err != nil {
+		return nil, err
+	}
+
+	priceParams := &stripe.PriceListParams{}
+	priceParams.Context = ctx
+	prices := price.List(priceParams)
+	for prices.Next() {
+		if p := prices.Price(); len(p.LookupKey) > 0 {
+			catalog.priceByLookupKey[p.LookupKey] = p
+		}
+	}
+
+	if err := prices.Err(); err != nil {
+		return nil, err
+	}
+
+	return catalog, nil
+}
+
+func effectivePrice(item *ShopItem) int64 {
+	if item.ItemSalePrice != nil {
+		return *item.ItemSalePrice
+	}
+
+	return item.ItemPrice
+}
+
+func (c *stripeCatalog) check(item *ShopItem) []CatalogMismatch {
+	p, exists := c.products[item.StripeProductApiID]
+
+	if item.DeletedAt != nil {
+		if exists && p.Active {
+			return []CatalogMismatch{{
+				Kind:               MismatchDeletedButActive,
+				ShopItemID:         item.ID,
+				StripeProductApiID: item.StripeProductApiID,
+				Detail:             fmt.Sprintf("shop item %d is deleted but product %s is active", item.ID, p.ID),
+			}}
+		}
+
+		return nil
+	}
+
+	if !exists {
+		return []CatalogMismatch{{
+			Kind:               MismatchMissingProduct,
+			ShopItemID:         item.ID,
+			StripeProductApiID: item.StripeProductApiID,
+			Detail:             fmt.Sprintf("product %s of shop item %d doesn't exist", item.StripeProductApiID, item.ID),
+		}}
+	}
+
+	c.shopItems[item.ID] = item
+
+	var mismatches []CatalogMismatch
+	if !p.Active {
+		mismatches = append(mismatches, CatalogMismatch{
+			Kind:               MismatchInactiveProduct,
+			ShopItemID:         item.ID,
+			StripeProductApiID: p.ID,
+			Detail:             fmt.Sprintf("product %s of shop item %d is archived", p.ID, item.ID),
+		})
+	}
+
+	description := ""
+	if item.ItemDescription != nil {
+		description = *item.ItemDescription
+	}
+
+	if p.Name != item.ItemName || p.Description != description {
+		mismatches = append(mismatches, CatalogMismatch{
+			Kind:               MismatchProductDetails,
+			ShopItemID:         item.ID,
+			StripeProductApiID: p.ID,
+			Detail:             fmt.Sprintf("product %s is named %q, shop item %d %q", p.ID, p.Name, item.ID, item.ItemName),
+		})
+	}
+
+	wantPrice := effectivePrice(item)
+	current, ok := c.priceByLookupKey[item.UniqueStripePriceLookupKey]
+	switch {
+	case len(item.UniqueStripePriceLookupKey) == 0:
+		mismatches = append(mismatches, CatalogMismatch{
+			Kind:               MismatchMissingLookupKey,
+			ShopItemID:         item.ID,
+			StripeProductApiID: p.ID,
+			Detail:             fmt.Sprintf("shop item %d has no price lookup key", item.ID),
+		})
+	case !ok:
+		mismatches = append(mismatches, CatalogMismatch{
+			Kind:               MismatchWrongPrice,
+			ShopItemID:         item.ID,
+			StripeProductApiID: p.ID,
+			Detail:             fmt.Sprintf("no price has lookup key %s of shop item %d", item.UniqueStripePriceLookupKey, item.ID),
+		})
+	case !current.Active || current.UnitAmount != wantPrice || current.Product == nil || current.Product.ID != p.ID:
+		mismatches = append(mismatches, CatalogMismatch{
+			Kind:               MismatchWrongPrice,
+			ShopItemID:         item.ID,
+			StripeProductApiID: p.ID,
+			Detail:             fmt.Sprintf("price %s (active: %t, amount: %d) doesn't match shop item %d (amount: %d)", current.ID, current.Active, current.UnitAmount, item.ID, wantPrice),
+		})
+	}
+
+	return mismatches
+}
+
+// repair fixes the mismatch in Stripe, missing products and lookup keys are left alone
+func (c *stripeCatalog) repair(ctx context.Context, mismatch *CatalogMismatch) error {
+	switch mismatch.Kind {
+	case MismatchDeletedButActive, MismatchOrphanedProduct:
+		return archiveStripeProductAndPrices(ctx, mismatch.StripeProductApiID)
+	case MismatchInactiveProduct:
+		return reactivateStripeProduct(ctx, mismatch.StripeProductApiID)
+	}
+
+	item, ok := c.shopItems[mismatch.ShopItemID]
+	if !ok {
+		return nil
+	}
+
+	switch mismatch.Kind {
+	case MismatchProductDetails:
+		_, err := updateStripeProduct(ctx, mismatch.StripeProductApiID, item.ItemName, NewOptionalString(item.ItemDescription))
+		return err
+	case MismatchWrongPrice:
+		currency := string(stripe.CurrencyEUR)
+		current, hadPrice := c.priceByLookupKey[item.UniqueStripePriceLookupKey]
+		if hadPrice {
+			currency = string(current.Currency)
+		}
+
+		params := &stripe.PriceParams{
+			Product:           stripe.String(mismatch.StripeProductApiID),
+			Currency:          stripe.String(currency),
+			UnitAmount:        stripe.Int64(effectivePrice(item)),
+			LookupKey:         stripe.String(item.UniqueStripePriceLookupKey),
+			TransferLookupKey: stripe.Bool(true),
+		}
+		params.Context = ctx
+
+		if _, err := price.New(params); err != nil {
+			return err
+		}
+
+		if hadPrice && current.Active {
+			return archiveStripePrice(ctx, current.ID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package mop_shop
+
+import (
+	"context"
+	"github.com/croatiangrn/mop-shop/stripetest"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShop_ReconcileCatalog(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	stripeServer := stripetest.Start(t)
+	storage := NewMemoryStorage()
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(storage))
+
+	addItem := func(name string, price int64, product stripetest.Product, stripePrice *stripetest.Price) *ShopItem {
+		item := &ShopItem{ItemName: name, ItemPrice: price, Quantity: 1, StripeProductApiID: product.ID, UniqueStripePriceLookupKey: "key-" + product.ID, CreatedAt: now, UpdatedAt: now}
+		stripeServer.AddProduct(product)
+		if stripePrice != nil {
+			stripePrice.Product, stripePrice.LookupKey, stripePrice.Currency = product.ID, item.UniqueStripePriceLookupKey, "eur"
+			stripeServer.AddPrice(*stripePrice)
+		}
+
+		assert.NoError(t, storage.ShopItems().Create(ctx, item))
+		return item
+	}
+
+	addItem("Mop", 1000, stripetest.Product{ID: "prod_ok", Name: "Mop", Active: true}, &stripetest.Price{UnitAmount: 1000, Active: true})
+	drifted := addItem("Bucket", 2000, stripetest.Product{ID: "prod_drifted", Name: "Old bucket", Active: false}, &stripetest.Price{UnitAmount: 1500, Active: true})
+	deleted := addItem("Broom", 500, stripetest.Product{ID: "prod_deleted", Name: "Broom", Active: true}, &stripetest.Price{UnitAmount: 500, Active: true})
+	missing := &ShopItem{ItemName: "Sponge", ItemPrice: 100, StripeProductApiID: "prod_missing", UniqueStripePriceLookupKey: "key-missing"}
+	assert.NoError(t, storage.ShopItems().Create(ctx, missing))
+	assert.NoError(t, storage.ShopItems().SoftDelete(ctx, deleted.ID, now))
+
+	noLookupKey := &ShopItem{ItemName: "Rag", ItemPrice: 300, Quantity: 1, StripeProductApiID: "prod_no_key", CreatedAt: now, UpdatedAt: now}
+	stripeServer.AddProduct(stripetest.Product{ID: "prod_no_key", Name: "Rag", Active: true})
+	assert.NoError(t, storage.ShopItems().Create(ctx, noLookupKey))
+
+	stripeServer.AddProduct(stripetest.Product{ID: "prod_orphan", Name: "Orphan", Active: true, Metadata: map[string]string{StripeLookupKeyMetadata: "key"}})
+	// a product whose ShopItem.Create is still storing the shop item
+	stripeServer.AddProduct(stripetest.Product{ID: "prod_creating", Name: "Creating", Active: true, Metadata: map[string]string{StripeLookupKeyMetadata: "key-creating"}, Created: time.Now().Unix()})
+	stripeServer.AddProduct(stripetest.Product{ID: "prod_foreign", Name: "Not ours", Active: true})
+
+	kinds := func(report *CatalogReport) map[CatalogMismatchKind][]int {
+		found := map[CatalogMismatchKind][]int{}
+		for _, m := range report.Mismatches {
+			found[m.Kind] = append(found[m.Kind], m.ShopItemID)
+		}
+		return found
+	}
+
+	report, err := shop.ReconcileCatalog(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, 5, report.CheckedShopItems)
+	assert.Equal(t, map[CatalogMismatchKind][]int{
+		MismatchInactiveProduct:  {drifted.ID},
+		MismatchProductDetails:   {drifted.ID},
+		MismatchWrongPrice:       {drifted.ID},
+		MismatchDeletedButActive: {deleted.ID},
+		MismatchMissingProduct:   {missing.ID},
+		MismatchMissingLookupKey: {noLookupKey.ID},
+		MismatchOrphanedProduct:  {0},
+	}, kinds(report))
+
+	for _, request := range stripeServer.Requests() {
+		assert.True(t, strings.HasPrefix(request, http.MethodGet), "dry run must not change stripe, got %s", request)
+	}
+
+	report, err = shop.ReconcileCatalog(ctx, ReconcileRepair())
+	assert.NoError(t, err)
+	for _, m := range report.Mismatches {
+		assert.NoError(t, m.RepairErr)
+		assert.Equal(t, m.Kind != MismatchMissingProduct && m.Kind != MismatchMissingLookupKey, m.Repaired, "%s of shop item %d", m.Kind, m.ShopItemID)
+	}
+
+	report, err = shop.ReconcileCatalog(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[CatalogMismatchKind][]int{MismatchMissingProduct: {missing.ID}, MismatchMissingLookupKey: {noLookupKey.ID}}, kinds(report))
+
+	for _, p := range stripeServer.Prices() {
+		assert.NotEqual(t, "prod_no_key", p.Product, "no price should be created without a lookup key")
+	}
+
+	bucket, _ := stripeServer.Product("prod_drifted")
+	assert.True(t, bucket.Active)
+	assert.Equal(t, "Bucket", bucket.Name)
+
+	bucketPrice, _ := stripeServer.PriceByLookupKey(drifted.UniqueStripePriceLookupKey)
+	assert.Equal(t, int64(2000), bucketPrice.UnitAmount)
+
+	orphan, _ := stripeServer.Product("prod_orphan")
+	assert.False(t, orphan.Active)
+
+	foreign, _ := stripeServer.Product("prod_foreign")
+	assert.True(t, foreign.Active)
+
+	creating, _ := stripeServer.Product("prod_creating")
+	assert.True(t, creating.Active)
+
+	report, err = shop.ReconcileCatalog(ctx, ReconcileOrphanGracePeriod(-time.Minute))
+	assert.NoError(t, err)
+	assert.Contains(t, kinds(report), MismatchOrphanedProduct, "products older than the grace period are orphaned")
+}
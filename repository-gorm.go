@@ -0,0 +1,672 @@
+package mop_shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"math"
+	"time"
+)
+
+// gormShopItemRepository only uses GORM's query builder so it works on every dialect GORM supports
+type gormShopItemRepository struct {
+	db *gorm.DB
+}
+
+func NewGormShopItemRepository(db *gorm.DB) ShopItemRepository {
+	return &gormShopItemRepository{db: db}
+}
+
+func (r *gormShopItemRepository) FindOneByID(ctx context.Context, shopItemID int) (*ShopItem, error) {
+	item := &ShopItem{}
+	if err := r.db.WithContext(ctx).Where("id = ? AND deleted_at IS NULL", shopItemID).Take(item).Error; err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+func (r *gormShopItemRepository) FindOneDeletedByID(ctx context.Context, shopItemID int) (*ShopItem, error) {
+	item := &ShopItem{}
+	if err := r.db.WithContext(ctx).Where("id = ? AND deleted_at IS NOT NULL", shopItemID).Take(item).Error; err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+func (r *gormShopItemRepository) FindOneBySKU(ctx context.Context, sku string) (*ShopItem, error) {
+	item := &ShopItem{}
+	if err := r.db.WithContext(ctx).Where("sku = ? AND deleted_at IS NULL", sku).Take(item).Error; err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+func (r *gormShopItemRepository) FindOneDeletedBySKU(ctx context.Context, sku string) (*ShopItem, error) {
+	item := &ShopItem{}
+	if err := r.db.WithContext(ctx).Where("sku = ? AND deleted_at IS NOT NULL", sku).Take(item).Error; err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+func (r *gormShopItemRepository) Create(ctx context.Context, item *ShopItem) error {
+	return r.db.WithContext(ctx).Create(item).Error
+}
+
+func (r *gormShopItemRepository) Update(ctx context.Context, item *ShopItem) error {
+	return r.updateVersioned(ctx, item, shopItemColumns(item))
+}
+
+func (r *gormShopItemRepository) UpdateColumns(ctx context.Context, item *ShopItem, columns []string) error {
+	all := shopItemColumns(item)
+	updates := map[string]interface{}{"updated_at": item.UpdatedAt}
+	for _, column := range columns {
+		value, ok := all[column]
+		if !ok {
+			return fmt.Errorf("shop item column %q can't be updated", column)
+		}
+
+		updates[column] = value
+	}
+
+	return r.updateVersioned(ctx, item, updates)
+}
+
+func (r *gormShopItemRepository) updateVersioned(ctx context.Context, item *ShopItem, updates map[string]interface{}) error {
+	updates["version"] = gorm.Expr("version + 1")
+
+	if item.Version == 0 {
+		return r.db.WithContext(ctx).Model(&ShopItem{}).Where("id = ? AND deleted_at IS NULL", item.ID).Updates(updates).Error
+	}
+
+	update := r.db.WithContext(ctx).Model(&ShopItem{}).
+		Where("id = ? AND version = ? AND deleted_at IS NULL", item.ID, item.Version).
+		Updates(updates)
+	if update.Error != nil {
+		return update.Error
+	}
+
+	if update.RowsAffected == 0 {
+		return ErrVersionConflict
+	}
+
+	return nil
+}
+
+// shopItemColumns maps every editable column to its value in item
+func shopItemColumns(item *ShopItem) map[string]interface{} {
+	return map[string]interface{}{
+		"sku":              item.SKU,
+		"gtin":             item.GTIN,
+		"item_name":        item.ItemName,
+		"item_picture":     item.ItemPicture,
+		"item_price":       item.ItemPrice,
+		"item_sale_price":  item.ItemSalePrice,
+		"item_description": item.ItemDescription,
+		"shippable":        item.Shippable,
+		"weight_grams":     item.WeightGrams,
+		"tax_class":        item.TaxClass,
+		"quantity":         item.Quantity,
+		"updated_at":       item.UpdatedAt,
+	}
+}
+
+func (r *gormShopItemRepository) SoftDelete(ctx context.Context, shopItemID int, deletedAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&ShopItem{}).Where("id = ?", shopItemID).Updates(map[string]interface{}{
+		"deleted_at": deletedAt,
+		"updated_at": deletedAt,
+	}).Error
+}
+
+func (r *gormShopItemRepository) Restore(ctx context.Context, shopItemID int, updatedAt time.Time) error {
+	restore := r.db.WithContext(ctx).Model(&ShopItem{}).Where("id = ? AND deleted_at IS NOT NULL", shopItemID).Updates(map[string]interface{}{
+		"deleted_at": nil,
+		"updated_at": updatedAt,
+	})
+
+	if restore.Error != nil {
+		return restore.Error
+	}
+
+	if restore.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (r *gormShopItemRepository) FindDeleted(ctx context.Context, paginationParams PaginationParams) ([]ShopItem, error) {
+	query := paginate(r.db.WithContext(ctx).Where("deleted_at IS NOT NULL"), "id", paginationParams)
+
+	var data []ShopItem
+	if err := query.Find(&data).Error; err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *gormShopItemRepository) FindAll(ctx context.Context, paginationParams PaginationParams) ([]ShopItem, error) {
+	query := paginate(r.db.WithContext(ctx), "id", paginationParams)
+
+	var data []ShopItem
+	if err := query.Find(&data).Error; err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *gormShopItemRepository) FindForFrontend(ctx context.Context, paginationParams PaginationParams) ([]ShopItemForResponse, error) {
+	query := r.db.WithContext(ctx).Model(&ShopItem{}).
+		Select(`id, item_name, item_picture, item_price AS item_price_int_64, item_sale_price AS item_sale_price_int_64,
+			item_description, shippable, quantity`).
+		Where("deleted_at IS NULL")
+
+	query = paginate(query, "id", paginationParams)
+
+	var data []ShopItemForResponse
+	if err := query.Scan(&data).Error; err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *gormShopItemRepository) FindWithStripeInfoByIDs(ctx context.Context, shopItemIDs []int) ([]ItemWithStripeInfo, error) {
+	var data []ItemWithStripeInfo
+	err := r.db.WithContext(ctx).Model(&ShopItem{}).
+		Select("id AS item_id, sku, item_name, item_picture, item_description, stripe_product_api_id, unique_stripe_price_lookup_key, item_price, item_sale_price, shippable, weight_grams, tax_class, quantity").
+		Where("id IN ?", shopItemIDs).
+		Scan(&data).Error
+
+	return data, err
+}
+
+func (r *gormShopItemRepository) FindWithStripeInfoByStripeProductIDs(ctx context.Context, stripeProductApiIDs []string) ([]ItemWithStripeInfo, error) {
+	var data []ItemWithStripeInfo
+	err := r.db.WithContext(ctx).Model(&ShopItem{}).
+		Select("id AS item_id, sku, item_name, item_picture, item_description, unique_stripe_price_lookup_key, item_price, item_sale_price, stripe_product_api_id").
+		Where("stripe_product_api_id IN ?", stripeProductApiIDs).
+		Scan(&data).Error
+
+	return data, err
+}
+
+// paginate applies the cursor conditions shared by all listings: without a cursor the newest rows come first,
+// After continues towards older rows and Before goes back towards newer ones. One extra row is fetched so
+// callers can tell whether there is another page.
+func paginate(query *gorm.DB, idColumn string, paginationParams PaginationParams) *gorm.DB {
+	switch {
+	case paginationParams.Before == 0 && paginationParams.After == 0:
+		query = query.Where(idColumn+" > ?", 0).Order(idColumn + " DESC")
+	case paginationParams.After > 0:
+		query = query.Where(idColumn+" <= ?", paginationParams.After).Order(idColumn + " DESC")
+	case paginationParams.Before > 0:
+		query = query.Where(idColumn+" >= ?", paginationParams.Before).Order(idColumn + " ASC")
+	}
+
+	return query.Limit(paginationParams.PerPage + 1)
+}
+
+type gormPriceHistoryRepository struct {
+	db *gorm.DB
+}
+
+func NewGormPriceHistoryRepository(db *gorm.DB) PriceHistoryRepository {
+	return &gormPriceHistoryRepository{db: db}
+}
+
+func (r *gormPriceHistoryRepository) Add(ctx context.Context, entry *ShopItemPriceHistory) error {
+	return r.db.WithContext(ctx).Create(entry).Error
+}
+
+func (r *gormPriceHistoryRepository) FindByShopItemID(ctx context.Context, shopItemID int) ([]ShopItemPriceHistory, error) {
+	var data []ShopItemPriceHistory
+	err := r.db.WithContext(ctx).Where("shop_item_id = ?", shopItemID).Order("effective_from ASC, id ASC").Find(&data).Error
+	return data, err
+}
+
+func (r *gormPriceHistoryRepository) FindInEffectAt(ctx context.Context, shopItemID int, at time.Time) (*ShopItemPriceHistory, error) {
+	entry := &ShopItemPriceHistory{}
+	err := r.db.WithContext(ctx).
+		Where("shop_item_id = ? AND effective_from <= ?", shopItemID, at).
+		Order("effective_from DESC, id DESC").
+		Take(entry).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+type gormShopItemImageRepository struct {
+	db *gorm.DB
+}
+
+func NewGormShopItemImageRepository(db *gorm.DB) ShopItemImageRepository {
+	return &gormShopItemImageRepository{db: db}
+}
+
+func (r *gormShopItemImageRepository) Replace(ctx context.Context, shopItemID int, images []ShopItemImage) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("shop_item_id = ?", shopItemID).Delete(&ShopItemImage{}).Error; err != nil {
+			return err
+		}
+
+		if len(images) == 0 {
+			return nil
+		}
+
+		return tx.Create(&images).Error
+	})
+}
+
+// Append locks the shop item row so concurrent appends to the same item are serialized and can't reuse a position
+// or exceed maxImages. SQLite has no row locks but only allows one writing transaction anyway.
+func (r *gormShopItemImageRepository) Append(ctx context.Context, shopItemID int, image *ShopItemImage, maxImages int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var ids []int
+		if err := tx.Model(&ShopItem{}).Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", shopItemID).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+
+		var count int64
+		if err := tx.Model(&ShopItemImage{}).Where("shop_item_id = ?", shopItemID).Count(&count).Error; err != nil {
+			return err
+		}
+
+		if int(count) >= maxImages {
+			return ErrTooManyImages
+		}
+
+		image.ID, image.ShopItemID, image.Position = 0, shopItemID, int(count)
+		return tx.Create(image).Error
+	})
+}
+
+func (r *gormShopItemImageRepository) FindByShopItemIDs(ctx context.Context, shopItemIDs []int) ([]ShopItemImage, error) {
+	var data []ShopItemImage
+	if len(shopItemIDs) == 0 {
+		return data, nil
+	}
+
+	err := r.db.WithContext(ctx).Where("shop_item_id IN ?", shopItemIDs).Order("shop_item_id ASC, position ASC").Find(&data).Error
+	return data, err
+}
+
+type gormShopItemTranslationRepository struct {
+	db *gorm.DB
+}
+
+func NewGormShopItemTranslationRepository(db *gorm.DB) ShopItemTranslationRepository {
+	return &gormShopItemTranslationRepository{db: db}
+}
+
+func (r *gormShopItemTranslationRepository) Save(ctx context.Context, translation *ShopItemTranslation) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		existing := &ShopItemTranslation{}
+		err := tx.Where("shop_item_id = ? AND locale = ?", translation.ShopItemID, translation.Locale).Take(existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tx.Create(translation).Error
+		}
+
+		if err != nil {
+			return err
+		}
+
+		translation.ID = existing.ID
+		return tx.Model(existing).Updates(map[string]interface{}{
+			"item_name":        translation.ItemName,
+			"item_description": translation.ItemDescription,
+		}).Error
+	})
+}
+
+func (r *gormShopItemTranslationRepository) Delete(ctx context.Context, shopItemID int, locale string) error {
+	result := r.db.WithContext(ctx).Where("shop_item_id = ? AND locale = ?", shopItemID, locale).Delete(&ShopItemTranslation{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (r *gormShopItemTranslationRepository) FindByShopItemIDs(ctx context.Context, shopItemIDs []int, locales []string) ([]ShopItemTranslation, error) {
+	var data []ShopItemTranslation
+	if len(shopItemIDs) == 0 {
+		return data, nil
+	}
+
+	query := r.db.WithContext(ctx).Where("shop_item_id IN ?", shopItemIDs)
+	if len(locales) > 0 {
+		query = query.Where("locale IN ?", locales)
+	}
+
+	err := query.Order("shop_item_id ASC, locale ASC").Find(&data).Error
+	return data, err
+}
+
+type gormOrderRepository struct {
+	db    *gorm.DB
+	users UserResolver
+}
+
+// NewGormOrderRepository only returns orders of users found through DefaultUserResolver
+func NewGormOrderRepository(db *gorm.DB) OrderRepository {
+	return NewGormOrderRepositoryWithUsers(db, DefaultUserResolver())
+}
+
+// NewGormOrderRepositoryWithUsers joins orders with the users table described by users, see UserResolver
+func NewGormOrderRepositoryWithUsers(db *gorm.DB, users UserResolver) OrderRepository {
+	return &gormOrderRepository{db: db, users: users}
+}
+
+func (r *gormOrderRepository) CreateEmptyOrder(ctx context.Context, order *UserOrder) error {
+	return r.db.WithContext(ctx).Select("user_id", "total_price", "created_at", "updated_at", "stripe_client_reference_id", "shipping_method", "shipping_price", "shipping_address", "billing_address", "tax_amount", "tax_lines", "vat_id", "reverse_charge", "item_snapshots").
+		Create(order).Error
+}
+
+func (r *gormOrderRepository) FindOneByClientReferenceID(ctx context.Context, clientReferenceID string, orderCompleted bool) (*UserOrder, error) {
+	order := &UserOrder{}
+	err := r.db.WithContext(ctx).
+		Where("stripe_client_reference_id = ? AND is_completed = ?", clientReferenceID, orderCompleted).
+		Take(order).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func (r *gormOrderRepository) CompleteOrder(ctx context.Context, completion OrderCompletion) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{
+			"updated_at":        completion.CompletedAt,
+			"is_completed":      true,
+			"total_price":       completion.TotalPrice,
+			"stripe_session_id": completion.StripeSessionID,
+		}
+
+		if completion.ShippingAddress != nil {
+			updates["shipping_address"] = completion.ShippingAddress
+		}
+
+		orderUpdate := tx.Model(&UserOrder{}).Where("stripe_client_reference_id = ?", completion.ClientReferenceID).Updates(updates)
+
+		if orderUpdate.Error != nil {
+			return orderUpdate.Error
+		}
+
+		if orderUpdate.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if len(completion.Items) == 0 {
+			return nil
+		}
+
+		if err := tx.Create(&completion.Items).Error; err != nil {
+			return err
+		}
+
+		for i := range completion.Items {
+			err := tx.Model(&ShopItem{}).
+				Where("id = ?", completion.Items[i].ShopItemID).
+				UpdateColumns(map[string]interface{}{
+					"quantity": gorm.Expr("quantity - ?", completion.Items[i].Quantity),
+					"version":  gorm.Expr("version + 1"),
+				}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// orderRow and orderItemRow read prices as floats since they are stored in float columns, which not every driver
+// converts to integers
+type orderRow struct {
+	ID          int
+	TotalPrice  float64
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	IsCompleted bool
+}
+
+func (o orderRow) toFrontResponse() UserOrderFrontResponse {
+	return UserOrderFrontResponse{
+		ID:              o.ID,
+		TotalPriceInt64: roundedInt64(o.TotalPrice),
+		CreatedAt:       o.CreatedAt,
+		UpdatedAt:       o.UpdatedAt,
+		IsCompleted:     o.IsCompleted,
+	}
+}
+
+// orderDetailsRow is orderRow with the shipping and tax columns returned for a single order
+type orderDetailsRow struct {
+	ID              int
+	TotalPrice      float64
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	IsCompleted     bool
+	ShippingMethod  *string
+	ShippingPrice   float64
+	ShippingAddress *OrderAddress
+	BillingAddress  *OrderAddress
+	TaxAmount       float64
+	TaxLines        OrderTaxLines `gorm:"type:text;"`
+	VATID           *string       `gorm:"column:vat_id;"`
+	ReverseCharge   bool
+}
+
+func (o orderDetailsRow) toFrontResponse() UserOrderFrontResponse {
+	data := orderRow{ID: o.ID, TotalPrice: o.TotalPrice, CreatedAt: o.CreatedAt, UpdatedAt: o.UpdatedAt, IsCompleted: o.IsCompleted}.toFrontResponse()
+	data.ShippingAddress, data.BillingAddress = o.ShippingAddress, o.BillingAddress
+
+	if o.ShippingMethod != nil {
+		data.ShippingMethod = o.ShippingMethod
+		data.ShippingPriceInt64 = roundedInt64(o.ShippingPrice)
+	}
+
+	if len(o.TaxLines) > 0 {
+		data.TaxAmountInt64 = roundedInt64(o.TaxAmount)
+		data.TaxLines = taxLinesFrontResponse(o.TaxLines)
+	}
+
+	data.VATID, data.ReverseCharge = o.VATID, o.ReverseCharge
+	return data
+}
+
+type orderItemRow struct {
+	ItemID           int
+	SKU              *string
+	ItemName         *string
+	ItemPrice        float64
+	ItemPicture      *string
+	ItemDescription  *string
+	ItemTranslations OrderItemTranslations `gorm:"type:text;"`
+	Quantity         int
+}
+
+func roundedInt64(f float64) *int64 {
+	rounded := int64(math.Round(f))
+	return &rounded
+}
+
+const (
+	orderColumns        = "uo.id, uo.total_price, uo.created_at, uo.updated_at, uo.is_completed"
+	orderDetailsColumns = orderColumns + ", uo.shipping_method, uo.shipping_price, uo.shipping_address, uo.billing_address, uo.tax_amount, uo.tax_lines, uo.vat_id, uo.reverse_charge"
+)
+
+func (r *gormOrderRepository) ordersOfUser(ctx context.Context, userID int, completedOnly bool, columns string) *gorm.DB {
+	query := r.db.WithContext(ctx).Table("user_orders uo").Select(columns)
+
+	if join := r.users.joinClause(); len(join) > 0 {
+		query = query.Joins(join)
+	}
+
+	query = query.Where("uo.user_id = ?", userID)
+
+	if completedOnly {
+		query = query.Where("uo.is_completed = ?", true)
+	}
+
+	return query
+}
+
+func (r *gormOrderRepository) FindByUserID(ctx context.Context, userID int, completedOnly bool, paginationParams PaginationParams) ([]UserOrderFrontResponse, error) {
+	query := r.ordersOfUser(ctx, userID, completedOnly, orderColumns).
+		Where("EXISTS (SELECT 1 FROM user_order_items uoi WHERE uoi.user_order_id = uo.id)")
+
+	var rows []orderRow
+	if err := paginate(query, "uo.id", paginationParams).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	data := make([]UserOrderFrontResponse, 0, len(rows))
+	for i := range rows {
+		data = append(data, rows[i].toFrontResponse())
+	}
+
+	return data, nil
+}
+
+func (r *gormOrderRepository) FindOneByIDAndUserID(ctx context.Context, orderID, userID int, completedOnly bool) (*UserOrderFrontResponse, error) {
+	var row orderDetailsRow
+	if err := r.ordersOfUser(ctx, userID, completedOnly, orderDetailsColumns).Where("uo.id = ?", orderID).Take(&row).Error; err != nil {
+		return nil, err
+	}
+
+	var itemRows []orderItemRow
+	err := r.db.WithContext(ctx).Table("user_order_items uoi").
+		Select("uoi.shop_item_id AS item_id, uoi.sku, uoi.item_name, uoi.item_price, uoi.item_picture, uoi.item_description, uoi.item_translations, uoi.quantity").
+		Where("uoi.user_order_id = ?", orderID).
+		Order("uoi.id ASC").
+		Scan(&itemRows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if len(itemRows) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	data := row.toFrontResponse()
+	for i := range itemRows {
+		item := UserOrderItemFrontResponse{
+			ItemID:         itemRows[i].ItemID,
+			SKU:            itemRows[i].SKU,
+			ItemPriceInt64: roundedInt64(itemRows[i].ItemPrice),
+			Quantity:       itemRows[i].Quantity,
+			translations:   itemRows[i].ItemTranslations,
+		}
+
+		if itemRows[i].ItemName != nil {
+			item.ItemName = *itemRows[i].ItemName
+		}
+
+		if itemRows[i].ItemPicture != nil {
+			item.ItemPicture = *itemRows[i].ItemPicture
+		}
+
+		if itemRows[i].ItemDescription != nil {
+			item.ItemDescription = *itemRows[i].ItemDescription
+		}
+
+		data.Items = append(data.Items, item)
+	}
+
+	return &data, nil
+}
+
+// invoiceSequence is the last invoice sequence used in a year
+type invoiceSequence struct {
+	Year         int `gorm:"primaryKey;autoIncrement:false;"`
+	LastSequence int `gorm:"not null;"`
+}
+
+func (s *invoiceSequence) TableName() string {
+	return "invoice_sequences"
+}
+
+type gormInvoiceRepository struct {
+	db *gorm.DB
+}
+
+func NewGormInvoiceRepository(db *gorm.DB) InvoiceRepository {
+	return &gormInvoiceRepository{db: db}
+}
+
+// Create increments the sequence of the year before reading it, which locks its row until the transaction ends so
+// concurrent invoices of the same year wait for each other. The first invoices of a year issued concurrently may
+// fail with a duplicate key error on the sequence instead, retrying them succeeds.
+func (r *gormInvoiceRepository) Create(ctx context.Context, invoice *Invoice, formatNumber func(year, sequence int) string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&invoiceSequence{}).Where("year = ?", invoice.Year).UpdateColumn("last_sequence", gorm.Expr("last_sequence + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			if err := tx.Create(&invoiceSequence{Year: invoice.Year, LastSequence: 1}).Error; err != nil {
+				return err
+			}
+		}
+
+		sequence := &invoiceSequence{}
+		if err := tx.Where("year = ?", invoice.Year).Take(sequence).Error; err != nil {
+			return err
+		}
+
+		invoice.Sequence = sequence.LastSequence
+		invoice.Number = formatNumber(invoice.Year, invoice.Sequence)
+		return tx.Create(invoice).Error
+	})
+}
+
+func (r *gormInvoiceRepository) FindOneByUserOrderID(ctx context.Context, userOrderID int) (*Invoice, error) {
+	invoice := &Invoice{}
+	if err := r.db.WithContext(ctx).Where("user_order_id = ?", userOrderID).Take(invoice).Error; err != nil {
+		return nil, err
+	}
+
+	return invoice, nil
+}
+
+type gormTransactionRunner struct {
+	db    *gorm.DB
+	users UserResolver
+}
+
+// NewGormTransactionRunner runs functions in transactions of db, joining orders with users like
+// NewGormOrderRepositoryWithUsers does. Transactions the repositories start themselves become savepoints.
+func NewGormTransactionRunner(db *gorm.DB, users UserResolver) TransactionRunner {
+	return &gormTransactionRunner{db: db, users: users}
+}
+
+func (r *gormTransactionRunner) WithinTransaction(ctx context.Context, fn func(repos Repositories) error) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(Repositories{
+			ShopItems:    NewGormShopItemRepository(tx),
+			Orders:       NewGormOrderRepositoryWithUsers(tx, r.users),
+			PriceHistory: NewGormPriceHistoryRepository(tx),
+			Images:       NewGormShopItemImageRepository(tx),
+			Translations: NewGormShopItemTranslationRepository(tx),
+			Invoices:     NewGormInvoiceRepository(tx),
+		})
+	})
+}
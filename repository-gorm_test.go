@@ -0,0 +1,252 @@
+package mop_shop
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func newGormForTest(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	dbTest, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	t.Cleanup(func() {
+		//goland:noinspection GoUnhandledErrorResult
+		dbTest.Close()
+	})
+
+	dialector := mysql.New(mysql.Config{
+		DSN:                       "sqlmock_db_0",
+		DriverName:                "postgres",
+		Conn:                      dbTest,
+		SkipInitializeWithVersion: true,
+	})
+
+	database, _ := gorm.Open(dialector, &gorm.Config{})
+	return database, mock
+}
+
+func TestGormOrderRepository_CompleteOrder(t *testing.T) {
+	completedAt := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	updateOrderQuery := "UPDATE `user_orders` SET `is_completed`=?,`stripe_session_id`=?,`total_price`=?,`updated_at`=? WHERE stripe_client_reference_id = ?"
+	insertItemsQuery := "INSERT INTO `user_order_items` (`user_order_id`,`shop_item_id`,`item_name`,`item_price`,`quantity`) VALUES (?,?,?,?,?),(?,?,?,?,?)"
+	updateQuantityQuery := "UPDATE `shop_items` SET `quantity`=quantity - ?,`version`=version + 1 WHERE id = ?"
+
+	completion := OrderCompletion{
+		ClientReferenceID: "ref",
+		StripeSessionID:   "cs_test",
+		TotalPrice:        3000,
+		CompletedAt:       completedAt,
+		Items: []UserOrderItem{
+			{UserOrderID: 4, ShopItemID: 1, ItemName: "Mop", ItemPrice: 1000, Quantity: 1},
+			{UserOrderID: 4, ShopItemID: 2, ItemName: "Bucket", ItemPrice: 1000, Quantity: 2},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		expectMock func(mock sqlmock.Sqlmock)
+		wantErr    error
+	}{
+		{
+			name: "All is good",
+			expectMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(updateOrderQuery)).
+					WithArgs(true, "cs_test", float32(3000), completedAt, "ref").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta(insertItemsQuery)).
+					WithArgs(4, 1, "Mop", float32(1000), 1, 4, 2, "Bucket", float32(1000), 2).
+					WillReturnResult(sqlmock.NewResult(1, 2))
+				mock.ExpectExec(regexp.QuoteMeta(updateQuantityQuery)).WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta(updateQuantityQuery)).WithArgs(2, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			wantErr: nil,
+		},
+		{
+			name: "Order not found is rolled back",
+			expectMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(updateOrderQuery)).
+					WithArgs(true, "cs_test", float32(3000), completedAt, "ref").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+			},
+			wantErr: gorm.ErrRecordNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database, mock := newGormForTest(t)
+			tt.expectMock(mock)
+
+			err := NewGormOrderRepository(database).CompleteOrder(context.Background(), completion)
+			assert.Equal(t, tt.wantErr, err, "CompleteOrder() error = %v, wantErr %v", err, tt.wantErr)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGormOrderRepository_FindOneByIDAndUserID(t *testing.T) {
+	database, mock := newGormForTest(t)
+	createdAt := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	sku := "MOP-1"
+	shippingMethod := "standard"
+
+	orderQuery := "SELECT uo.id, uo.total_price, uo.created_at, uo.updated_at, uo.is_completed, uo.shipping_method, uo.shipping_price, uo.shipping_address, uo.billing_address, uo.tax_amount, uo.tax_lines, uo.vat_id, uo.reverse_charge FROM user_orders uo " +
+		"INNER JOIN users u ON u.id = uo.user_id AND u.deleted_at IS NULL WHERE uo.user_id = ? AND uo.is_completed = ? AND uo.id = ? LIMIT 1"
+	itemsQuery := "SELECT uoi.shop_item_id AS item_id, uoi.sku, uoi.item_name, uoi.item_price, uoi.item_picture, uoi.item_description, uoi.item_translations, uoi.quantity " +
+		"FROM user_order_items uoi WHERE uoi.user_order_id = ? ORDER BY uoi.id ASC"
+
+	mock.ExpectQuery(regexp.QuoteMeta(orderQuery)).WithArgs(3, true, 8).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "total_price", "created_at", "updated_at", "is_completed", "shipping_method", "shipping_price", "shipping_address", "billing_address", "tax_amount", "tax_lines", "vat_id", "reverse_charge"}).
+			AddRow(8, 3499.0, createdAt, createdAt, true, shippingMethod, 500.0, `{"name":"Ana","line1":"Ilica 1","city":"Zagreb","postal_code":"10000","country":"HR"}`, nil,
+				700.0, `[{"tax_class":"standard","country":"HR","rate":2500,"net_amount":2799,"tax_amount":700}]`, nil, false))
+	mock.ExpectQuery(regexp.QuoteMeta(itemsQuery)).WithArgs(8).WillReturnRows(
+		sqlmock.NewRows([]string{"item_id", "sku", "item_name", "item_price", "item_picture", "item_description", "item_translations", "quantity"}).
+			AddRow(1, "MOP-1", "Mop", 2999.0, nil, "Wooden mop", `[{"locale":"en","item_name":"Floor mop","item_description":null}]`, 1))
+
+	got, err := NewGormOrderRepository(database).FindOneByIDAndUserID(context.Background(), 8, 3, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, &UserOrderFrontResponse{
+		ID:                 8,
+		TotalPriceInt64:    roundedInt64(3499),
+		CreatedAt:          createdAt,
+		UpdatedAt:          createdAt,
+		IsCompleted:        true,
+		ShippingMethod:     &shippingMethod,
+		ShippingPriceInt64: roundedInt64(500),
+		ShippingAddress:    &OrderAddress{Name: "Ana", Line1: "Ilica 1", City: "Zagreb", PostalCode: "10000", Country: "HR"},
+		TaxAmountInt64:     roundedInt64(700),
+		TaxLines:           []UserOrderTaxLineFrontResponse{{TaxClass: TaxClassStandard, Country: "HR", Rate: 25, NetAmount: 27.99, TaxAmount: 7}},
+		Items: []UserOrderItemFrontResponse{
+			{ItemID: 1, SKU: &sku, ItemName: "Mop", ItemPriceInt64: roundedInt64(2999), ItemDescription: "Wooden mop", Quantity: 1, translations: OrderItemTranslations{{Locale: "en", ItemName: "Floor mop"}}},
+		},
+	}, got)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGormShopItemRepository_UpdateColumns(t *testing.T) {
+	database, mock := newGormForTest(t)
+	updatedAt := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	picture := "mop.png"
+
+	query := "UPDATE `shop_items` SET `item_picture`=?,`quantity`=?,`updated_at`=?,`version`=version + 1 WHERE id = ? AND version = ? AND deleted_at IS NULL"
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(query)).WithArgs(picture, 4, updatedAt, 7, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(query)).WithArgs(picture, 4, updatedAt, 7, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	item := &ShopItem{ID: 7, ItemName: "Mop", ItemPicture: &picture, Quantity: 4, UpdatedAt: updatedAt, Version: 2}
+	repository := NewGormShopItemRepository(database)
+	assert.NoError(t, repository.UpdateColumns(context.Background(), item, []string{"item_picture", "quantity"}))
+	assert.Equal(t, ErrVersionConflict, repository.UpdateColumns(context.Background(), item, []string{"item_picture", "quantity"}))
+	assert.Error(t, repository.UpdateColumns(context.Background(), item, []string{"stripe_product_api_id"}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// newSQLiteForTest returns a migrated SQLite database, so repositories run their real queries
+func newSQLiteForTest(t *testing.T) *gorm.DB {
+	database, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "shop.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening an SQLite database", err)
+	}
+
+	if err := Migrate(database); err != nil {
+		t.Fatalf("an error '%s' was not expected when migrating", err)
+	}
+
+	return database
+}
+
+func TestGormOrderRepository_SQLite(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLiteForTest(t)
+	items := NewGormShopItemRepository(db)
+	orders := NewGormOrderRepository(db)
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.NoError(t, db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, deleted_at DATETIME NULL)").Error)
+	deletedAt := now.Add(-time.Hour)
+	assert.NoError(t, db.Exec("INSERT INTO users (id, deleted_at) VALUES (?, NULL), (?, ?)", 1, 2, deletedAt).Error)
+
+	item := &ShopItem{ItemName: "Mug", ItemPrice: 1500, Quantity: 10, StripeProductApiID: "prod_mug", Version: 1, CreatedAt: now, UpdatedAt: now}
+	assert.NoError(t, items.Create(ctx, item))
+
+	sku := "MUG-1"
+	snapshots := OrderItemSnapshots{{ShopItemID: item.ID, SKU: &sku, ItemName: "Mug", Translations: OrderItemTranslations{{Locale: "en", ItemName: "Cup"}}}}
+	for _, order := range []*UserOrder{
+		{UserID: 1, TotalPrice: 3000, StripeClientReferenceID: "ref-active", CreatedAt: now, UpdatedAt: now, ItemSnapshots: snapshots},
+		{UserID: 2, TotalPrice: 1500, StripeClientReferenceID: "ref-deleted", CreatedAt: now, UpdatedAt: now},
+	} {
+		assert.NoError(t, orders.CreateEmptyOrder(ctx, order))
+	}
+
+	active, err := orders.FindOneByClientReferenceID(ctx, "ref-active", false)
+	assert.NoError(t, err)
+	assert.Equal(t, snapshots, active.ItemSnapshots)
+
+	assert.NoError(t, orders.CompleteOrder(ctx, OrderCompletion{
+		ClientReferenceID: "ref-active",
+		StripeSessionID:   "cs_test_1",
+		TotalPrice:        3000,
+		CompletedAt:       now,
+		Items:             []UserOrderItem{{UserOrderID: active.ID, ShopItemID: item.ID, SKU: &sku, ItemName: "Mug", ItemTranslations: snapshots[0].Translations, ItemPrice: 1500, Quantity: 2}},
+	}))
+
+	assert.True(t, IsNotFound(orders.CompleteOrder(ctx, OrderCompletion{ClientReferenceID: "ref-missing", CompletedAt: now})))
+
+	stock, err := items.FindOneByID(ctx, item.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, stock.Quantity)
+	assert.Equal(t, 2, stock.Version)
+
+	details, err := orders.FindOneByIDAndUserID(ctx, active.ID, 1, true)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3000), *details.TotalPriceInt64)
+	assert.True(t, details.IsCompleted)
+	assert.Len(t, details.Items, 1)
+	assert.Equal(t, "Mug", details.Items[0].ItemName)
+	assert.Equal(t, &sku, details.Items[0].SKU)
+	assert.Equal(t, snapshots[0].Translations, details.Items[0].translations)
+	assert.Equal(t, int64(1500), *details.Items[0].ItemPriceInt64)
+	assert.Equal(t, 2, details.Items[0].Quantity)
+
+	_, err = orders.FindOneByIDAndUserID(ctx, active.ID, 2, false)
+	assert.True(t, IsNotFound(err), "order of another user should not be found, got %v", err)
+
+	list, err := orders.FindByUserID(ctx, 1, true, PaginationParams{})
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+	assert.Equal(t, active.ID, list[0].ID)
+
+	list, err = orders.FindByUserID(ctx, 2, false, PaginationParams{})
+	assert.NoError(t, err)
+	assert.Empty(t, list, "orders of deleted users and orders without items should not be listed")
+
+	duplicate := &ShopItem{ItemName: "Mug", ItemPrice: 1500, StripeProductApiID: "prod_mug", Version: 1, CreatedAt: now, UpdatedAt: now}
+	assert.True(t, IsConflict(items.Create(ctx, duplicate)), "duplicate stripe product ID should conflict")
+}
+
+func TestGormTransactionRunner_SQLite(t *testing.T) {
+	db := newSQLiteForTest(t)
+	testWithinTransaction(t, NewShop(db, ""), NewGormShopItemRepository(db))
+}
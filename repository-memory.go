@@ -0,0 +1,1088 @@
+package mop_shop
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps shop items and orders in memory, behaving like the GORM repositories do on a real database.
+// It's meant for tests and demos and is safe for concurrent use. Table based UserResolvers are ignored since there
+// is no users table, use UserCallbackResolver to limit orders to active users.
+type MemoryStorage struct {
+	mu                sync.RWMutex
+	shopItems         map[int]ShopItem
+	orders            map[int]UserOrder
+	orderItems        map[int][]UserOrderItem
+	priceHistory      []ShopItemPriceHistory
+	images            map[int][]ShopItemImage
+	translations      map[int]map[string]ShopItemTranslation
+	invoices          map[int]Invoice
+	invoiceSequences  map[int]int
+	lastShopItemID    int
+	lastOrderID       int
+	lastOrderItemID   int
+	lastImageID       int
+	lastTranslationID int
+	lastInvoiceID     int
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		shopItems:        map[int]ShopItem{},
+		orders:           map[int]UserOrder{},
+		orderItems:       map[int][]UserOrderItem{},
+		images:           map[int][]ShopItemImage{},
+		translations:     map[int]map[string]ShopItemTranslation{},
+		invoices:         map[int]Invoice{},
+		invoiceSequences: map[int]int{},
+	}
+}
+
+// WithMemoryStorage stores shop items and orders in storage instead of the DB passed to NewShop
+func WithMemoryStorage(storage *MemoryStorage) Option {
+	return func(s *Shop) {
+		s.items = storage.ShopItems()
+		s.orders = storage.Orders()
+		s.priceHistory = storage.PriceHistory()
+		s.images = storage.Images()
+		s.translations = storage.Translations()
+		s.invoices = storage.Invoices()
+		s.transactions = storage
+	}
+}
+
+func (m *MemoryStorage) ShopItems() ShopItemRepository {
+	return &memoryShopItemRepository{storage: m}
+}
+
+func (m *MemoryStorage) Orders() OrderRepository {
+	return &memoryOrderRepository{storage: m}
+}
+
+func (m *MemoryStorage) PriceHistory() PriceHistoryRepository {
+	return &memoryPriceHistoryRepository{storage: m}
+}
+
+func (m *MemoryStorage) Images() ShopItemImageRepository {
+	return &memoryShopItemImageRepository{storage: m}
+}
+
+func (m *MemoryStorage) Translations() ShopItemTranslationRepository {
+	return &memoryShopItemTranslationRepository{storage: m}
+}
+
+func (m *MemoryStorage) Invoices() InvoiceRepository {
+	return &memoryInvoiceRepository{storage: m}
+}
+
+func (m *MemoryStorage) repositories() Repositories {
+	return Repositories{
+		ShopItems:    m.ShopItems(),
+		Orders:       m.Orders(),
+		PriceHistory: m.PriceHistory(),
+		Images:       m.Images(),
+		Translations: m.Translations(),
+		Invoices:     m.Invoices(),
+	}
+}
+
+// WithinTransaction runs fn on a copy of the storage that replaces it once fn succeeds. The storage stays locked
+// meanwhile, so transactions are serialized and fn must only use the repositories it's given.
+func (m *MemoryStorage) WithinTransaction(ctx context.Context, fn func(repos Repositories) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := m.copyState()
+	if err := fn(tx.repositories()); err != nil {
+		return err
+	}
+
+	m.shopItems, m.orders, m.orderItems, m.priceHistory = tx.shopItems, tx.orders, tx.orderItems, tx.priceHistory
+	m.images, m.translations, m.invoices, m.invoiceSequences = tx.images, tx.translations, tx.invoices, tx.invoiceSequences
+	m.lastShopItemID, m.lastOrderID, m.lastOrderItemID = tx.lastShopItemID, tx.lastOrderID, tx.lastOrderItemID
+	m.lastImageID, m.lastTranslationID, m.lastInvoiceID = tx.lastImageID, tx.lastTranslationID, tx.lastInvoiceID
+	return nil
+}
+
+// copyState copies the maps and slices of the storage, values are stored as clones so they are shared safely.
+// The caller holds the lock.
+func (m *MemoryStorage) copyState() *MemoryStorage {
+	c := NewMemoryStorage()
+	for id, item := range m.shopItems {
+		c.shopItems[id] = item
+	}
+
+	for id, order := range m.orders {
+		c.orders[id] = order
+	}
+
+	for id, items := range m.orderItems {
+		c.orderItems[id] = append([]UserOrderItem{}, items...)
+	}
+
+	c.priceHistory = append([]ShopItemPriceHistory{}, m.priceHistory...)
+
+	for id, images := range m.images {
+		c.images[id] = append([]ShopItemImage{}, images...)
+	}
+
+	for id, translations := range m.translations {
+		c.translations[id] = map[string]ShopItemTranslation{}
+		for locale, translation := range translations {
+			c.translations[id][locale] = translation
+		}
+	}
+
+	for id, invoice := range m.invoices {
+		c.invoices[id] = invoice
+	}
+
+	for year, sequence := range m.invoiceSequences {
+		c.invoiceSequences[year] = sequence
+	}
+
+	c.lastShopItemID, c.lastOrderID, c.lastOrderItemID = m.lastShopItemID, m.lastOrderID, m.lastOrderItemID
+	c.lastImageID, c.lastTranslationID, c.lastInvoiceID = m.lastImageID, m.lastTranslationID, m.lastInvoiceID
+	return c
+}
+
+// cloneShopItem copies item without sharing pointers, so neither the caller nor the storage see later changes
+// made by the other one
+func cloneShopItem(item ShopItem) ShopItem {
+	item.items, item.priceHistory, item.logger = nil, nil, nil
+	item.SKU = cloneString(item.SKU)
+	item.GTIN = cloneString(item.GTIN)
+	item.ItemPicture = cloneString(item.ItemPicture)
+	item.ItemSalePrice = cloneInt64(item.ItemSalePrice)
+	item.ItemDescription = cloneString(item.ItemDescription)
+	item.WeightGrams = cloneInt(item.WeightGrams)
+
+	if item.DeletedAt != nil {
+		deletedAt := *item.DeletedAt
+		item.DeletedAt = &deletedAt
+	}
+
+	return item
+}
+
+func cloneString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+
+	c := *s
+	return &c
+}
+
+func cloneInt64(i *int64) *int64 {
+	if i == nil {
+		return nil
+	}
+
+	c := *i
+	return &c
+}
+
+func cloneInt(i *int) *int {
+	if i == nil {
+		return nil
+	}
+
+	c := *i
+	return &c
+}
+
+// paginateIDs mirrors paginate for IDs held in memory
+func paginateIDs(ids []int, paginationParams PaginationParams) []int {
+	var filtered []int
+	for _, id := range ids {
+		switch {
+		case paginationParams.Before == 0 && paginationParams.After == 0:
+			filtered = append(filtered, id)
+		case paginationParams.After > 0 && id <= paginationParams.After:
+			filtered = append(filtered, id)
+		case paginationParams.After == 0 && paginationParams.Before > 0 && id >= paginationParams.Before:
+			filtered = append(filtered, id)
+		}
+	}
+
+	if paginationParams.After == 0 && paginationParams.Before > 0 {
+		sort.Ints(filtered)
+	} else {
+		sort.Sort(sort.Reverse(sort.IntSlice(filtered)))
+	}
+
+	if len(filtered) > paginationParams.PerPage+1 {
+		filtered = filtered[:paginationParams.PerPage+1]
+	}
+
+	return filtered
+}
+
+type memoryShopItemRepository struct {
+	storage *MemoryStorage
+}
+
+func (r *memoryShopItemRepository) FindOneByID(ctx context.Context, shopItemID int) (*ShopItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.storage.mu.RLock()
+	defer r.storage.mu.RUnlock()
+
+	item, ok := r.storage.shopItems[shopItemID]
+	if !ok || item.DeletedAt != nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	found := cloneShopItem(item)
+	return &found, nil
+}
+
+func (r *memoryShopItemRepository) FindOneDeletedByID(ctx context.Context, shopItemID int) (*ShopItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.storage.mu.RLock()
+	defer r.storage.mu.RUnlock()
+
+	item, ok := r.storage.shopItems[shopItemID]
+	if !ok || item.DeletedAt == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	found := cloneShopItem(item)
+	return &found, nil
+}
+
+func (r *memoryShopItemRepository) FindOneBySKU(ctx context.Context, sku string) (*ShopItem, error) {
+	return r.findOneBySKU(ctx, sku, false)
+}
+
+func (r *memoryShopItemRepository) FindOneDeletedBySKU(ctx context.Context, sku string) (*ShopItem, error) {
+	return r.findOneBySKU(ctx, sku, true)
+}
+
+func (r *memoryShopItemRepository) findOneBySKU(ctx context.Context, sku string, deleted bool) (*ShopItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.storage.mu.RLock()
+	defer r.storage.mu.RUnlock()
+
+	for _, item := range r.storage.shopItems {
+		if item.SKU != nil && *item.SKU == sku && (item.DeletedAt != nil) == deleted {
+			found := cloneShopItem(item)
+			return &found, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryShopItemRepository) Create(ctx context.Context, item *ShopItem) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.storage.mu.Lock()
+	defer r.storage.mu.Unlock()
+
+	for _, existing := range r.storage.shopItems {
+		if existing.StripeProductApiID == item.StripeProductApiID || (item.SKU != nil && equalStrings(existing.SKU, item.SKU)) {
+			return ErrDuplicateKey
+		}
+	}
+
+	r.storage.lastShopItemID++
+	item.ID = r.storage.lastShopItemID
+	if item.Version == 0 {
+		item.Version = 1
+	}
+	r.storage.shopItems[item.ID] = cloneShopItem(*item)
+	return nil
+}
+
+func (r *memoryShopItemRepository) Update(ctx context.Context, item *ShopItem) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.storage.mu.Lock()
+	defer r.storage.mu.Unlock()
+
+	stored, ok := r.storage.shopItems[item.ID]
+	if !ok || stored.DeletedAt != nil || (item.Version != 0 && stored.Version != item.Version) {
+		if item.Version != 0 {
+			return ErrVersionConflict
+		}
+
+		return nil
+	}
+
+	if r.skuTaken(item) {
+		return ErrDuplicateKey
+	}
+
+	stored.SKU = cloneString(item.SKU)
+	stored.GTIN = cloneString(item.GTIN)
+	stored.ItemName = item.ItemName
+	stored.ItemPicture = cloneString(item.ItemPicture)
+	stored.ItemPrice = item.ItemPrice
+	stored.ItemSalePrice = cloneInt64(item.ItemSalePrice)
+	stored.ItemDescription = cloneString(item.ItemDescription)
+	stored.Shippable = item.Shippable
+	stored.WeightGrams = cloneInt(item.WeightGrams)
+	stored.TaxClass = item.TaxClass
+	stored.Quantity = item.Quantity
+	stored.UpdatedAt = item.UpdatedAt
+	stored.Version++
+	r.storage.shopItems[item.ID] = stored
+	return nil
+}
+
+func (r *memoryShopItemRepository) UpdateColumns(ctx context.Context, item *ShopItem, columns []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.storage.mu.Lock()
+	defer r.storage.mu.Unlock()
+
+	stored, ok := r.storage.shopItems[item.ID]
+	if !ok || stored.DeletedAt != nil || (item.Version != 0 && stored.Version != item.Version) {
+		if item.Version != 0 {
+			return ErrVersionConflict
+		}
+
+		return nil
+	}
+
+	for _, column := range columns {
+		switch column {
+		case "sku":
+			if r.skuTaken(item) {
+				return ErrDuplicateKey
+			}
+
+			stored.SKU = cloneString(item.SKU)
+		case "gtin":
+			stored.GTIN = cloneString(item.GTIN)
+		case "item_name":
+			stored.ItemName = item.ItemName
+		case "item_picture":
+			stored.ItemPicture = cloneString(item.ItemPicture)
+		case "item_price":
+			stored.ItemPrice = item.ItemPrice
+		case "item_sale_price":
+			stored.ItemSalePrice = cloneInt64(item.ItemSalePrice)
+		case "item_description":
+			stored.ItemDescription = cloneString(item.ItemDescription)
+		case "shippable":
+			stored.Shippable = item.Shippable
+		case "weight_grams":
+			stored.WeightGrams = cloneInt(item.WeightGrams)
+		case "tax_class":
+			stored.TaxClass = item.TaxClass
+		case "quantity":
+			stored.Quantity = item.Quantity
+		case "updated_at":
+		default:
+			return fmt.Errorf("shop item column %q can't be updated", column)
+		}
+	}
+
+	stored.UpdatedAt = item.UpdatedAt
+	stored.Version++
+	r.storage.shopItems[item.ID] = stored
+	return nil
+}
+
+// skuTaken reports whether another item, soft-deleted ones included, has the SKU of item
+func (r *memoryShopItemRepository) skuTaken(item *ShopItem) bool {
+	if item.SKU == nil {
+		return false
+	}
+
+	for id, existing := range r.storage.shopItems {
+		if id != item.ID && equalStrings(existing.SKU, item.SKU) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *memoryShopItemRepository) SoftDelete(ctx context.Context, shopItemID int, deletedAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.storage.mu.Lock()
+	defer r.storage.mu.Unlock()
+
+	stored, ok := r.storage.shopItems[shopItemID]
+	if !ok {
+		return nil
+	}
+
+	stored.DeletedAt = &deletedAt
+	stored.UpdatedAt = deletedAt
+	r.storage.shopItems[shopItemID] = stored
+	return nil
+}
+
+func (r *memoryShopItemRepository) Restore(ctx context.Context, shopItemID int, updatedAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.storage.mu.Lock()
+	defer r.storage.mu.Unlock()
+
+	stored, ok := r.storage.shopItems[shopItemID]
+	if !ok || stored.DeletedAt == nil {
+		return gorm.ErrRecordNotFound
+	}
+
+	stored.DeletedAt = nil
+	stored.UpdatedAt = updatedAt
+	r.storage.shopItems[shopItemID] = stored
+	return nil
+}
+
+func (r *memoryShopItemRepository) FindDeleted(ctx context.Context, paginationParams PaginationParams) ([]ShopItem, error) {
+	return r.findPage(ctx, paginationParams, func(item ShopItem) bool { return item.DeletedAt != nil })
+}
+
+func (r *memoryShopItemRepository) FindAll(ctx context.Context, paginationParams PaginationParams) ([]ShopItem, error) {
+	return r.findPage(ctx, paginationParams, func(item ShopItem) bool { return true })
+}
+
+// findPage returns matching items around the cursor the way paginateIDs orders them
+func (r *memoryShopItemRepository) findPage(ctx context.Context, paginationParams PaginationParams, match func(item ShopItem) bool) ([]ShopItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.storage.mu.RLock()
+	defer r.storage.mu.RUnlock()
+
+	var ids []int
+	for id, item := range r.storage.shopItems {
+		if match(item) {
+			ids = append(ids, id)
+		}
+	}
+
+	ids = paginateIDs(ids, paginationParams)
+
+	data := make([]ShopItem, 0, len(ids))
+	for _, id := range ids {
+		data = append(data, cloneShopItem(r.storage.shopItems[id]))
+	}
+
+	return data, nil
+}
+
+func (r *memoryShopItemRepository) FindForFrontend(ctx context.Context, paginationParams PaginationParams) ([]ShopItemForResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.storage.mu.RLock()
+	defer r.storage.mu.RUnlock()
+
+	var ids []int
+	for id, item := range r.storage.shopItems {
+		if item.DeletedAt == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	ids = paginateIDs(ids, paginationParams)
+
+	data := make([]ShopItemForResponse, 0, len(ids))
+	for _, id := range ids {
+		item := cloneShopItem(r.storage.shopItems[id])
+		price, quantity := item.ItemPrice, item.Quantity
+
+		data = append(data, ShopItemForResponse{
+			ID:                 item.ID,
+			ItemName:           item.ItemName,
+			ItemPicture:        item.ItemPicture,
+			ItemPriceInt64:     &price,
+			ItemSalePriceInt64: item.ItemSalePrice,
+			ItemDescription:    item.ItemDescription,
+			Shippable:          item.Shippable,
+			Quantity:           &quantity,
+		})
+	}
+
+	return data, nil
+}
+
+func itemWithStripeInfo(item ShopItem) ItemWithStripeInfo {
+	info := ItemWithStripeInfo{
+		ItemID:                     item.ID,
+		SKU:                        cloneString(item.SKU),
+		ItemName:                   item.ItemName,
+		ItemPicture:                cloneString(item.ItemPicture),
+		ItemDescription:            cloneString(item.ItemDescription),
+		UniqueStripePriceLookupKey: item.UniqueStripePriceLookupKey,
+		ItemPrice:                  float32(item.ItemPrice),
+		StripeProductApiID:         item.StripeProductApiID,
+		Shippable:                  item.Shippable,
+		WeightGrams:                cloneInt(item.WeightGrams),
+		TaxClass:                   item.TaxClass,
+		Quantity:                   item.Quantity,
+	}
+
+	if item.ItemSalePrice != nil {
+		salePrice := float32(*item.ItemSalePrice)
+		info.ItemSalePrice = &salePrice
+	}
+
+	return info
+}
+
+func (r *memoryShopItemRepository) FindWithStripeInfoByIDs(ctx context.Context, shopItemIDs []int) ([]ItemWithStripeInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.storage.mu.RLock()
+	defer r.storage.mu.RUnlock()
+
+	var data []ItemWithStripeInfo
+	for _, id := range shopItemIDs {
+		if item, ok := r.storage.shopItems[id]; ok {
+			data = append(data, itemWithStripeInfo(item))
+		}
+	}
+
+	return data, nil
+}
+
+func (r *memoryShopItemRepository) FindWithStripeInfoByStripeProductIDs(ctx context.Context, stripeProductApiIDs []string) ([]ItemWithStripeInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.storage.mu.RLock()
+	defer r.storage.mu.RUnlock()
+
+	wanted := make(map[string]bool, len(stripeProductApiIDs))
+	for _, id := range stripeProductApiIDs {
+		wanted[id] = true
+	}
+
+	var data []ItemWithStripeInfo
+	for _, item := range r.storage.shopItems {
+		if wanted[item.StripeProductApiID] {
+			data = append(data, itemWithStripeInfo(item))
+		}
+	}
+
+	return data, nil
+}
+
+type memoryPriceHistoryRepository struct {
+	storage *MemoryStorage
+}
+
+func (r *memoryPriceHistoryRepository) Add(ctx context.Context, entry *ShopItemPriceHistory) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.storage.mu.Lock()
+	defer r.storage.mu.Unlock()
+
+	entry.ID = len(r.storage.priceHistory) + 1
+
+	stored := *entry
+	stored.ItemSalePrice = cloneInt64(entry.ItemSalePrice)
+	stored.ChangedBy = cloneString(entry.ChangedBy)
+	r.storage.priceHistory = append(r.storage.priceHistory, stored)
+	return nil
+}
+
+// entriesOf returns entries of the item ordered by EffectiveFrom and ID ascending
+func (r *memoryPriceHistoryRepository) entriesOf(shopItemID int) []ShopItemPriceHistory {
+	var data []ShopItemPriceHistory
+	for _, entry := range r.storage.priceHistory {
+		if entry.ShopItemID == shopItemID {
+			entry.ItemSalePrice = cloneInt64(entry.ItemSalePrice)
+			entry.ChangedBy = cloneString(entry.ChangedBy)
+			data = append(data, entry)
+		}
+	}
+
+	sort.SliceStable(data, func(i, j int) bool { return data[i].EffectiveFrom.Before(data[j].EffectiveFrom) })
+	return data
+}
+
+func (r *memoryPriceHistoryRepository) FindByShopItemID(ctx context.Context, shopItemID int) ([]ShopItemPriceHistory, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.storage.mu.RLock()
+	defer r.storage.mu.RUnlock()
+
+	return r.entriesOf(shopItemID), nil
+}
+
+func (r *memoryPriceHistoryRepository) FindInEffectAt(ctx context.Context, shopItemID int, at time.Time) (*ShopItemPriceHistory, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.storage.mu.RLock()
+	defer r.storage.mu.RUnlock()
+
+	entries := r.entriesOf(shopItemID)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].EffectiveFrom.After(at) {
+			return &entries[i], nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+type memoryShopItemImageRepository struct {
+	storage *MemoryStorage
+}
+
+func cloneShopItemImage(image ShopItemImage) ShopItemImage {
+	image.AltText = cloneString(image.AltText)
+	image.Width = cloneInt(image.Width)
+	image.Height = cloneInt(image.Height)
+	image.Thumbnails = append(ImageThumbnails(nil), image.Thumbnails...)
+	return image
+}
+
+func (r *memoryShopItemImageRepository) Replace(ctx context.Context, shopItemID int, images []ShopItemImage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.storage.mu.Lock()
+	defer r.storage.mu.Unlock()
+
+	stored := make([]ShopItemImage, 0, len(images))
+	for i := range images {
+		r.storage.lastImageID++
+		images[i].ID, images[i].ShopItemID = r.storage.lastImageID, shopItemID
+		stored = append(stored, cloneShopItemImage(images[i]))
+	}
+
+	sort.SliceStable(stored, func(i, j int) bool { return stored[i].Position < stored[j].Position })
+	r.storage.images[shopItemID] = stored
+	return nil
+}
+
+func (r *memoryShopItemImageRepository) Append(ctx context.Context, shopItemID int, image *ShopItemImage, maxImages int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.storage.mu.Lock()
+	defer r.storage.mu.Unlock()
+
+	stored := r.storage.images[shopItemID]
+	if len(stored) >= maxImages {
+		return ErrTooManyImages
+	}
+
+	r.storage.lastImageID++
+	image.ID, image.ShopItemID, image.Position = r.storage.lastImageID, shopItemID, len(stored)
+	r.storage.images[shopItemID] = append(stored, cloneShopItemImage(*image))
+	return nil
+}
+
+func (r *memoryShopItemImageRepository) FindByShopItemIDs(ctx context.Context, shopItemIDs []int) ([]ShopItemImage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.storage.mu.RLock()
+	defer r.storage.mu.RUnlock()
+
+	ids := append([]int(nil), shopItemIDs...)
+	sort.Ints(ids)
+
+	var data []ShopItemImage
+	for i, id := range ids {
+		if i > 0 && ids[i-1] == id {
+			continue
+		}
+
+		for _, image := range r.storage.images[id] {
+			data = append(data, cloneShopItemImage(image))
+		}
+	}
+
+	return data, nil
+}
+
+type memoryShopItemTranslationRepository struct {
+	storage *MemoryStorage
+}
+
+func (r *memoryShopItemTranslationRepository) Save(ctx context.Context, translation *ShopItemTranslation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.storage.mu.Lock()
+	defer r.storage.mu.Unlock()
+
+	translations := r.storage.translations[translation.ShopItemID]
+	if translations == nil {
+		translations = map[string]ShopItemTranslation{}
+		r.storage.translations[translation.ShopItemID] = translations
+	}
+
+	if existing, ok := translations[translation.Locale]; ok {
+		translation.ID = existing.ID
+	} else {
+		r.storage.lastTranslationID++
+		translation.ID = r.storage.lastTranslationID
+	}
+
+	stored := *translation
+	stored.ItemDescription = cloneString(translation.ItemDescription)
+	translations[translation.Locale] = stored
+	return nil
+}
+
+func (r *memoryShopItemTranslationRepository) Delete(ctx context.Context, shopItemID int, locale string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.storage.mu.Lock()
+	defer r.storage.mu.Unlock()
+
+	if _, ok := r.storage.translations[shopItemID][locale]; !ok {
+		return gorm.ErrRecordNotFound
+	}
+
+	delete(r.storage.translations[shopItemID], locale)
+	return nil
+}
+
+func (r *memoryShopItemTranslationRepository) FindByShopItemIDs(ctx context.Context, shopItemIDs []int, locales []string) ([]ShopItemTranslation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.storage.mu.RLock()
+	defer r.storage.mu.RUnlock()
+
+	wanted := map[string]bool{}
+	for _, locale := range locales {
+		wanted[locale] = true
+	}
+
+	ids := append([]int(nil), shopItemIDs...)
+	sort.Ints(ids)
+
+	var data []ShopItemTranslation
+	for i, id := range ids {
+		if i > 0 && ids[i-1] == id {
+			continue
+		}
+
+		start := len(data)
+		for locale, translation := range r.storage.translations[id] {
+			if len(wanted) == 0 || wanted[locale] {
+				translation.ItemDescription = cloneString(translation.ItemDescription)
+				data = append(data, translation)
+			}
+		}
+
+		found := data[start:]
+		sort.Slice(found, func(i, j int) bool { return found[i].Locale < found[j].Locale })
+	}
+
+	return data, nil
+}
+
+type memoryOrderRepository struct {
+	storage *MemoryStorage
+}
+
+func cloneUserOrder(order UserOrder) UserOrder {
+	order.orderItems, order.shippingMethods, order.taxes, order.invoicing, order.orders, order.items, order.translations, order.logger = nil, nil, nil, nil, nil, nil, nil, nil
+	order.StripeSessionID = cloneString(order.StripeSessionID)
+	order.ShippingMethod = cloneString(order.ShippingMethod)
+	order.ShippingAddress = cloneOrderAddress(order.ShippingAddress)
+	order.BillingAddress = cloneOrderAddress(order.BillingAddress)
+	order.TaxLines = append(OrderTaxLines(nil), order.TaxLines...)
+	order.VATID = cloneString(order.VATID)
+	order.ItemSnapshots = append(OrderItemSnapshots(nil), order.ItemSnapshots...)
+	return order
+}
+
+func (r *memoryOrderRepository) CreateEmptyOrder(ctx context.Context, order *UserOrder) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.storage.mu.Lock()
+	defer r.storage.mu.Unlock()
+
+	r.storage.lastOrderID++
+	order.ID = r.storage.lastOrderID
+
+	stored := cloneUserOrder(*order)
+	stored.StripeSessionID = nil
+	stored.IsCompleted = false
+	r.storage.orders[order.ID] = stored
+	return nil
+}
+
+func (r *memoryOrderRepository) FindOneByClientReferenceID(ctx context.Context, clientReferenceID string, orderCompleted bool) (*UserOrder, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.storage.mu.RLock()
+	defer r.storage.mu.RUnlock()
+
+	for _, order := range r.storage.orders {
+		if order.StripeClientReferenceID == clientReferenceID && order.IsCompleted == orderCompleted {
+			found := cloneUserOrder(order)
+			return &found, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+// CompleteOrder holds the write lock for the whole completion, which makes it as atomic as the GORM transaction
+func (r *memoryOrderRepository) CompleteOrder(ctx context.Context, completion OrderCompletion) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.storage.mu.Lock()
+	defer r.storage.mu.Unlock()
+
+	var orderIDs []int
+	for id, order := range r.storage.orders {
+		if order.StripeClientReferenceID == completion.ClientReferenceID {
+			orderIDs = append(orderIDs, id)
+		}
+	}
+
+	if len(orderIDs) == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	for _, id := range orderIDs {
+		order := r.storage.orders[id]
+		sessionID := completion.StripeSessionID
+		order.UpdatedAt = completion.CompletedAt
+		order.IsCompleted = true
+		order.TotalPrice = completion.TotalPrice
+		order.StripeSessionID = &sessionID
+		if completion.ShippingAddress != nil {
+			order.ShippingAddress = cloneOrderAddress(completion.ShippingAddress)
+		}
+		r.storage.orders[id] = order
+	}
+
+	for i := range completion.Items {
+		r.storage.lastOrderItemID++
+		completion.Items[i].ID = r.storage.lastOrderItemID
+
+		orderItem := completion.Items[i]
+		orderItem.SKU = cloneString(orderItem.SKU)
+		orderItem.ItemPicture = cloneString(orderItem.ItemPicture)
+		orderItem.ItemDescription = cloneString(orderItem.ItemDescription)
+		orderItem.ItemTranslations = append(OrderItemTranslations(nil), orderItem.ItemTranslations...)
+		r.storage.orderItems[orderItem.UserOrderID] = append(r.storage.orderItems[orderItem.UserOrderID], orderItem)
+
+		if item, ok := r.storage.shopItems[orderItem.ShopItemID]; ok {
+			item.Quantity -= orderItem.Quantity
+			item.Version++
+			r.storage.shopItems[orderItem.ShopItemID] = item
+		}
+	}
+
+	return nil
+}
+
+func (r *memoryOrderRepository) ordersOfUser(userID int, completedOnly bool) []int {
+	var ids []int
+	for id, order := range r.storage.orders {
+		if order.UserID == userID && (!completedOnly || order.IsCompleted) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+func orderFrontResponse(order UserOrder) UserOrderFrontResponse {
+	return UserOrderFrontResponse{
+		ID:              order.ID,
+		TotalPriceInt64: roundedInt64(float64(order.TotalPrice)),
+		CreatedAt:       order.CreatedAt,
+		UpdatedAt:       order.UpdatedAt,
+		IsCompleted:     order.IsCompleted,
+	}
+}
+
+// orderItemsOf returns items of the order as they were when it was completed
+func (r *memoryOrderRepository) orderItemsOf(orderID int) []UserOrderItemFrontResponse {
+	var data []UserOrderItemFrontResponse
+	for _, orderItem := range r.storage.orderItems[orderID] {
+		response := UserOrderItemFrontResponse{
+			ItemID:         orderItem.ShopItemID,
+			SKU:            cloneString(orderItem.SKU),
+			ItemName:       orderItem.ItemName,
+			ItemPriceInt64: roundedInt64(float64(orderItem.ItemPrice)),
+			Quantity:       orderItem.Quantity,
+			translations:   append(OrderItemTranslations(nil), orderItem.ItemTranslations...),
+		}
+
+		if orderItem.ItemPicture != nil {
+			response.ItemPicture = *orderItem.ItemPicture
+		}
+
+		if orderItem.ItemDescription != nil {
+			response.ItemDescription = *orderItem.ItemDescription
+		}
+
+		data = append(data, response)
+	}
+
+	return data
+}
+
+func (r *memoryOrderRepository) FindByUserID(ctx context.Context, userID int, completedOnly bool, paginationParams PaginationParams) ([]UserOrderFrontResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.storage.mu.RLock()
+	defer r.storage.mu.RUnlock()
+
+	var ids []int
+	for _, id := range r.ordersOfUser(userID, completedOnly) {
+		if len(r.orderItemsOf(id)) > 0 {
+			ids = append(ids, id)
+		}
+	}
+
+	ids = paginateIDs(ids, paginationParams)
+
+	data := make([]UserOrderFrontResponse, 0, len(ids))
+	for _, id := range ids {
+		data = append(data, orderFrontResponse(r.storage.orders[id]))
+	}
+
+	return data, nil
+}
+
+func (r *memoryOrderRepository) FindOneByIDAndUserID(ctx context.Context, orderID, userID int, completedOnly bool) (*UserOrderFrontResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.storage.mu.RLock()
+	defer r.storage.mu.RUnlock()
+
+	order, ok := r.storage.orders[orderID]
+	if !ok || order.UserID != userID || (completedOnly && !order.IsCompleted) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	items := r.orderItemsOf(orderID)
+	if len(items) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	data := orderFrontResponse(order)
+	data.ShippingAddress, data.BillingAddress = cloneOrderAddress(order.ShippingAddress), cloneOrderAddress(order.BillingAddress)
+	if order.ShippingMethod != nil {
+		data.ShippingMethod = cloneString(order.ShippingMethod)
+		data.ShippingPriceInt64 = roundedInt64(float64(order.ShippingPrice))
+	}
+
+	if len(order.TaxLines) > 0 {
+		data.TaxAmountInt64 = roundedInt64(float64(order.TaxAmount))
+		data.TaxLines = taxLinesFrontResponse(order.TaxLines)
+	}
+
+	data.VATID, data.ReverseCharge = cloneString(order.VATID), order.ReverseCharge
+	data.Items = items
+	return &data, nil
+}
+
+type memoryInvoiceRepository struct {
+	storage *MemoryStorage
+}
+
+func cloneInvoice(invoice Invoice) Invoice {
+	invoice.Seller.Address = cloneOrderAddress(invoice.Seller.Address)
+	invoice.Buyer.Address = cloneOrderAddress(invoice.Buyer.Address)
+	invoice.Lines = append(InvoiceLines(nil), invoice.Lines...)
+	invoice.TaxLines = append(OrderTaxLines(nil), invoice.TaxLines...)
+	return invoice
+}
+
+// Create holds the write lock while assigning the sequence, so sequences have no gaps like with the GORM repository
+func (r *memoryInvoiceRepository) Create(ctx context.Context, invoice *Invoice, formatNumber func(year, sequence int) string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.storage.mu.Lock()
+	defer r.storage.mu.Unlock()
+
+	for _, existing := range r.storage.invoices {
+		if existing.UserOrderID == invoice.UserOrderID {
+			return ErrDuplicateKey
+		}
+	}
+
+	r.storage.invoiceSequences[invoice.Year]++
+	r.storage.lastInvoiceID++
+	invoice.ID = r.storage.lastInvoiceID
+	invoice.Sequence = r.storage.invoiceSequences[invoice.Year]
+	invoice.Number = formatNumber(invoice.Year, invoice.Sequence)
+	r.storage.invoices[invoice.ID] = cloneInvoice(*invoice)
+	return nil
+}
+
+func (r *memoryInvoiceRepository) FindOneByUserOrderID(ctx context.Context, userOrderID int) (*Invoice, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.storage.mu.RLock()
+	defer r.storage.mu.RUnlock()
+
+	for _, invoice := range r.storage.invoices {
+		if invoice.UserOrderID == userOrderID {
+			found := cloneInvoice(invoice)
+			return &found, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
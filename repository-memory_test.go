@@ -0,0 +1,172 @@
+package mop_shop
+
+import (
+	"context"
+	"errors"
+	"github.com/croatiangrn/mop-shop/stripetest"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMemoryStorage_shopItems(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	storage := NewMemoryStorage()
+	shop := NewShop(nil, "", WithMemoryStorage(storage))
+
+	stripeServer := stripetest.Start(t)
+	stripeServer.AddProduct(stripetest.Product{ID: "prod_2", Name: "Mop"})
+
+	for i := 1; i <= 3; i++ {
+		item := &ShopItem{ItemName: "Mop", ItemPrice: int64(i * 1000), Quantity: 5, StripeProductApiID: "prod_" + strconv.Itoa(i), CreatedAt: now, UpdatedAt: now}
+		assert.NoError(t, storage.ShopItems().Create(ctx, item))
+		assert.Equal(t, i, item.ID)
+	}
+
+	err := storage.ShopItems().Create(ctx, &ShopItem{StripeProductApiID: "prod_1"})
+	assert.True(t, IsConflict(err), "duplicate stripe product ID should conflict, got %v", err)
+
+	item := shop.NewShopItem()
+	assert.NoError(t, item.FindOneByIDContext(ctx, 2))
+	assert.Equal(t, int64(2000), item.ItemPrice)
+
+	assert.NoError(t, item.items.SoftDelete(ctx, 2, now))
+	assert.True(t, errors.Is(shop.NewShopItem().FindOneByIDContext(ctx, 2), gorm.ErrRecordNotFound))
+
+	deleted, deletedPages, err := shop.FindDeletedShopItemsContext(ctx, PaginationParams{PerPage: 10}, httptest.NewRequest("GET", "/items/deleted", nil))
+	assert.NoError(t, err)
+	if assert.Len(t, deleted, 1) {
+		assert.Equal(t, 2, deleted[0].ID)
+	}
+	assert.Nil(t, deletedPages.After)
+
+	req := httptest.NewRequest("GET", "/items?per_page=1", nil)
+	data, pages, err := shop.GetShopItemsForFrontendContext(ctx, true, "eur", "", PaginationParams{PerPage: 1}, req)
+	assert.NoError(t, err)
+	if assert.Len(t, data, 1) {
+		assert.Equal(t, 3, data[0].ID)
+	}
+	if assert.NotNil(t, pages.After) {
+		assert.Equal(t, "1", *pages.After)
+	}
+
+	assert.NoError(t, shop.NewShopItem().RestoreContext(ctx, 2, now))
+	assert.True(t, errors.Is(shop.NewShopItem().RestoreContext(ctx, 2, now), gorm.ErrRecordNotFound))
+	assert.NoError(t, shop.NewShopItem().FindOneByIDContext(ctx, 2))
+}
+
+func TestMemoryStorage_orders(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	storage := NewMemoryStorage()
+	shop := NewShop(nil, "", WithMemoryStorage(storage))
+
+	description := "Wooden mop"
+	mop := &ShopItem{ItemName: "Mop", ItemDescription: &description, ItemPrice: 2999, Quantity: 5, StripeProductApiID: "prod_mop"}
+	assert.NoError(t, storage.ShopItems().Create(ctx, mop))
+
+	order := shop.NewUserOrder()
+	assert.NoError(t, order.CreateEmptyOrderContext(ctx, 3, "ref"))
+	assert.Equal(t, 1, order.ID)
+
+	_, err := shop.FindOrderByByIDAndUserIDContext(ctx, order.ID, 3, false, "eur", "")
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "orders without items should not be found, got %v", err)
+
+	err = storage.Orders().CompleteOrder(ctx, OrderCompletion{ClientReferenceID: "missing", CompletedAt: now})
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+
+	err = storage.Orders().CompleteOrder(ctx, OrderCompletion{
+		ClientReferenceID: "ref",
+		StripeSessionID:   "cs_test",
+		TotalPrice:        5998,
+		CompletedAt:       now,
+		Items:             []UserOrderItem{{UserOrderID: order.ID, ShopItemID: mop.ID, ItemName: "Mop", ItemDescription: &description, ItemPrice: 2999, Quantity: 2}},
+	})
+	assert.NoError(t, err)
+
+	stock, err := storage.ShopItems().FindOneByID(ctx, mop.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, stock.Quantity)
+
+	completed, err := storage.Orders().FindOneByClientReferenceID(ctx, "ref", true)
+	assert.NoError(t, err)
+	if assert.NotNil(t, completed.StripeSessionID) {
+		assert.Equal(t, "cs_test", *completed.StripeSessionID)
+	}
+
+	description = "Plastic mop"
+	renamed := *stock
+	renamed.ItemName = "Old mop"
+	assert.NoError(t, storage.ShopItems().Update(ctx, &renamed))
+	assert.NoError(t, storage.ShopItems().SoftDelete(ctx, mop.ID, now))
+
+	got, err := shop.FindOrderByByIDAndUserIDContext(ctx, order.ID, 3, true, "eur", "")
+	assert.NoError(t, err)
+	if assert.Len(t, got.Items, 1) {
+		assert.Equal(t, "Mop", got.Items[0].ItemName)
+		assert.Equal(t, "Wooden mop", got.Items[0].ItemDescription)
+		assert.Equal(t, 2, got.Items[0].Quantity)
+		assert.Equal(t, 29.99, *got.Items[0].ItemPrice)
+	}
+	assert.Equal(t, 59.98, *got.TotalPrice)
+
+	req := httptest.NewRequest("GET", "/orders", nil)
+	orders, _, err := shop.FindOrdersByUserIDContext(ctx, 3, true, PaginationParams{PerPage: 10}, "eur", req)
+	assert.NoError(t, err)
+	assert.Len(t, orders, 1)
+
+	_, err = shop.FindOrderByByIDAndUserIDContext(ctx, order.ID, 4, true, "eur", "")
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+}
+
+// testWithinTransaction checks that writes of a failed transaction are discarded and writes of a successful one
+// kept, items being the repository of the same storage outside of transactions
+func testWithinTransaction(t *testing.T, shop *Shop, items ShopItemRepository) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	errAbort := errors.New("abort")
+
+	var rolledBackID int
+	err := shop.WithinTransaction(ctx, func(repos Repositories) error {
+		item := &ShopItem{ItemName: "Bucket", ItemPrice: 900, StripeProductApiID: "prod_tx_1", Version: 1, CreatedAt: now, UpdatedAt: now}
+		if err := repos.ShopItems.Create(ctx, item); err != nil {
+			return err
+		}
+
+		rolledBackID = item.ID
+		_, err := repos.ShopItems.FindOneByID(ctx, item.ID)
+		assert.NoError(t, err, "item should be visible inside the transaction")
+		return errAbort
+	})
+	assert.Equal(t, errAbort, err)
+
+	_, err = items.FindOneByID(ctx, rolledBackID)
+	assert.True(t, IsNotFound(err), "item of a failed transaction should be discarded, got %v", err)
+
+	var committed *ShopItem
+	err = shop.WithinTransaction(ctx, func(repos Repositories) error {
+		committed = &ShopItem{ItemName: "Bucket", ItemPrice: 900, StripeProductApiID: "prod_tx_2", Version: 1, CreatedAt: now, UpdatedAt: now}
+		if err := repos.ShopItems.Create(ctx, committed); err != nil {
+			return err
+		}
+
+		return repos.PriceHistory.Add(ctx, &ShopItemPriceHistory{ShopItemID: committed.ID, ItemPrice: 900, EffectiveFrom: now})
+	})
+	assert.NoError(t, err)
+
+	found, err := items.FindOneByID(ctx, committed.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "prod_tx_2", found.StripeProductApiID)
+}
+
+func TestMemoryStorage_WithinTransaction(t *testing.T) {
+	storage := NewMemoryStorage()
+	testWithinTransaction(t, NewShop(nil, "", WithMemoryStorage(storage)), storage.ShopItems())
+
+	err := NewShop(nil, "").WithinTransaction(context.Background(), func(repos Repositories) error { return nil })
+	assert.True(t, errors.Is(err, ErrTransactionsNotConfigured))
+}
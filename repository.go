@@ -0,0 +1,126 @@
+package mop_shop
+
+import (
+	"context"
+	"time"
+)
+
+// ShopItemRepository stores shop items. Methods return gorm.ErrRecordNotFound when the requested
+// item doesn't exist and unwrapped storage errors otherwise, wrapping them is left to the callers.
+type ShopItemRepository interface {
+	// FindOneByID returns the item unless it's soft-deleted
+	FindOneByID(ctx context.Context, shopItemID int) (*ShopItem, error)
+	// FindOneBySKU returns the item with the given SKU unless it's soft-deleted
+	FindOneBySKU(ctx context.Context, sku string) (*ShopItem, error)
+	// FindOneDeletedByID returns the item only if it's soft-deleted
+	FindOneDeletedByID(ctx context.Context, shopItemID int) (*ShopItem, error)
+	// FindOneDeletedBySKU returns the item with the given SKU only if it's soft-deleted. SKUs are unique among
+	// soft-deleted items too, so they can't be reused before the item is restored.
+	FindOneDeletedBySKU(ctx context.Context, sku string) (*ShopItem, error)
+	// Create inserts the item and sets its ID
+	Create(ctx context.Context, item *ShopItem) error
+	// Update writes every editable column of a not soft-deleted item and increments its version. When
+	// item.Version isn't 0 the row is only written if it still has that version, ErrVersionConflict is returned
+	// otherwise.
+	Update(ctx context.Context, item *ShopItem) error
+	// UpdateColumns works like Update but writes only the given columns, plus updated_at
+	UpdateColumns(ctx context.Context, item *ShopItem, columns []string) error
+	SoftDelete(ctx context.Context, shopItemID int, deletedAt time.Time) error
+	// Restore clears the deletion time of a soft-deleted item
+	Restore(ctx context.Context, shopItemID int, updatedAt time.Time) error
+	// FindDeleted returns up to paginationParams.PerPage+1 soft-deleted items around the cursor, ordered like
+	// FindForFrontend
+	FindDeleted(ctx context.Context, paginationParams PaginationParams) ([]ShopItem, error)
+	// FindAll works like FindDeleted but returns soft-deleted and active items alike
+	FindAll(ctx context.Context, paginationParams PaginationParams) ([]ShopItem, error)
+	// FindForFrontend returns up to paginationParams.PerPage+1 not soft-deleted items around the cursor, see
+	// GetShopItemsForFrontend for the ordering
+	FindForFrontend(ctx context.Context, paginationParams PaginationParams) ([]ShopItemForResponse, error)
+	FindWithStripeInfoByIDs(ctx context.Context, shopItemIDs []int) ([]ItemWithStripeInfo, error)
+	FindWithStripeInfoByStripeProductIDs(ctx context.Context, stripeProductApiIDs []string) ([]ItemWithStripeInfo, error)
+}
+
+// OrderRepository stores user orders, following the same error conventions as ShopItemRepository
+type OrderRepository interface {
+	// CreateEmptyOrder inserts the order and sets its ID
+	CreateEmptyOrder(ctx context.Context, order *UserOrder) error
+	FindOneByClientReferenceID(ctx context.Context, clientReferenceID string, orderCompleted bool) (*UserOrder, error)
+	// CompleteOrder atomically marks the order with the given client reference ID as completed, stores its items
+	// and decreases stock of the ordered shop items
+	CompleteOrder(ctx context.Context, completion OrderCompletion) error
+	// FindByUserID returns up to paginationParams.PerPage+1 orders having at least one item around the cursor,
+	// see FindOrdersByUserID for the ordering
+	FindByUserID(ctx context.Context, userID int, completedOnly bool, paginationParams PaginationParams) ([]UserOrderFrontResponse, error)
+	// FindOneByIDAndUserID returns the order together with its items
+	FindOneByIDAndUserID(ctx context.Context, orderID, userID int, completedOnly bool) (*UserOrderFrontResponse, error)
+}
+
+// PriceHistoryRepository stores prices shop items had, following the same error conventions as ShopItemRepository
+type PriceHistoryRepository interface {
+	// Add inserts the entry and sets its ID
+	Add(ctx context.Context, entry *ShopItemPriceHistory) error
+	// FindByShopItemID returns all entries of the item ordered by EffectiveFrom ascending
+	FindByShopItemID(ctx context.Context, shopItemID int) ([]ShopItemPriceHistory, error)
+	// FindInEffectAt returns the latest entry of the item effective at or before the given time
+	FindInEffectAt(ctx context.Context, shopItemID int, at time.Time) (*ShopItemPriceHistory, error)
+}
+
+// ShopItemImageRepository stores images of shop items, following the same error conventions as ShopItemRepository
+type ShopItemImageRepository interface {
+	// Replace deletes all images of the item and inserts images in a single transaction, setting their IDs
+	Replace(ctx context.Context, shopItemID int, images []ShopItemImage) error
+	// Append inserts image after the item's last image in a single transaction, setting its ID, ShopItemID and
+	// Position, and returns ErrTooManyImages when the item already has maxImages images
+	Append(ctx context.Context, shopItemID int, image *ShopItemImage, maxImages int) error
+	// FindByShopItemIDs returns images of the items ordered by shop item ID and position
+	FindByShopItemIDs(ctx context.Context, shopItemIDs []int) ([]ShopItemImage, error)
+}
+
+// ShopItemTranslationRepository stores translations of shop items, following the same error conventions as
+// ShopItemRepository. Locales are normalized by callers.
+type ShopItemTranslationRepository interface {
+	// Save inserts the translation or replaces the one of the same item and locale, setting its ID
+	Save(ctx context.Context, translation *ShopItemTranslation) error
+	// Delete removes the translation of the item to locale, returning gorm.ErrRecordNotFound when there's none
+	Delete(ctx context.Context, shopItemID int, locale string) error
+	// FindByShopItemIDs returns translations of the items to any of locales, or to all locales when locales is
+	// empty, ordered by shop item ID and locale
+	FindByShopItemIDs(ctx context.Context, shopItemIDs []int, locales []string) ([]ShopItemTranslation, error)
+}
+
+// InvoiceRepository stores invoices, following the same error conventions as ShopItemRepository
+type InvoiceRepository interface {
+	// Create assigns the invoice the next sequence of its year, sets its number via formatNumber and inserts it
+	// setting its ID. It's done in a single transaction so invoices failing to insert leave no gaps in sequences.
+	Create(ctx context.Context, invoice *Invoice, formatNumber func(year, sequence int) string) error
+	FindOneByUserOrderID(ctx context.Context, userOrderID int) (*Invoice, error)
+}
+
+// Repositories are the repositories a TransactionRunner hands to the function it runs
+type Repositories struct {
+	ShopItems    ShopItemRepository
+	Orders       OrderRepository
+	PriceHistory PriceHistoryRepository
+	Images       ShopItemImageRepository
+	Translations ShopItemTranslationRepository
+	Invoices     InvoiceRepository
+}
+
+// TransactionRunner runs several repository calls as a unit
+type TransactionRunner interface {
+	// WithinTransaction calls fn with repositories whose writes are all kept when fn returns nil and all discarded
+	// when it returns an error, which is then returned unchanged. Only the given repositories take part in the
+	// transaction.
+	WithinTransaction(ctx context.Context, fn func(repos Repositories) error) error
+}
+
+// OrderCompletion is everything OrderRepository.CompleteOrder needs to complete an order
+type OrderCompletion struct {
+	ClientReferenceID string
+	StripeSessionID   string
+	TotalPrice        float32
+	CompletedAt       time.Time
+	Items             []UserOrderItem
+	// ShippingAddress replaces the one of the order unless nil, it's set when Stripe Checkout collected the address
+	ShippingAddress *OrderAddress
+}
@@ -0,0 +1,314 @@
+package mop_shop
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/stripe/stripe-go/v72"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ShippingRateType tells how a ShippingMethod prices an order
+type ShippingRateType string
+
+const (
+	// ShippingRateFlat charges Amount for every order
+	ShippingRateFlat ShippingRateType = "flat"
+	// ShippingRateWeight charges the Amount of the first WeightTiers entry the order weight fits into
+	ShippingRateWeight ShippingRateType = "weight"
+	// ShippingRateFreeOverThreshold charges Amount unless the order subtotal reaches FreeOver
+	ShippingRateFreeOverThreshold ShippingRateType = "free_over_threshold"
+)
+
+// WeightTier is a step of a weight based rate, orders weighing up to UpToGrams inclusive are charged Amount
+type WeightTier struct {
+	UpToGrams int   `json:"up_to_grams"`
+	Amount    int64 `json:"amount"`
+}
+
+// ShippingMethod is a way of shipping orders customers can choose from, configured via WithShippingMethods.
+// Amounts are in cents like ShopItem.ItemPrice.
+type ShippingMethod struct {
+	// Code identifies the method in CreateUserOrder.ShippingMethod and is stored on the order
+	Code     string           `json:"code"`
+	Name     string           `json:"name"`
+	Type     ShippingRateType `json:"type"`
+	Amount   int64            `json:"amount"`
+	FreeOver int64            `json:"free_over,omitempty"`
+	// WeightTiers are ordered by UpToGrams ascending, orders heavier than the last tier can't be shipped
+	WeightTiers []WeightTier `json:"weight_tiers,omitempty"`
+	// Countries are ISO 3166-1 alpha-2 codes the method ships to, none means any country. Stripe Checkout needs
+	// them to collect addresses, so orders shipped with a method without countries have to give the address.
+	Countries []string `json:"countries,omitempty"`
+}
+
+// Rate returns the shipping price of an order with the given subtotal and weight, or false when the method can't
+// ship it
+func (m ShippingMethod) Rate(subtotal int64, weightGrams int) (int64, bool) {
+	switch m.Type {
+	case ShippingRateFlat:
+		return m.Amount, true
+	case ShippingRateFreeOverThreshold:
+		if subtotal >= m.FreeOver {
+			return 0, true
+		}
+
+		return m.Amount, true
+	case ShippingRateWeight:
+		for _, tier := range m.WeightTiers {
+			if weightGrams <= tier.UpToGrams {
+				return tier.Amount, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// ShipsTo tells whether the method ships to country, given as an ISO 3166-1 alpha-2 code in any case
+func (m ShippingMethod) ShipsTo(country string) bool {
+	if len(m.Countries) == 0 {
+		return true
+	}
+
+	for _, c := range m.Countries {
+		if strings.EqualFold(c, country) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithShippingMethods sets the shipping methods orders of shippable items are shipped with. Without any, orders of
+// shippable items fail with ErrShippingMethodUnknown.
+func WithShippingMethods(methods ...ShippingMethod) Option {
+	return func(s *Shop) {
+		s.shippingMethods = append([]ShippingMethod(nil), methods...)
+	}
+}
+
+// ShippingMethods returns the shipping methods shipping to country, or all of them when country is empty
+func (s *Shop) ShippingMethods(country string) []ShippingMethod {
+	methods := []ShippingMethod{}
+	for _, method := range s.shippingMethods {
+		if len(country) == 0 || method.ShipsTo(country) {
+			methods = append(methods, method)
+		}
+	}
+
+	return methods
+}
+
+func findShippingMethod(methods []ShippingMethod, code string) (ShippingMethod, bool) {
+	for _, method := range methods {
+		if method.Code == code {
+			return method, true
+		}
+	}
+
+	return ShippingMethod{}, false
+}
+
+// countryPattern matches ISO 3166-1 alpha-2 codes after upper-casing
+var countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// OrderAddress is a shipping or billing address of an order, stored as a JSON object
+type OrderAddress struct {
+	Name       string `json:"name"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city"`
+	PostalCode string `json:"postal_code"`
+	State      string `json:"state,omitempty"`
+	// Country is an ISO 3166-1 alpha-2 code such as HR
+	Country string `json:"country"`
+	Phone   string `json:"phone,omitempty"`
+}
+
+func (a OrderAddress) Value() (driver.Value, error) {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func (a *OrderAddress) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case nil:
+		*a = OrderAddress{}
+		return nil
+	case []byte:
+		return json.Unmarshal(data, a)
+	case string:
+		return json.Unmarshal([]byte(data), a)
+	default:
+		return fmt.Errorf("unsupported address type %T", value)
+	}
+}
+
+// validate adds errors of fields such as shipping_address.city, field being the name of the address
+func (a *OrderAddress) validate(field string, validationErrors *ValidationErrors) {
+	a.Country = strings.ToUpper(strings.TrimSpace(a.Country))
+
+	required := []struct {
+		name  string
+		value string
+	}{
+		{name: "name", value: a.Name},
+		{name: "line1", value: a.Line1},
+		{name: "city", value: a.City},
+		{name: "postal_code", value: a.PostalCode},
+	}
+
+	for _, r := range required {
+		if len(strings.TrimSpace(r.value)) == 0 {
+			validationErrors.add(field+"."+r.name, ErrAddressFieldBlank)
+		}
+	}
+
+	if !countryPattern.MatchString(a.Country) {
+		validationErrors.add(field+".country", ErrCountryInvalid)
+	}
+}
+
+func cloneOrderAddress(address *OrderAddress) *OrderAddress {
+	if address == nil {
+		return nil
+	}
+
+	cloned := *address
+	return &cloned
+}
+
+// prepareShipping sets the addresses of the order and, when any of its items is shippable, prices the shipping
+// method chosen in data. Orders without shippable items have no shipping method or shipping address, orders
+// shipped with a method Stripe Checkout can't collect the address for need a shipping address.
+func (o *UserOrder) prepareShipping(data *CreateUserOrder, requiresShipping bool, subtotal int64, weightGrams int) error {
+	o.ShippingMethod, o.ShippingPrice = nil, 0
+	o.ShippingAddress, o.BillingAddress = nil, cloneOrderAddress(data.BillingAddress)
+
+	if !requiresShipping {
+		return nil
+	}
+
+	var validationErrors ValidationErrors
+
+	method, ok := findShippingMethod(o.shippingMethods, data.ShippingMethod)
+	switch {
+	case len(data.ShippingMethod) == 0:
+		validationErrors.add("shipping_method", ErrShippingMethodRequired)
+	case !ok:
+		validationErrors.add("shipping_method", ErrShippingMethodUnknown)
+	case data.ShippingAddress == nil && len(method.Countries) == 0:
+		validationErrors.add("shipping_address", ErrShippingAddressRequired)
+	case data.ShippingAddress != nil && !method.ShipsTo(data.ShippingAddress.Country):
+		validationErrors.add("shipping_address.country", ErrShippingNotAvailable)
+	}
+
+	if err := validationErrors.errOrNil(); err != nil {
+		return err
+	}
+
+	price, ok := method.Rate(subtotal, weightGrams)
+	if !ok {
+		validationErrors.add("shipping_method", ErrShippingNotAvailable)
+		return validationErrors
+	}
+
+	o.ShippingMethod = &method.Code
+	o.ShippingPrice = float32(price)
+	o.ShippingAddress = cloneOrderAddress(data.ShippingAddress)
+	return nil
+}
+
+// ApplyCheckoutShipping adds the shipping method chosen in PrepareForOrder to params as the only shipping option.
+// The shipping address of the order is passed on to the payment, without one Stripe Checkout is made to collect it
+// in the countries of the method. Reverse charged orders are charged the shipping without the VAT it includes.
+// Orders without shipping leave params untouched.
+func (o *UserOrder) ApplyCheckoutShipping(params *stripe.CheckoutSessionParams) error {
+	if o.ShippingMethod == nil {
+		return nil
+	}
+
+	method, ok := findShippingMethod(o.shippingMethods, *o.ShippingMethod)
+	if !ok {
+		return ErrShippingMethodUnknown
+	}
+
+	if o.ShippingAddress != nil {
+		if params.PaymentIntentData == nil {
+			params.PaymentIntentData = &stripe.CheckoutSessionPaymentIntentDataParams{}
+		}
+		params.PaymentIntentData.Shipping = o.ShippingAddress.stripeParams()
+	} else {
+		if len(method.Countries) == 0 {
+			return ErrShippingCountriesUnknown
+		}
+
+		countries := make([]string, 0, len(method.Countries))
+		for _, country := range method.Countries {
+			countries = append(countries, strings.ToUpper(country))
+		}
+
+		params.ShippingAddressCollection = &stripe.CheckoutSessionShippingAddressCollectionParams{
+			AllowedCountries: stripe.StringSlice(countries),
+		}
+	}
+
+	// stripe-go v72 has no parameters for inline shipping options yet
+	prefix := "shipping_options[0][shipping_rate_data]"
+	params.AddExtra(prefix+"[type]", "fixed_amount")
+	params.AddExtra(prefix+"[display_name]", method.Name)
+	params.AddExtra(prefix+"[fixed_amount][amount]", strconv.FormatInt(o.chargedAmount(TaxClassStandard, int64(o.ShippingPrice)), 10))
+	params.AddExtra(prefix+"[fixed_amount][currency]", string(stripe.CurrencyEUR))
+	return nil
+}
+
+func (a *OrderAddress) stripeParams() *stripe.ShippingDetailsParams {
+	params := &stripe.ShippingDetailsParams{
+		Name: stripe.String(a.Name),
+		Address: &stripe.AddressParams{
+			Line1:      stripe.String(a.Line1),
+			City:       stripe.String(a.City),
+			PostalCode: stripe.String(a.PostalCode),
+			Country:    stripe.String(a.Country),
+		},
+	}
+
+	if len(a.Line2) > 0 {
+		params.Address.Line2 = stripe.String(a.Line2)
+	}
+
+	if len(a.State) > 0 {
+		params.Address.State = stripe.String(a.State)
+	}
+
+	if len(a.Phone) > 0 {
+		params.Phone = stripe.String(a.Phone)
+	}
+
+	return params
+}
+
+// orderAddressFromStripe returns the address a customer entered in Stripe Checkout, or nil
+func orderAddressFromStripe(shipping *stripe.ShippingDetails) *OrderAddress {
+	if shipping == nil || shipping.Address == nil {
+		return nil
+	}
+
+	return &OrderAddress{
+		Name:       shipping.Name,
+		Line1:      shipping.Address.Line1,
+		Line2:      shipping.Address.Line2,
+		City:       shipping.Address.City,
+		PostalCode: shipping.Address.PostalCode,
+		State:      shipping.Address.State,
+		Country:    shipping.Address.Country,
+		Phone:      shipping.Phone,
+	}
+}
@@ -0,0 +1,256 @@
+package mop_shop
+
+import (
+	"context"
+	"github.com/croatiangrn/mop-shop/stripetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/checkout/session"
+	"testing"
+)
+
+var testShippingMethods = []ShippingMethod{
+	{Code: "standard", Name: "Standard", Type: ShippingRateFreeOverThreshold, Amount: 500, FreeOver: 5000, Countries: []string{"HR", "si"}},
+	{Code: "parcel", Name: "Parcel", Type: ShippingRateWeight, WeightTiers: []WeightTier{{UpToGrams: 1000, Amount: 400}, {UpToGrams: 5000, Amount: 900}}},
+	{Code: "courier", Name: "Courier", Type: ShippingRateFlat, Amount: 1500, Countries: []string{"HR"}},
+}
+
+func TestShippingMethod_Rate(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      ShippingMethod
+		subtotal    int64
+		weightGrams int
+		want        int64
+		wantOK      bool
+	}{
+		{name: "Flat", method: testShippingMethods[2], subtotal: 100000, weightGrams: 20000, want: 1500, wantOK: true},
+		{name: "Below threshold", method: testShippingMethods[0], subtotal: 4999, want: 500, wantOK: true},
+		{name: "At threshold", method: testShippingMethods[0], subtotal: 5000, want: 0, wantOK: true},
+		{name: "First weight tier", method: testShippingMethods[1], weightGrams: 1000, want: 400, wantOK: true},
+		{name: "Second weight tier", method: testShippingMethods[1], weightGrams: 1001, want: 900, wantOK: true},
+		{name: "Too heavy", method: testShippingMethods[1], weightGrams: 5001, wantOK: false},
+		{name: "Unknown type", method: ShippingMethod{Type: "pigeon", Amount: 100}, wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.method.Rate(tt.subtotal, tt.weightGrams)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestUserOrder_PrepareForOrderContext_shipping(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	shop := NewShop(nil, "", WithMemoryStorage(storage), WithShippingMethods(testShippingMethods...))
+
+	mopWeight := 1200
+	mop := &ShopItem{ItemName: "Mop", ItemPrice: 2000, Shippable: true, WeightGrams: &mopWeight, Quantity: 10, StripeProductApiID: "prod_mop"}
+	voucher := &ShopItem{ItemName: "Voucher", ItemPrice: 5000, Quantity: 10, StripeProductApiID: "prod_voucher"}
+	assert.NoError(t, storage.ShopItems().Create(ctx, mop))
+	assert.NoError(t, storage.ShopItems().Create(ctx, voucher))
+
+	zagreb := &OrderAddress{Name: "Ana", Line1: "Ilica 1", City: "Zagreb", PostalCode: "10000", Country: "hr"}
+	vienna := &OrderAddress{Name: "Ana", Line1: "Ring 1", City: "Wien", PostalCode: "1010", Country: "AT"}
+
+	tests := []struct {
+		name              string
+		items             []CreateUserOrderItem
+		shippingMethod    string
+		shippingAddress   *OrderAddress
+		wantErr           error
+		wantMethod        *string
+		wantShippingPrice float32
+		wantTotalPrice    float32
+	}{
+		{
+			name:           "Nothing to ship",
+			items:          []CreateUserOrderItem{{ItemID: voucher.ID, Quantity: 1}},
+			shippingMethod: "courier",
+			wantTotalPrice: 5000,
+		},
+		{
+			name:    "Shipping method is required",
+			items:   []CreateUserOrderItem{{ItemID: voucher.ID, Quantity: 1}, {ItemID: mop.ID, Quantity: 1}},
+			wantErr: ValidationErrors{{Field: "shipping_method", Err: ErrShippingMethodRequired}},
+		},
+		{
+			name:           "Unknown shipping method",
+			items:          []CreateUserOrderItem{{ItemID: mop.ID, Quantity: 1}},
+			shippingMethod: "drone",
+			wantErr:        ValidationErrors{{Field: "shipping_method", Err: ErrShippingMethodUnknown}},
+		},
+		{
+			name:            "Method doesn't ship to the country",
+			items:           []CreateUserOrderItem{{ItemID: mop.ID, Quantity: 1}},
+			shippingMethod:  "courier",
+			shippingAddress: vienna,
+			wantErr:         ValidationErrors{{Field: "shipping_address.country", Err: ErrShippingNotAvailable}},
+		},
+		{
+			name:           "Method without countries needs the address",
+			items:          []CreateUserOrderItem{{ItemID: mop.ID, Quantity: 1}},
+			shippingMethod: "parcel",
+			wantErr:        ValidationErrors{{Field: "shipping_address", Err: ErrShippingAddressRequired}},
+		},
+		{
+			name:            "Too heavy for the method",
+			items:           []CreateUserOrderItem{{ItemID: mop.ID, Quantity: 5}},
+			shippingMethod:  "parcel",
+			shippingAddress: vienna,
+			wantErr:         ValidationErrors{{Field: "shipping_method", Err: ErrShippingNotAvailable}},
+		},
+		{
+			name:              "Weight counts every piece",
+			items:             []CreateUserOrderItem{{ItemID: mop.ID, Quantity: 2}},
+			shippingMethod:    "parcel",
+			shippingAddress:   vienna,
+			wantMethod:        stripe.String("parcel"),
+			wantShippingPrice: 900,
+			wantTotalPrice:    4900,
+		},
+		{
+			name:              "Below free shipping threshold",
+			items:             []CreateUserOrderItem{{ItemID: mop.ID, Quantity: 2}},
+			shippingMethod:    "standard",
+			shippingAddress:   zagreb,
+			wantMethod:        stripe.String("standard"),
+			wantShippingPrice: 500,
+			wantTotalPrice:    4500,
+		},
+		{
+			name:           "Free shipping over threshold",
+			items:          []CreateUserOrderItem{{ItemID: mop.ID, Quantity: 1}, {ItemID: voucher.ID, Quantity: 1}},
+			shippingMethod: "standard",
+			wantMethod:     stripe.String("standard"),
+			wantTotalPrice: 7000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := NewCreateUserOrder(3)
+			data.Items, data.ShippingMethod, data.ShippingAddress = tt.items, tt.shippingMethod, cloneOrderAddress(tt.shippingAddress)
+
+			order := shop.NewUserOrder()
+			err := order.PrepareForOrderContext(ctx, data)
+			assert.Equal(t, tt.wantErr, err)
+			if err != nil {
+				return
+			}
+
+			assert.Equal(t, tt.wantMethod, order.ShippingMethod)
+			assert.Equal(t, tt.wantShippingPrice, order.ShippingPrice)
+			assert.Equal(t, tt.wantTotalPrice, order.TotalPrice)
+			if tt.wantMethod == nil {
+				assert.Nil(t, order.ShippingAddress)
+			}
+		})
+	}
+
+	t.Run("Addresses are validated", func(t *testing.T) {
+		data := NewCreateUserOrder(3)
+		data.Items = []CreateUserOrderItem{{ItemID: mop.ID, Quantity: 1}}
+		data.ShippingMethod = "standard"
+		data.ShippingAddress = &OrderAddress{Name: "Ana", Line1: "Ilica 1", City: " ", PostalCode: "10000", Country: "Croatia"}
+		data.BillingAddress = &OrderAddress{}
+
+		err := shop.NewUserOrder().PrepareForOrderContext(ctx, data)
+		assert.Equal(t, ValidationErrors{
+			{Field: "shipping_address.city", Err: ErrAddressFieldBlank},
+			{Field: "shipping_address.country", Err: ErrCountryInvalid},
+			{Field: "billing_address.name", Err: ErrAddressFieldBlank},
+			{Field: "billing_address.line1", Err: ErrAddressFieldBlank},
+			{Field: "billing_address.city", Err: ErrAddressFieldBlank},
+			{Field: "billing_address.postal_code", Err: ErrAddressFieldBlank},
+			{Field: "billing_address.country", Err: ErrCountryInvalid},
+		}, err)
+	})
+}
+
+func TestUserOrder_ApplyCheckoutShipping_stripe(t *testing.T) {
+	ctx := context.Background()
+	stripeServer := stripetest.Start(t)
+	storage := NewMemoryStorage()
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(storage), WithShippingMethods(testShippingMethods...))
+
+	productID := stripeServer.AddProduct(stripetest.Product{Name: "Mop", Active: true})
+	priceID := stripeServer.AddPrice(stripetest.Price{Product: productID, Currency: "eur", UnitAmount: 2000, LookupKey: "mop", Active: true})
+
+	mop := &ShopItem{ItemName: "Mop", ItemPrice: 2000, Shippable: true, Quantity: 5, StripeProductApiID: productID, UniqueStripePriceLookupKey: "mop"}
+	assert.NoError(t, storage.ShopItems().Create(ctx, mop))
+
+	checkout := func(order *UserOrder, clientReferenceID string) (string, error) {
+		data := NewCreateUserOrder(3)
+		data.Items, data.ShippingMethod = []CreateUserOrderItem{{ItemID: mop.ID, Quantity: 2}}, "standard"
+		if err := order.PrepareForOrderContext(ctx, data); err != nil {
+			return "", err
+		}
+
+		params := &stripe.CheckoutSessionParams{
+			ClientReferenceID: stripe.String(clientReferenceID),
+			Mode:              stripe.String(string(stripe.CheckoutSessionModePayment)),
+			LineItems:         []*stripe.CheckoutSessionLineItemParams{{Price: stripe.String(priceID), Quantity: stripe.Int64(2)}},
+		}
+		if err := order.ApplyCheckoutShipping(params); err != nil {
+			return "", err
+		}
+
+		cs, err := session.New(params)
+		if err != nil {
+			return "", err
+		}
+
+		return cs.ID, order.CreateEmptyOrderContext(ctx, 3, clientReferenceID)
+	}
+
+	order := shop.NewUserOrder()
+	sessionID, err := checkout(order, "ref")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cs, _ := stripeServer.CheckoutSession(sessionID)
+	assert.Equal(t, []string{"HR", "SI"}, cs.AllowedCountries)
+	assert.Equal(t, []stripetest.ShippingOption{{DisplayName: "Standard", Amount: 500, Currency: "eur"}}, cs.ShippingOptions)
+	assert.Equal(t, int64(4500), cs.AmountTotal)
+
+	assert.Error(t, stripeServer.SetCheckoutSessionShipping(sessionID, stripetest.Address{Country: "AT"}))
+	assert.NoError(t, stripeServer.SetCheckoutSessionShipping(sessionID, stripetest.Address{Name: "Ana", Line1: "Ilica 1", City: "Zagreb", PostalCode: "10000", Country: "HR"}))
+
+	completed := shop.NewUserOrder()
+	if !assert.NoError(t, completed.FindOneByClientReferenceIDContext(ctx, "ref", false)) {
+		return
+	}
+	if !assert.NoError(t, completed.UpdateEmptyOrderAfterCheckoutContext(ctx, sessionID, "ref", float32(cs.AmountTotal))) {
+		return
+	}
+
+	got, err := shop.FindOrderByByIDAndUserIDContext(ctx, order.ID, 3, true, "eur", "")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "standard", *got.ShippingMethod)
+		assert.Equal(t, 5.0, *got.ShippingPrice)
+		assert.Equal(t, 45.0, *got.TotalPrice)
+		assert.Equal(t, &OrderAddress{Name: "Ana", Line1: "Ilica 1", City: "Zagreb", PostalCode: "10000", Country: "HR"}, got.ShippingAddress)
+	}
+
+	t.Run("Methods without countries can't collect addresses", func(t *testing.T) {
+		data := NewCreateUserOrder(3)
+		data.Items, data.ShippingMethod = []CreateUserOrderItem{{ItemID: mop.ID, Quantity: 1}}, "parcel"
+
+		order := shop.NewUserOrder()
+		assert.Equal(t, ValidationErrors{{Field: "shipping_address", Err: ErrShippingAddressRequired}}, order.PrepareForOrderContext(ctx, data))
+
+		data.ShippingAddress = &OrderAddress{Name: "Ana", Line1: "Ring 1", City: "Wien", PostalCode: "1010", Country: "AT"}
+		params := &stripe.CheckoutSessionParams{}
+		if assert.NoError(t, order.PrepareForOrderContext(ctx, data)) && assert.NoError(t, order.ApplyCheckoutShipping(params)) {
+			assert.Nil(t, params.ShippingAddressCollection)
+			if assert.NotNil(t, params.PaymentIntentData) {
+				assert.Equal(t, "AT", *params.PaymentIntentData.Shipping.Address.Country)
+			}
+		}
+	})
+}
@@ -0,0 +1,250 @@
+package mop_shop
+
+import (
+	"context"
+	"github.com/stripe/stripe-go/v72"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+// RequestIDHeader is read from incoming requests when the context has no request ID, see ContextWithRequestID
+const RequestIDHeader = "X-Request-Id"
+
+// Shop holds configuration shared by everything created through it. Create it once via NewShop and
+// use its constructors and methods instead of the package-level ones.
+type Shop struct {
+	items        ShopItemRepository
+	orders       OrderRepository
+	priceHistory PriceHistoryRepository
+	images       ShopItemImageRepository
+	translations ShopItemTranslationRepository
+	invoices     InvoiceRepository
+	transactions TransactionRunner
+	blobs        BlobStore
+	uploads      uploadConfig
+	users        UserResolver
+	// shippingMethods are offered to orders of shippable items, see WithShippingMethods
+	shippingMethods []ShippingMethod
+	// taxes are nil unless orders are charged VAT, see WithTaxes
+	taxes *TaxConfig
+	// invoiceConfig is nil unless invoices are issued, see WithInvoices
+	invoiceConfig *InvoiceConfig
+	logger        Logger
+	// defaultLocale is the normalized locale of item names and descriptions stored on shop items
+	defaultLocale string
+	debugQueries  bool
+}
+
+type Option func(s *Shop)
+
+// WithLogger sets the Logger receiving errors and diagnostics, NopLogger is used by default
+func WithLogger(logger Logger) Option {
+	return func(s *Shop) {
+		s.logger = logger
+	}
+}
+
+// WithQueryDebug enables logging of every SQL statement through GORM's logger
+func WithQueryDebug(enabled bool) Option {
+	return func(s *Shop) {
+		s.debugQueries = enabled
+	}
+}
+
+// WithShopItemRepository replaces the GORM shop item storage
+func WithShopItemRepository(items ShopItemRepository) Option {
+	return func(s *Shop) {
+		s.items = items
+	}
+}
+
+// WithOrderRepository replaces the GORM order storage
+func WithOrderRepository(orders OrderRepository) Option {
+	return func(s *Shop) {
+		s.orders = orders
+	}
+}
+
+// WithPriceHistoryRepository replaces the GORM price history storage
+func WithPriceHistoryRepository(priceHistory PriceHistoryRepository) Option {
+	return func(s *Shop) {
+		s.priceHistory = priceHistory
+	}
+}
+
+// WithShopItemImageRepository replaces the GORM shop item image storage
+func WithShopItemImageRepository(images ShopItemImageRepository) Option {
+	return func(s *Shop) {
+		s.images = images
+	}
+}
+
+// WithShopItemTranslationRepository replaces the GORM shop item translation storage
+func WithShopItemTranslationRepository(translations ShopItemTranslationRepository) Option {
+	return func(s *Shop) {
+		s.translations = translations
+	}
+}
+
+// WithInvoiceRepository replaces the GORM invoice storage
+func WithInvoiceRepository(invoices InvoiceRepository) Option {
+	return func(s *Shop) {
+		s.invoices = invoices
+	}
+}
+
+// WithTransactionRunner replaces the GORM transactions, it should run functions with repositories of the same
+// storage as the other ones
+func WithTransactionRunner(transactions TransactionRunner) Option {
+	return func(s *Shop) {
+		s.transactions = transactions
+	}
+}
+
+// WithDefaultLocale sets the locale of names and descriptions stored on shop items, DefaultLocale is used by
+// default. Stripe products are named in this locale and every other locale falls back to it.
+func WithDefaultLocale(locale string) Option {
+	return func(s *Shop) {
+		s.defaultLocale = NormalizeLocale(locale)
+	}
+}
+
+// WithBlobStore sets where uploaded images are stored, uploads fail with ErrBlobStoreNotConfigured without one
+func WithBlobStore(blobs BlobStore) Option {
+	return func(s *Shop) {
+		s.blobs = blobs
+	}
+}
+
+// WithMaxUploadSize limits the size of uploaded images in bytes, DefaultMaxUploadSize is used by default
+func WithMaxUploadSize(maxBytes int64) Option {
+	return func(s *Shop) {
+		s.uploads.maxSize = maxBytes
+	}
+}
+
+// WithThumbnailSizes sets the longer side in pixels of thumbnails generated for uploaded images,
+// DefaultThumbnailSizes are used by default. Passing no sizes disables thumbnails.
+func WithThumbnailSizes(sizes ...int) Option {
+	return func(s *Shop) {
+		s.uploads.thumbnailSizes = append([]int{}, sizes...)
+	}
+}
+
+// WithUserResolver changes how orders are limited to active users, DefaultUserResolver is used by default. It only
+// affects the GORM order storage when no OrderRepository is supplied, callbacks are honoured either way.
+func WithUserResolver(users UserResolver) Option {
+	return func(s *Shop) {
+		s.users = users
+	}
+}
+
+// NewShop creates a Shop storing data in db through GORM, unless repositories are replaced via options in which
+// case db may be nil
+func NewShop(db *gorm.DB, stripeKey string, options ...Option) *Shop {
+	stripe.Key = stripeKey
+
+	s := &Shop{logger: NopLogger{}, users: DefaultUserResolver(), uploads: defaultUploadConfig(), defaultLocale: DefaultLocale}
+	for _, option := range options {
+		option(s)
+	}
+
+	if s.logger == nil {
+		s.logger = NopLogger{}
+	}
+
+	if len(s.defaultLocale) == 0 {
+		s.defaultLocale = DefaultLocale
+	}
+
+	if s.debugQueries && db != nil {
+		db = db.Debug()
+	}
+
+	if s.items == nil && db != nil {
+		s.items = NewGormShopItemRepository(db)
+	}
+
+	if s.priceHistory == nil && db != nil {
+		s.priceHistory = NewGormPriceHistoryRepository(db)
+	}
+
+	if s.images == nil && db != nil {
+		s.images = NewGormShopItemImageRepository(db)
+	}
+
+	if s.translations == nil && db != nil {
+		s.translations = NewGormShopItemTranslationRepository(db)
+	}
+
+	if s.invoices == nil && db != nil {
+		s.invoices = NewGormInvoiceRepository(db)
+	}
+
+	if s.orders == nil && db != nil {
+		s.orders = NewGormOrderRepositoryWithUsers(db, s.users)
+	}
+
+	if s.transactions == nil && db != nil {
+		s.transactions = NewGormTransactionRunner(db, s.users)
+	}
+
+	return s
+}
+
+func (s *Shop) Logger() Logger {
+	return s.logger
+}
+
+// defaultShop is used by the package-level functions which only receive a DB
+func defaultShop(db *gorm.DB) *Shop {
+	return NewShop(db, stripe.Key)
+}
+
+func (s *Shop) NewShopItem() *ShopItem {
+	return &ShopItem{items: s.items, priceHistory: s.priceHistory, logger: s.logger}
+}
+
+func (s *Shop) NewShopItemForUpdate(shopItemID int, stripeProductApiID, uniqueStripePriceLookupKey string) *ShopItem {
+	return &ShopItem{ID: shopItemID, items: s.items, priceHistory: s.priceHistory, logger: s.logger, StripeProductApiID: stripeProductApiID, UniqueStripePriceLookupKey: uniqueStripePriceLookupKey}
+}
+
+func (s *Shop) NewUserOrder() *UserOrder {
+	return &UserOrder{orders: s.orders, items: s.items, translations: s.translations, shippingMethods: s.shippingMethods, taxes: s.taxes, invoicing: s.invoiceIssuer(), logger: s.logger}
+}
+
+// WithinTransaction calls fn with repositories whose writes are kept together only when fn returns nil, see
+// TransactionRunner. It fails with ErrTransactionsNotConfigured when the shop has neither a DB nor a
+// TransactionRunner.
+func (s *Shop) WithinTransaction(ctx context.Context, fn func(repos Repositories) error) error {
+	if s.transactions == nil {
+		return ErrTransactionsNotConfigured
+	}
+
+	return s.transactions.WithinTransaction(ctx, fn)
+}
+
+func loggerOrNop(logger Logger) Logger {
+	if logger == nil {
+		return NopLogger{}
+	}
+
+	return logger
+}
+
+// contextWithRequestHeader takes the request ID from RequestIDHeader if ctx doesn't carry one already
+func contextWithRequestHeader(ctx context.Context, req *http.Request) context.Context {
+	if req == nil || len(RequestIDFromContext(ctx)) > 0 {
+		return ctx
+	}
+
+	if requestID := req.Header.Get(RequestIDHeader); len(requestID) > 0 {
+		return ContextWithRequestID(ctx, requestID)
+	}
+
+	return ctx
+}
+
+func (s *Shop) log(ctx context.Context, level Level, msg string, keysAndValues ...interface{}) {
+	logWithContext(ctx, s.logger, level, msg, keysAndValues...)
+}
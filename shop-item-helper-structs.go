@@ -1,6 +1,8 @@
 package mop_shop
 
 import (
+	"context"
+	"encoding/json"
 	"github.com/google/uuid"
 	"github.com/stripe/stripe-go/v72"
 	"github.com/stripe/stripe-go/v72/price"
@@ -8,13 +10,17 @@ import (
 )
 
 type ShopItemCreate struct {
-	ItemName        string  `json:"item_name"`
-	ItemPicture     *string `json:"item_picture"`
-	ItemPrice       int64   `json:"item_price"`
-	ItemSalePrice   *int64  `json:"item_sale_price"`
-	ItemDescription *string `json:"item_description"`
-	Shippable       bool    `json:"shippable"`
-	Quantity        int     `json:"quantity"`
+	SKU             *string  `json:"sku"`
+	GTIN            *string  `json:"gtin"`
+	ItemName        string   `json:"item_name"`
+	ItemPicture     *string  `json:"item_picture"`
+	ItemPrice       int64    `json:"item_price"`
+	ItemSalePrice   *int64   `json:"item_sale_price"`
+	ItemDescription *string  `json:"item_description"`
+	Shippable       bool     `json:"shippable"`
+	WeightGrams     *int     `json:"weight_grams"`
+	TaxClass        TaxClass `json:"tax_class"`
+	Quantity        int      `json:"quantity"`
 	uuid            string
 }
 
@@ -58,65 +64,236 @@ func (c *ShopItemCreate) GetShippable() bool {
 	return c.Shippable
 }
 
+func (c *ShopItemCreate) GetWeightGrams() *int {
+	return c.WeightGrams
+}
+
+func (c *ShopItemCreate) GetTaxClass() TaxClass {
+	return c.TaxClass
+}
+
 func (c *ShopItemCreate) GetQuantity() int {
 	return c.Quantity
 }
 
-func (c *ShopItemCreate) createStripeProduct(name string, description *string) (*stripe.Product, error) {
+func (c *ShopItemCreate) GetSKU() *string {
+	return c.SKU
+}
+
+func (c *ShopItemCreate) GetGTIN() *string {
+	return c.GTIN
+}
+
+// StripeLookupKeyMetadata is the Stripe product metadata key holding the price lookup key of the shop item, it
+// lets ReconcileCatalog recognise products created by this package
+const StripeLookupKeyMetadata = "mop_shop_lookup_key"
+
+func (c *ShopItemCreate) createStripeProduct(ctx context.Context, name string, description *string, lookupKey string) (*stripe.Product, error) {
 	params := &stripe.ProductParams{
 		Name:        stripe.String(name),
 		Description: description,
 		Active:      stripe.Bool(true),
 	}
+	params.Context = ctx
+	params.AddMetadata(StripeLookupKeyMetadata, lookupKey)
 
 	return product.New(params)
 }
 
-func (c *ShopItemCreate) createStripeProductPrice(product *stripe.Product, unitAmount int64, lookupKey string) (*stripe.Price, error) {
+func (c *ShopItemCreate) createStripeProductPrice(ctx context.Context, product *stripe.Product, unitAmount int64, lookupKey string) (*stripe.Price, error) {
 	priceParams := &stripe.PriceParams{
 		Product:    stripe.String(product.ID),
 		Currency:   stripe.String(string(stripe.CurrencyEUR)),
 		UnitAmount: stripe.Int64(unitAmount),
 		LookupKey:  stripe.String(lookupKey),
 	}
+	priceParams.Context = ctx
 
 	return price.New(priceParams)
 }
 
+func (c *ShopItemCreate) archiveStripeProduct(ctx context.Context, stripeProductApiID string) error {
+	return archiveStripeProduct(ctx, stripeProductApiID)
+}
+
+func (c *ShopItemCreate) archiveStripePrice(ctx context.Context, stripePriceApiID string) error {
+	return archiveStripePrice(ctx, stripePriceApiID)
+}
+
+// patch returns ShopItemPatch setting the fields of c named in fields, which uses the JSON names of the fields
+func (c *ShopItemCreate) patch(fields map[string]bool) *ShopItemPatch {
+	patch := &ShopItemPatch{}
+
+	if fields["sku"] {
+		patch.SKU = NewOptionalString(c.SKU)
+	}
+
+	if fields["gtin"] {
+		patch.GTIN = NewOptionalString(c.GTIN)
+	}
+
+	if fields["item_name"] {
+		patch.ItemName = &c.ItemName
+	}
+
+	if fields["item_picture"] {
+		patch.ItemPicture = NewOptionalString(c.ItemPicture)
+	}
+
+	if fields["item_price"] {
+		patch.ItemPrice = &c.ItemPrice
+	}
+
+	if fields["item_sale_price"] {
+		patch.ItemSalePrice = NewOptionalInt64(c.ItemSalePrice)
+	}
+
+	if fields["item_description"] {
+		patch.ItemDescription = NewOptionalString(c.ItemDescription)
+	}
+
+	if fields["shippable"] {
+		patch.Shippable = &c.Shippable
+	}
+
+	if fields["weight_grams"] {
+		patch.WeightGrams = NewOptionalInt(c.WeightGrams)
+	}
+
+	if fields["tax_class"] {
+		patch.TaxClass = &c.TaxClass
+	}
+
+	if fields["quantity"] {
+		patch.Quantity = &c.Quantity
+	}
+
+	return patch
+}
+
+// Validate returns ValidationErrors with every invalid field, or nil
 func (c *ShopItemCreate) Validate() error {
-	if len(c.ItemName) == 0 {
-		return ErrItemNameBlank
+	return append(validateShopItemCodes(c.SKU, c.GTIN), validateShopItemFields(c.ItemName, c.ItemPrice, c.ItemSalePrice, c.Quantity, c.WeightGrams, c.TaxClass)...).errOrNil()
+}
+
+// validateShopItemCodes checks the optional SKU and GTIN, GTIN check digits are verified for every GTIN length
+func validateShopItemCodes(sku, gtin *string) ValidationErrors {
+	var validationErrors ValidationErrors
+
+	if sku != nil && !validSKU(*sku) {
+		validationErrors.add("sku", ErrSKUInvalid)
 	}
 
-	if c.ItemPrice < 0 {
-		return ErrShopItemPriceNegative
+	if gtin != nil {
+		switch {
+		case !validGTINFormat(*gtin):
+			validationErrors.add("gtin", ErrGTINInvalid)
+		case !validGTINCheckDigit(*gtin):
+			validationErrors.add("gtin", ErrGTINCheckDigit)
+		}
+	}
+
+	return validationErrors
+}
+
+// validSKU allows up to 64 letters, digits, dots, dashes and underscores
+func validSKU(sku string) bool {
+	if len(sku) == 0 || len(sku) > 64 {
+		return false
 	}
 
-	if c.ItemSalePrice != nil {
-		if *c.ItemSalePrice < 0 {
-			return ErrShopItemSalePriceNegative
+	for _, r := range sku {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_') {
+			return false
 		}
+	}
+
+	return true
+}
 
-		if *c.ItemSalePrice > c.ItemPrice {
-			return ErrShopItemSalePriceGreaterThanItemPrice
+// validGTINFormat accepts GTIN-8, GTIN-12 (UPC-A), GTIN-13 (EAN-13) and GTIN-14
+func validGTINFormat(gtin string) bool {
+	switch len(gtin) {
+	case 8, 12, 13, 14:
+	default:
+		return false
+	}
+
+	for _, r := range gtin {
+		if r < '0' || r > '9' {
+			return false
 		}
 	}
 
-	if c.Quantity <= 0 {
-		return ErrShopItemQuantityZeroOrNegative
+	return true
+}
+
+// validGTINCheckDigit verifies the last digit using the GS1 mod 10 algorithm, digits are weighted 3 and 1
+// alternately starting from the one next to the check digit
+func validGTINCheckDigit(gtin string) bool {
+	sum := 0
+	for i := len(gtin) - 2; i >= 0; i-- {
+		digit := int(gtin[i] - '0')
+		if (len(gtin)-2-i)%2 == 0 {
+			digit *= 3
+		}
+
+		sum += digit
 	}
 
-	return nil
+	return (10-sum%10)%10 == int(gtin[len(gtin)-1]-'0')
 }
 
+func validateShopItemFields(itemName string, itemPrice int64, itemSalePrice *int64, quantity int, weightGrams *int, taxClass TaxClass) ValidationErrors {
+	var validationErrors ValidationErrors
+
+	if len(itemName) == 0 {
+		validationErrors.add("item_name", ErrItemNameBlank)
+	}
+
+	if itemPrice < 0 {
+		validationErrors.add("item_price", ErrShopItemPriceNegative)
+	}
+
+	if itemSalePrice != nil {
+		if *itemSalePrice < 0 {
+			validationErrors.add("item_sale_price", ErrShopItemSalePriceNegative)
+		}
+
+		if *itemSalePrice > itemPrice {
+			validationErrors.add("item_sale_price", ErrShopItemSalePriceGreaterThanItemPrice)
+		}
+	}
+
+	if quantity <= 0 {
+		validationErrors.add("quantity", ErrShopItemQuantityZeroOrNegative)
+	}
+
+	if weightGrams != nil && *weightGrams < 0 {
+		validationErrors.add("weight_grams", ErrShopItemWeightNegative)
+	}
+
+	if !taxClass.valid() {
+		validationErrors.add("tax_class", ErrTaxClassUnknown)
+	}
+
+	return validationErrors
+}
+
+// ShopItemUpdate replaces the fields of a shop item, except for SKU, GTIN, WeightGrams and TaxClass which are left
+// unchanged when nil or empty. Clearing them is done with ShopItemPatch.
 type ShopItemUpdate struct {
-	ItemName        string  `json:"item_name"`
-	ItemPicture     *string `json:"item_picture"`
-	ItemPrice       int64   `json:"item_price"`
-	ItemSalePrice   *int64  `json:"item_sale_price"`
-	ItemDescription *string `json:"item_description"`
-	Shippable       bool    `json:"shippable"`
-	Quantity        int     `json:"quantity"`
+	SKU             *string  `json:"sku"`
+	GTIN            *string  `json:"gtin"`
+	ItemName        string   `json:"item_name"`
+	ItemPicture     *string  `json:"item_picture"`
+	ItemPrice       int64    `json:"item_price"`
+	ItemSalePrice   *int64   `json:"item_sale_price"`
+	ItemDescription *string  `json:"item_description"`
+	Shippable       bool     `json:"shippable"`
+	WeightGrams     *int     `json:"weight_grams"`
+	TaxClass        TaxClass `json:"tax_class"`
+	Quantity        int      `json:"quantity"`
 	stripeProductID string
 }
 
@@ -124,55 +301,201 @@ func NewShopItemUpdate(stripeProductID string) *ShopItemUpdate {
 	return &ShopItemUpdate{stripeProductID: stripeProductID}
 }
 
+// Validate returns ValidationErrors with every invalid field, or ErrShopItemNotInitializedProperly if
+// ShopItemUpdate wasn't created via NewShopItemUpdate
 func (u *ShopItemUpdate) Validate() error {
 	if len(u.stripeProductID) == 0 {
 		return ErrShopItemNotInitializedProperly
 	}
 
-	if len(u.ItemName) == 0 {
-		return ErrItemNameBlank
+	return append(validateShopItemCodes(u.SKU, u.GTIN), validateShopItemFields(u.ItemName, u.ItemPrice, u.ItemSalePrice, u.Quantity, u.WeightGrams, u.TaxClass)...).errOrNil()
+}
+
+func (u *ShopItemUpdate) updateStripeProduct(ctx context.Context, stripeProductApiID, name string, description OptionalString) (*stripe.Product, error) {
+	return updateStripeProduct(ctx, stripeProductApiID, name, description)
+}
+
+func (u *ShopItemUpdate) updateStripeProductPrice(ctx context.Context, productApiID, priceLookupKey string, unitAmount int64) (*stripe.Price, error) {
+	return replaceStripePrice(ctx, productApiID, priceLookupKey, unitAmount)
+}
+
+// OptionalString is a nullable field of ShopItemPatch. Set tells whether the field was given at all, which lets
+// JSON null clear the field while a missing key leaves it untouched.
+type OptionalString struct {
+	Set   bool
+	Value *string
+}
+
+// NewOptionalString returns a set OptionalString, pass nil to clear the field
+func NewOptionalString(value *string) OptionalString {
+	return OptionalString{Set: true, Value: value}
+}
+
+func (o *OptionalString) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	return json.Unmarshal(data, &o.Value)
+}
+
+// OptionalInt64 works like OptionalString
+type OptionalInt64 struct {
+	Set   bool
+	Value *int64
+}
+
+// NewOptionalInt64 returns a set OptionalInt64, pass nil to clear the field
+func NewOptionalInt64(value *int64) OptionalInt64 {
+	return OptionalInt64{Set: true, Value: value}
+}
+
+func (o *OptionalInt64) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	return json.Unmarshal(data, &o.Value)
+}
+
+// OptionalInt works like OptionalString
+type OptionalInt struct {
+	Set   bool
+	Value *int
+}
+
+// NewOptionalInt returns a set OptionalInt, pass nil to clear the field
+func NewOptionalInt(value *int) OptionalInt {
+	return OptionalInt{Set: true, Value: value}
+}
+
+func (o *OptionalInt) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	return json.Unmarshal(data, &o.Value)
+}
+
+// ShopItemPatch changes only the fields which are given, nil pointers and unset optionals keep the current value
+type ShopItemPatch struct {
+	SKU             OptionalString `json:"sku"`
+	GTIN            OptionalString `json:"gtin"`
+	ItemName        *string        `json:"item_name"`
+	ItemPicture     OptionalString `json:"item_picture"`
+	ItemPrice       *int64         `json:"item_price"`
+	ItemSalePrice   OptionalInt64  `json:"item_sale_price"`
+	ItemDescription OptionalString `json:"item_description"`
+	Shippable       *bool          `json:"shippable"`
+	WeightGrams     OptionalInt    `json:"weight_grams"`
+	TaxClass        *TaxClass      `json:"tax_class"`
+	Quantity        *int           `json:"quantity"`
+	// Version, when set, makes the patch fail with VersionConflictError unless the item still has it
+	Version *int `json:"version"`
+}
+
+func NewShopItemPatch() *ShopItemPatch {
+	return &ShopItemPatch{}
+}
+
+// apply copies the given fields onto item and returns the names of the columns whose value changed
+func (p *ShopItemPatch) apply(item *ShopItem) []string {
+	var columns []string
+
+	if p.SKU.Set && !equalStrings(p.SKU.Value, item.SKU) {
+		item.SKU = p.SKU.Value
+		columns = append(columns, "sku")
+	}
+
+	if p.GTIN.Set && !equalStrings(p.GTIN.Value, item.GTIN) {
+		item.GTIN = p.GTIN.Value
+		columns = append(columns, "gtin")
 	}
 
-	if u.ItemPrice < 0 {
-		return ErrShopItemPriceNegative
+	if p.ItemName != nil && *p.ItemName != item.ItemName {
+		item.ItemName = *p.ItemName
+		columns = append(columns, "item_name")
 	}
 
-	if u.ItemSalePrice != nil {
-		if *u.ItemSalePrice < 0 {
-			return ErrShopItemSalePriceNegative
-		}
+	if p.ItemPicture.Set && !equalStrings(p.ItemPicture.Value, item.ItemPicture) {
+		item.ItemPicture = p.ItemPicture.Value
+		columns = append(columns, "item_picture")
+	}
+
+	if p.ItemPrice != nil && *p.ItemPrice != item.ItemPrice {
+		item.ItemPrice = *p.ItemPrice
+		columns = append(columns, "item_price")
+	}
+
+	if p.ItemSalePrice.Set && !equalInt64s(p.ItemSalePrice.Value, item.ItemSalePrice) {
+		item.ItemSalePrice = p.ItemSalePrice.Value
+		columns = append(columns, "item_sale_price")
+	}
+
+	if p.ItemDescription.Set && !equalStrings(p.ItemDescription.Value, item.ItemDescription) {
+		item.ItemDescription = p.ItemDescription.Value
+		columns = append(columns, "item_description")
+	}
+
+	if p.Shippable != nil && *p.Shippable != item.Shippable {
+		item.Shippable = *p.Shippable
+		columns = append(columns, "shippable")
+	}
+
+	if p.WeightGrams.Set && !equalInts(p.WeightGrams.Value, item.WeightGrams) {
+		item.WeightGrams = p.WeightGrams.Value
+		columns = append(columns, "weight_grams")
+	}
+
+	if p.TaxClass != nil && *p.TaxClass != item.TaxClass {
+		item.TaxClass = *p.TaxClass
+		columns = append(columns, "tax_class")
+	}
+
+	if p.Quantity != nil && *p.Quantity != item.Quantity {
+		item.Quantity = *p.Quantity
+		columns = append(columns, "quantity")
+	}
+
+	return columns
+}
+
+// validate checks the fields p sets as they are once applied to item, fields p leaves alone aren't validated again.
+// The sale price is also checked when only the item price is set since it can't exceed it. Unlike new items, patched
+// ones may be out of stock.
+func (p *ShopItemPatch) validate(item *ShopItem) error {
+	set := map[string]bool{
+		"sku":             p.SKU.Set,
+		"gtin":            p.GTIN.Set,
+		"item_name":       p.ItemName != nil,
+		"item_price":      p.ItemPrice != nil,
+		"item_sale_price": p.ItemSalePrice.Set || p.ItemPrice != nil,
+		"weight_grams":    p.WeightGrams.Set,
+		"tax_class":       p.TaxClass != nil,
+		"quantity":        p.Quantity != nil,
+	}
 
-		if *u.ItemSalePrice > u.ItemPrice {
-			return ErrShopItemSalePriceGreaterThanItemPrice
+	var validationErrors ValidationErrors
+	for _, fieldError := range append(validateShopItemCodes(item.SKU, item.GTIN), validateShopItemFields(item.ItemName, item.ItemPrice, item.ItemSalePrice, item.Quantity, item.WeightGrams, item.TaxClass)...) {
+		if set[fieldError.Field] && !(fieldError.Field == "quantity" && item.Quantity == 0) {
+			validationErrors = append(validationErrors, fieldError)
 		}
 	}
 
-	if u.Quantity <= 0 {
-		return ErrShopItemQuantityZeroOrNegative
+	return validationErrors.errOrNil()
+}
+
+func equalStrings(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
 	}
 
-	return nil
+	return *a == *b
 }
 
-func (u *ShopItemUpdate) updateStripeProduct(stripeProductApiID, name string, description *string) (*stripe.Product, error) {
-	params := &stripe.ProductParams{
-		Name:        stripe.String(name),
-		Description: description,
+func equalInt64s(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
 	}
 
-	return product.Update(stripeProductApiID, params)
+	return *a == *b
 }
 
-func (u *ShopItemUpdate) updateStripeProductPrice(productApiID, priceLookupKey string, unitAmount int64) (*stripe.Price, error) {
-	params := &stripe.PriceParams{
-		Product:           stripe.String(productApiID),
-		Currency:          stripe.String(string(stripe.CurrencyEUR)),
-		UnitAmount:        stripe.Int64(unitAmount),
-		LookupKey:         stripe.String(priceLookupKey),
-		TransferLookupKey: stripe.Bool(true),
+func equalInts(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
 	}
 
-	// This will create new price instead of updating unit amount, if we want to update unit amount then it has to be
-	// done using session authentication (Stripe API)
-	return price.New(params)
+	return *a == *b
 }
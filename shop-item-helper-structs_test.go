@@ -1,6 +1,8 @@
 package mop_shop
 
 import (
+	"encoding/json"
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stripe/stripe-go/v72"
 	"testing"
@@ -99,7 +101,7 @@ func TestShopItemCreate_Validate(t *testing.T) {
 			}
 
 			validationErr := c.Validate()
-			assert.Equal(t, tt.wantErr, validationErr, "Validate() error = %v, wantErr %v", validationErr, tt.wantErr)
+			assert.True(t, errors.Is(validationErr, tt.wantErr), "Validate() error = %v, wantErr %v", validationErr, tt.wantErr)
 		})
 	}
 }
@@ -233,7 +235,100 @@ func TestShopItemUpdate_Validate(t *testing.T) {
 				stripeProductID: tt.fields.stripeProductID,
 			}
 			validationErr := u.Validate()
-			assert.Equal(t, tt.wantErr, validationErr, "Validate() error = %v, wantErr %v", validationErr, tt.wantErr)
+			assert.True(t, errors.Is(validationErr, tt.wantErr), "Validate() error = %v, wantErr %v", validationErr, tt.wantErr)
 		})
 	}
 }
+
+func TestShopItemCreate_ValidateCollectsAllFields(t *testing.T) {
+	c := &ShopItemCreate{
+		ItemName:      "",
+		ItemPrice:     -20,
+		ItemSalePrice: stripe.Int64(-30),
+		Quantity:      0,
+	}
+
+	validationErr := c.Validate()
+
+	var validationErrors ValidationErrors
+	if !assert.True(t, errors.As(validationErr, &validationErrors), "Validate() error = %v", validationErr) {
+		return
+	}
+
+	assert.Equal(t, ValidationErrors{
+		{Field: "item_name", Err: ErrItemNameBlank},
+		{Field: "item_price", Err: ErrShopItemPriceNegative},
+		{Field: "item_sale_price", Err: ErrShopItemSalePriceNegative},
+		{Field: "quantity", Err: ErrShopItemQuantityZeroOrNegative},
+	}, validationErrors)
+
+	serialized, err := json.Marshal(validationErrors)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"field": "item_name", "error": "item_name_cannot_be_blank"},
+		{"field": "item_price", "error": "price_cannot_be_negative"},
+		{"field": "item_sale_price", "error": "sale_price_cannot_be_negative"},
+		{"field": "quantity", "error": "quantity_cannot_be_zero_or_negative"}
+	]`, string(serialized))
+}
+
+func TestShopItemPatch_UnmarshalJSON(t *testing.T) {
+	picture := "old.png"
+	description := "Wooden mop"
+	item := ShopItem{ItemName: "Mop", ItemPicture: &picture, ItemPrice: 1000, ItemDescription: &description, Quantity: 2}
+
+	patch := NewShopItemPatch()
+	if !assert.NoError(t, json.Unmarshal([]byte(`{"item_picture":null,"item_price":1200,"quantity":2}`), patch)) {
+		return
+	}
+
+	assert.Equal(t, OptionalString{Set: true}, patch.ItemPicture)
+	assert.False(t, patch.ItemDescription.Set)
+	assert.False(t, patch.ItemSalePrice.Set)
+	assert.Nil(t, patch.ItemName)
+
+	assert.Equal(t, []string{"item_picture", "item_price"}, patch.apply(&item))
+	assert.Nil(t, item.ItemPicture)
+	assert.Equal(t, int64(1200), item.ItemPrice)
+	assert.Equal(t, &description, item.ItemDescription)
+}
+
+func Test_validateShopItemCodes(t *testing.T) {
+	tests := []struct {
+		name string
+		sku  string
+		gtin string
+		want ValidationErrors
+	}{
+		{name: "EAN-13", sku: "MOP-1", gtin: "4006381333931"},
+		{name: "UPC-A", sku: "mop_1.blue", gtin: "036000291452"},
+		{name: "GTIN-8", sku: "MOP1", gtin: "96385074"},
+		{name: "GTIN-14", sku: "MOP1", gtin: "10012345678902"},
+		{
+			name: "Wrong EAN-13 check digit",
+			sku:  "MOP-1",
+			gtin: "4006381333932",
+			want: ValidationErrors{{Field: "gtin", Err: ErrGTINCheckDigit}},
+		},
+		{
+			name: "Invalid SKU and GTIN",
+			sku:  "MOP 1",
+			gtin: "40063813339A1",
+			want: ValidationErrors{{Field: "sku", Err: ErrSKUInvalid}, {Field: "gtin", Err: ErrGTINInvalid}},
+		},
+		{
+			name: "Unsupported GTIN length",
+			sku:  "MOP-1",
+			gtin: "123456789",
+			want: ValidationErrors{{Field: "gtin", Err: ErrGTINInvalid}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, validateShopItemCodes(&tt.sku, &tt.gtin))
+		})
+	}
+
+	assert.Nil(t, validateShopItemCodes(nil, nil))
+}
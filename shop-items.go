@@ -1,13 +1,10 @@
 package mop_shop
 
 import (
+	"context"
 	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
-	"log"
 	"net/http"
-	"net/url"
-	"strconv"
-	"strings"
 )
 
 type ShopItemForResponse struct {
@@ -23,6 +20,10 @@ type ShopItemForResponse struct {
 	ItemDescription    *string  `json:"item_description"`
 	Shippable          bool     `json:"shippable"`
 	Quantity           *int     `json:"quantity"`
+	// Locale is a virtual field holding the locale ItemName and ItemDescription are in
+	Locale string `gorm:"-" json:"locale"`
+	// Images is a virtual field ordered by position
+	Images []ShopItemImage `gorm:"-" json:"images"`
 }
 
 // GetShopItemsForFrontend returns all shop items from DB, if ``isAuthorized`` is false then
@@ -32,36 +33,42 @@ type ShopItemForResponse struct {
 //
 // - ShopItemForResponse.Quantity
 //
-// Reason for that is to force users to create an account and see full shop item info
-func GetShopItemsForFrontend(isAuthorized bool, currency string, paginationParams PaginationParams, req *http.Request, db *gorm.DB) ([]ShopItemForResponse, *PaginationResponse, error) {
-	var shopQuery strings.Builder
-	var params []interface{}
-
-	shopQuery.WriteString(`SELECT 
-			id, item_name, item_picture, item_price AS item_price_int_64,
-			item_sale_price AS item_sale_price_int_64, item_description, 
-			shippable, quantity
-		FROM shop_items 
-		WHERE deleted_at IS NULL `)
-
-	switch {
-	case paginationParams.Before == 0 && paginationParams.After == 0:
-		shopQuery.WriteString(`AND id > ? ORDER BY id DESC LIMIT ?`)
-		params = append(params, 0)
-	case paginationParams.After > 0:
-		shopQuery.WriteString(`AND id <= ? ORDER BY id DESC LIMIT ?`)
-		params = append(params, paginationParams.After)
-	case paginationParams.Before > 0:
-		shopQuery.WriteString(`AND id >= ? ORDER BY id ASC LIMIT ?`)
-		params = append(params, paginationParams.Before)
+// Reason for that is to force users to create an account and see full shop item info.
+//
+// Names and descriptions are translated to locale, falling back from a regional locale to its language (en-gb to en)
+// and then to the shop's default locale. An empty locale means the default one.
+func GetShopItemsForFrontend(isAuthorized bool, currency, locale string, paginationParams PaginationParams, req *http.Request, db *gorm.DB) ([]ShopItemForResponse, *PaginationResponse, error) {
+	return defaultShop(db).GetShopItemsForFrontend(isAuthorized, currency, locale, paginationParams, req)
+}
+
+func GetShopItemsForFrontendContext(ctx context.Context, isAuthorized bool, currency, locale string, paginationParams PaginationParams, req *http.Request, db *gorm.DB) ([]ShopItemForResponse, *PaginationResponse, error) {
+	return defaultShop(db).GetShopItemsForFrontendContext(ctx, isAuthorized, currency, locale, paginationParams, req)
+}
+
+// GetShopItemsForFrontend works like the package-level GetShopItemsForFrontend using the request's context
+func (s *Shop) GetShopItemsForFrontend(isAuthorized bool, currency, locale string, paginationParams PaginationParams, req *http.Request) ([]ShopItemForResponse, *PaginationResponse, error) {
+	return s.GetShopItemsForFrontendContext(req.Context(), isAuthorized, currency, locale, paginationParams, req)
+}
+
+func (s *Shop) GetShopItemsForFrontendContext(ctx context.Context, isAuthorized bool, currency, locale string, paginationParams PaginationParams, req *http.Request) ([]ShopItemForResponse, *PaginationResponse, error) {
+	ctx = contextWithRequestHeader(ctx, req)
+
+	paginationParams.normalize()
+
+	data, err := s.items.FindForFrontend(ctx, paginationParams)
+	if err != nil {
+		s.log(ctx, LevelError, "error while getting shop items", "error", err)
+		return nil, nil, wrapInternal("GetShopItemsForFrontend: query shop items", err)
 	}
 
-	params = append(params, paginationParams.PerPage+1)
+	if err := s.attachImages(ctx, data); err != nil {
+		s.log(ctx, LevelError, "error while getting shop item images", "error", err)
+		return nil, nil, wrapInternal("GetShopItemsForFrontend: query images", err)
+	}
 
-	var data []ShopItemForResponse
-	if err := db.Debug().Raw(shopQuery.String(), params...).Scan(&data).Error; err != nil {
-		log.Printf("error while getting user orders: %v\n", err)
-		return nil, nil, ErrInternal
+	if err := s.translateItems(ctx, data, locale); err != nil {
+		s.log(ctx, LevelError, "error while getting shop item translations", "locale", locale, "error", err)
+		return nil, nil, wrapInternal("GetShopItemsForFrontend: query translations", err)
 	}
 
 	if isAuthorized {
@@ -97,85 +104,57 @@ func GetShopItemsForFrontend(isAuthorized bool, currency string, paginationParam
 		data = []ShopItemForResponse{}
 	}
 
-	pages := PaginationResponse{}
+	ids := make([]int, len(data))
+	for i := range data {
+		ids[i] = data[i].ID
+	}
 
-	parsedURL, err := url.Parse(getCurrentURL(req))
+	pages, from, to, err := pageCursors(req, paginationParams, ids)
 	if err != nil {
-		return nil, nil, ErrParsingURL
+		return nil, nil, err
 	}
 
-	beforeQ := parsedURL.Query()
-	afterQ := parsedURL.Query()
-
-	switch {
-	case len(data) == paginationParams.PerPage+1:
-
-		if paginationParams.After > 0 {
-			beforeId := strconv.Itoa(data[0].ID + 1)
-
-			beforeQ.Del("after")
-			beforeQ.Set("before", beforeId)
-			parsedURL.RawQuery = beforeQ.Encode()
-			cursorBefore := parsedURL.String()
-
-			pages.CursorBefore = &cursorBefore
-			pages.Before = &beforeId
-		} else if paginationParams.Before > 0 {
-			beforeId := strconv.Itoa(data[0].ID)
+	return data[from:to], pages, nil
+}
 
-			beforeQ.Del("after")
-			beforeQ.Set("before", beforeId)
-			parsedURL.RawQuery = beforeQ.Encode()
-			cursorBefore := parsedURL.String()
+// FindDeletedShopItems returns soft-deleted shop items so they can be reviewed and restored. Pagination works like
+// in GetShopItemsForFrontend, newest items first.
+func FindDeletedShopItems(paginationParams PaginationParams, req *http.Request, db *gorm.DB) ([]ShopItem, *PaginationResponse, error) {
+	return defaultShop(db).FindDeletedShopItems(paginationParams, req)
+}
 
-			pages.Before = &beforeId
-			pages.CursorBefore = &cursorBefore
-		}
+func FindDeletedShopItemsContext(ctx context.Context, paginationParams PaginationParams, req *http.Request, db *gorm.DB) ([]ShopItem, *PaginationResponse, error) {
+	return defaultShop(db).FindDeletedShopItemsContext(ctx, paginationParams, req)
+}
 
-		afterId := strconv.Itoa(data[len(data)-1].ID)
+// FindDeletedShopItems works like the package-level FindDeletedShopItems using the request's context
+func (s *Shop) FindDeletedShopItems(paginationParams PaginationParams, req *http.Request) ([]ShopItem, *PaginationResponse, error) {
+	return s.FindDeletedShopItemsContext(req.Context(), paginationParams, req)
+}
 
-		afterQ.Set("after", afterId)
-		parsedURL.RawQuery = afterQ.Encode()
-		cursorAfter := parsedURL.String()
+func (s *Shop) FindDeletedShopItemsContext(ctx context.Context, paginationParams PaginationParams, req *http.Request) ([]ShopItem, *PaginationResponse, error) {
+	ctx = contextWithRequestHeader(ctx, req)
+	paginationParams.normalize()
 
-		pages.CursorAfter = &cursorAfter
-		pages.After = &afterId
+	data, err := s.items.FindDeleted(ctx, paginationParams)
+	if err != nil {
+		s.log(ctx, LevelError, "error while getting deleted shop items", "error", err)
+		return nil, nil, wrapInternal("FindDeletedShopItems: query shop items", err)
+	}
 
-		if paginationParams.Before > 0 {
-			afterId := strconv.Itoa(data[len(data)-1].ID - 1)
+	if len(data) == 0 {
+		data = []ShopItem{}
+	}
 
-			afterQ.Del("before")
-			afterQ.Set("after", afterId)
-			parsedURL.RawQuery = afterQ.Encode()
-			cursorAfter := parsedURL.String()
+	ids := make([]int, len(data))
+	for i := range data {
+		ids[i] = data[i].ID
+	}
 
-			pages.After = &afterId
-			pages.CursorAfter = &cursorAfter
-			data = data[1:]
-		} else {
-			data = data[:len(data)-1]
-		}
-	case len(data) <= paginationParams.PerPage && paginationParams.Before > 0:
-		afterId := strconv.Itoa(data[len(data)-1].ID - 1)
-
-		afterQ.Del("before")
-		afterQ.Set("after", afterId)
-		parsedURL.RawQuery = afterQ.Encode()
-		cursorAfter := parsedURL.String()
-
-		pages.After = &afterId
-		pages.CursorAfter = &cursorAfter
-	case len(data) <= paginationParams.PerPage && paginationParams.After > 0:
-		beforeId := strconv.Itoa(data[0].ID + 1)
-
-		beforeQ.Del("after")
-		beforeQ.Set("before", beforeId)
-		parsedURL.RawQuery = beforeQ.Encode()
-		cursorBefore := parsedURL.String()
-
-		pages.Before = &beforeId
-		pages.CursorBefore = &cursorBefore
+	pages, from, to, err := pageCursors(req, paginationParams, ids)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return data, &pages, nil
+	return data[from:to], pages, nil
 }
@@ -0,0 +1,457 @@
+package mop_shop
+
+import (
+	"context"
+	"errors"
+	"github.com/croatiangrn/mop-shop/stripetest"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestShopItem_CreateAndUpdateContext_stripe(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	stripeServer := stripetest.Start(t)
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(NewMemoryStorage()))
+
+	salePrice := int64(1500)
+	create := &ShopItemCreate{ItemName: "Mop", ItemPrice: 2000, ItemSalePrice: &salePrice, Quantity: 3}
+	create.SetUUID("lookup-key")
+
+	item := shop.NewShopItem()
+	if !assert.NoError(t, item.CreateContext(ctx, create, now)) {
+		return
+	}
+
+	stripeProduct, ok := stripeServer.Product(item.StripeProductApiID)
+	assert.True(t, ok)
+	assert.Equal(t, "Mop", stripeProduct.Name)
+	assert.Equal(t, "lookup-key", stripeProduct.Metadata[StripeLookupKeyMetadata])
+
+	stripePrice, ok := stripeServer.PriceByLookupKey("lookup-key")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1500), stripePrice.UnitAmount)
+
+	update := NewShopItemUpdate(item.StripeProductApiID)
+	update.ItemName, update.ItemPrice, update.Quantity = "Wooden mop", 2500, 3
+
+	updated := shop.NewShopItemForUpdate(item.ID, item.StripeProductApiID, item.UniqueStripePriceLookupKey)
+	if !assert.NoError(t, updated.UpdateContext(ctx, update)) {
+		return
+	}
+
+	stripeProduct, _ = stripeServer.Product(item.StripeProductApiID)
+	assert.Equal(t, "Wooden mop", stripeProduct.Name)
+
+	stripePrice, _ = stripeServer.PriceByLookupKey("lookup-key")
+	assert.Equal(t, int64(2500), stripePrice.UnitAmount)
+	assert.Len(t, stripeServer.Prices(), 2)
+}
+
+func TestUserOrder_UpdateEmptyOrderAfterCheckoutContext_stripe(t *testing.T) {
+	ctx := context.Background()
+	stripeServer := stripetest.Start(t)
+	storage := NewMemoryStorage()
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(storage))
+
+	productID := stripeServer.AddProduct(stripetest.Product{Name: "Mop", Active: true})
+	priceID := stripeServer.AddPrice(stripetest.Price{Product: productID, Currency: "eur", UnitAmount: 1500, LookupKey: "mop", Active: true})
+
+	sku := "MOP-1"
+	mop := &ShopItem{SKU: &sku, ItemName: "Mop", ItemPrice: 1500, Quantity: 5, StripeProductApiID: productID, UniqueStripePriceLookupKey: "mop"}
+	assert.NoError(t, storage.ShopItems().Create(ctx, mop))
+
+	order := shop.NewUserOrder()
+	assert.NoError(t, order.CreateEmptyOrderContext(ctx, 3, "ref"))
+
+	sessionID, err := stripeServer.AddCheckoutSession("ref", stripetest.LineItem{Price: priceID, Quantity: 2})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	if !assert.NoError(t, order.UpdateEmptyOrderAfterCheckoutContext(ctx, sessionID, "ref", 3000)) {
+		return
+	}
+
+	stock, _ := storage.ShopItems().FindOneByID(ctx, mop.ID)
+	assert.Equal(t, 3, stock.Quantity)
+
+	renamed, renamedItem := "MOP-2", "Wooden mop"
+	assert.NoError(t, shop.NewShopItem().PatchContext(ctx, mop.ID, &ShopItemPatch{SKU: NewOptionalString(&renamed), ItemName: &renamedItem}, time.Now()))
+
+	got, err := shop.FindOrderByByIDAndUserIDContext(ctx, order.ID, 3, true, "eur", "")
+	if assert.NoError(t, err) && assert.Len(t, got.Items, 1) {
+		assert.Equal(t, mop.ID, got.Items[0].ItemID)
+		assert.Equal(t, &sku, got.Items[0].SKU, "order keeps the SKU the item had when ordered")
+		assert.Equal(t, "Mop", got.Items[0].ItemName, "order keeps the name the item had when ordered")
+		assert.Equal(t, 15.0, *got.Items[0].ItemPrice)
+		assert.Equal(t, 2, got.Items[0].Quantity)
+	}
+
+	found := shop.NewShopItem()
+	if assert.NoError(t, found.FindOneBySKUContext(ctx, "MOP-2")) {
+		assert.Equal(t, mop.ID, found.ID)
+	}
+	assert.True(t, errors.Is(shop.NewShopItem().FindOneBySKUContext(ctx, "MOP-1"), gorm.ErrRecordNotFound))
+}
+
+func TestUserOrder_UpdateEmptyOrderAfterCheckoutContext_snapshotAtPlacement(t *testing.T) {
+	ctx := context.Background()
+	stripeServer := stripetest.Start(t)
+	storage := NewMemoryStorage()
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(storage))
+
+	productID := stripeServer.AddProduct(stripetest.Product{Name: "Mop", Active: true})
+	priceID := stripeServer.AddPrice(stripetest.Price{Product: productID, Currency: "eur", UnitAmount: 1500, LookupKey: "mop", Active: true})
+
+	description := "Wooden mop"
+	mop := &ShopItem{ItemName: "Mop", ItemDescription: &description, ItemPrice: 1500, Quantity: 5, StripeProductApiID: productID, UniqueStripePriceLookupKey: "mop"}
+	assert.NoError(t, storage.ShopItems().Create(ctx, mop))
+	assert.NoError(t, storage.Translations().Save(ctx, &ShopItemTranslation{ShopItemID: mop.ID, Locale: "en", ItemName: "Floor mop"}))
+
+	data := NewCreateUserOrder(3)
+	data.Items = []CreateUserOrderItem{{ItemID: mop.ID, Quantity: 2}}
+
+	order := shop.NewUserOrder()
+	if !assert.NoError(t, order.PrepareForOrderContext(ctx, data)) || !assert.NoError(t, order.CreateEmptyOrderContext(ctx, 3, "ref")) {
+		return
+	}
+
+	// the item changes after the order was placed, but before Stripe reports the checkout as completed
+	renamed := "Plastic mop"
+	assert.NoError(t, shop.NewShopItem().PatchContext(ctx, mop.ID, &ShopItemPatch{ItemName: &renamed, ItemDescription: NewOptionalString(nil)}, time.Now()))
+	assert.NoError(t, storage.Translations().Save(ctx, &ShopItemTranslation{ShopItemID: mop.ID, Locale: "en", ItemName: "Swab"}))
+
+	sessionID, err := stripeServer.AddCheckoutSession("ref", stripetest.LineItem{Price: priceID, Quantity: 2})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	placed := shop.NewUserOrder()
+	if !assert.NoError(t, placed.FindOneByClientReferenceIDContext(ctx, "ref", false)) || !assert.NoError(t, placed.UpdateEmptyOrderAfterCheckoutContext(ctx, sessionID, "ref", 3000)) {
+		return
+	}
+
+	got, err := shop.FindOrderByByIDAndUserIDContext(ctx, order.ID, 3, true, "eur", "")
+	if assert.NoError(t, err) && assert.Len(t, got.Items, 1) {
+		assert.Equal(t, "Mop", got.Items[0].ItemName)
+		assert.Equal(t, description, got.Items[0].ItemDescription)
+	}
+
+	got, err = shop.FindOrderByByIDAndUserIDContext(ctx, order.ID, 3, true, "eur", "en")
+	if assert.NoError(t, err) && assert.Len(t, got.Items, 1) {
+		assert.Equal(t, "Floor mop", got.Items[0].ItemName, "order keeps the translation the item had when ordered")
+	}
+}
+
+type failingShopItemRepository struct {
+	ShopItemRepository
+	err error
+}
+
+func (r failingShopItemRepository) Create(ctx context.Context, item *ShopItem) error {
+	return r.err
+}
+
+func TestShopItem_CreateContext_compensation(t *testing.T) {
+	errInsert := errors.New("insert failed")
+
+	tests := []struct {
+		name          string
+		failStripe    func(s *stripetest.Server)
+		items         ShopItemRepository
+		wantErr       error
+		wantLeftovers []string
+	}{
+		{
+			name: "Price fails, product is archived",
+			failStripe: func(s *stripetest.Server) {
+				s.FailNext(http.MethodPost, "/v1/prices", http.StatusBadRequest, "")
+			},
+			items:   NewMemoryStorage().ShopItems(),
+			wantErr: ErrInternal,
+		},
+		{
+			name:       "Insert fails, product and price are archived",
+			failStripe: func(s *stripetest.Server) {},
+			items:      failingShopItemRepository{err: errInsert},
+			wantErr:    errInsert,
+		},
+		{
+			name: "Archiving fails, leftovers are reported",
+			failStripe: func(s *stripetest.Server) {
+				s.FailNext(http.MethodPost, "/v1/products/", http.StatusServiceUnavailable, "")
+			},
+			items:         failingShopItemRepository{err: errInsert},
+			wantErr:       errInsert,
+			wantLeftovers: []string{"product"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stripeServer := stripetest.Start(t)
+			tt.failStripe(stripeServer)
+
+			shop := NewShop(nil, "sk_test", WithShopItemRepository(tt.items))
+			item := shop.NewShopItem()
+			err := item.CreateContext(context.Background(), &ShopItemCreate{ItemName: "Mop", ItemPrice: 2000, Quantity: 3}, time.Now())
+			assert.True(t, errors.Is(err, tt.wantErr), "CreateContext() error = %v, wantErr %v", err, tt.wantErr)
+			assert.Empty(t, item.StripeProductApiID)
+
+			var compensationErr *CompensationError
+			if len(tt.wantLeftovers) == 0 {
+				assert.False(t, errors.As(err, &compensationErr), "unexpected leftovers: %v", err)
+
+				for _, p := range stripeServer.Products() {
+					assert.False(t, p.Active, "product %s should be archived", p.ID)
+				}
+
+				for _, p := range stripeServer.Prices() {
+					assert.False(t, p.Active, "price %s should be archived", p.ID)
+				}
+
+				return
+			}
+
+			if assert.True(t, errors.As(err, &compensationErr)) {
+				var objects []string
+				for _, leftover := range compensationErr.Leftovers {
+					objects = append(objects, leftover.Object)
+				}
+
+				assert.Equal(t, tt.wantLeftovers, objects)
+			}
+		})
+	}
+}
+
+func TestShopItem_DeleteAndRestoreContext_stripe(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	stripeServer := stripetest.Start(t)
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(NewMemoryStorage()))
+
+	created := shop.NewShopItem()
+	if !assert.NoError(t, created.CreateContext(ctx, &ShopItemCreate{ItemName: "Mop", ItemPrice: 2000, Quantity: 3}, now)) {
+		return
+	}
+
+	update := NewShopItemUpdate(created.StripeProductApiID)
+	update.ItemName, update.ItemPrice, update.Quantity = "Mop", 2500, 3
+	assert.NoError(t, shop.NewShopItemForUpdate(created.ID, created.StripeProductApiID, created.UniqueStripePriceLookupKey).UpdateContext(ctx, update))
+
+	assert.NoError(t, shop.NewShopItem().DeleteContext(ctx, created.ID, now))
+
+	stripeProduct, ok := stripeServer.Product(created.StripeProductApiID)
+	assert.True(t, ok, "product should be archived, not deleted")
+	assert.False(t, stripeProduct.Active)
+	for _, p := range stripeServer.Prices() {
+		assert.False(t, p.Active, "price %s should be inactive", p.ID)
+	}
+
+	assert.True(t, errors.Is(shop.NewShopItem().DeleteContext(ctx, created.ID, now), gorm.ErrRecordNotFound))
+
+	restored := shop.NewShopItem()
+	assert.NoError(t, restored.RestoreContext(ctx, created.ID, now))
+	assert.Equal(t, created.StripeProductApiID, restored.StripeProductApiID)
+	assert.Nil(t, restored.DeletedAt)
+
+	stripeProduct, _ = stripeServer.Product(created.StripeProductApiID)
+	assert.True(t, stripeProduct.Active)
+	for _, p := range stripeServer.Prices() {
+		assert.Equal(t, p.LookupKey == created.UniqueStripePriceLookupKey, p.Active, "price %s", p.ID)
+	}
+}
+
+func TestShopItem_DeleteAndRestoreContext_stripeFailure(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	stripeServer := stripetest.Start(t)
+	structured := &structuredLoggerTest{}
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(NewMemoryStorage()), WithLogger(NewStructuredLogger(structured)))
+
+	created := shop.NewShopItem()
+	if !assert.NoError(t, created.CreateContext(ctx, &ShopItemCreate{ItemName: "Mop", ItemPrice: 2000, Quantity: 3}, now)) {
+		return
+	}
+
+	stripeServer.FailNext(http.MethodPost, "/v1/products/"+created.StripeProductApiID, http.StatusServiceUnavailable, "")
+	assert.NoError(t, shop.NewShopItem().DeleteContext(ctx, created.ID, now), "a saved deletion should not be reported as failed")
+	assert.True(t, errors.Is(shop.NewShopItem().FindOneByIDContext(ctx, created.ID), gorm.ErrRecordNotFound))
+
+	stripeProduct, _ := stripeServer.Product(created.StripeProductApiID)
+	assert.True(t, stripeProduct.Active, "the product should be left for the reconciler")
+	if assert.Len(t, structured.entries, 1) {
+		assert.Equal(t, "error", structured.entries[0].level)
+	}
+
+	report, err := shop.ReconcileCatalog(ctx, ReconcileRepair())
+	if assert.NoError(t, err) && assert.Len(t, report.Mismatches, 1) {
+		assert.Equal(t, MismatchDeletedButActive, report.Mismatches[0].Kind)
+		assert.True(t, report.Mismatches[0].Repaired)
+	}
+
+	stripeProduct, _ = stripeServer.Product(created.StripeProductApiID)
+	assert.False(t, stripeProduct.Active)
+
+	stripeServer.FailNext(http.MethodPost, "/v1/products/"+created.StripeProductApiID, http.StatusServiceUnavailable, "")
+	restored := shop.NewShopItem()
+	assert.NoError(t, restored.RestoreContext(ctx, created.ID, now))
+	assert.Nil(t, restored.DeletedAt)
+	assert.NoError(t, shop.NewShopItem().FindOneByIDContext(ctx, created.ID))
+	assert.Len(t, structured.entries, 2)
+}
+
+func TestShopItem_UpdateContext_stripeDescription(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	stripeServer := stripetest.Start(t)
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(NewMemoryStorage()))
+
+	description := "Wooden mop"
+	item := shop.NewShopItem()
+	if !assert.NoError(t, item.CreateContext(ctx, &ShopItemCreate{ItemName: "Mop", ItemPrice: 2000, ItemDescription: &description, Quantity: 3}, now)) {
+		return
+	}
+
+	update := NewShopItemUpdate(item.StripeProductApiID)
+	update.ItemName, update.ItemPrice, update.Quantity = "Floor mop", 2000, 3
+	assert.NoError(t, shop.NewShopItemForUpdate(item.ID, item.StripeProductApiID, item.UniqueStripePriceLookupKey).UpdateContext(ctx, update))
+
+	stripeProduct, _ := stripeServer.Product(item.StripeProductApiID)
+	assert.Equal(t, "Floor mop", stripeProduct.Name)
+	assert.Equal(t, description, stripeProduct.Description, "a missing description should not be sent to stripe")
+
+	name := "Wet mop"
+	assert.NoError(t, shop.NewShopItem().PatchContext(ctx, item.ID, &ShopItemPatch{ItemName: &name}, now))
+
+	stripeProduct, _ = stripeServer.Product(item.StripeProductApiID)
+	assert.Equal(t, "Wet mop", stripeProduct.Name)
+	assert.Equal(t, description, stripeProduct.Description, "patching the name should not touch the description")
+}
+
+func TestShopItem_PatchContext_stripe(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	stripeServer := stripetest.Start(t)
+	storage := NewMemoryStorage()
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(storage))
+
+	description := "Wooden mop"
+	create := &ShopItemCreate{ItemName: "Mop", ItemPrice: 2000, ItemDescription: &description, Quantity: 3}
+	create.SetUUID("lookup-key")
+
+	item := shop.NewShopItem()
+	if !assert.NoError(t, item.CreateContext(ctx, create, now)) {
+		return
+	}
+
+	picture := "mop.png"
+	quantity := 5
+	requests := len(stripeServer.Requests())
+	patched := shop.NewShopItem()
+	if !assert.NoError(t, patched.PatchContext(ctx, item.ID, &ShopItemPatch{ItemPicture: NewOptionalString(&picture), Quantity: &quantity}, now.Add(time.Hour))) {
+		return
+	}
+
+	assert.Len(t, stripeServer.Requests(), requests, "stripe must not be called when only local fields change")
+	assert.Equal(t, &picture, patched.ItemPicture)
+	assert.Equal(t, 5, patched.Quantity)
+	assert.Equal(t, "Mop", patched.ItemName)
+	assert.Equal(t, now.Add(time.Hour), patched.UpdatedAt)
+
+	salePrice := int64(1500)
+	patched = shop.NewShopItem()
+	if !assert.NoError(t, patched.PatchContext(ctx, item.ID, &ShopItemPatch{ItemSalePrice: NewOptionalInt64(&salePrice), ItemDescription: NewOptionalString(nil)}, now.Add(2*time.Hour))) {
+		return
+	}
+
+	stripeProduct, _ := stripeServer.Product(item.StripeProductApiID)
+	assert.Equal(t, "", stripeProduct.Description)
+	stripePrice, _ := stripeServer.PriceByLookupKey("lookup-key")
+	assert.Equal(t, int64(1500), stripePrice.UnitAmount)
+	assert.Len(t, stripeServer.Prices(), 2)
+
+	stored := shop.NewShopItem()
+	assert.NoError(t, stored.FindOneByIDContext(ctx, item.ID))
+	assert.Equal(t, &picture, stored.ItemPicture)
+	assert.Equal(t, &salePrice, stored.ItemSalePrice)
+	assert.Nil(t, stored.ItemDescription)
+
+	itemPrice := int64(3000)
+	assert.NoError(t, shop.NewShopItem().PatchContext(ctx, item.ID, &ShopItemPatch{ItemPrice: &itemPrice}, now.Add(3*time.Hour)))
+	assert.Len(t, stripeServer.Prices(), 2, "effective price didn't change")
+
+	history, err := shop.FindShopItemPriceHistoryContext(ctx, item.ID)
+	if assert.NoError(t, err) && assert.Len(t, history, 3) {
+		assert.Equal(t, int64(3000), history[2].ItemPrice)
+		assert.Equal(t, history[1].StripePriceApiID, history[2].StripePriceApiID)
+	}
+
+	invalidSalePrice := int64(5000)
+	err = shop.NewShopItem().PatchContext(ctx, item.ID, &ShopItemPatch{ItemSalePrice: NewOptionalInt64(&invalidSalePrice)}, now)
+	var validationErrors ValidationErrors
+	assert.True(t, errors.As(err, &validationErrors))
+
+	// orders sell items out, which must not stop patching fields other than the quantity
+	soldOut := shop.NewShopItem()
+	assert.NoError(t, soldOut.FindOneByIDContext(ctx, item.ID))
+	soldOut.Quantity = 0
+	assert.NoError(t, storage.ShopItems().UpdateColumns(ctx, soldOut, []string{"quantity"}))
+
+	otherPicture := "mop-2.png"
+	assert.NoError(t, shop.NewShopItem().PatchContext(ctx, item.ID, &ShopItemPatch{ItemPicture: NewOptionalString(&otherPicture)}, now))
+
+	restocked, negative := 4, -1
+	assert.NoError(t, shop.NewShopItem().PatchContext(ctx, item.ID, &ShopItemPatch{Quantity: &restocked}, now))
+	assert.NoError(t, shop.NewShopItem().PatchContext(ctx, item.ID, &ShopItemPatch{Quantity: &soldOut.Quantity}, now), "patches may sell items out")
+
+	err = shop.NewShopItem().PatchContext(ctx, item.ID, &ShopItemPatch{ItemPicture: NewOptionalString(&picture), Quantity: &negative}, now)
+	if assert.True(t, errors.As(err, &validationErrors)) {
+		assert.Equal(t, ValidationErrors{{Field: "quantity", Err: ErrShopItemQuantityZeroOrNegative}}, validationErrors)
+	}
+}
+
+func TestShopItem_UpdateContext_versionConflict(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	stripeServer := stripetest.Start(t)
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(NewMemoryStorage()))
+
+	item := shop.NewShopItem()
+	if !assert.NoError(t, item.CreateContext(ctx, &ShopItemCreate{ItemName: "Mop", ItemPrice: 2000, Quantity: 3}, now)) {
+		return
+	}
+
+	first, second := shop.NewShopItem(), shop.NewShopItem()
+	assert.NoError(t, first.FindOneByIDContext(ctx, item.ID))
+	assert.NoError(t, second.FindOneByIDContext(ctx, item.ID))
+	assert.Equal(t, 1, first.Version)
+
+	update := NewShopItemUpdate(item.StripeProductApiID)
+	update.ItemName, update.ItemPrice, update.Quantity = "Wooden mop", 2000, 3
+	if !assert.NoError(t, first.UpdateContext(ctx, update)) {
+		return
+	}
+	assert.Equal(t, 2, first.Version)
+
+	update.ItemName = "Plastic mop"
+	err := second.UpdateContext(ctx, update)
+	assert.True(t, IsConflict(err))
+	assert.Equal(t, &VersionConflictError{ShopItemID: item.ID, ExpectedVersion: 1, CurrentVersion: 2}, err)
+
+	stripeProduct, _ := stripeServer.Product(item.StripeProductApiID)
+	assert.Equal(t, "Wooden mop", stripeProduct.Name, "losing update must not reach stripe")
+
+	quantity, staleVersion := 10, 1
+	err = shop.NewShopItem().PatchContext(ctx, item.ID, &ShopItemPatch{Quantity: &quantity, Version: &staleVersion}, now)
+	assert.True(t, errors.Is(err, ErrVersionConflict))
+
+	patched := shop.NewShopItem()
+	assert.NoError(t, patched.PatchContext(ctx, item.ID, &ShopItemPatch{Quantity: &quantity, Version: &first.Version}, now))
+	assert.Equal(t, 3, patched.Version)
+}
@@ -1,11 +1,10 @@
 package mop_shop
 
 import (
+	"context"
 	"errors"
 	"github.com/stripe/stripe-go/v72"
-	"github.com/stripe/stripe-go/v72/product"
 	"gorm.io/gorm"
-	"log"
 	"time"
 )
 
@@ -16,28 +15,46 @@ type shopItemCreateInterface interface {
 	GetItemSalePrice() *int64
 	GetItemDescription() *string
 	GetShippable() bool
+	GetWeightGrams() *int
+	GetTaxClass() TaxClass
 	GetQuantity() int
-	createStripeProduct(name string, description *string) (*stripe.Product, error)
-	createStripeProductPrice(product *stripe.Product, unitAmount int64, lookupKey string) (*stripe.Price, error)
+	GetSKU() *string
+	GetGTIN() *string
+	createStripeProduct(ctx context.Context, name string, description *string, lookupKey string) (*stripe.Product, error)
+	createStripeProductPrice(ctx context.Context, product *stripe.Product, unitAmount int64, lookupKey string) (*stripe.Price, error)
+	archiveStripeProduct(ctx context.Context, stripeProductApiID string) error
+	archiveStripePrice(ctx context.Context, stripePriceApiID string) error
 	GetUUID() string
 	Validate() error
 }
 
 type ShopItem struct {
-	ID                         int        `gorm:"primaryKey" json:"id"`
-	ItemName                   string     `gorm:"not null;type:varchar(255);" json:"item_name"`
-	ItemPicture                *string    `gorm:"default:null;type:varchar(255);" json:"item_picture"`
-	ItemPrice                  int64      `gorm:"not null;" json:"item_price"`
-	ItemSalePrice              *int64     `gorm:"default: null;" json:"item_sale_price"`
-	ItemDescription            *string    `gorm:"type:text;default:null;" json:"item_description"`
-	Shippable                  bool       `gorm:"not null;default:false;" json:"shippable"`
-	Quantity                   int        `gorm:"not null; default:0;" json:"quantity"`
-	StripeProductApiID         string     `gorm:"not null; type:varchar(255);uniqueIndex:ux_stripe_product_api_id;" json:"stripe_product_api_id"`
-	UniqueStripePriceLookupKey string     `gorm:"type:varchar(36);" json:"unique_stripe_price_lookup_key"`
-	CreatedAt                  time.Time  `gorm:"not null;" json:"created_at"`
-	UpdatedAt                  time.Time  `gorm:"not null;" json:"updated_at"`
-	DeletedAt                  *time.Time `json:"-"`
-	db                         *gorm.DB
+	ID  int     `gorm:"primaryKey" json:"id"`
+	SKU *string `gorm:"type:varchar(64);uniqueIndex:ux_shop_items_sku;" json:"sku"`
+	// GTIN is the barcode number, such as EAN-13
+	GTIN            *string `gorm:"type:varchar(14);" json:"gtin"`
+	ItemName        string  `gorm:"not null;type:varchar(255);" json:"item_name"`
+	ItemPicture     *string `gorm:"default:null;type:varchar(255);" json:"item_picture"`
+	ItemPrice       int64   `gorm:"not null;" json:"item_price"`
+	ItemSalePrice   *int64  `gorm:"default: null;" json:"item_sale_price"`
+	ItemDescription *string `gorm:"type:text;default:null;" json:"item_description"`
+	Shippable       bool    `gorm:"not null;default:false;" json:"shippable"`
+	// WeightGrams is the shipping weight of one piece, used by weight based shipping methods
+	WeightGrams *int `json:"weight_grams"`
+	// TaxClass picks the VAT rate of the item, empty being TaxClassStandard
+	TaxClass                   TaxClass `gorm:"type:varchar(32);not null;" json:"tax_class"`
+	Quantity                   int      `gorm:"not null; default:0;" json:"quantity"`
+	StripeProductApiID         string   `gorm:"not null; type:varchar(255);uniqueIndex:ux_stripe_product_api_id;" json:"stripe_product_api_id"`
+	UniqueStripePriceLookupKey string   `gorm:"type:varchar(36);" json:"unique_stripe_price_lookup_key"`
+	// Version is incremented on every write of the item, updates of a loaded item fail with VersionConflictError
+	// when it changed in the meantime. Items made via NewShopItemForUpdate have no version and aren't checked.
+	Version      int        `gorm:"not null;default:1;" json:"version"`
+	CreatedAt    time.Time  `gorm:"not null;" json:"created_at"`
+	UpdatedAt    time.Time  `gorm:"not null;" json:"updated_at"`
+	DeletedAt    *time.Time `json:"-"`
+	items        ShopItemRepository
+	priceHistory PriceHistoryRepository
+	logger       Logger
 }
 
 func (i *ShopItem) TableName() string {
@@ -45,31 +62,72 @@ func (i *ShopItem) TableName() string {
 }
 
 func NewShopItem(db *gorm.DB, stripeKey string) *ShopItem {
-	stripe.Key = stripeKey
-	return &ShopItem{db: db}
+	return NewShop(db, stripeKey).NewShopItem()
 }
 
 func NewShopItemForUpdate(db *gorm.DB, shopItemID int, stripeKey string, stripeProductApiID, uniqueStripePriceLookupKey string) *ShopItem {
-	stripe.Key = stripeKey
-	return &ShopItem{ID: shopItemID, db: db, StripeProductApiID: stripeProductApiID, UniqueStripePriceLookupKey: uniqueStripePriceLookupKey}
+	return NewShop(db, stripeKey).NewShopItemForUpdate(shopItemID, stripeProductApiID, uniqueStripePriceLookupKey)
+}
+
+func (i *ShopItem) log(ctx context.Context, level Level, msg string, keysAndValues ...interface{}) {
+	logWithContext(ctx, loggerOrNop(i.logger), level, msg, append([]interface{}{"shop_item_id", i.ID}, keysAndValues...)...)
 }
 
 func (i *ShopItem) FindOneByID(shopItemID int) error {
-	query := `SELECT * FROM shop_items WHERE id = ? AND deleted_at IS NULL`
+	return i.FindOneByIDContext(context.Background(), shopItemID)
+}
 
-	if err := i.db.Debug().Raw(query, shopItemID).Take(&i).Error; err != nil {
+func (i *ShopItem) FindOneByIDContext(ctx context.Context, shopItemID int) error {
+	if i.items == nil {
+		return ErrShopItemNotInitializedProperly
+	}
+
+	item, err := i.items.FindOneByID(ctx, shopItemID)
+	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return err
 		}
 
-		return ErrInternal
+		i.log(ctx, LevelError, "error while getting shop item", "requested_shop_item_id", shopItemID, "error", err)
+		return wrapInternal("ShopItem.FindOneByID: query shop item", err)
 	}
 
+	item.items, item.priceHistory, item.logger = i.items, i.priceHistory, i.logger
+	*i = *item
+	return nil
+}
+
+func (i *ShopItem) FindOneBySKU(sku string) error {
+	return i.FindOneBySKUContext(context.Background(), sku)
+}
+
+// FindOneBySKUContext works like FindOneByIDContext, looking the item up by its SKU
+func (i *ShopItem) FindOneBySKUContext(ctx context.Context, sku string) error {
+	if i.items == nil {
+		return ErrShopItemNotInitializedProperly
+	}
+
+	item, err := i.items.FindOneBySKU(ctx, sku)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		i.log(ctx, LevelError, "error while getting shop item by sku", "sku", sku, "error", err)
+		return wrapInternal("ShopItem.FindOneBySKU: query shop item", err)
+	}
+
+	item.items, item.priceHistory, item.logger = i.items, i.priceHistory, i.logger
+	*i = *item
 	return nil
 }
 
 func (i *ShopItem) Create(data shopItemCreateInterface, currentTime time.Time) error {
-	if i.db == nil {
+	return i.CreateContext(context.Background(), data, currentTime)
+}
+
+func (i *ShopItem) CreateContext(ctx context.Context, data shopItemCreateInterface, currentTime time.Time) error {
+	if i.items == nil {
 		return ErrShopItemNotInitializedProperly
 	}
 
@@ -81,20 +139,26 @@ func (i *ShopItem) Create(data shopItemCreateInterface, currentTime time.Time) e
 		return err
 	}
 
+	i.SKU = data.GetSKU()
+	i.GTIN = data.GetGTIN()
 	i.ItemName = data.GetItemName()
 	i.ItemPicture = data.GetItemPicture()
 	i.ItemPrice = data.GetItemPrice()
 	i.ItemSalePrice = data.GetItemSalePrice()
 	i.ItemDescription = data.GetItemDescription()
 	i.Shippable = data.GetShippable()
+	i.WeightGrams = data.GetWeightGrams()
+	i.TaxClass = data.GetTaxClass()
 	i.Quantity = data.GetQuantity()
 	i.CreatedAt = currentTime
 	i.UpdatedAt = currentTime
+	i.Version = 1
 
-	stripeProduct, err := data.createStripeProduct(data.GetItemName(), data.GetItemDescription())
+	lookUpKey := data.GetUUID()
+	stripeProduct, err := data.createStripeProduct(ctx, data.GetItemName(), data.GetItemDescription(), lookUpKey)
 	if err != nil {
-		log.Printf("error occurred while creating stripe product: %v", err)
-		return err
+		i.log(ctx, LevelError, "error occurred while creating stripe product", "error", err)
+		return wrapInternal("ShopItem.Create: create stripe product", err)
 	}
 
 	itemPrice := data.GetItemPrice()
@@ -102,34 +166,63 @@ func (i *ShopItem) Create(data shopItemCreateInterface, currentTime time.Time) e
 		itemPrice = *data.GetItemSalePrice()
 	}
 
-	lookUpKey := data.GetUUID()
-	if _, err := data.createStripeProductPrice(stripeProduct, itemPrice, lookUpKey); err != nil {
-		log.Printf("error occurred while creating stripe product price: %v", err)
-		return err
+	stripePrice, err := data.createStripeProductPrice(ctx, stripeProduct, itemPrice, lookUpKey)
+	if err != nil {
+		i.log(ctx, LevelError, "error occurred while creating stripe product price", "stripe_product_id", stripeProduct.ID, "error", err)
+		return i.compensateCreate(ctx, data, wrapInternal("ShopItem.Create: create stripe price", err), stripeProduct.ID, "")
 	}
 
-	insertQuery := `INSERT INTO shop_items (item_name, item_picture, item_price, item_sale_price, item_description, shippable, quantity, stripe_product_api_id, unique_stripe_price_lookup_key, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	i.StripeProductApiID = stripeProduct.ID
+	i.UniqueStripePriceLookupKey = lookUpKey
+
+	if err := i.items.Create(ctx, i); err != nil {
+		i.log(ctx, LevelError, "error while saving shop item to db", "stripe_product_id", stripeProduct.ID, "error", err)
+		return i.compensateCreate(ctx, data, wrapInternal("ShopItem.Create: insert shop item", err), stripeProduct.ID, stripePrice.ID)
+	}
 
-	params := []interface{}{i.ItemName, i.ItemPicture, i.ItemPrice, i.ItemSalePrice, i.ItemDescription, i.Shippable,
-		i.Quantity, stripeProduct.ID, lookUpKey, i.CreatedAt, i.UpdatedAt}
+	i.recordPrice(ctx, stripePrice.ID, currentTime)
+	return nil
+}
 
-	if err := i.db.Debug().Exec(insertQuery, params...).Error; err != nil {
-		log.Printf("error while saving to db: %v\n", err)
-		return ErrInternal
+// compensateCreate archives the Stripe price and product created before Create failed with cause. Archiving
+// doesn't use ctx directly since it's often the reason of the failure, only its request ID is kept.
+func (i *ShopItem) compensateCreate(ctx context.Context, data shopItemCreateInterface, cause error, stripeProductApiID, stripePriceApiID string) error {
+	compensationCtx := ContextWithRequestID(context.Background(), RequestIDFromContext(ctx))
+
+	var leftovers []StripeLeftover
+	if len(stripePriceApiID) > 0 {
+		if err := data.archiveStripePrice(compensationCtx, stripePriceApiID); err != nil {
+			leftovers = append(leftovers, StripeLeftover{Object: "price", ID: stripePriceApiID, Err: err})
+		}
 	}
 
-	lastID, err := getLastInsertedID(i.db)
-	if err != nil {
-		return err
+	if err := data.archiveStripeProduct(compensationCtx, stripeProductApiID); err != nil {
+		leftovers = append(leftovers, StripeLeftover{Object: "product", ID: stripeProductApiID, Err: err})
 	}
 
-	i.ID = lastID
-	i.StripeProductApiID = stripeProduct.ID
-	i.UniqueStripePriceLookupKey = lookUpKey
-	return nil
+	i.StripeProductApiID = ""
+	i.UniqueStripePriceLookupKey = ""
+
+	if len(leftovers) == 0 {
+		return cause
+	}
+
+	for _, leftover := range leftovers {
+		i.log(ctx, LevelError, "could not archive stripe object after failed create", "stripe_object", leftover.Object, "stripe_id", leftover.ID, "error", leftover.Err)
+	}
+
+	return &CompensationError{Err: cause, Leftovers: leftovers}
 }
 
 func (i *ShopItem) Update(data *ShopItemUpdate) error {
+	return i.UpdateContext(context.Background(), data)
+}
+
+func (i *ShopItem) UpdateContext(ctx context.Context, data *ShopItemUpdate) error {
+	if i.items == nil {
+		return ErrShopItemNotInitializedProperly
+	}
+
 	if data == nil {
 		return ErrShopItemUpdateBlank
 	}
@@ -138,6 +231,24 @@ func (i *ShopItem) Update(data *ShopItemUpdate) error {
 		return err
 	}
 
+	// Checking the version before Stripe is written keeps a losing update from changing the Stripe product, the
+	// versioned write below still guards against updates racing past this point
+	if i.Version != 0 {
+		current, err := i.items.FindOneByID(ctx, i.ID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+
+			i.log(ctx, LevelError, "error while getting shop item", "error", err)
+			return wrapInternal("ShopItem.Update: query shop item", err)
+		}
+
+		if current.Version != i.Version {
+			return &VersionConflictError{ShopItemID: i.ID, ExpectedVersion: i.Version, CurrentVersion: current.Version}
+		}
+	}
+
 	i.ItemName = data.ItemName
 	i.ItemPicture = data.ItemPicture
 	i.ItemPrice = data.ItemPrice
@@ -146,9 +257,32 @@ func (i *ShopItem) Update(data *ShopItemUpdate) error {
 	i.Shippable = data.Shippable
 	i.Quantity = data.Quantity
 
-	if _, err := data.updateStripeProduct(i.StripeProductApiID, i.ItemName, i.ItemDescription); err != nil {
-		log.Printf("error occurred while updating stripe product: %v", err)
-		return err
+	columns := []string{"item_name", "item_picture", "item_price", "item_sale_price", "item_description", "shippable", "quantity"}
+	if data.SKU != nil {
+		i.SKU = data.SKU
+		columns = append(columns, "sku")
+	}
+
+	if data.GTIN != nil {
+		i.GTIN = data.GTIN
+		columns = append(columns, "gtin")
+	}
+
+	if data.WeightGrams != nil {
+		i.WeightGrams = data.WeightGrams
+		columns = append(columns, "weight_grams")
+	}
+
+	if len(data.TaxClass) > 0 {
+		i.TaxClass = data.TaxClass
+		columns = append(columns, "tax_class")
+	}
+
+	// A missing description leaves the one of the Stripe product alone, only patches clear it explicitly
+	description := OptionalString{Set: i.ItemDescription != nil, Value: i.ItemDescription}
+	if _, err := data.updateStripeProduct(ctx, i.StripeProductApiID, i.ItemName, description); err != nil {
+		i.log(ctx, LevelError, "error occurred while updating stripe product", "stripe_product_id", i.StripeProductApiID, "error", err)
+		return wrapInternal("ShopItem.Update: update stripe product", err)
 	}
 
 	itemPrice := i.ItemPrice
@@ -156,44 +290,190 @@ func (i *ShopItem) Update(data *ShopItemUpdate) error {
 		itemPrice = *data.ItemSalePrice
 	}
 
-	if _, err := data.updateStripeProductPrice(i.StripeProductApiID, i.UniqueStripePriceLookupKey, itemPrice); err != nil {
-		log.Printf("error occurred while updating stripe product price: %v", err)
+	stripePrice, err := data.updateStripeProductPrice(ctx, i.StripeProductApiID, i.UniqueStripePriceLookupKey, itemPrice)
+	if err != nil {
+		i.log(ctx, LevelError, "error occurred while updating stripe product price", "stripe_product_id", i.StripeProductApiID, "error", err)
+		return wrapInternal("ShopItem.Update: create stripe price", err)
+	}
+
+	if err := i.items.UpdateColumns(ctx, i, columns); err != nil {
+		if errors.Is(err, ErrVersionConflict) {
+			i.log(ctx, LevelError, "shop item changed while updating, stripe product may be out of sync", "stripe_product_id", i.StripeProductApiID)
+			return i.versionConflict(ctx, i.Version)
+		}
+
+		i.log(ctx, LevelError, "error while updating shop item", "error", err)
+		return wrapInternal("ShopItem.Update: update shop item", err)
+	}
+
+	if i.Version != 0 {
+		i.Version++
+	}
+
+	i.recordPrice(ctx, stripePrice.ID, time.Now())
+	return nil
+}
+
+func (i *ShopItem) Patch(shopItemID int, data *ShopItemPatch, currentTime time.Time) error {
+	return i.PatchContext(context.Background(), shopItemID, data, currentTime)
+}
+
+// PatchContext loads the item and changes only the fields given in data, which are the only ones validated. The
+// quantity may be set to 0 for items which sold out. Only changed columns are written, the Stripe product is updated
+// only when name or description changed and a new Stripe price is created only when the effective price changed.
+// VersionConflictError is returned when data.Version is set and the item has another version, or when the item
+// changed while patching.
+func (i *ShopItem) PatchContext(ctx context.Context, shopItemID int, data *ShopItemPatch, currentTime time.Time) error {
+	if i.items == nil {
+		return ErrShopItemNotInitializedProperly
+	}
+
+	if data == nil {
+		return ErrShopItemUpdateBlank
+	}
+
+	if err := i.FindOneByIDContext(ctx, shopItemID); err != nil {
 		return err
 	}
 
-	updateQuery := `UPDATE shop_items SET item_name = ?, item_picture = ?, item_price = ?, item_sale_price = ?, item_description = ?, 
-		shippable = ?, quantity = ?, updated_at = ? WHERE id = ? AND deleted_at IS NULL`
+	if data.Version != nil && *data.Version != i.Version {
+		return &VersionConflictError{ShopItemID: i.ID, ExpectedVersion: *data.Version, CurrentVersion: i.Version}
+	}
 
-	params := []interface{}{i.ItemName, i.ItemPicture, i.ItemPrice, i.ItemSalePrice, i.ItemDescription, i.Shippable,
-		i.Quantity, i.UpdatedAt, i.ID}
+	patched := *i
+	columns := data.apply(&patched)
+	if err := data.validate(&patched); err != nil {
+		return err
+	}
+
+	if len(columns) == 0 {
+		return nil
+	}
 
-	if err := i.db.Debug().Exec(updateQuery, params...).Error; err != nil {
-		log.Printf("error while updating shop item: %v\n", err)
-		return ErrInternal
+	changed := map[string]bool{}
+	for _, column := range columns {
+		changed[column] = true
+	}
+
+	if changed["item_name"] || changed["item_description"] {
+		if _, err := updateStripeProduct(ctx, patched.StripeProductApiID, patched.ItemName, data.ItemDescription); err != nil {
+			i.log(ctx, LevelError, "error occurred while updating stripe product", "stripe_product_id", patched.StripeProductApiID, "error", err)
+			return wrapInternal("ShopItem.Patch: update stripe product", err)
+		}
+	}
+
+	stripePriceApiID := ""
+	if effectivePrice(&patched) != effectivePrice(i) {
+		stripePrice, err := replaceStripePrice(ctx, patched.StripeProductApiID, patched.UniqueStripePriceLookupKey, effectivePrice(&patched))
+		if err != nil {
+			i.log(ctx, LevelError, "error occurred while updating stripe product price", "stripe_product_id", patched.StripeProductApiID, "error", err)
+			return wrapInternal("ShopItem.Patch: create stripe price", err)
+		}
+
+		stripePriceApiID = stripePrice.ID
+	} else if changed["item_price"] || changed["item_sale_price"] {
+		stripePriceApiID = i.stripePriceApiIDAt(ctx, currentTime)
+	}
+
+	patched.UpdatedAt = currentTime
+	if err := i.items.UpdateColumns(ctx, &patched, columns); err != nil {
+		if errors.Is(err, ErrVersionConflict) {
+			i.log(ctx, LevelError, "shop item changed while patching, stripe product may be out of sync", "stripe_product_id", i.StripeProductApiID)
+			return i.versionConflict(ctx, i.Version)
+		}
+
+		i.log(ctx, LevelError, "error while patching shop item", "columns", columns, "error", err)
+		return wrapInternal("ShopItem.Patch: update shop item", err)
+	}
+
+	patched.Version++
+	*i = patched
+	if changed["item_price"] || changed["item_sale_price"] {
+		i.recordPrice(ctx, stripePriceApiID, currentTime)
 	}
 
 	return nil
 }
 
+// versionConflict returns VersionConflictError after a versioned write found the item changed or deleted
+func (i *ShopItem) versionConflict(ctx context.Context, expectedVersion int) error {
+	conflict := &VersionConflictError{ShopItemID: i.ID, ExpectedVersion: expectedVersion}
+	if current, err := i.items.FindOneByID(ctx, i.ID); err == nil {
+		conflict.CurrentVersion = current.Version
+	}
+
+	return conflict
+}
+
 func (i *ShopItem) Delete(shopItemID int, currentTime time.Time) error {
+	return i.DeleteContext(context.Background(), shopItemID, currentTime)
+}
+
+// DeleteContext soft-deletes the item, deactivates all its Stripe prices and archives its Stripe product. Stripe
+// products are never deleted so past orders keep referencing them and the item can be restored. The item stays
+// deleted when archiving fails, the failure is only logged and ReconcileCatalog archives the product later.
+func (i *ShopItem) DeleteContext(ctx context.Context, shopItemID int, currentTime time.Time) error {
+	if i.items == nil {
+		return ErrShopItemNotInitializedProperly
+	}
+
+	if err := i.FindOneByIDContext(ctx, shopItemID); err != nil {
+		return err
+	}
+
+	if err := i.items.SoftDelete(ctx, shopItemID, currentTime); err != nil {
+		i.log(ctx, LevelError, "error while soft-deleting shop item", "error", err)
+		return wrapInternal("ShopItem.Delete: soft-delete shop item", err)
+	}
+
 	i.UpdatedAt = currentTime
 	i.DeletedAt = &currentTime
 
-	softDeleteQuery := `UPDATE shop_items SET deleted_at = NOW() WHERE id = ?`
+	if err := archiveStripeProductAndPrices(ctx, i.StripeProductApiID); err != nil {
+		i.log(ctx, LevelError, "error while archiving stripe product, leaving it to the reconciler", "stripe_product_id", i.StripeProductApiID, "error", err)
+	}
+
+	return nil
+}
 
-	if err := i.db.Debug().Exec(softDeleteQuery, shopItemID).Error; err != nil {
+func (i *ShopItem) Restore(shopItemID int, currentTime time.Time) error {
+	return i.RestoreContext(context.Background(), shopItemID, currentTime)
+}
+
+// RestoreContext clears the deletion time of a soft-deleted item and reactivates its Stripe product together with
+// the price holding its lookup key. Like in DeleteContext a failure to reactivate is only logged, the item is
+// restored regardless and ReconcileCatalog reactivates the product later.
+func (i *ShopItem) RestoreContext(ctx context.Context, shopItemID int, currentTime time.Time) error {
+	if i.items == nil {
+		return ErrShopItemNotInitializedProperly
+	}
+
+	item, err := i.items.FindOneDeletedByID(ctx, shopItemID)
+	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return err
 		}
 
-		log.Printf("error while soft-deleting shop item: %v\n", err)
-		return ErrInternal
+		i.log(ctx, LevelError, "error while getting deleted shop item", "requested_shop_item_id", shopItemID, "error", err)
+		return wrapInternal("ShopItem.Restore: query shop item", err)
 	}
 
-	// TODO: Delete user-created prices if they exist first and
-	//  then delete the product because otherwise this won't work!
-	if _, err := product.Del(i.StripeProductApiID, nil); err != nil {
-		return err
+	if err := i.items.Restore(ctx, shopItemID, currentTime); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		i.log(ctx, LevelError, "error while restoring shop item", "requested_shop_item_id", shopItemID, "error", err)
+		return wrapInternal("ShopItem.Restore: restore shop item", err)
+	}
+
+	item.items, item.priceHistory, item.logger = i.items, i.priceHistory, i.logger
+	*i = *item
+	i.UpdatedAt = currentTime
+	i.DeletedAt = nil
+
+	if err := reactivateStripeProductAndPrice(ctx, i.StripeProductApiID, i.UniqueStripePriceLookupKey); err != nil {
+		i.log(ctx, LevelError, "error while reactivating stripe product, leaving it to the reconciler", "stripe_product_id", i.StripeProductApiID, "error", err)
 	}
 
 	return nil
@@ -1,6 +1,9 @@
 package mop_shop
 
 import (
+	"context"
+	"database/sql/driver"
+	"errors"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stripe/stripe-go/v72"
@@ -140,7 +143,7 @@ func TestShopItem_FindOneByID(t *testing.T) {
 		},
 	}
 
-	getUserQuery := `SELECT * FROM shop_items WHERE id = ? AND deleted_at IS NULL`
+	getUserQuery := "SELECT * FROM `shop_items` WHERE id = ? AND deleted_at IS NULL LIMIT 1"
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -158,7 +161,7 @@ func TestShopItem_FindOneByID(t *testing.T) {
 				CreatedAt:                  tt.fields.CreatedAt,
 				UpdatedAt:                  tt.fields.UpdatedAt,
 				DeletedAt:                  tt.fields.DeletedAt,
-				db:                         tt.fields.db,
+				items:                      shopItemRepositoryForTest(tt.fields.db),
 			}
 
 			getRows := sqlmock.NewRows([]string{"id"}).AddRow(tt.fields.ID)
@@ -166,20 +169,24 @@ func TestShopItem_FindOneByID(t *testing.T) {
 			mock.ExpectQuery(regexp.QuoteMeta(getUserQuery)).WillReturnRows(getRows).WillReturnError(tt.expectedMock.expectedDBError)
 
 			methodErr := i.FindOneByID(tt.args.shopItemID)
-			assert.Equal(t, tt.wantErr, methodErr, "FindOneByID() error = %v, wantErr %v", methodErr, tt.wantErr)
+			assert.True(t, errors.Is(methodErr, tt.wantErr), "FindOneByID() error = %v, wantErr %v", methodErr, tt.wantErr)
 
 		})
 	}
 }
 
 type ShopItemCreateTest struct {
-	ItemName        string  `json:"item_name"`
-	ItemPicture     *string `json:"item_picture"`
-	ItemPrice       int64   `json:"item_price"`
-	ItemSalePrice   *int64  `json:"item_sale_price"`
-	ItemDescription *string `json:"item_description"`
-	Shippable       bool    `json:"shippable"`
-	Quantity        int     `json:"quantity"`
+	SKU             *string  `json:"sku"`
+	GTIN            *string  `json:"gtin"`
+	ItemName        string   `json:"item_name"`
+	ItemPicture     *string  `json:"item_picture"`
+	ItemPrice       int64    `json:"item_price"`
+	ItemSalePrice   *int64   `json:"item_sale_price"`
+	ItemDescription *string  `json:"item_description"`
+	Shippable       bool     `json:"shippable"`
+	WeightGrams     *int     `json:"weight_grams"`
+	TaxClass        TaxClass `json:"tax_class"`
+	Quantity        int      `json:"quantity"`
 	uuid            string
 }
 
@@ -207,6 +214,14 @@ func (s ShopItemCreateTest) GetItemDescription() *string {
 	return s.ItemDescription
 }
 
+func (s ShopItemCreateTest) GetWeightGrams() *int {
+	return s.WeightGrams
+}
+
+func (s ShopItemCreateTest) GetTaxClass() TaxClass {
+	return s.TaxClass
+}
+
 func (s ShopItemCreateTest) GetShippable() bool {
 	return s.Shippable
 }
@@ -215,11 +230,27 @@ func (s ShopItemCreateTest) GetQuantity() int {
 	return s.Quantity
 }
 
-func (s ShopItemCreateTest) createStripeProduct(name string, description *string) (*stripe.Product, error) {
-	return &stripe.Product{}, nil
+func (s ShopItemCreateTest) GetSKU() *string {
+	return s.SKU
 }
 
-func (s ShopItemCreateTest) createStripeProductPrice(product *stripe.Product, unitAmount int64, lookupKey string) (*stripe.Price, error) {
+func (s ShopItemCreateTest) GetGTIN() *string {
+	return s.GTIN
+}
+
+func (s ShopItemCreateTest) createStripeProduct(ctx context.Context, name string, description *string, lookupKey string) (*stripe.Product, error) {
+	return &stripe.Product{ID: "prod_test"}, nil
+}
+
+func (s ShopItemCreateTest) archiveStripeProduct(ctx context.Context, stripeProductApiID string) error {
+	return nil
+}
+
+func (s ShopItemCreateTest) archiveStripePrice(ctx context.Context, stripePriceApiID string) error {
+	return nil
+}
+
+func (s ShopItemCreateTest) createStripeProductPrice(ctx context.Context, product *stripe.Product, unitAmount int64, lookupKey string) (*stripe.Price, error) {
 	return &stripe.Price{}, nil
 }
 
@@ -289,8 +320,17 @@ func TestShopItem_Create(t *testing.T) {
 	type expectedMock struct {
 		expectedDBError error
 		expectQuery     bool
+		insertArgs      []driver.Value
 	}
 
+	currentTime := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	sku, gtin, weight := "MOP-1", "4006381333931", 800
+
+	// Nil pointers of columns defaulting to NULL are left out of the insert, they are only added in random order
+	// when set so the success case leaves them nil
+	insertQuery := "INSERT INTO `shop_items` (`sku`,`gtin`,`item_name`,`item_price`,`shippable`,`weight_grams`,`tax_class`,`quantity`," +
+		"`stripe_product_api_id`,`unique_stripe_price_lookup_key`,`version`,`created_at`,`updated_at`,`deleted_at`) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
+
 	tests := []struct {
 		name         string
 		fields       fields
@@ -341,13 +381,34 @@ func TestShopItem_Create(t *testing.T) {
 			expectedMock: expectedMock{
 				expectedDBError: ErrInternal,
 				expectQuery:     true,
+				insertArgs:      []driver.Value{nil, nil, "Test item", 0, false, nil, "", 2, "prod_test", "", 1, currentTime, currentTime, nil},
 			},
 			wantErr: ErrInternal,
 		},
+		{
+			name: "Success",
+			fields: fields{
+				db: database,
+			},
+			args: args{
+				data: &ShopItemCreateTest{
+					SKU:         &sku,
+					GTIN:        &gtin,
+					ItemName:    "Mop",
+					ItemPrice:   2000,
+					Shippable:   true,
+					WeightGrams: &weight,
+					TaxClass:    TaxClassReduced,
+					Quantity:    3,
+				},
+			},
+			expectedMock: expectedMock{
+				expectQuery: true,
+				insertArgs:  []driver.Value{sku, gtin, "Mop", 2000, true, weight, "reduced", 3, "prod_test", "", 1, currentTime, currentTime, nil},
+			},
+		},
 	}
 
-	insertQuery := `INSERT INTO shop_items (item_name, item_picture, item_price, item_sale_price, item_description, shippable, quantity, stripe_product_api_id, unique_stripe_price_lookup_key, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			i := &ShopItem{
@@ -364,18 +425,36 @@ func TestShopItem_Create(t *testing.T) {
 				CreatedAt:                  tt.fields.CreatedAt,
 				UpdatedAt:                  tt.fields.UpdatedAt,
 				DeletedAt:                  tt.fields.DeletedAt,
-				db:                         tt.fields.db,
+				items:                      shopItemRepositoryForTest(tt.fields.db),
 			}
 
-			currentTime := time.Now()
 			if tt.expectedMock.expectQuery {
-				mock.ExpectExec(insertQuery).WithArgs(tt.args.data.GetItemName(), i.ItemPicture, i.ItemPrice, i.ItemSalePrice,
-					i.ItemDescription, i.Shippable, tt.args.data.GetQuantity(), i.StripeProductApiID, tt.args.data.GetUUID(),
-					currentTime, currentTime).WillReturnError(tt.expectedMock.expectedDBError)
+				mock.ExpectBegin()
+				exec := mock.ExpectExec(insertQuery).WithArgs(tt.expectedMock.insertArgs...)
+				if tt.expectedMock.expectedDBError != nil {
+					exec.WillReturnError(tt.expectedMock.expectedDBError)
+					mock.ExpectRollback()
+				} else {
+					exec.WillReturnResult(sqlmock.NewResult(7, 1))
+					mock.ExpectCommit()
+				}
 			}
 
 			validationErr := i.Create(tt.args.data, currentTime)
-			assert.Equal(t, tt.wantErr, validationErr, "Create() error = %v, wantErr %v", validationErr, tt.wantErr)
+			assert.True(t, errors.Is(validationErr, tt.wantErr), "Create() error = %v, wantErr %v", validationErr, tt.wantErr)
+			assert.NoError(t, mock.ExpectationsWereMet())
+			if tt.wantErr == nil {
+				assert.Equal(t, 7, i.ID)
+				assert.Equal(t, "prod_test", i.StripeProductApiID)
+			}
 		})
 	}
 }
+
+func shopItemRepositoryForTest(db *gorm.DB) ShopItemRepository {
+	if db == nil {
+		return nil
+	}
+
+	return NewGormShopItemRepository(db)
+}
@@ -0,0 +1,124 @@
+package mop_shop
+
+import (
+	"context"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/price"
+	"github.com/stripe/stripe-go/v72/product"
+)
+
+func archiveStripePrice(ctx context.Context, stripePriceApiID string) error {
+	params := &stripe.PriceParams{Active: stripe.Bool(false)}
+	params.Context = ctx
+
+	_, err := price.Update(stripePriceApiID, params)
+	return err
+}
+
+func archiveStripeProduct(ctx context.Context, stripeProductApiID string) error {
+	params := &stripe.ProductParams{Active: stripe.Bool(false)}
+	params.Context = ctx
+
+	_, err := product.Update(stripeProductApiID, params)
+	return err
+}
+
+func reactivateStripeProduct(ctx context.Context, stripeProductApiID string) error {
+	params := &stripe.ProductParams{Active: stripe.Bool(true)}
+	params.Context = ctx
+
+	_, err := product.Update(stripeProductApiID, params)
+	return err
+}
+
+// archiveStripeProductAndPrices deactivates every active price of the product and then archives the product
+func archiveStripeProductAndPrices(ctx context.Context, stripeProductApiID string) error {
+	params := &stripe.PriceListParams{Product: stripe.String(stripeProductApiID), Active: stripe.Bool(true)}
+	params.Context = ctx
+
+	prices := price.List(params)
+	for prices.Next() {
+		if err := archiveStripePrice(ctx, prices.Price().ID); err != nil {
+			return err
+		}
+	}
+
+	if err := prices.Err(); err != nil {
+		return err
+	}
+
+	return archiveStripeProduct(ctx, stripeProductApiID)
+}
+
+// reactivateStripeProductAndPrice activates the product and the price currently holding the lookup key, older prices
+// stay inactive
+func reactivateStripeProductAndPrice(ctx context.Context, stripeProductApiID, lookupKey string) error {
+	if err := reactivateStripeProduct(ctx, stripeProductApiID); err != nil {
+		return err
+	}
+
+	params := &stripe.PriceListParams{Product: stripe.String(stripeProductApiID), LookupKeys: stripe.StringSlice([]string{lookupKey})}
+	params.Context = ctx
+
+	prices := price.List(params)
+	for prices.Next() {
+		if prices.Price().Active {
+			continue
+		}
+
+		priceParams := &stripe.PriceParams{Active: stripe.Bool(true)}
+		priceParams.Context = ctx
+
+		if _, err := price.Update(prices.Price().ID, priceParams); err != nil {
+			return err
+		}
+	}
+
+	return prices.Err()
+}
+
+// updateStripeProduct sets the name of the product and its description when description is set. Stripe unsets
+// parameters given as empty strings, so a nil or empty description is sent that way explicitly to clear it.
+func updateStripeProduct(ctx context.Context, stripeProductApiID, name string, description OptionalString) (*stripe.Product, error) {
+	params := &stripe.ProductParams{Name: stripe.String(name)}
+	if description.Set {
+		if description.Value != nil && len(*description.Value) > 0 {
+			params.Description = description.Value
+		} else {
+			params.AddExtra("description", "")
+		}
+	}
+	params.Context = ctx
+
+	return product.Update(stripeProductApiID, params)
+}
+
+// updateStripeProductImage makes imageURL the only image of the product, an empty imageURL removes all images
+func updateStripeProductImage(ctx context.Context, stripeProductApiID, imageURL string) error {
+	params := &stripe.ProductParams{}
+	if len(imageURL) > 0 {
+		params.Images = stripe.StringSlice([]string{imageURL})
+	} else {
+		params.AddExtra("images", "")
+	}
+	params.Context = ctx
+
+	_, err := product.Update(stripeProductApiID, params)
+	return err
+}
+
+// replaceStripePrice creates a new price and moves the lookup key to it
+func replaceStripePrice(ctx context.Context, productApiID, priceLookupKey string, unitAmount int64) (*stripe.Price, error) {
+	params := &stripe.PriceParams{
+		Product:           stripe.String(productApiID),
+		Currency:          stripe.String(string(stripe.CurrencyEUR)),
+		UnitAmount:        stripe.Int64(unitAmount),
+		LookupKey:         stripe.String(priceLookupKey),
+		TransferLookupKey: stripe.Bool(true),
+	}
+	params.Context = ctx
+
+	// This will create new price instead of updating unit amount, if we want to update unit amount then it has to be
+	// done using session authentication (Stripe API)
+	return price.New(params)
+}
@@ -0,0 +1,1001 @@
+// Package stripetest provides a fake Stripe API so Stripe dependent code can be tested without network access.
+// Only the subset of the API used by mop_shop is implemented: products, prices, checkout sessions with their line
+// items and inline shipping options, and refunds.
+package stripetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stripe/stripe-go/v72"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Product struct {
+	ID          string
+	Name        string
+	Description string
+	Active      bool
+	Images      []string
+	Metadata    map[string]string
+	// Created is the Unix time the product was created at, products created through the API get the current time
+	Created int64
+}
+
+type Price struct {
+	ID         string
+	Product    string
+	Currency   string
+	UnitAmount int64
+	LookupKey  string
+	Active     bool
+	Metadata   map[string]string
+}
+
+type LineItem struct {
+	Price    string
+	Quantity int64
+}
+
+// ShippingOption is an inline shipping rate of a checkout session, the first one is selected
+type ShippingOption struct {
+	DisplayName string
+	Amount      int64
+	Currency    string
+}
+
+type Address struct {
+	Name       string
+	Phone      string
+	Line1      string
+	Line2      string
+	City       string
+	PostalCode string
+	State      string
+	Country    string
+}
+
+type CheckoutSession struct {
+	ID                string
+	ClientReferenceID string
+	PaymentIntent     string
+	Mode              string
+	SuccessURL        string
+	CancelURL         string
+	AmountTotal       int64
+	AmountShipping    int64
+	LineItems         []LineItem
+	ShippingOptions   []ShippingOption
+	// AllowedCountries are the countries of shipping_address_collection, nil when no address is collected
+	AllowedCountries []string
+	// Shipping is the address the customer entered, see SetCheckoutSessionShipping
+	Shipping *Address
+	Metadata map[string]string
+}
+
+type Refund struct {
+	ID            string
+	PaymentIntent string
+	Amount        int64
+	Reason        string
+	Metadata      map[string]string
+}
+
+// Server is a fake Stripe API running on httptest. Its state can be inspected and seeded through its methods.
+type Server struct {
+	URL string
+
+	server   *httptest.Server
+	previous stripe.Backend
+
+	mu       sync.Mutex
+	lastID   int
+	products map[string]*Product
+	prices   map[string]*Price
+	sessions map[string]*CheckoutSession
+	refunds  map[string]*Refund
+	failures []failure
+	requests []string
+}
+
+type failure struct {
+	method     string
+	pathPrefix string
+	status     int
+	code       string
+}
+
+// NewServer starts the fake and points the Stripe API backend at it until Close is called
+func NewServer() *Server {
+	s := &Server{
+		products: map[string]*Product{},
+		prices:   map[string]*Price{},
+		sessions: map[string]*CheckoutSession{},
+		refunds:  map[string]*Refund{},
+	}
+
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+	s.previous = stripe.GetBackend(stripe.APIBackend)
+
+	stripe.SetBackend(stripe.APIBackend, stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+		URL:               stripe.String(s.server.URL),
+		MaxNetworkRetries: stripe.Int64(0),
+		LeveledLogger:     &stripe.LeveledLogger{Level: stripe.LevelNull},
+	}))
+
+	return s
+}
+
+// Start works like NewServer and closes the server when the test finishes
+func Start(t testing.TB) *Server {
+	s := NewServer()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Close stops the server and restores the previous Stripe API backend
+func (s *Server) Close() {
+	stripe.SetBackend(stripe.APIBackend, s.previous)
+	s.server.Close()
+}
+
+// FailNext makes the next request whose method and path match fail with the given HTTP status and Stripe error
+// code, path being matched by prefix, e.g. "/v1/prices"
+func (s *Server) FailNext(method, pathPrefix string, status int, code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, failure{method: method, pathPrefix: pathPrefix, status: status, code: code})
+}
+
+// Requests returns "METHOD /path" of every request received so far
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) Product(id string) (Product, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.products[id]
+	if !ok {
+		return Product{}, false
+	}
+
+	return *p, true
+}
+
+// Products returns all products which weren't deleted, ordered by their IDs
+func (s *Server) Products() []Product {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var products []Product
+	for _, p := range s.products {
+		products = append(products, *p)
+	}
+
+	sort.Slice(products, func(i, j int) bool { return idNumber(products[i].ID) < idNumber(products[j].ID) })
+	return products
+}
+
+func (s *Server) Price(id string) (Price, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.prices[id]
+	if !ok {
+		return Price{}, false
+	}
+
+	return *p, true
+}
+
+func (s *Server) PriceByLookupKey(lookupKey string) (Price, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.prices {
+		if p.LookupKey == lookupKey {
+			return *p, true
+		}
+	}
+
+	return Price{}, false
+}
+
+// Prices returns all prices, ordered by their IDs
+func (s *Server) Prices() []Price {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sortedPrices(func(p *Price) bool { return true })
+}
+
+func (s *Server) Refunds() []Refund {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var refunds []Refund
+	for _, r := range s.refunds {
+		refunds = append(refunds, *r)
+	}
+
+	sort.Slice(refunds, func(i, j int) bool { return idNumber(refunds[i].ID) < idNumber(refunds[j].ID) })
+	return refunds
+}
+
+// AddProduct seeds a product and returns its ID
+func (s *Server) AddProduct(p Product) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(p.ID) == 0 {
+		p.ID = s.newID("prod")
+	}
+
+	s.products[p.ID] = &p
+	return p.ID
+}
+
+// AddPrice seeds a price and returns its ID
+func (s *Server) AddPrice(p Price) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(p.ID) == 0 {
+		p.ID = s.newID("price")
+	}
+
+	s.prices[p.ID] = &p
+	return p.ID
+}
+
+// AddCheckoutSession seeds a completed checkout session the way a customer paying through Stripe would create it
+// and returns its ID
+func (s *Server) AddCheckoutSession(clientReferenceID string, lineItems ...LineItem) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, err := s.newCheckoutSession(clientReferenceID, lineItems)
+	if err != nil {
+		return "", err
+	}
+
+	return session.ID, nil
+}
+
+// SetCheckoutSessionShipping sets the shipping address of a checkout session the way a customer entering it in
+// Checkout would, failing when the session doesn't collect addresses or doesn't allow the country
+func (s *Server) SetCheckoutSessionShipping(id string, address Address) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cs, ok := s.sessions[id]
+	if !ok {
+		return fmt.Errorf("no such checkout session: %s", id)
+	}
+
+	allowed := false
+	for _, country := range cs.AllowedCountries {
+		allowed = allowed || country == address.Country
+	}
+
+	if !allowed {
+		return fmt.Errorf("checkout session %s doesn't ship to %q", id, address.Country)
+	}
+
+	cs.Shipping = &address
+	return nil
+}
+
+func (s *Server) CheckoutSession(id string) (CheckoutSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cs, ok := s.sessions[id]
+	if !ok {
+		return CheckoutSession{}, false
+	}
+
+	return *cs, true
+}
+
+func (s *Server) newID(prefix string) string {
+	s.lastID++
+	return fmt.Sprintf("%s_test_%d", prefix, s.lastID)
+}
+
+func idNumber(id string) int {
+	n, _ := strconv.Atoi(id[strings.LastIndex(id, "_")+1:])
+	return n
+}
+
+func (s *Server) newCheckoutSession(clientReferenceID string, lineItems []LineItem) (*CheckoutSession, error) {
+	session := &CheckoutSession{
+		ID:                s.newID("cs"),
+		ClientReferenceID: clientReferenceID,
+		PaymentIntent:     s.newID("pi"),
+		Mode:              string(stripe.CheckoutSessionModePayment),
+		LineItems:         lineItems,
+	}
+
+	for _, li := range lineItems {
+		price, ok := s.prices[li.Price]
+		if !ok {
+			return nil, fmt.Errorf("no such price: %s", li.Price)
+		}
+
+		session.AmountTotal += price.UnitAmount * li.Quantity
+	}
+
+	s.sessions[session.ID] = session
+	return session, nil
+}
+
+type apiError struct {
+	status  int
+	code    string
+	message string
+	param   string
+}
+
+func notFound(resource, id string) *apiError {
+	return &apiError{status: http.StatusNotFound, code: string(stripe.ErrorCodeResourceMissing), message: fmt.Sprintf("No such %s: '%s'", resource, id), param: "id"}
+}
+
+func invalidRequest(code stripe.ErrorCode, param, message string) *apiError {
+	return &apiError{status: http.StatusBadRequest, code: string(code), message: message, param: param}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		writeError(w, invalidRequest(stripe.ErrorCodeParameterUnknown, "", err.Error()))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, req.Method+" "+req.URL.Path)
+
+	for i, f := range s.failures {
+		if f.method == req.Method && strings.HasPrefix(req.URL.Path, f.pathPrefix) {
+			s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			writeError(w, &apiError{status: f.status, code: f.code, message: "injected failure"})
+			return
+		}
+	}
+
+	body, apiErr := s.route(req.Method, strings.Split(strings.Trim(req.URL.Path, "/"), "/"), req.Form)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	//goland:noinspection GoUnhandledErrorResult
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, apiErr *apiError) {
+	errorType := "invalid_request_error"
+	if apiErr.status >= http.StatusInternalServerError {
+		errorType = "api_error"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.status)
+	//goland:noinspection GoUnhandledErrorResult
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"type":    errorType,
+			"code":    apiErr.code,
+			"message": apiErr.message,
+			"param":   apiErr.param,
+		},
+	})
+}
+
+func (s *Server) route(method string, path []string, form url.Values) (interface{}, *apiError) {
+	if len(path) < 2 || path[0] != "v1" {
+		return nil, &apiError{status: http.StatusNotFound, message: "Unrecognized request URL"}
+	}
+
+	resource, rest := path[1], path[2:]
+	if resource == "checkout" && len(rest) > 0 && rest[0] == "sessions" {
+		resource, rest = "checkout/sessions", rest[1:]
+	}
+
+	switch {
+	case resource == "products" && len(rest) == 0 && method == http.MethodPost:
+		return s.createProduct(form)
+	case resource == "products" && len(rest) == 0 && method == http.MethodGet:
+		return s.listProducts(form), nil
+	case resource == "products" && len(rest) == 1 && method == http.MethodGet:
+		return s.retrieveProduct(rest[0])
+	case resource == "products" && len(rest) == 1 && method == http.MethodPost:
+		return s.updateProduct(rest[0], form)
+	case resource == "products" && len(rest) == 1 && method == http.MethodDelete:
+		return s.deleteProduct(rest[0])
+	case resource == "prices" && len(rest) == 0 && method == http.MethodPost:
+		return s.createPrice(form)
+	case resource == "prices" && len(rest) == 0 && method == http.MethodGet:
+		return s.listPrices(form), nil
+	case resource == "prices" && len(rest) == 1 && method == http.MethodGet:
+		return s.retrievePrice(rest[0])
+	case resource == "prices" && len(rest) == 1 && method == http.MethodPost:
+		return s.updatePrice(rest[0], form)
+	case resource == "checkout/sessions" && len(rest) == 0 && method == http.MethodPost:
+		return s.createCheckoutSession(form)
+	case resource == "checkout/sessions" && len(rest) == 1 && method == http.MethodGet:
+		return s.retrieveCheckoutSession(rest[0])
+	case resource == "checkout/sessions" && len(rest) == 2 && rest[1] == "line_items" && method == http.MethodGet:
+		return s.listLineItems(rest[0])
+	case resource == "refunds" && len(rest) == 0 && method == http.MethodPost:
+		return s.createRefund(form)
+	case resource == "refunds" && len(rest) == 1 && method == http.MethodGet:
+		return s.retrieveRefund(rest[0])
+	}
+
+	return nil, &apiError{status: http.StatusNotFound, message: fmt.Sprintf("Unrecognized request URL (%s: /%s)", method, strings.Join(path, "/"))}
+}
+
+// indexed returns values of form fields named like name[0], name[1] in order
+func indexed(form url.Values, name string) []string {
+	var values []string
+	for i := 0; ; i++ {
+		value, ok := form[fmt.Sprintf("%s[%d]", name, i)]
+		if !ok {
+			return values
+		}
+
+		values = append(values, value[0])
+	}
+}
+
+// metadata reads metadata[key] fields into m, creating it when needed, and removes keys set to an empty string
+func metadata(form url.Values, m map[string]string) map[string]string {
+	for key, values := range form {
+		if !strings.HasPrefix(key, "metadata[") || !strings.HasSuffix(key, "]") {
+			continue
+		}
+
+		if m == nil {
+			m = map[string]string{}
+		}
+
+		name := key[len("metadata[") : len(key)-1]
+		if len(values[0]) == 0 {
+			delete(m, name)
+			continue
+		}
+
+		m[name] = values[0]
+	}
+
+	return m
+}
+
+func listOf(url string, data []interface{}) map[string]interface{} {
+	if data == nil {
+		data = []interface{}{}
+	}
+
+	return map[string]interface{}{"object": "list", "url": url, "has_more": false, "data": data}
+}
+
+func productJSON(p *Product) map[string]interface{} {
+	images := p.Images
+	if images == nil {
+		images = []string{}
+	}
+
+	return map[string]interface{}{
+		"id":          p.ID,
+		"object":      "product",
+		"name":        p.Name,
+		"description": p.Description,
+		"active":      p.Active,
+		"images":      images,
+		"metadata":    p.Metadata,
+		"created":     p.Created,
+	}
+}
+
+func (s *Server) createProduct(form url.Values) (interface{}, *apiError) {
+	if len(form.Get("name")) == 0 {
+		return nil, invalidRequest(stripe.ErrorCodeParameterMissing, "name", "Missing required param: name.")
+	}
+
+	p := &Product{
+		ID:          form.Get("id"),
+		Name:        form.Get("name"),
+		Description: form.Get("description"),
+		Active:      form.Get("active") != "false",
+		Images:      indexed(form, "images"),
+		Metadata:    metadata(form, nil),
+		Created:     time.Now().Unix(),
+	}
+
+	if len(p.ID) == 0 {
+		p.ID = s.newID("prod")
+	} else if _, exists := s.products[p.ID]; exists {
+		return nil, &apiError{status: http.StatusBadRequest, code: string(stripe.ErrorCodeResourceAlreadyExists), message: "Product already exists.", param: "id"}
+	}
+
+	s.products[p.ID] = p
+	return productJSON(p), nil
+}
+
+func (s *Server) listProducts(form url.Values) interface{} {
+	var ids []string
+	for id, p := range s.products {
+		if active := form.Get("active"); len(active) == 0 || strconv.FormatBool(p.Active) == active {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return idNumber(ids[i]) < idNumber(ids[j]) })
+
+	var data []interface{}
+	for _, id := range ids {
+		data = append(data, productJSON(s.products[id]))
+	}
+
+	return listOf("/v1/products", data)
+}
+
+func (s *Server) retrieveProduct(id string) (interface{}, *apiError) {
+	p, ok := s.products[id]
+	if !ok {
+		return nil, notFound("product", id)
+	}
+
+	return productJSON(p), nil
+}
+
+func (s *Server) updateProduct(id string, form url.Values) (interface{}, *apiError) {
+	p, ok := s.products[id]
+	if !ok {
+		return nil, notFound("product", id)
+	}
+
+	if _, ok := form["name"]; ok {
+		p.Name = form.Get("name")
+	}
+
+	if _, ok := form["description"]; ok {
+		p.Description = form.Get("description")
+	}
+
+	if _, ok := form["active"]; ok {
+		p.Active = form.Get("active") == "true"
+	}
+
+	if images := indexed(form, "images"); images != nil {
+		p.Images = images
+	} else if _, ok := form["images"]; ok {
+		p.Images = nil
+	}
+
+	p.Metadata = metadata(form, p.Metadata)
+	return productJSON(p), nil
+}
+
+// deleteProduct fails for products having prices, like Stripe does
+func (s *Server) deleteProduct(id string) (interface{}, *apiError) {
+	if _, ok := s.products[id]; !ok {
+		return nil, notFound("product", id)
+	}
+
+	for _, price := range s.prices {
+		if price.Product == id {
+			return nil, &apiError{status: http.StatusBadRequest, message: "This product cannot be deleted because it has one or more user-created prices."}
+		}
+	}
+
+	delete(s.products, id)
+	return map[string]interface{}{"id": id, "object": "product", "deleted": true}, nil
+}
+
+func priceJSON(p *Price) map[string]interface{} {
+	var lookupKey interface{}
+	if len(p.LookupKey) > 0 {
+		lookupKey = p.LookupKey
+	}
+
+	return map[string]interface{}{
+		"id":          p.ID,
+		"object":      "price",
+		"product":     p.Product,
+		"currency":    p.Currency,
+		"unit_amount": p.UnitAmount,
+		"lookup_key":  lookupKey,
+		"active":      p.Active,
+		"type":        "one_time",
+		"metadata":    p.Metadata,
+	}
+}
+
+// setLookupKey assigns lookupKey to p, moving it from another price only when transfer is requested
+func (s *Server) setLookupKey(p *Price, lookupKey string, transfer bool) *apiError {
+	for _, other := range s.prices {
+		if other.ID == p.ID || len(lookupKey) == 0 || other.LookupKey != lookupKey {
+			continue
+		}
+
+		if !transfer {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    string(stripe.ErrorCodeResourceAlreadyExists),
+				message: fmt.Sprintf("A price (%s) already uses that lookup key.", other.ID),
+				param:   "lookup_key",
+			}
+		}
+
+		other.LookupKey = ""
+	}
+
+	p.LookupKey = lookupKey
+	return nil
+}
+
+func (s *Server) createPrice(form url.Values) (interface{}, *apiError) {
+	if _, ok := s.products[form.Get("product")]; !ok {
+		return nil, notFound("product", form.Get("product"))
+	}
+
+	if len(form.Get("currency")) == 0 {
+		return nil, invalidRequest(stripe.ErrorCodeParameterMissing, "currency", "Missing required param: currency.")
+	}
+
+	unitAmount, err := strconv.ParseInt(form.Get("unit_amount"), 10, 64)
+	if err != nil || unitAmount < 0 {
+		return nil, invalidRequest(stripe.ErrorCodeParameterInvalidInteger, "unit_amount", "Invalid non-negative integer")
+	}
+
+	p := &Price{
+		Product:    form.Get("product"),
+		Currency:   form.Get("currency"),
+		UnitAmount: unitAmount,
+		Active:     form.Get("active") != "false",
+		Metadata:   metadata(form, nil),
+	}
+
+	if apiErr := s.setLookupKey(p, form.Get("lookup_key"), form.Get("transfer_lookup_key") == "true"); apiErr != nil {
+		return nil, apiErr
+	}
+
+	p.ID = s.newID("price")
+	s.prices[p.ID] = p
+	return priceJSON(p), nil
+}
+
+func (s *Server) sortedPrices(filter func(p *Price) bool) []Price {
+	var prices []Price
+	for _, p := range s.prices {
+		if filter(p) {
+			prices = append(prices, *p)
+		}
+	}
+
+	sort.Slice(prices, func(i, j int) bool { return idNumber(prices[i].ID) < idNumber(prices[j].ID) })
+	return prices
+}
+
+func (s *Server) listPrices(form url.Values) interface{} {
+	lookupKeys := map[string]bool{}
+	for _, key := range indexed(form, "lookup_keys") {
+		lookupKeys[key] = true
+	}
+
+	prices := s.sortedPrices(func(p *Price) bool {
+		if product := form.Get("product"); len(product) > 0 && p.Product != product {
+			return false
+		}
+
+		if active := form.Get("active"); len(active) > 0 && strconv.FormatBool(p.Active) != active {
+			return false
+		}
+
+		return len(lookupKeys) == 0 || lookupKeys[p.LookupKey]
+	})
+
+	var data []interface{}
+	for i := range prices {
+		data = append(data, priceJSON(&prices[i]))
+	}
+
+	return listOf("/v1/prices", data)
+}
+
+func (s *Server) retrievePrice(id string) (interface{}, *apiError) {
+	p, ok := s.prices[id]
+	if !ok {
+		return nil, notFound("price", id)
+	}
+
+	return priceJSON(p), nil
+}
+
+func (s *Server) updatePrice(id string, form url.Values) (interface{}, *apiError) {
+	p, ok := s.prices[id]
+	if !ok {
+		return nil, notFound("price", id)
+	}
+
+	if _, ok := form["lookup_key"]; ok {
+		if apiErr := s.setLookupKey(p, form.Get("lookup_key"), form.Get("transfer_lookup_key") == "true"); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+
+	if _, ok := form["active"]; ok {
+		p.Active = form.Get("active") == "true"
+	}
+
+	p.Metadata = metadata(form, p.Metadata)
+	return priceJSON(p), nil
+}
+
+func checkoutSessionJSON(cs *CheckoutSession) map[string]interface{} {
+	var shipping, addressCollection interface{}
+	if cs.Shipping != nil {
+		shipping = map[string]interface{}{
+			"name":  cs.Shipping.Name,
+			"phone": cs.Shipping.Phone,
+			"address": map[string]interface{}{
+				"line1":       cs.Shipping.Line1,
+				"line2":       cs.Shipping.Line2,
+				"city":        cs.Shipping.City,
+				"postal_code": cs.Shipping.PostalCode,
+				"state":       cs.Shipping.State,
+				"country":     cs.Shipping.Country,
+			},
+		}
+	}
+
+	if cs.AllowedCountries != nil {
+		addressCollection = map[string]interface{}{"allowed_countries": cs.AllowedCountries}
+	}
+
+	return map[string]interface{}{
+		"id":                          cs.ID,
+		"object":                      "checkout.session",
+		"client_reference_id":         cs.ClientReferenceID,
+		"payment_intent":              cs.PaymentIntent,
+		"mode":                        cs.Mode,
+		"success_url":                 cs.SuccessURL,
+		"cancel_url":                  cs.CancelURL,
+		"amount_total":                cs.AmountTotal,
+		"total_details":               map[string]interface{}{"amount_discount": 0, "amount_shipping": cs.AmountShipping, "amount_tax": 0},
+		"shipping":                    shipping,
+		"shipping_address_collection": addressCollection,
+		"payment_status":              "paid",
+		"status":                      "complete",
+		"url":                         "https://checkout.stripe.test/pay/" + cs.ID,
+		"metadata":                    cs.Metadata,
+	}
+}
+
+func (s *Server) createCheckoutSession(form url.Values) (interface{}, *apiError) {
+	var lineItems []LineItem
+	for i := 0; ; i++ {
+		priceID := form.Get(fmt.Sprintf("line_items[%d][price]", i))
+		if len(priceID) == 0 {
+			var apiErr *apiError
+			if priceID, apiErr = s.inlinePrice(form, fmt.Sprintf("line_items[%d][price_data]", i)); apiErr != nil {
+				return nil, apiErr
+			}
+		}
+
+		if len(priceID) == 0 {
+			break
+		}
+
+		if _, ok := s.prices[priceID]; !ok {
+			return nil, notFound("price", priceID)
+		}
+
+		quantity, err := strconv.ParseInt(form.Get(fmt.Sprintf("line_items[%d][quantity]", i)), 10, 64)
+		if err != nil || quantity <= 0 {
+			return nil, invalidRequest(stripe.ErrorCodeParameterInvalidInteger, fmt.Sprintf("line_items[%d][quantity]", i), "Invalid positive integer")
+		}
+
+		lineItems = append(lineItems, LineItem{Price: priceID, Quantity: quantity})
+	}
+
+	if len(lineItems) == 0 {
+		return nil, invalidRequest(stripe.ErrorCodeParameterMissing, "line_items", "Missing required param: line_items.")
+	}
+
+	var shippingOptions []ShippingOption
+	for i := 0; ; i++ {
+		prefix := fmt.Sprintf("shipping_options[%d][shipping_rate_data]", i)
+		name, ok := form[prefix+"[display_name]"]
+		if !ok {
+			break
+		}
+
+		amount, err := strconv.ParseInt(form.Get(prefix+"[fixed_amount][amount]"), 10, 64)
+		if err != nil || amount < 0 {
+			return nil, invalidRequest(stripe.ErrorCodeParameterInvalidInteger, prefix+"[fixed_amount][amount]", "Invalid non-negative integer")
+		}
+
+		shippingOptions = append(shippingOptions, ShippingOption{DisplayName: name[0], Amount: amount, Currency: form.Get(prefix + "[fixed_amount][currency]")})
+	}
+
+	session, err := s.newCheckoutSession(form.Get("client_reference_id"), lineItems)
+	if err != nil {
+		return nil, invalidRequest(stripe.ErrorCodeResourceMissing, "line_items", err.Error())
+	}
+
+	if mode := form.Get("mode"); len(mode) > 0 {
+		session.Mode = mode
+	}
+
+	session.AllowedCountries = indexed(form, "shipping_address_collection[allowed_countries]")
+	session.ShippingOptions = shippingOptions
+
+	if len(shippingOptions) > 0 {
+		session.AmountShipping = shippingOptions[0].Amount
+		session.AmountTotal += session.AmountShipping
+	}
+
+	session.SuccessURL = form.Get("success_url")
+	session.CancelURL = form.Get("cancel_url")
+	session.Metadata = metadata(form, nil)
+	return checkoutSessionJSON(session), nil
+}
+
+// inlinePrice creates the inactive price of line item price data the way Stripe does, together with a product unless
+// the price data names an existing one. An empty ID is returned when there is no price data under prefix.
+func (s *Server) inlinePrice(form url.Values, prefix string) (string, *apiError) {
+	rawAmount, ok := form[prefix+"[unit_amount]"]
+	if !ok {
+		return "", nil
+	}
+
+	amount, err := strconv.ParseInt(rawAmount[0], 10, 64)
+	if err != nil || amount < 0 {
+		return "", invalidRequest(stripe.ErrorCodeParameterInvalidInteger, prefix+"[unit_amount]", "Invalid non-negative integer")
+	}
+
+	productID := form.Get(prefix + "[product]")
+	if len(productID) == 0 {
+		product := &Product{ID: s.newID("prod"), Name: form.Get(prefix + "[product_data][name]")}
+		s.products[product.ID] = product
+		productID = product.ID
+	} else if _, ok := s.products[productID]; !ok {
+		return "", notFound("product", productID)
+	}
+
+	price := &Price{ID: s.newID("price"), Product: productID, Currency: form.Get(prefix + "[currency]"), UnitAmount: amount}
+	s.prices[price.ID] = price
+	return price.ID, nil
+}
+
+func (s *Server) retrieveCheckoutSession(id string) (interface{}, *apiError) {
+	cs, ok := s.sessions[id]
+	if !ok {
+		return nil, notFound("checkout.session", id)
+	}
+
+	return checkoutSessionJSON(cs), nil
+}
+
+func (s *Server) listLineItems(sessionID string) (interface{}, *apiError) {
+	cs, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, notFound("checkout.session", sessionID)
+	}
+
+	var data []interface{}
+	for i, li := range cs.LineItems {
+		price := s.prices[li.Price]
+
+		description := ""
+		if product, ok := s.products[price.Product]; ok {
+			description = product.Name
+		}
+
+		data = append(data, map[string]interface{}{
+			"id":              fmt.Sprintf("li_%s_%d", cs.ID, i),
+			"object":          "item",
+			"description":     description,
+			"currency":        price.Currency,
+			"quantity":        li.Quantity,
+			"amount_subtotal": price.UnitAmount * li.Quantity,
+			"amount_total":    price.UnitAmount * li.Quantity,
+			"price":           priceJSON(price),
+		})
+	}
+
+	return listOf("/v1/checkout/sessions/"+sessionID+"/line_items", data), nil
+}
+
+func refundJSON(r *Refund) map[string]interface{} {
+	return map[string]interface{}{
+		"id":             r.ID,
+		"object":         "refund",
+		"payment_intent": r.PaymentIntent,
+		"amount":         r.Amount,
+		"reason":         r.Reason,
+		"status":         "succeeded",
+		"metadata":       r.Metadata,
+	}
+}
+
+// createRefund refunds payment intents of checkout sessions, refunding more than what was paid fails
+func (s *Server) createRefund(form url.Values) (interface{}, *apiError) {
+	paymentIntent := form.Get("payment_intent")
+
+	var paid int64
+	found := false
+	for _, cs := range s.sessions {
+		if cs.PaymentIntent == paymentIntent {
+			paid, found = cs.AmountTotal, true
+		}
+	}
+
+	if !found {
+		return nil, notFound("payment_intent", paymentIntent)
+	}
+
+	var refunded int64
+	for _, r := range s.refunds {
+		if r.PaymentIntent == paymentIntent {
+			refunded += r.Amount
+		}
+	}
+
+	amount := paid - refunded
+	if len(form.Get("amount")) > 0 {
+		parsed, err := strconv.ParseInt(form.Get("amount"), 10, 64)
+		if err != nil || parsed <= 0 {
+			return nil, invalidRequest(stripe.ErrorCodeParameterInvalidInteger, "amount", "Invalid positive integer")
+		}
+
+		amount = parsed
+	}
+
+	if amount <= 0 && refunded > 0 {
+		return nil, &apiError{status: http.StatusBadRequest, code: string(stripe.ErrorCodeChargeAlreadyRefunded), message: "Charge has already been refunded."}
+	}
+
+	if refunded+amount > paid {
+		return nil, &apiError{status: http.StatusBadRequest, code: string(stripe.ErrorCodeAmountTooLarge), message: "Refund amount is greater than unrefunded amount on charge.", param: "amount"}
+	}
+
+	r := &Refund{
+		ID:            s.newID("re"),
+		PaymentIntent: paymentIntent,
+		Amount:        amount,
+		Reason:        form.Get("reason"),
+		Metadata:      metadata(form, nil),
+	}
+
+	s.refunds[r.ID] = r
+	return refundJSON(r), nil
+}
+
+func (s *Server) retrieveRefund(id string) (interface{}, *apiError) {
+	r, ok := s.refunds[id]
+	if !ok {
+		return nil, notFound("refund", id)
+	}
+
+	return refundJSON(r), nil
+}
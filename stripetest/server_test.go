@@ -0,0 +1,98 @@
+package stripetest
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/checkout/session"
+	"github.com/stripe/stripe-go/v72/price"
+	"github.com/stripe/stripe-go/v72/product"
+	"github.com/stripe/stripe-go/v72/refund"
+	"net/http"
+	"testing"
+)
+
+func TestServer_prices(t *testing.T) {
+	s := Start(t)
+
+	p, err := product.New(&stripe.ProductParams{Name: stripe.String("Mop")})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	first, err := price.New(&stripe.PriceParams{Product: stripe.String(p.ID), Currency: stripe.String("eur"), UnitAmount: stripe.Int64(1000), LookupKey: stripe.String("mop")})
+	assert.NoError(t, err)
+
+	_, err = price.New(&stripe.PriceParams{Product: stripe.String(p.ID), Currency: stripe.String("eur"), UnitAmount: stripe.Int64(900), LookupKey: stripe.String("mop")})
+	var stripeErr *stripe.Error
+	if assert.True(t, errors.As(err, &stripeErr)) {
+		assert.Equal(t, stripe.ErrorCodeResourceAlreadyExists, stripeErr.Code)
+	}
+
+	second, err := price.New(&stripe.PriceParams{Product: stripe.String(p.ID), Currency: stripe.String("eur"), UnitAmount: stripe.Int64(900), LookupKey: stripe.String("mop"), TransferLookupKey: stripe.Bool(true)})
+	assert.NoError(t, err)
+
+	moved, _ := s.PriceByLookupKey("mop")
+	assert.Equal(t, second.ID, moved.ID)
+
+	old, _ := s.Price(first.ID)
+	assert.Empty(t, old.LookupKey)
+
+	_, err = product.Del(p.ID, nil)
+	assert.Error(t, err, "products with prices cannot be deleted")
+
+	s.FailNext(http.MethodPost, "/v1/products", http.StatusServiceUnavailable, "")
+	_, err = product.New(&stripe.ProductParams{Name: stripe.String("Bucket")})
+	if assert.True(t, errors.As(err, &stripeErr)) {
+		assert.Equal(t, http.StatusServiceUnavailable, stripeErr.HTTPStatusCode)
+	}
+	assert.Len(t, s.Products(), 1)
+}
+
+func TestServer_checkoutAndRefunds(t *testing.T) {
+	s := Start(t)
+
+	productID := s.AddProduct(Product{Name: "Mop", Active: true})
+	priceID := s.AddPrice(Price{Product: productID, Currency: "eur", UnitAmount: 1500, LookupKey: "mop", Active: true})
+
+	cs, err := session.New(&stripe.CheckoutSessionParams{
+		ClientReferenceID:  stripe.String("ref"),
+		Mode:               stripe.String(string(stripe.CheckoutSessionModePayment)),
+		SuccessURL:         stripe.String("https://shop.test/success"),
+		CancelURL:          stripe.String("https://shop.test/cancel"),
+		PaymentMethodTypes: stripe.StringSlice([]string{"card"}),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{Price: stripe.String(priceID), Quantity: stripe.Int64(2)},
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(3000), cs.AmountTotal)
+
+	i := session.ListLineItems(cs.ID, &stripe.CheckoutSessionListLineItemsParams{})
+	var lineItems []*stripe.LineItem
+	for i.Next() {
+		lineItems = append(lineItems, i.LineItem())
+	}
+	assert.NoError(t, i.Err())
+	if assert.Len(t, lineItems, 1) {
+		assert.Equal(t, productID, lineItems[0].Price.Product.ID)
+		assert.Equal(t, "mop", lineItems[0].Price.LookupKey)
+		assert.Equal(t, int64(2), lineItems[0].Quantity)
+	}
+
+	_, err = refund.New(&stripe.RefundParams{PaymentIntent: stripe.String(cs.PaymentIntent.ID), Amount: stripe.Int64(1000)})
+	assert.NoError(t, err)
+
+	_, err = refund.New(&stripe.RefundParams{PaymentIntent: stripe.String(cs.PaymentIntent.ID), Amount: stripe.Int64(2500)})
+	assert.Error(t, err, "refunds cannot exceed the paid amount")
+
+	_, err = refund.New(&stripe.RefundParams{PaymentIntent: stripe.String(cs.PaymentIntent.ID)})
+	assert.NoError(t, err)
+
+	refunds := s.Refunds()
+	if assert.Len(t, refunds, 2) {
+		assert.Equal(t, int64(2000), refunds[1].Amount)
+	}
+}
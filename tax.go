@@ -0,0 +1,385 @@
+package mop_shop
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/stripe/stripe-go/v72"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// TaxClass groups items taxed at the same VAT rate
+type TaxClass string
+
+const (
+	TaxClassStandard TaxClass = "standard"
+	// TaxClassReduced falls back to the standard rate in countries without a reduced rate
+	TaxClassReduced TaxClass = "reduced"
+	// TaxClassSuperReduced falls back to the reduced rate in countries without a super-reduced rate
+	TaxClassSuperReduced TaxClass = "super_reduced"
+	// TaxClassZero is never taxed
+	TaxClassZero TaxClass = "zero"
+)
+
+// valid tells whether c is one of the tax classes or empty, which is TaxClassStandard
+func (c TaxClass) valid() bool {
+	switch c {
+	case "", TaxClassStandard, TaxClassReduced, TaxClassSuperReduced, TaxClassZero:
+		return true
+	}
+
+	return false
+}
+
+func (c TaxClass) orStandard() TaxClass {
+	if len(c) == 0 {
+		return TaxClassStandard
+	}
+
+	return c
+}
+
+// TaxRates are VAT rates in basis points by ISO 3166-1 alpha-2 country code and tax class, e.g.
+// TaxRates{"HR": {TaxClassStandard: 2500, TaxClassReduced: 1300, TaxClassSuperReduced: 500}}
+type TaxRates map[string]map[TaxClass]int64
+
+// rate returns the rate of class in country following the fallbacks of the tax classes, or false when customers in
+// country aren't charged VAT
+func (r TaxRates) rate(country string, class TaxClass) (int64, bool) {
+	rates, ok := r[strings.ToUpper(country)]
+	if !ok {
+		return 0, false
+	}
+
+	for class = class.orStandard(); class != TaxClassZero; {
+		if rate, ok := rates[class]; ok {
+			return rate, true
+		}
+
+		switch class {
+		case TaxClassSuperReduced:
+			class = TaxClassReduced
+		case TaxClassReduced:
+			class = TaxClassStandard
+		default:
+			return 0, true
+		}
+	}
+
+	return 0, true
+}
+
+// TaxConfig describes how orders are taxed, see WithTaxes
+type TaxConfig struct {
+	// OriginCountry is the country the shop is registered for VAT in. Its rates apply while the country of the
+	// customer is unknown, e.g. when Stripe Checkout collects the shipping address.
+	OriginCountry string
+	// PricesIncludeTax tells whether item prices and shipping rates are gross, as is usual for consumers in the EU.
+	// Otherwise VAT is added on top of them.
+	PricesIncludeTax bool
+	// Rates are the VAT rates of every country whose customers are charged VAT, customers from other countries
+	// aren't charged any
+	Rates TaxRates
+}
+
+// WithTaxes makes orders charge VAT. Without it orders have no tax lines.
+func WithTaxes(config TaxConfig) Option {
+	return func(s *Shop) {
+		config.OriginCountry = strings.ToUpper(config.OriginCountry)
+		s.taxes = &config
+	}
+}
+
+// OrderTaxLine is the VAT of an order in a single tax class, amounts are in cents
+type OrderTaxLine struct {
+	TaxClass TaxClass `json:"tax_class"`
+	Country  string   `json:"country"`
+	// Rate is in basis points, 0 for reverse charged orders and customers outside of TaxConfig.Rates
+	Rate      int64 `json:"rate"`
+	NetAmount int64 `json:"net_amount"`
+	TaxAmount int64 `json:"tax_amount"`
+}
+
+// OrderTaxLines is stored as a JSON array, ordered by Rate descending
+type OrderTaxLines []OrderTaxLine
+
+func (l OrderTaxLines) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal([]OrderTaxLine(l))
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func (l *OrderTaxLines) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(data, l)
+	case string:
+		return json.Unmarshal([]byte(data), l)
+	default:
+		return fmt.Errorf("unsupported tax lines type %T", value)
+	}
+}
+
+// TaxAmount returns the VAT of all lines in cents
+func (l OrderTaxLines) TaxAmount() int64 {
+	var amount int64
+	for i := range l {
+		amount += l[i].TaxAmount
+	}
+
+	return amount
+}
+
+// taxableAmount is a part of an order in cents, such as the price of an item times its quantity
+type taxableAmount struct {
+	class  TaxClass
+	amount int64
+	// quantity is how many units amount is made of, 0 counts as 1. Reverse charged VAT is taken out of every unit,
+	// so net amounts add up to the unit prices charged in Stripe.
+	quantity int64
+}
+
+// vatIDPattern matches VAT identification numbers after normalizeVATID, starting with the country prefix
+var vatIDPattern = regexp.MustCompile(`^[A-Z]{2}[0-9A-Z+*]{2,12}$`)
+
+// normalizeVATID upper-cases the VAT ID and removes spaces, dots and hyphens
+func normalizeVATID(vatID string) string {
+	return strings.NewReplacer(" ", "", ".", "", "-", "").Replace(strings.ToUpper(vatID))
+}
+
+// vatIDCountry returns the ISO country code of a normalized VAT ID, Greek VAT IDs starting with EL
+func vatIDCountry(vatID string) string {
+	if strings.HasPrefix(vatID, "EL") {
+		return "GR"
+	}
+
+	return vatID[:2]
+}
+
+// reverseCharge tells whether VAT of an order shipped to country is owed by the customer with the given VAT ID
+// rather than the shop, which is the case for businesses from other countries having VAT receiving goods outside
+// of the origin country. VAT IDs are only checked for their format.
+func (c *TaxConfig) reverseCharge(country, vatID string) bool {
+	if len(vatID) == 0 || country == c.OriginCountry {
+		return false
+	}
+
+	vatCountry := vatIDCountry(vatID)
+	_, hasVAT := c.Rates[vatCountry]
+	return hasVAT && vatCountry != c.OriginCountry
+}
+
+// calculate returns one tax line per tax class of amounts taxed in country. Reverse charged amounts are charged
+// without VAT, so the VAT of country is taken out of them when prices include tax.
+func (c *TaxConfig) calculate(country string, reverseCharge bool, amounts []taxableAmount) OrderTaxLines {
+	totals, charged := map[TaxClass]int64{}, map[TaxClass]int64{}
+	for _, a := range amounts {
+		quantity := a.quantity
+		if quantity == 0 {
+			quantity = 1
+		}
+
+		totals[a.class.orStandard()] += a.amount
+		charged[a.class.orStandard()] += quantity * c.chargedUnitAmount(country, reverseCharge, a.class, a.amount/quantity)
+	}
+
+	lines := make(OrderTaxLines, 0, len(totals))
+	for class, amount := range totals {
+		line := OrderTaxLine{TaxClass: class, Country: country, NetAmount: amount}
+
+		rate, ok := c.Rates.rate(country, class)
+		switch {
+		case !ok || rate == 0:
+		case reverseCharge:
+			// the customer owes the VAT, so the shop charges the net amount only
+			line.NetAmount = charged[class]
+		case c.PricesIncludeTax:
+			line.Rate = rate
+			line.TaxAmount = roundedDiv(amount*rate, 10000+rate)
+			line.NetAmount = amount - line.TaxAmount
+		default:
+			line.Rate = rate
+			line.TaxAmount = roundedDiv(amount*rate, 10000)
+		}
+
+		lines = append(lines, line)
+	}
+
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].Rate != lines[j].Rate {
+			return lines[i].Rate > lines[j].Rate
+		}
+
+		return lines[i].TaxClass < lines[j].TaxClass
+	})
+
+	return lines
+}
+
+// chargedUnitAmount returns what is charged for a unit priced unitAmount, which is unitAmount less the VAT it
+// includes when the VAT is reverse charged
+func (c *TaxConfig) chargedUnitAmount(country string, reverseCharge bool, class TaxClass, unitAmount int64) int64 {
+	rate, ok := c.Rates.rate(country, class.orStandard())
+	if !reverseCharge || !c.PricesIncludeTax || !ok {
+		return unitAmount
+	}
+
+	return unitAmount - roundedDiv(unitAmount*rate, 10000+rate)
+}
+
+// roundedDiv divides non-negative a by b rounding half up
+func roundedDiv(a, b int64) int64 {
+	return (a + b/2) / b
+}
+
+// prepareTaxes sets the tax lines of the order from amounts, which are taxed in the country of the shipping
+// address, the billing address or the origin country, whichever is known first
+func (o *UserOrder) prepareTaxes(data *CreateUserOrder, amounts []taxableAmount) {
+	o.TaxAmount, o.TaxLines, o.VATID, o.ReverseCharge = 0, nil, nil, false
+
+	if len(data.VATID) > 0 {
+		vatID := data.VATID
+		o.VATID = &vatID
+	}
+
+	if o.taxes == nil {
+		return
+	}
+
+	country := o.taxes.OriginCountry
+	switch {
+	case o.ShippingAddress != nil:
+		country = o.ShippingAddress.Country
+	case o.BillingAddress != nil:
+		country = o.BillingAddress.Country
+	}
+
+	o.ReverseCharge = o.taxes.reverseCharge(country, data.VATID)
+	o.TaxLines = o.taxes.calculate(country, o.ReverseCharge, amounts)
+	o.TaxAmount = float32(o.TaxLines.TaxAmount())
+}
+
+// chargedAmount returns what Stripe has to charge for a unit of class priced unitAmount, see
+// TaxConfig.chargedUnitAmount
+func (o *UserOrder) chargedAmount(class TaxClass, unitAmount int64) int64 {
+	if o.taxes == nil || len(o.TaxLines) == 0 {
+		return unitAmount
+	}
+
+	return o.taxes.chargedUnitAmount(o.TaxLines[0].Country, o.ReverseCharge, class, unitAmount)
+}
+
+// totalPrice returns what the customer pays for items worth subtotal and the shipping of the order, which are the
+// net and tax amounts of the tax lines when the shop charges VAT
+func (o *UserOrder) totalPrice(subtotal int64) float32 {
+	if o.taxes == nil {
+		return float32(subtotal) + o.ShippingPrice
+	}
+
+	var total int64
+	for _, line := range o.TaxLines {
+		total += line.NetAmount + line.TaxAmount
+	}
+
+	return float32(total)
+}
+
+// taxOnTop returns the VAT customers pay on top of the prices, which is zero when prices include tax
+func (o *UserOrder) taxOnTop() float32 {
+	if o.taxes == nil || o.taxes.PricesIncludeTax {
+		return 0
+	}
+
+	return o.TaxAmount
+}
+
+// CheckoutTaxLineItemName is the name of the line item ApplyCheckoutTaxes adds
+const CheckoutTaxLineItemName = "VAT"
+
+// ApplyCheckoutTaxes adds the VAT calculated in PrepareForOrder to params as a line item when it isn't included in
+// the prices already. Reverse charged orders get no such line item. When their prices include tax, the line items
+// of params are replaced by ones for the items of the order priced without the VAT, so Stripe charges TotalPrice
+// together with the shipping of ApplyCheckoutShipping.
+func (o *UserOrder) ApplyCheckoutTaxes(params *stripe.CheckoutSessionParams) {
+	if o.ReverseCharge && o.taxes != nil && o.taxes.PricesIncludeTax {
+		params.LineItems = o.reverseChargedLineItems()
+		return
+	}
+
+	amount := int64(o.taxOnTop())
+	if amount == 0 {
+		return
+	}
+
+	params.LineItems = append(params.LineItems, &stripe.CheckoutSessionLineItemParams{
+		PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
+			Currency:    stripe.String(string(stripe.CurrencyEUR)),
+			ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{Name: stripe.String(CheckoutTaxLineItemName)},
+			UnitAmount:  stripe.Int64(amount),
+		},
+		Quantity: stripe.Int64(1),
+	})
+}
+
+// reverseChargedLineItems returns a line item for every item of the order, priced without the VAT and ordered by
+// shop item ID
+func (o *UserOrder) reverseChargedLineItems() []*stripe.CheckoutSessionLineItemParams {
+	itemIDs := make([]int, 0, len(o.orderItems))
+	for id := range o.orderItems {
+		itemIDs = append(itemIDs, id)
+	}
+	sort.Ints(itemIDs)
+
+	lineItems := make([]*stripe.CheckoutSessionLineItemParams, 0, len(itemIDs))
+	for _, id := range itemIDs {
+		item := o.orderItems[id]
+		lineItems = append(lineItems, &stripe.CheckoutSessionLineItemParams{
+			PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
+				Currency:   stripe.String(string(stripe.CurrencyEUR)),
+				Product:    stripe.String(item.StripeProductApiID),
+				UnitAmount: stripe.Int64(o.chargedAmount(item.TaxClass, int64(item.Price))),
+			},
+			Quantity: stripe.Int64(int64(item.Quantity)),
+		})
+	}
+
+	return lineItems
+}
+
+// UserOrderTaxLineFrontResponse is OrderTaxLine with the rate as a percentage and amounts in currency units
+type UserOrderTaxLineFrontResponse struct {
+	TaxClass  TaxClass `json:"tax_class"`
+	Country   string   `json:"country"`
+	Rate      float64  `json:"rate"`
+	NetAmount float64  `json:"net_amount"`
+	TaxAmount float64  `json:"tax_amount"`
+}
+
+func taxLinesFrontResponse(lines OrderTaxLines) []UserOrderTaxLineFrontResponse {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	data := make([]UserOrderTaxLineFrontResponse, 0, len(lines))
+	for _, line := range lines {
+		rate, _ := decimal.New(line.Rate, -2).Float64()
+		net, _ := decimal.New(line.NetAmount, -2).Float64()
+		tax, _ := decimal.New(line.TaxAmount, -2).Float64()
+		data = append(data, UserOrderTaxLineFrontResponse{TaxClass: line.TaxClass, Country: line.Country, Rate: rate, NetAmount: net, TaxAmount: tax})
+	}
+
+	return data
+}
@@ -0,0 +1,338 @@
+package mop_shop
+
+import (
+	"context"
+	"github.com/croatiangrn/mop-shop/stripetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/checkout/session"
+	"testing"
+)
+
+var testTaxRates = TaxRates{
+	"HR": {TaxClassStandard: 2500, TaxClassReduced: 1300, TaxClassSuperReduced: 500},
+	"AT": {TaxClassStandard: 2000, TaxClassReduced: 1000},
+	"DK": {TaxClassStandard: 2500},
+}
+
+func TestTaxConfig_calculate(t *testing.T) {
+	amounts := []taxableAmount{{class: TaxClassReduced, amount: 1000}, {amount: 2000}, {class: TaxClassStandard, amount: 499}, {class: TaxClassZero, amount: 300}}
+
+	tests := []struct {
+		name             string
+		pricesIncludeTax bool
+		country          string
+		reverseCharge    bool
+		amounts          []taxableAmount
+		want             OrderTaxLines
+	}{
+		{
+			name:             "Prices include tax",
+			pricesIncludeTax: true,
+			country:          "HR",
+			amounts:          amounts,
+			want: OrderTaxLines{
+				{TaxClass: TaxClassStandard, Country: "HR", Rate: 2500, NetAmount: 1999, TaxAmount: 500},
+				{TaxClass: TaxClassReduced, Country: "HR", Rate: 1300, NetAmount: 885, TaxAmount: 115},
+				{TaxClass: TaxClassZero, Country: "HR", NetAmount: 300},
+			},
+		},
+		{
+			name:    "Tax on top of prices",
+			country: "HR",
+			amounts: amounts,
+			want: OrderTaxLines{
+				{TaxClass: TaxClassStandard, Country: "HR", Rate: 2500, NetAmount: 2499, TaxAmount: 625},
+				{TaxClass: TaxClassReduced, Country: "HR", Rate: 1300, NetAmount: 1000, TaxAmount: 130},
+				{TaxClass: TaxClassZero, Country: "HR", NetAmount: 300},
+			},
+		},
+		{
+			name:    "Super-reduced falls back to reduced",
+			country: "AT",
+			amounts: []taxableAmount{{class: TaxClassSuperReduced, amount: 1005}},
+			want:    OrderTaxLines{{TaxClass: TaxClassSuperReduced, Country: "AT", Rate: 1000, NetAmount: 1005, TaxAmount: 101}},
+		},
+		{
+			name:    "Reduced falls back to standard",
+			country: "DK",
+			amounts: []taxableAmount{{class: TaxClassReduced, amount: 1000}},
+			want:    OrderTaxLines{{TaxClass: TaxClassReduced, Country: "DK", Rate: 2500, NetAmount: 1000, TaxAmount: 250}},
+		},
+		{
+			name:             "Reverse charge",
+			pricesIncludeTax: true,
+			country:          "AT",
+			reverseCharge:    true,
+			amounts:          []taxableAmount{{amount: 1200}},
+			want:             OrderTaxLines{{TaxClass: TaxClassStandard, Country: "AT", NetAmount: 1000}},
+		},
+		{
+			name:          "Reverse charge on top of prices",
+			country:       "AT",
+			reverseCharge: true,
+			amounts:       []taxableAmount{{amount: 1200}},
+			want:          OrderTaxLines{{TaxClass: TaxClassStandard, Country: "AT", NetAmount: 1200}},
+		},
+		{
+			name:    "Country without VAT",
+			country: "US",
+			amounts: []taxableAmount{{amount: 1200}},
+			want:    OrderTaxLines{{TaxClass: TaxClassStandard, Country: "US", NetAmount: 1200}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &TaxConfig{OriginCountry: "HR", PricesIncludeTax: tt.pricesIncludeTax, Rates: testTaxRates}
+			assert.Equal(t, tt.want, config.calculate(tt.country, tt.reverseCharge, tt.amounts))
+		})
+	}
+}
+
+func TestTaxConfig_reverseCharge(t *testing.T) {
+	config := &TaxConfig{OriginCountry: "HR", Rates: testTaxRates}
+
+	tests := []struct {
+		name    string
+		country string
+		vatID   string
+		want    bool
+	}{
+		{name: "No VAT ID", country: "AT"},
+		{name: "Business from another country", country: "AT", vatID: "ATU12345678", want: true},
+		{name: "Business from the origin country", country: "AT", vatID: "HR12345678901"},
+		{name: "Shipped within the origin country", country: "HR", vatID: "ATU12345678"},
+		{name: "Business from a country without VAT", country: "AT", vatID: "GB123456789"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, config.reverseCharge(tt.country, tt.vatID))
+		})
+	}
+}
+
+func TestUserOrder_PrepareForOrderContext_taxes(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+
+	mop := &ShopItem{ItemName: "Mop", ItemPrice: 2500, Shippable: true, Quantity: 10, StripeProductApiID: "prod_mop"}
+	book := &ShopItem{ItemName: "Book", ItemPrice: 1130, TaxClass: TaxClassReduced, Quantity: 10, StripeProductApiID: "prod_book"}
+	assert.NoError(t, storage.ShopItems().Create(ctx, mop))
+	assert.NoError(t, storage.ShopItems().Create(ctx, book))
+
+	vienna := &OrderAddress{Name: "Ana", Line1: "Ring 1", City: "Wien", PostalCode: "1010", Country: "AT"}
+	newYork := &OrderAddress{Name: "Ana", Line1: "5th Avenue 1", City: "New York", PostalCode: "10001", Country: "US"}
+
+	tests := []struct {
+		name              string
+		pricesIncludeTax  bool
+		items             []CreateUserOrderItem
+		shippingAddress   *OrderAddress
+		vatID             string
+		wantErr           error
+		wantTotalPrice    float32
+		wantTaxAmount     float32
+		wantReverseCharge bool
+		wantCountry       string
+	}{
+		{
+			name:             "Origin country while the address is unknown",
+			pricesIncludeTax: true,
+			items:            []CreateUserOrderItem{{ItemID: mop.ID, Quantity: 1}, {ItemID: book.ID, Quantity: 1}},
+			wantTotalPrice:   4130,
+			wantTaxAmount:    730,
+			wantCountry:      "HR",
+		},
+		{
+			name:            "Tax on top of prices in the country of the shipping address",
+			items:           []CreateUserOrderItem{{ItemID: mop.ID, Quantity: 2}},
+			shippingAddress: vienna,
+			wantTotalPrice:  6600,
+			wantTaxAmount:   1100,
+			wantCountry:     "AT",
+		},
+		{
+			name:              "Reverse charge",
+			items:             []CreateUserOrderItem{{ItemID: mop.ID, Quantity: 1}},
+			shippingAddress:   vienna,
+			vatID:             "atu 1234-5678",
+			wantTotalPrice:    3000,
+			wantReverseCharge: true,
+			wantCountry:       "AT",
+		},
+		{
+			name:              "Reverse charge leaves out the VAT included in prices",
+			pricesIncludeTax:  true,
+			items:             []CreateUserOrderItem{{ItemID: mop.ID, Quantity: 2}},
+			shippingAddress:   vienna,
+			vatID:             "ATU12345678",
+			wantTotalPrice:    4583,
+			wantReverseCharge: true,
+			wantCountry:       "AT",
+		},
+		{
+			name:            "Export",
+			items:           []CreateUserOrderItem{{ItemID: mop.ID, Quantity: 1}},
+			shippingAddress: newYork,
+			wantTotalPrice:  3000,
+			wantCountry:     "US",
+		},
+		{
+			name:    "Invalid VAT ID",
+			items:   []CreateUserOrderItem{{ItemID: book.ID, Quantity: 1}},
+			vatID:   "12345",
+			wantErr: ValidationErrors{{Field: "vat_id", Err: ErrVATIDInvalid}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			methods := WithShippingMethods(ShippingMethod{Code: "courier", Name: "Courier", Type: ShippingRateFlat, Amount: 500, Countries: []string{"HR", "AT", "US"}})
+			shop := NewShop(nil, "", WithMemoryStorage(storage), methods, WithTaxes(TaxConfig{OriginCountry: "hr", PricesIncludeTax: tt.pricesIncludeTax, Rates: testTaxRates}))
+
+			data := NewCreateUserOrder(3)
+			data.Items, data.ShippingMethod, data.ShippingAddress, data.VATID = tt.items, "courier", cloneOrderAddress(tt.shippingAddress), tt.vatID
+
+			order := shop.NewUserOrder()
+			err := order.PrepareForOrderContext(ctx, data)
+			assert.Equal(t, tt.wantErr, err)
+			if err != nil {
+				return
+			}
+
+			assert.Equal(t, tt.wantTotalPrice, order.TotalPrice)
+			assert.Equal(t, tt.wantTaxAmount, order.TaxAmount)
+			assert.Equal(t, tt.wantReverseCharge, order.ReverseCharge)
+			if assert.NotEmpty(t, order.TaxLines) {
+				assert.Equal(t, tt.wantCountry, order.TaxLines[0].Country)
+			}
+		})
+	}
+
+	t.Run("Shops without taxes only store the VAT ID", func(t *testing.T) {
+		data := NewCreateUserOrder(3)
+		data.Items, data.VATID = []CreateUserOrderItem{{ItemID: book.ID, Quantity: 1}}, "ATU12345678"
+
+		order := NewShop(nil, "", WithMemoryStorage(storage)).NewUserOrder()
+		if assert.NoError(t, order.PrepareForOrderContext(ctx, data)) {
+			assert.Equal(t, "ATU12345678", *order.VATID)
+			assert.Nil(t, order.TaxLines)
+			assert.Equal(t, float32(1130), order.TotalPrice)
+		}
+	})
+}
+
+func TestUserOrder_ApplyCheckoutTaxes_stripe(t *testing.T) {
+	ctx := context.Background()
+	stripeServer := stripetest.Start(t)
+	storage := NewMemoryStorage()
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(storage), WithTaxes(TaxConfig{OriginCountry: "HR", Rates: testTaxRates}))
+
+	productID := stripeServer.AddProduct(stripetest.Product{Name: "Book", Active: true})
+	priceID := stripeServer.AddPrice(stripetest.Price{Product: productID, Currency: "eur", UnitAmount: 1000, LookupKey: "book", Active: true})
+
+	book := &ShopItem{ItemName: "Book", ItemPrice: 1000, TaxClass: TaxClassReduced, Quantity: 5, StripeProductApiID: productID, UniqueStripePriceLookupKey: "book"}
+	assert.NoError(t, storage.ShopItems().Create(ctx, book))
+
+	order := shop.NewUserOrder()
+	data := NewCreateUserOrder(3)
+	data.Items = []CreateUserOrderItem{{ItemID: book.ID, Quantity: 1}}
+	if !assert.NoError(t, order.PrepareForOrderContext(ctx, data)) {
+		return
+	}
+
+	params := &stripe.CheckoutSessionParams{
+		ClientReferenceID: stripe.String("ref"),
+		Mode:              stripe.String(string(stripe.CheckoutSessionModePayment)),
+		LineItems:         []*stripe.CheckoutSessionLineItemParams{{Price: stripe.String(priceID), Quantity: stripe.Int64(1)}},
+	}
+	order.ApplyCheckoutTaxes(params)
+
+	cs, err := session.New(params)
+	if !assert.NoError(t, err) || !assert.NoError(t, order.CreateEmptyOrderContext(ctx, 3, "ref")) {
+		return
+	}
+	assert.Equal(t, int64(1130), cs.AmountTotal)
+
+	completed := shop.NewUserOrder()
+	if !assert.NoError(t, completed.FindOneByClientReferenceIDContext(ctx, "ref", false)) {
+		return
+	}
+	if !assert.NoError(t, completed.UpdateEmptyOrderAfterCheckoutContext(ctx, cs.ID, "ref", float32(cs.AmountTotal))) {
+		return
+	}
+
+	got, err := shop.FindOrderByByIDAndUserIDContext(ctx, order.ID, 3, true, "eur", "")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 11.3, *got.TotalPrice)
+		assert.Equal(t, 1.3, *got.TaxAmount)
+		assert.Equal(t, []UserOrderTaxLineFrontResponse{{TaxClass: TaxClassReduced, Country: "HR", Rate: 13, NetAmount: 10, TaxAmount: 1.3}}, got.TaxLines)
+		if assert.Len(t, got.Items, 1) {
+			assert.Equal(t, book.ID, got.Items[0].ItemID)
+		}
+	}
+
+	t.Run("Prices including tax need no line item", func(t *testing.T) {
+		order := NewShop(nil, "sk_test", WithMemoryStorage(storage), WithTaxes(TaxConfig{OriginCountry: "HR", PricesIncludeTax: true, Rates: testTaxRates})).NewUserOrder()
+		if assert.NoError(t, order.PrepareForOrderContext(ctx, data)) {
+			params := &stripe.CheckoutSessionParams{}
+			order.ApplyCheckoutTaxes(params)
+			assert.Empty(t, params.LineItems)
+			assert.Equal(t, float32(115), order.TaxAmount)
+		}
+	})
+
+	t.Run("Reverse charged orders are charged their total price", func(t *testing.T) {
+		mopProductID := stripeServer.AddProduct(stripetest.Product{Name: "Mop", Active: true})
+		mopPriceID := stripeServer.AddPrice(stripetest.Price{Product: mopProductID, Currency: "eur", UnitAmount: 2500, LookupKey: "mop", Active: true})
+		mop := &ShopItem{ItemName: "Mop", ItemPrice: 2500, Shippable: true, Quantity: 5, StripeProductApiID: mopProductID, UniqueStripePriceLookupKey: "mop"}
+		assert.NoError(t, storage.ShopItems().Create(ctx, mop))
+
+		shop := NewShop(nil, "sk_test", WithMemoryStorage(storage),
+			WithShippingMethods(ShippingMethod{Code: "courier", Name: "Courier", Type: ShippingRateFlat, Amount: 500}),
+			WithTaxes(TaxConfig{OriginCountry: "HR", PricesIncludeTax: true, Rates: testTaxRates}))
+
+		data := NewCreateUserOrder(3)
+		data.Items, data.ShippingMethod, data.VATID = []CreateUserOrderItem{{ItemID: mop.ID, Quantity: 2}}, "courier", "ATU12345678"
+		data.ShippingAddress = &OrderAddress{Name: "Ana", Line1: "Ring 1", City: "Wien", PostalCode: "1010", Country: "AT"}
+
+		order := shop.NewUserOrder()
+		if !assert.NoError(t, order.PrepareForOrderContext(ctx, data)) || !assert.True(t, order.ReverseCharge) {
+			return
+		}
+
+		params := &stripe.CheckoutSessionParams{
+			ClientReferenceID: stripe.String("reverse-charged"),
+			Mode:              stripe.String(string(stripe.CheckoutSessionModePayment)),
+			LineItems:         []*stripe.CheckoutSessionLineItemParams{{Price: stripe.String(mopPriceID), Quantity: stripe.Int64(2)}},
+		}
+		assert.NoError(t, order.ApplyCheckoutShipping(params))
+		order.ApplyCheckoutTaxes(params)
+
+		cs, err := session.New(params)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, int64(4583), cs.AmountTotal)
+		assert.Equal(t, int64(order.TotalPrice), cs.AmountTotal)
+
+		if !assert.NoError(t, order.CreateEmptyOrderContext(ctx, 3, "reverse-charged")) {
+			return
+		}
+
+		completed := shop.NewUserOrder()
+		if !assert.NoError(t, completed.FindOneByClientReferenceIDContext(ctx, "reverse-charged", false)) ||
+			!assert.NoError(t, completed.UpdateEmptyOrderAfterCheckoutContext(ctx, cs.ID, "reverse-charged", float32(cs.AmountTotal))) {
+			return
+		}
+
+		got, err := shop.FindOrderByByIDAndUserIDContext(ctx, order.ID, 3, true, "eur", "")
+		if assert.NoError(t, err) && assert.Len(t, got.Items, 1) {
+			assert.Equal(t, 45.83, *got.TotalPrice)
+			assert.Equal(t, mop.ID, got.Items[0].ItemID)
+			assert.Equal(t, 2, got.Items[0].Quantity)
+		}
+	})
+}
@@ -0,0 +1,320 @@
+package mop_shop
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultLocale is the locale of ShopItem.ItemName and ShopItem.ItemDescription unless changed via WithDefaultLocale
+const DefaultLocale = "hr"
+
+// localePattern matches normalized BCP 47 tags such as hr, en or en-gb
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// ShopItemTranslation is the name and description of a shop item in a locale other than the default one, the default
+// locale text is kept in the shop item itself
+type ShopItemTranslation struct {
+	ID              int     `gorm:"primaryKey" json:"id"`
+	ShopItemID      int     `gorm:"not null;uniqueIndex:ux_shop_item_translations_item_locale;" json:"shop_item_id"`
+	Locale          string  `gorm:"not null;type:varchar(35);uniqueIndex:ux_shop_item_translations_item_locale;" json:"locale"`
+	ItemName        string  `gorm:"not null;type:varchar(255);" json:"item_name"`
+	ItemDescription *string `gorm:"default:null;type:text;" json:"item_description"`
+}
+
+func (t *ShopItemTranslation) TableName() string {
+	return "shop_item_translations"
+}
+
+// NormalizeLocale lower-cases the locale and uses hyphens as separators, so en_GB and en-GB both become en-gb
+func NormalizeLocale(locale string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(locale)), "_", "-")
+}
+
+// localeFallbacks returns the locales whose translations are tried for locale, most specific first. Fallback goes
+// from the locale to its language, e.g. en-gb to en, and stops at the default locale whose text the shop item holds.
+func (s *Shop) localeFallbacks(locale string) []string {
+	locale = NormalizeLocale(locale)
+
+	var fallbacks []string
+	for len(locale) > 0 && locale != s.defaultLocale {
+		fallbacks = append(fallbacks, locale)
+
+		i := strings.LastIndex(locale, "-")
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+
+	return fallbacks
+}
+
+// translationFor returns the most specific translation of the item following fallbacks, or nil
+func translationFor(translations map[int]map[string]ShopItemTranslation, shopItemID int, fallbacks []string) *ShopItemTranslation {
+	for _, locale := range fallbacks {
+		if translation, ok := translations[shopItemID][locale]; ok {
+			return &translation
+		}
+	}
+
+	return nil
+}
+
+// findTranslations returns translations of the items in any of the fallback locales by shop item ID and locale
+func (s *Shop) findTranslations(ctx context.Context, shopItemIDs []int, fallbacks []string) (map[int]map[string]ShopItemTranslation, error) {
+	translations := map[int]map[string]ShopItemTranslation{}
+	if s.translations == nil || len(shopItemIDs) == 0 || len(fallbacks) == 0 {
+		return translations, nil
+	}
+
+	data, err := s.translations.FindByShopItemIDs(ctx, shopItemIDs, fallbacks)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, translation := range data {
+		if translations[translation.ShopItemID] == nil {
+			translations[translation.ShopItemID] = map[string]ShopItemTranslation{}
+		}
+		translations[translation.ShopItemID][translation.Locale] = translation
+	}
+
+	return translations, nil
+}
+
+// translateItems replaces names and descriptions of data with their translations to locale and sets Locale to the
+// locale actually used
+func (s *Shop) translateItems(ctx context.Context, data []ShopItemForResponse, locale string) error {
+	fallbacks := s.localeFallbacks(locale)
+
+	ids := make([]int, 0, len(data))
+	for i := range data {
+		ids = append(ids, data[i].ID)
+	}
+
+	translations, err := s.findTranslations(ctx, ids, fallbacks)
+	if err != nil {
+		return err
+	}
+
+	for i := range data {
+		data[i].Locale = s.defaultLocale
+		if translation := translationFor(translations, data[i].ID, fallbacks); translation != nil {
+			data[i].Locale, data[i].ItemName, data[i].ItemDescription = translation.Locale, translation.ItemName, translation.ItemDescription
+		}
+	}
+
+	return nil
+}
+
+// OrderItemTranslation is the name and description an ordered item had in a locale when the order was placed
+type OrderItemTranslation struct {
+	Locale          string  `json:"locale"`
+	ItemName        string  `json:"item_name"`
+	ItemDescription *string `json:"item_description"`
+}
+
+// OrderItemTranslations is stored as a JSON array, ordered by locale
+type OrderItemTranslations []OrderItemTranslation
+
+func (l OrderItemTranslations) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal([]OrderItemTranslation(l))
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func (l *OrderItemTranslations) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(data, l)
+	case string:
+		return json.Unmarshal([]byte(data), l)
+	default:
+		return fmt.Errorf("unsupported order item translations type %T", value)
+	}
+}
+
+// orderItemTranslations converts translations of a shop item into the ones kept with its order items
+func orderItemTranslations(translations []ShopItemTranslation) OrderItemTranslations {
+	var data OrderItemTranslations
+	for _, translation := range translations {
+		data = append(data, OrderItemTranslation{
+			Locale:          translation.Locale,
+			ItemName:        translation.ItemName,
+			ItemDescription: cloneString(translation.ItemDescription),
+		})
+	}
+
+	return data
+}
+
+// translateOrderItems works like translateItems for items of an order, but uses the translations the items had when
+// the order was placed instead of the current ones
+func (s *Shop) translateOrderItems(items []UserOrderItemFrontResponse, locale string) {
+	fallbacks := s.localeFallbacks(locale)
+
+	for i := range items {
+		translations := map[int]map[string]ShopItemTranslation{items[i].ItemID: {}}
+		for _, translation := range items[i].translations {
+			translations[items[i].ItemID][translation.Locale] = ShopItemTranslation{ItemName: translation.ItemName, ItemDescription: translation.ItemDescription}
+		}
+
+		if translation := translationFor(translations, items[i].ItemID, fallbacks); translation != nil {
+			items[i].ItemName, items[i].ItemDescription = translation.ItemName, ""
+			if translation.ItemDescription != nil {
+				items[i].ItemDescription = *translation.ItemDescription
+			}
+		}
+	}
+}
+
+// Validate checks the translation without looking at the database
+func (t *ShopItemTranslation) Validate() error {
+	var validationErrors ValidationErrors
+
+	if !localePattern.MatchString(NormalizeLocale(t.Locale)) {
+		validationErrors.add("locale", ErrLocaleInvalid)
+	}
+
+	if len(strings.TrimSpace(t.ItemName)) == 0 {
+		validationErrors.add("item_name", ErrItemNameBlank)
+	}
+
+	return validationErrors.errOrNil()
+}
+
+func SetShopItemTranslation(data *ShopItemTranslation, currentTime time.Time, db *gorm.DB) error {
+	return defaultShop(db).SetShopItemTranslation(data, currentTime)
+}
+
+func SetShopItemTranslationContext(ctx context.Context, data *ShopItemTranslation, currentTime time.Time, db *gorm.DB) error {
+	return defaultShop(db).SetShopItemTranslationContext(ctx, data, currentTime)
+}
+
+// SetShopItemTranslation works like the package-level SetShopItemTranslation
+func (s *Shop) SetShopItemTranslation(data *ShopItemTranslation, currentTime time.Time) error {
+	return s.SetShopItemTranslationContext(context.Background(), data, currentTime)
+}
+
+// SetShopItemTranslationContext adds or replaces the translation of a not soft-deleted item. A translation to the
+// default locale patches the item itself like ShopItem.Patch, which also renames the Stripe product.
+func (s *Shop) SetShopItemTranslationContext(ctx context.Context, data *ShopItemTranslation, currentTime time.Time) error {
+	if s.items == nil || s.translations == nil {
+		return ErrShopItemNotInitializedProperly
+	}
+
+	if data == nil {
+		return ErrShopItemUpdateBlank
+	}
+
+	if err := data.Validate(); err != nil {
+		return err
+	}
+
+	data.Locale = NormalizeLocale(data.Locale)
+
+	item := s.NewShopItem()
+	if data.Locale == s.defaultLocale {
+		patch := NewShopItemPatch()
+		patch.ItemName = &data.ItemName
+		patch.ItemDescription = NewOptionalString(data.ItemDescription)
+		return item.PatchContext(ctx, data.ShopItemID, patch, currentTime)
+	}
+
+	if err := item.FindOneByIDContext(ctx, data.ShopItemID); err != nil {
+		return err
+	}
+
+	if err := s.translations.Save(ctx, data); err != nil {
+		s.log(ctx, LevelError, "error while saving shop item translation", "shop_item_id", data.ShopItemID, "locale", data.Locale, "error", err)
+		return wrapInternal("SetShopItemTranslation: save translation", err)
+	}
+
+	return nil
+}
+
+func DeleteShopItemTranslation(shopItemID int, locale string, db *gorm.DB) error {
+	return defaultShop(db).DeleteShopItemTranslation(shopItemID, locale)
+}
+
+func DeleteShopItemTranslationContext(ctx context.Context, shopItemID int, locale string, db *gorm.DB) error {
+	return defaultShop(db).DeleteShopItemTranslationContext(ctx, shopItemID, locale)
+}
+
+// DeleteShopItemTranslation works like the package-level DeleteShopItemTranslation
+func (s *Shop) DeleteShopItemTranslation(shopItemID int, locale string) error {
+	return s.DeleteShopItemTranslationContext(context.Background(), shopItemID, locale)
+}
+
+// DeleteShopItemTranslationContext removes a translation, so the locale falls back again. The default locale text
+// belongs to the item and can't be deleted.
+func (s *Shop) DeleteShopItemTranslationContext(ctx context.Context, shopItemID int, locale string) error {
+	if s.translations == nil {
+		return ErrShopItemNotInitializedProperly
+	}
+
+	locale = NormalizeLocale(locale)
+	if locale == s.defaultLocale {
+		return ErrDefaultLocaleTranslation
+	}
+
+	if err := s.translations.Delete(ctx, shopItemID, locale); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		s.log(ctx, LevelError, "error while deleting shop item translation", "shop_item_id", shopItemID, "locale", locale, "error", err)
+		return wrapInternal("DeleteShopItemTranslation: delete translation", err)
+	}
+
+	return nil
+}
+
+func FindShopItemTranslations(shopItemID int, db *gorm.DB) ([]ShopItemTranslation, error) {
+	return defaultShop(db).FindShopItemTranslations(shopItemID)
+}
+
+func FindShopItemTranslationsContext(ctx context.Context, shopItemID int, db *gorm.DB) ([]ShopItemTranslation, error) {
+	return defaultShop(db).FindShopItemTranslationsContext(ctx, shopItemID)
+}
+
+// FindShopItemTranslations works like the package-level FindShopItemTranslations
+func (s *Shop) FindShopItemTranslations(shopItemID int) ([]ShopItemTranslation, error) {
+	return s.FindShopItemTranslationsContext(context.Background(), shopItemID)
+}
+
+// FindShopItemTranslationsContext returns all translations of the item ordered by locale, without the default locale
+func (s *Shop) FindShopItemTranslationsContext(ctx context.Context, shopItemID int) ([]ShopItemTranslation, error) {
+	if s.translations == nil {
+		return nil, ErrShopItemNotInitializedProperly
+	}
+
+	data, err := s.translations.FindByShopItemIDs(ctx, []int{shopItemID}, nil)
+	if err != nil {
+		s.log(ctx, LevelError, "error while getting shop item translations", "shop_item_id", shopItemID, "error", err)
+		return nil, wrapInternal("FindShopItemTranslations: query translations", err)
+	}
+
+	if len(data) == 0 {
+		data = []ShopItemTranslation{}
+	}
+
+	return data, nil
+}
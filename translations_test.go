@@ -0,0 +1,152 @@
+package mop_shop
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/croatiangrn/mop-shop/stripetest"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestShop_localeFallbacks(t *testing.T) {
+	shop := NewShop(nil, "", WithMemoryStorage(NewMemoryStorage()), WithDefaultLocale("hr"))
+
+	tests := []struct {
+		locale string
+		want   []string
+	}{
+		{locale: "", want: nil},
+		{locale: "hr", want: nil},
+		{locale: "en", want: []string{"en"}},
+		{locale: "en_GB", want: []string{"en-gb", "en"}},
+		{locale: "sr-Latn-RS", want: []string{"sr-latn-rs", "sr-latn", "sr"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			assert.Equal(t, tt.want, shop.localeFallbacks(tt.locale))
+		})
+	}
+}
+
+func TestShop_SetShopItemTranslationContext(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	stripeServer := stripetest.Start(t)
+	shop := NewShop(nil, "sk_test", WithMemoryStorage(NewMemoryStorage()), WithDefaultLocale("HR"))
+
+	description := "Mop za pod"
+	item := shop.NewShopItem()
+	if !assert.NoError(t, item.CreateContext(ctx, &ShopItemCreate{ItemName: "Mop", ItemDescription: &description, ItemPrice: 2000, Quantity: 3}, now)) {
+		return
+	}
+
+	englishDescription := "Floor mop"
+	assert.NoError(t, shop.SetShopItemTranslationContext(ctx, &ShopItemTranslation{ShopItemID: item.ID, Locale: "en", ItemName: "Floor mop", ItemDescription: &englishDescription}, now))
+	assert.NoError(t, shop.SetShopItemTranslationContext(ctx, &ShopItemTranslation{ShopItemID: item.ID, Locale: "en_US", ItemName: "Swab"}, now))
+
+	translated := func(locale string) ShopItemForResponse {
+		data, _, err := shop.GetShopItemsForFrontendContext(ctx, false, "eur", locale, PaginationParams{}, httptest.NewRequest("GET", "/items", nil))
+		if !assert.NoError(t, err) || !assert.Len(t, data, 1) {
+			return ShopItemForResponse{}
+		}
+
+		return data[0]
+	}
+
+	tests := []struct {
+		locale          string
+		wantLocale      string
+		wantName        string
+		wantDescription *string
+	}{
+		{locale: "", wantLocale: "hr", wantName: "Mop", wantDescription: &description},
+		{locale: "en-US", wantLocale: "en-us", wantName: "Swab"},
+		{locale: "en-GB", wantLocale: "en", wantName: "Floor mop", wantDescription: &englishDescription},
+		{locale: "de", wantLocale: "hr", wantName: "Mop", wantDescription: &description},
+	}
+
+	for _, tt := range tests {
+		t.Run("Locale "+tt.locale, func(t *testing.T) {
+			got := translated(tt.locale)
+			assert.Equal(t, tt.wantLocale, got.Locale)
+			assert.Equal(t, tt.wantName, got.ItemName)
+			assert.Equal(t, tt.wantDescription, got.ItemDescription)
+		})
+	}
+
+	t.Run("Order items", func(t *testing.T) {
+		ordered := "Ordered mop"
+		items := []UserOrderItemFrontResponse{
+			{ItemID: item.ID, ItemName: "Mop", ItemDescription: description, translations: OrderItemTranslations{{Locale: "en", ItemName: "Floor mop", ItemDescription: &ordered}}},
+			{ItemID: item.ID, ItemName: "Mop", ItemDescription: description},
+			{ItemID: 99, ItemName: "Gone"},
+		}
+		shop.translateOrderItems(items, "en-GB")
+		assert.Equal(t, "Floor mop", items[0].ItemName)
+		assert.Equal(t, ordered, items[0].ItemDescription)
+		assert.Equal(t, "Mop", items[1].ItemName, "current translations aren't used for ordered items")
+		assert.Equal(t, "Gone", items[2].ItemName)
+	})
+
+	t.Run("Default locale renames the item and Stripe product", func(t *testing.T) {
+		assert.NoError(t, shop.SetShopItemTranslationContext(ctx, &ShopItemTranslation{ShopItemID: item.ID, Locale: "hr", ItemName: "Brisač"}, now))
+
+		product, _ := stripeServer.Product(item.StripeProductApiID)
+		assert.Equal(t, "Brisač", product.Name)
+
+		got := translated("hr")
+		assert.Equal(t, "Brisač", got.ItemName)
+		assert.Nil(t, got.ItemDescription)
+
+		translations, err := shop.FindShopItemTranslationsContext(ctx, item.ID)
+		if assert.NoError(t, err) && assert.Len(t, translations, 2) {
+			assert.Equal(t, "en", translations[0].Locale)
+			assert.Equal(t, "en-us", translations[1].Locale)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		assert.NoError(t, shop.DeleteShopItemTranslationContext(ctx, item.ID, "EN-us"))
+		assert.Equal(t, "Floor mop", translated("en-US").ItemName)
+
+		assert.True(t, errors.Is(shop.DeleteShopItemTranslationContext(ctx, item.ID, "en-us"), gorm.ErrRecordNotFound))
+		assert.Equal(t, ErrDefaultLocaleTranslation, shop.DeleteShopItemTranslationContext(ctx, item.ID, "hr"))
+	})
+
+	t.Run("Invalid translations", func(t *testing.T) {
+		err := shop.SetShopItemTranslationContext(ctx, &ShopItemTranslation{ShopItemID: item.ID, Locale: "english!", ItemName: " "}, now)
+
+		var validationErrors ValidationErrors
+		if assert.True(t, errors.As(err, &validationErrors)) {
+			assert.Equal(t, ValidationErrors{{Field: "locale", Err: ErrLocaleInvalid}, {Field: "item_name", Err: ErrItemNameBlank}}, validationErrors)
+		}
+
+		err = shop.SetShopItemTranslationContext(ctx, &ShopItemTranslation{ShopItemID: 99, Locale: "en", ItemName: "Mop"}, now)
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	})
+}
+
+func TestGormShopItemTranslationRepository_Save(t *testing.T) {
+	database, mock := newGormForTest(t)
+	description := "Floor mop"
+
+	findQuery := "SELECT * FROM `shop_item_translations` WHERE shop_item_id = ? AND locale = ? LIMIT 1"
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(findQuery)).WithArgs(4, "en").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "shop_item_id", "locale", "item_name"}).AddRow(6, 4, "en", "Mop"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `shop_item_translations` SET `item_description`=?,`item_name`=? WHERE `id` = ?")).
+		WithArgs(description, "Floor mop", 6).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	translation := &ShopItemTranslation{ShopItemID: 4, Locale: "en", ItemName: "Floor mop", ItemDescription: &description}
+	if assert.NoError(t, NewGormShopItemTranslationRepository(database).Save(context.Background(), translation)) {
+		assert.Equal(t, 6, translation.ID)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
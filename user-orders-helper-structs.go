@@ -1,10 +1,21 @@
 package mop_shop
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type CreateUserOrder struct {
-	userID     int
-	Items      []CreateUserOrderItem `json:"items"`
+	userID int
+	Items  []CreateUserOrderItem `json:"items"`
+	// ShippingMethod is the code of the ShippingMethod to use, required when any of the items is shippable
+	ShippingMethod string `json:"shipping_method"`
+	// ShippingAddress is optional, Stripe Checkout collects it when missing and any of the items is shippable
+	ShippingAddress *OrderAddress `json:"shipping_address"`
+	BillingAddress  *OrderAddress `json:"billing_address"`
+	// VATID of a business customer makes the order reverse charged when the business is from another country with
+	// VAT, see WithTaxes
+	VATID      string `json:"vat_id"`
 	totalPrice float32
 	createdAt  time.Time
 }
@@ -14,25 +25,42 @@ func NewCreateUserOrder(userID int) *CreateUserOrder {
 }
 
 func (c *CreateUserOrder) validate() error {
+	var validationErrors ValidationErrors
+
 	if c.userID == 0 {
-		return ErrInvalidUserID
+		validationErrors.add("user_id", ErrInvalidUserID)
 	}
 
 	if len(c.Items) == 0 {
-		return ErrOrderItemsEmpty
+		validationErrors.add("items", ErrOrderItemsEmpty)
 	}
 
 	for i := range c.Items {
 		if c.Items[i].ItemID <= 0 {
-			return ErrInvalidItemID
+			validationErrors.add(fmt.Sprintf("items[%d].item_id", i), ErrInvalidItemID)
 		}
 
 		if c.Items[i].Quantity <= 0 {
-			return ErrInvalidItemQuantity
+			validationErrors.add(fmt.Sprintf("items[%d].quantity", i), ErrInvalidItemQuantity)
+		}
+	}
+
+	if c.ShippingAddress != nil {
+		c.ShippingAddress.validate("shipping_address", &validationErrors)
+	}
+
+	if c.BillingAddress != nil {
+		c.BillingAddress.validate("billing_address", &validationErrors)
+	}
+
+	if len(c.VATID) > 0 {
+		c.VATID = normalizeVATID(c.VATID)
+		if !vatIDPattern.MatchString(c.VATID) {
+			validationErrors.add("vat_id", ErrVATIDInvalid)
 		}
 	}
 
-	return nil
+	return validationErrors.errOrNil()
 }
 
 type CreateUserOrderItem struct {
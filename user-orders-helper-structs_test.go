@@ -0,0 +1,55 @@
+package mop_shop
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCreateUserOrder_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		userID  int
+		items   []CreateUserOrderItem
+		wantErr error
+	}{
+		{
+			name:    "All is good",
+			userID:  1,
+			items:   []CreateUserOrderItem{{ItemID: 1, Quantity: 2}},
+			wantErr: nil,
+		},
+		{
+			name:   "User ID and items are required",
+			userID: 0,
+			items:  nil,
+			wantErr: ValidationErrors{
+				{Field: "user_id", Err: ErrInvalidUserID},
+				{Field: "items", Err: ErrOrderItemsEmpty},
+			},
+		},
+		{
+			name:   "Invalid items are reported by index",
+			userID: 1,
+			items: []CreateUserOrderItem{
+				{ItemID: 1, Quantity: 1},
+				{ItemID: 0, Quantity: 1},
+				{ItemID: 3, Quantity: 1},
+				{ItemID: 4, Quantity: -1},
+			},
+			wantErr: ValidationErrors{
+				{Field: "items[1].item_id", Err: ErrInvalidItemID},
+				{Field: "items[3].quantity", Err: ErrInvalidItemQuantity},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCreateUserOrder(tt.userID)
+			c.Items = tt.items
+
+			validationErr := c.validate()
+			assert.Equal(t, tt.wantErr, validationErr, "validate() error = %v, wantErr %v", validationErr, tt.wantErr)
+		})
+	}
+}
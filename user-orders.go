@@ -1,17 +1,17 @@
 package mop_shop
 
 import (
+	"context"
+	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/shopspring/decimal"
+	"github.com/stripe/stripe-go/v72"
 	"github.com/stripe/stripe-go/v72/checkout/session"
 	"gorm.io/gorm"
-	"log"
 	"net/http"
-	"net/url"
-	"strconv"
-	"strings"
+	"sort"
 	"time"
 )
 
@@ -24,8 +24,31 @@ type UserOrder struct {
 	UpdatedAt               time.Time `json:"updated_at"`
 	StripeClientReferenceID string    `gorm:"type:varchar(36);" json:"stripe_client_reference_id"`
 	IsCompleted             bool      `gorm:"default:false;" json:"-"`
-	orderItems              map[int]ItemWithStripeInfo
-	db                      *gorm.DB
+	// ShippingMethod is the code of the ShippingMethod the order is shipped with, nil when nothing needs shipping
+	ShippingMethod *string `gorm:"type:varchar(64);default:null;" json:"shipping_method"`
+	// ShippingPrice is included in TotalPrice
+	ShippingPrice float32 `gorm:"not null;default:0;" json:"shipping_price"`
+	// ShippingAddress is given in CreateUserOrder or, when missing, collected by Stripe Checkout
+	ShippingAddress *OrderAddress `gorm:"type:text;default:null;" json:"shipping_address"`
+	BillingAddress  *OrderAddress `gorm:"type:text;default:null;" json:"billing_address"`
+	// TaxAmount is the VAT of TaxLines, included in TotalPrice either way
+	TaxAmount float32       `gorm:"not null;default:0;" json:"tax_amount"`
+	TaxLines  OrderTaxLines `gorm:"type:text;default:null;" json:"tax_lines"`
+	VATID     *string       `gorm:"column:vat_id;type:varchar(20);default:null;" json:"vat_id"`
+	// ReverseCharge orders are charged no VAT, the customer owes it instead. When prices include tax, TotalPrice
+	// leaves out the VAT they include.
+	ReverseCharge bool `gorm:"not null;default:false;" json:"reverse_charge"`
+	// ItemSnapshots are taken by PrepareForOrder and stored by CreateEmptyOrder, so the order items created after
+	// checkout look like the items did when the order was placed
+	ItemSnapshots   OrderItemSnapshots `gorm:"type:text;default:null;" json:"-"`
+	orderItems      map[int]ItemWithStripeInfo
+	shippingMethods []ShippingMethod
+	taxes           *TaxConfig
+	invoicing       *invoiceIssuer
+	orders          OrderRepository
+	items           ShopItemRepository
+	translations    ShopItemTranslationRepository
+	logger          Logger
 }
 
 func (o *UserOrder) TableName() string {
@@ -37,31 +60,36 @@ func (o *UserOrder) GetOrderItems() map[int]ItemWithStripeInfo {
 }
 
 func NewUserOrder(db *gorm.DB) *UserOrder {
-	return &UserOrder{db: db}
+	return defaultShop(db).NewUserOrder()
+}
+
+func (o *UserOrder) log(ctx context.Context, level Level, msg string, keysAndValues ...interface{}) {
+	logWithContext(ctx, loggerOrNop(o.logger), level, msg, append([]interface{}{"user_order_id", o.ID}, keysAndValues...)...)
 }
 
 type ItemWithStripeInfo struct {
 	ItemID                     int
+	SKU                        *string
+	ItemName                   string
+	ItemPicture                *string
+	ItemDescription            *string
 	UniqueStripePriceLookupKey string
 	ItemPrice                  float32
 	ItemSalePrice              *float32
 	StripeProductApiID         string
+	Shippable                  bool
+	WeightGrams                *int
+	TaxClass                   TaxClass
 	// Price is a virtual helper field
 	Price float32
 	// Quantity is a virtual field and is being used as quantity when creating stripe.CheckoutSessionLineItemParams
 	Quantity int
 }
 
-func findItemsWithStripeInfo(itemIDs []int, db *gorm.DB) (map[int]ItemWithStripeInfo, error) {
-	var data []ItemWithStripeInfo
-	query := `SELECT 
-			id AS item_id, stripe_product_api_id, unique_stripe_price_lookup_key, 
-			item_price, item_sale_price, quantity 
-		FROM shop_items WHERE id IN (?)`
-
-	if err := db.Debug().Raw(query, itemIDs).Scan(&data).Error; err != nil {
-		log.Printf("error while getting findItemsWithStripeInfo: %v\n", err)
-		return nil, ErrInternal
+func findItemsWithStripeInfo(ctx context.Context, itemIDs []int, items ShopItemRepository) (map[int]ItemWithStripeInfo, error) {
+	data, err := items.FindWithStripeInfoByIDs(ctx, itemIDs)
+	if err != nil {
+		return nil, wrapInternal("findItemsWithStripeInfo: query shop items", err)
 	}
 
 	mapToReturn := make(map[int]ItemWithStripeInfo, len(data))
@@ -80,19 +108,33 @@ func findItemsWithStripeInfo(itemIDs []int, db *gorm.DB) (map[int]ItemWithStripe
 }
 
 func (o *UserOrder) CreateEmptyOrder(userID int, clientReferenceID string) error {
-	query := `INSERT INTO user_orders (user_id, total_price, created_at, stripe_client_reference_id) VALUES (?, ?, ?, ?)`
+	return o.CreateEmptyOrderContext(context.Background(), userID, clientReferenceID)
+}
+
+func (o *UserOrder) CreateEmptyOrderContext(ctx context.Context, userID int, clientReferenceID string) error {
+	if o.orders == nil {
+		return ErrOrderNotInitializedProperly
+	}
+
+	o.UserID = userID
+	o.TotalPrice = 0
+	o.CreatedAt = time.Now()
+	o.UpdatedAt = o.CreatedAt
+	o.StripeClientReferenceID = clientReferenceID
 
-	if err := o.db.Debug().Exec(query, userID, 0, time.Now(), clientReferenceID).Error; err != nil {
-		log.Printf("error while creating empty order: %v\n", err)
-		return ErrInternal
+	if err := o.orders.CreateEmptyOrder(ctx, o); err != nil {
+		o.log(ctx, LevelError, "error while creating empty order", "user_id", userID, "client_reference_id", clientReferenceID, "error", err)
+		return wrapInternal("UserOrder.CreateEmptyOrder: insert user order", err)
 	}
 
 	return nil
 }
 
 // Method returns map of type map[itemID]ItemWithStripeInfo{}
-func (o *UserOrder) getProductsFromOrderBySessionID(sessionID string) (map[string]ItemWithStripeInfo, error) {
-	i := session.ListLineItems(sessionID, nil)
+func (o *UserOrder) getProductsFromOrderBySessionID(ctx context.Context, sessionID string) (map[string]ItemWithStripeInfo, error) {
+	params := &stripe.CheckoutSessionListLineItemsParams{}
+	params.Context = ctx
+	i := session.ListLineItems(sessionID, params)
 	var productStripeIDs []string
 
 	products := make(map[string]ItemWithStripeInfo)
@@ -108,16 +150,24 @@ func (o *UserOrder) getProductsFromOrderBySessionID(sessionID string) (map[strin
 		}
 	}
 
-	var dbShopItems []ItemWithStripeInfo
-	query := `SELECT id AS item_id, unique_stripe_price_lookup_key, item_price, item_sale_price, stripe_product_api_id FROM shop_items WHERE stripe_product_api_id IN (?)`
-	if err := o.db.Debug().Raw(query, productStripeIDs).Scan(&dbShopItems).Error; err != nil {
-		log.Printf("error while getting shop items: %v\n", err)
-		return nil, ErrInternal
+	if err := i.Err(); err != nil {
+		o.log(ctx, LevelError, "error while listing checkout session line items", "stripe_session_id", sessionID, "error", err)
+		return nil, wrapInternal("UserOrder.getProductsFromOrderBySessionID: list stripe line items", err)
+	}
+
+	dbShopItems, err := o.items.FindWithStripeInfoByStripeProductIDs(ctx, productStripeIDs)
+	if err != nil {
+		o.log(ctx, LevelError, "error while getting shop items", "stripe_session_id", sessionID, "error", err)
+		return nil, wrapInternal("UserOrder.getProductsFromOrderBySessionID: query shop items", err)
 	}
 
 	for j := range dbShopItems {
 		if obj, ok := products[dbShopItems[j].StripeProductApiID]; ok {
 			obj.ItemID = dbShopItems[j].ItemID
+			obj.SKU = dbShopItems[j].SKU
+			obj.ItemName = dbShopItems[j].ItemName
+			obj.ItemPicture = dbShopItems[j].ItemPicture
+			obj.ItemDescription = dbShopItems[j].ItemDescription
 			products[dbShopItems[j].StripeProductApiID] = obj
 		}
 	}
@@ -125,109 +175,128 @@ func (o *UserOrder) getProductsFromOrderBySessionID(sessionID string) (map[strin
 	return products, nil
 }
 
+// collectedShippingAddress returns the shipping address the customer entered in Stripe Checkout, or nil
+func (o *UserOrder) collectedShippingAddress(ctx context.Context, sessionID string) (*OrderAddress, error) {
+	params := &stripe.CheckoutSessionParams{}
+	params.Context = ctx
+
+	checkoutSession, err := session.Get(sessionID, params)
+	if err != nil {
+		o.log(ctx, LevelError, "error while getting checkout session", "stripe_session_id", sessionID, "error", err)
+		return nil, wrapInternal("UserOrder.collectedShippingAddress: get stripe checkout session", err)
+	}
+
+	return orderAddressFromStripe(checkoutSession.Shipping), nil
+}
+
 func (o *UserOrder) UpdateEmptyOrderAfterCheckout(sessionID, clientReferenceID string, totalPrice float32) error {
+	return o.UpdateEmptyOrderAfterCheckoutContext(context.Background(), sessionID, clientReferenceID, totalPrice)
+}
+
+func (o *UserOrder) UpdateEmptyOrderAfterCheckoutContext(ctx context.Context, sessionID, clientReferenceID string, totalPrice float32) error {
 	if o.ID == 0 {
 		return ErrInvalidUserOrderID
 	}
 
+	if o.orders == nil || o.items == nil {
+		return ErrOrderNotInitializedProperly
+	}
+
 	o.TotalPrice = totalPrice
 
-	products, err := o.getProductsFromOrderBySessionID(sessionID)
+	products, err := o.getProductsFromOrderBySessionID(ctx, sessionID)
 	if err != nil {
 		return err
 	}
 
-	tx := o.db.Debug().Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
-
-	query := `UPDATE user_orders SET updated_at = ?, is_completed = ?, total_price = ?, stripe_session_id = ? WHERE stripe_client_reference_id = ?`
-
-	orderQuery := tx.Debug().Exec(query, time.Now(), true, totalPrice, sessionID, clientReferenceID)
-
-	if err := orderQuery.Error; err != nil {
-		tx.Rollback()
-		log.Printf("error while updating user order: %v\n", err)
-		return ErrInternal
+	completion := OrderCompletion{
+		ClientReferenceID: clientReferenceID,
+		StripeSessionID:   sessionID,
+		TotalPrice:        totalPrice,
+		CompletedAt:       time.Now(),
 	}
 
-	if orderQuery.RowsAffected == 0 {
-		tx.Rollback()
-		log.Printf("user order with client reference id %q not found\n", clientReferenceID)
-		return gorm.ErrRecordNotFound
+	if o.ShippingMethod != nil && o.ShippingAddress == nil {
+		if completion.ShippingAddress, err = o.collectedShippingAddress(ctx, sessionID); err != nil {
+			return err
+		}
 	}
 
-	var orderItemsQuerySB strings.Builder
-	var orderItemsQueryParams []interface{}
-
-	orderItemsQuery := `INSERT INTO user_order_items (user_order_id, shop_item_id, item_price, quantity) VALUES `
-	orderItemsQuerySB.WriteString(orderItemsQuery)
-	var itemIDs []int
-
-	lastAvailableIndex := len(products) - 1
-	counter := 0
 	for i := range products {
-		if counter == lastAvailableIndex {
-			orderItemsQuerySB.WriteString(`(?, ?, ?, ?) `)
-		} else {
-			orderItemsQuerySB.WriteString(`(?, ?, ?, ?), `)
+		// line items of no shop item, such as the VAT added by ApplyCheckoutTaxes, aren't order items
+		if products[i].ItemID == 0 {
+			continue
 		}
 
-		orderItemsQueryParams = append(orderItemsQueryParams, o.ID, products[i].ItemID, products[i].Price, products[i].Quantity)
-		itemIDs = append(itemIDs, products[i].ItemID)
-		counter++
-	}
-
-	if err := tx.Debug().Exec(orderItemsQuerySB.String(), orderItemsQueryParams...).Error; err != nil {
-		tx.Rollback()
-		log.Printf("error while inserting user order items: %v\n", err)
-		return ErrInternal
-	}
-
-	updateShopItemsQuantityQuery := `UPDATE shop_items SET quantity = ( CASE id `
+		orderItem := UserOrderItem{
+			UserOrderID:     o.ID,
+			ShopItemID:      products[i].ItemID,
+			SKU:             products[i].SKU,
+			ItemName:        products[i].ItemName,
+			ItemPicture:     products[i].ItemPicture,
+			ItemDescription: products[i].ItemDescription,
+			ItemPrice:       products[i].Price,
+			Quantity:        products[i].Quantity,
+		}
 
-	var updateQuantityQ strings.Builder
-	updateQuantityQ.WriteString(updateShopItemsQuantityQuery)
+		// orders placed without PrepareForOrder have no snapshot and keep what the item has now
+		if snapshot := o.ItemSnapshots.find(products[i].ItemID); snapshot != nil {
+			orderItem.SKU, orderItem.ItemName, orderItem.ItemPicture, orderItem.ItemDescription = snapshot.SKU, snapshot.ItemName, snapshot.ItemPicture, snapshot.ItemDescription
+			orderItem.ItemTranslations = snapshot.Translations
+		}
 
-	for s := range products {
-		updateQuantityQ.WriteString(fmt.Sprintf(`WHEN %d THEN quantity - %d`, products[s].ItemID, products[s].Quantity))
+		completion.Items = append(completion.Items, orderItem)
 	}
 
-	updateQuantityQ.WriteString(` END) WHERE id IN (?)`)
+	if err := o.orders.CompleteOrder(ctx, completion); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			o.log(ctx, LevelInfo, "user order not found", "client_reference_id", clientReferenceID)
+			return err
+		}
 
-	if err := tx.Debug().Exec(updateQuantityQ.String(), itemIDs).Error; err != nil {
-		tx.Rollback()
-		log.Printf("error while bulk-updating quantity of shop items: %v\n", err)
-		return ErrInternal
+		o.log(ctx, LevelError, "error while completing user order", "client_reference_id", clientReferenceID, "error", err)
+		return wrapInternal("UserOrder.UpdateEmptyOrderAfterCheckout: complete user order", err)
 	}
 
-	if err := tx.Commit().Error; err != nil {
-		log.Printf("error while comitting transaction in userOrder.UpdateEmptyOrderAfterCheckout: %v\n", err)
-		return ErrCommittingTransaction
+	if o.invoicing != nil {
+		// the order is completed either way, Shop.IssueInvoice retries issuing its invoice
+		if _, err := o.invoicing.issue(ctx, o.ID, o.UserID); err != nil {
+			o.log(ctx, LevelError, "error while issuing invoice", "user_order_id", o.ID, "user_id", o.UserID, "error", err)
+		}
 	}
 
 	return nil
 }
 
 func (o *UserOrder) FindOneByClientReferenceID(clientReferenceID string, orderCompleted bool) error {
-	query := `SELECT * FROM user_orders WHERE stripe_client_reference_id = ? AND is_completed = ?`
+	return o.FindOneByClientReferenceIDContext(context.Background(), clientReferenceID, orderCompleted)
+}
+
+func (o *UserOrder) FindOneByClientReferenceIDContext(ctx context.Context, clientReferenceID string, orderCompleted bool) error {
+	if o.orders == nil {
+		return ErrOrderNotInitializedProperly
+	}
 
-	if err := o.db.Debug().Raw(query, clientReferenceID, orderCompleted).Take(o).Error; err != nil {
+	order, err := o.orders.FindOneByClientReferenceID(ctx, clientReferenceID, orderCompleted)
+	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return err
 		}
 
-		log.Printf("error while getting user order by client reference id: %v\n", err)
-		return ErrInternal
+		o.log(ctx, LevelError, "error while getting user order by client reference id", "client_reference_id", clientReferenceID, "error", err)
+		return wrapInternal("UserOrder.FindOneByClientReferenceID: query user order", err)
 	}
 
+	order.orders, order.items, order.translations, order.shippingMethods, order.taxes, order.invoicing, order.logger = o.orders, o.items, o.translations, o.shippingMethods, o.taxes, o.invoicing, o.logger
+	*o = *order
 	return nil
 }
 
 func (o *UserOrder) PrepareForOrder(data *CreateUserOrder) error {
+	return o.PrepareForOrderContext(context.Background(), data)
+}
+
+func (o *UserOrder) PrepareForOrderContext(ctx context.Context, data *CreateUserOrder) error {
 	o.UserID = data.userID
 
 	if data == nil {
@@ -238,13 +307,18 @@ func (o *UserOrder) PrepareForOrder(data *CreateUserOrder) error {
 		return err
 	}
 
+	if o.items == nil {
+		return ErrOrderNotInitializedProperly
+	}
+
 	var itemIDs []int
 	for i := range data.Items {
 		itemIDs = append(itemIDs, data.Items[i].ItemID)
 	}
 
-	itemsWithStripeInfo, err := findItemsWithStripeInfo(itemIDs, o.db)
+	itemsWithStripeInfo, err := findItemsWithStripeInfo(ctx, itemIDs, o.items)
 	if err != nil {
+		o.log(ctx, LevelError, "error while getting items with stripe info", "user_id", o.UserID, "error", err)
 		return err
 	}
 
@@ -252,7 +326,8 @@ func (o *UserOrder) PrepareForOrder(data *CreateUserOrder) error {
 		return ErrSomeItemsDoNotExist
 	}
 
-	orderTotalPriceAmount := float32(0)
+	subtotal, weightGrams, requiresShipping := int64(0), 0, false
+	taxableAmounts := make([]taxableAmount, 0, len(data.Items)+1)
 
 	for i := range data.Items {
 		if obj, ok := itemsWithStripeInfo[data.Items[i].ItemID]; ok {
@@ -270,28 +345,147 @@ func (o *UserOrder) PrepareForOrder(data *CreateUserOrder) error {
 			obj.Quantity = data.Items[i].Quantity
 			itemsWithStripeInfo[data.Items[i].ItemID] = obj
 
-			orderTotalPriceAmount += price
+			subtotal += int64(price) * int64(data.Items[i].Quantity)
+			taxableAmounts = append(taxableAmounts, taxableAmount{class: obj.TaxClass, amount: int64(price) * int64(data.Items[i].Quantity), quantity: int64(data.Items[i].Quantity)})
+
+			if obj.Shippable {
+				requiresShipping = true
+
+				if obj.WeightGrams != nil {
+					weightGrams += *obj.WeightGrams * data.Items[i].Quantity
+				}
+			}
 		}
 	}
 
-	o.TotalPrice = orderTotalPriceAmount
+	if err := o.prepareShipping(data, requiresShipping, subtotal, weightGrams); err != nil {
+		return err
+	}
+
+	if o.ShippingPrice > 0 {
+		taxableAmounts = append(taxableAmounts, taxableAmount{class: TaxClassStandard, amount: int64(o.ShippingPrice)})
+	}
+
+	o.prepareTaxes(data, taxableAmounts)
+
+	if err := o.snapshotItems(ctx, itemsWithStripeInfo); err != nil {
+		return err
+	}
+
+	o.TotalPrice = o.totalPrice(subtotal)
 	o.orderItems = itemsWithStripeInfo
 
 	return nil
 }
 
+// snapshotItems sets ItemSnapshots to the details and translations the items have now
+func (o *UserOrder) snapshotItems(ctx context.Context, items map[int]ItemWithStripeInfo) error {
+	itemIDs := make([]int, 0, len(items))
+	for id := range items {
+		itemIDs = append(itemIDs, id)
+	}
+	sort.Ints(itemIDs)
+
+	translations := map[int][]ShopItemTranslation{}
+	if o.translations != nil {
+		data, err := o.translations.FindByShopItemIDs(ctx, itemIDs, nil)
+		if err != nil {
+			o.log(ctx, LevelError, "error while getting shop item translations", "user_id", o.UserID, "error", err)
+			return wrapInternal("UserOrder.PrepareForOrder: query translations", err)
+		}
+
+		for _, translation := range data {
+			translations[translation.ShopItemID] = append(translations[translation.ShopItemID], translation)
+		}
+	}
+
+	o.ItemSnapshots = make(OrderItemSnapshots, 0, len(itemIDs))
+	for _, id := range itemIDs {
+		o.ItemSnapshots = append(o.ItemSnapshots, OrderItemSnapshot{
+			ShopItemID:      id,
+			SKU:             items[id].SKU,
+			ItemName:        items[id].ItemName,
+			ItemPicture:     items[id].ItemPicture,
+			ItemDescription: items[id].ItemDescription,
+			Translations:    orderItemTranslations(translations[id]),
+		})
+	}
+
+	return nil
+}
+
 type UserOrderItem struct {
-	ID          int     `gorm:"primaryKey;" json:"id"`
-	UserOrderID int     `gorm:"not null;index:ix_user_order_item_order_id;" json:"user_order_id"`
-	ShopItemID  int     `gorm:"not null;" json:"shop_item_id"`
-	ItemPrice   float32 `gorm:"not null;" json:"item_price"`
-	Quantity    int     `gorm:"not null;"`
+	ID          int `gorm:"primaryKey;" json:"id"`
+	UserOrderID int `gorm:"not null;index:ix_user_order_item_order_id;" json:"user_order_id"`
+	ShopItemID  int `gorm:"not null;" json:"shop_item_id"`
+	// SKU, ItemName, ItemPicture and ItemDescription are what the item had when it was ordered, so later changes
+	// to the item don't change how past orders look
+	SKU             *string `gorm:"type:varchar(64);default:null;" json:"sku"`
+	ItemName        string  `gorm:"type:varchar(255);" json:"item_name"`
+	ItemPicture     *string `gorm:"type:varchar(255);default:null;" json:"item_picture"`
+	ItemDescription *string `gorm:"type:text;default:null;" json:"item_description"`
+	// ItemTranslations are the translations the item had when it was ordered
+	ItemTranslations OrderItemTranslations `gorm:"type:text;default:null;" json:"item_translations"`
+	// ItemPrice is the unit price charged by Stripe
+	ItemPrice float32 `gorm:"not null;" json:"item_price"`
+	Quantity  int     `gorm:"not null;"`
 }
 
 func (i *UserOrderItem) TableName() string {
 	return "user_order_items"
 }
 
+// OrderItemSnapshot is what an item of an order looked like when the order was placed
+type OrderItemSnapshot struct {
+	ShopItemID      int                   `json:"shop_item_id"`
+	SKU             *string               `json:"sku"`
+	ItemName        string                `json:"item_name"`
+	ItemPicture     *string               `json:"item_picture"`
+	ItemDescription *string               `json:"item_description"`
+	Translations    OrderItemTranslations `json:"translations"`
+}
+
+// OrderItemSnapshots is stored as a JSON array, ordered by ShopItemID
+type OrderItemSnapshots []OrderItemSnapshot
+
+func (l OrderItemSnapshots) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal([]OrderItemSnapshot(l))
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func (l *OrderItemSnapshots) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(data, l)
+	case string:
+		return json.Unmarshal([]byte(data), l)
+	default:
+		return fmt.Errorf("unsupported order item snapshots type %T", value)
+	}
+}
+
+// find returns the snapshot of the shop item, or nil
+func (l OrderItemSnapshots) find(shopItemID int) *OrderItemSnapshot {
+	for i := range l {
+		if l[i].ShopItemID == shopItemID {
+			return &l[i]
+		}
+	}
+
+	return nil
+}
+
 // UserOrderFrontResponse struct should be used for user requests such as getting all user orders, single user order
 type UserOrderFrontResponse struct {
 	ID              int       `json:"id"`
@@ -301,20 +495,35 @@ type UserOrderFrontResponse struct {
 	CreatedAt       time.Time `json:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at"`
 	IsCompleted     bool      `json:"-"`
+	// ShippingMethod, ShippingPrice and the addresses are only returned for a single order
+	ShippingMethod     *string       `json:"shipping_method,omitempty"`
+	ShippingPriceInt64 *int64        `json:"shipping_price_int_64,omitempty"`
+	ShippingPrice      *float64      `json:"shipping_price,omitempty"`
+	ShippingAddress    *OrderAddress `json:"shipping_address,omitempty"`
+	BillingAddress     *OrderAddress `json:"billing_address,omitempty"`
+	// TaxAmount and TaxLines are only returned for a single order of a shop charging VAT
+	TaxAmountInt64 *int64                          `json:"tax_amount_int_64,omitempty"`
+	TaxAmount      *float64                        `json:"tax_amount,omitempty"`
+	TaxLines       []UserOrderTaxLineFrontResponse `json:"tax_lines,omitempty"`
+	VATID          *string                         `json:"vat_id,omitempty"`
+	ReverseCharge  bool                            `json:"reverse_charge,omitempty"`
+	// Deprecated: RawItems is no longer populated, items are always returned in Items
+	RawItems json.RawMessage `json:"raw_items,omitempty"`
 	// Items will not be shown in JSON response if it's nil!
-	RawItems json.RawMessage              `json:"raw_items,omitempty"`
-	Items    []UserOrderItemFrontResponse `gorm:"-" json:"items,omitempty"` // GORM -> Ignore this field as it will be manually unmarshalled
-	db       *gorm.DB
+	Items []UserOrderItemFrontResponse `gorm:"-" json:"items,omitempty"`
 }
 
 type UserOrderItemFrontResponse struct {
 	ItemID          int      `json:"item_id"`
+	SKU             *string  `json:"sku"`
 	ItemName        string   `json:"item_name"`
 	ItemPriceInt64  *int64   `json:"item_price_int_64,omitempty"`
 	ItemPrice       *float64 `json:"item_price"`
 	ItemPicture     string   `json:"item_picture"`
 	ItemDescription string   `json:"item_description"`
 	Quantity        int      `json:"quantity"`
+	// translations are the ones the item had when it was ordered, see translateOrderItems
+	translations OrderItemTranslations
 }
 
 func (f *UserOrderItemFrontResponse) UnmarshalJSON(data []byte) error {
@@ -344,42 +553,35 @@ func getCurrentURL(req *http.Request) string {
 }
 
 func FindOrdersByUserID(userID int, queryCompletedOrders bool, db *gorm.DB, paginationParams PaginationParams, currency string, req *http.Request) ([]UserOrderFrontResponse, *PaginationResponse, error) {
-	paginationParams.normalize()
+	return defaultShop(db).FindOrdersByUserID(userID, queryCompletedOrders, paginationParams, currency, req)
+}
 
-	query := `SELECT 
-			uo.id, uo.total_price AS total_price_int_64, uo.created_at, uo.updated_at, uo.is_completed
-		FROM user_orders uo
-		INNER JOIN users u ON u.id = uo.user_id AND u.deleted_at IS NULL
-		INNER JOIN user_order_items uoi ON uoi.user_order_id = uo.id
-		INNER JOIN shop_items si ON si.id = uoi.shop_item_id
-		WHERE uo.user_id = ? `
+func FindOrdersByUserIDContext(ctx context.Context, userID int, queryCompletedOrders bool, db *gorm.DB, paginationParams PaginationParams, currency string, req *http.Request) ([]UserOrderFrontResponse, *PaginationResponse, error) {
+	return defaultShop(db).FindOrdersByUserIDContext(ctx, userID, queryCompletedOrders, paginationParams, currency, req)
+}
 
-	var userOrdersQuery strings.Builder
-	params := []interface{}{userID}
+// FindOrdersByUserID works like the package-level FindOrdersByUserID using the request's context
+func (s *Shop) FindOrdersByUserID(userID int, queryCompletedOrders bool, paginationParams PaginationParams, currency string, req *http.Request) ([]UserOrderFrontResponse, *PaginationResponse, error) {
+	return s.FindOrdersByUserIDContext(req.Context(), userID, queryCompletedOrders, paginationParams, currency, req)
+}
 
-	userOrdersQuery.WriteString(query)
-	if queryCompletedOrders {
-		userOrdersQuery.WriteString(`AND uo.is_completed = TRUE `)
-	}
+func (s *Shop) FindOrdersByUserIDContext(ctx context.Context, userID int, queryCompletedOrders bool, paginationParams PaginationParams, currency string, req *http.Request) ([]UserOrderFrontResponse, *PaginationResponse, error) {
+	ctx = contextWithRequestHeader(ctx, req)
+	paginationParams.normalize()
 
-	switch {
-	case paginationParams.Before == 0 && paginationParams.After == 0:
-		userOrdersQuery.WriteString(`AND uo.id > ? ORDER BY uo.id DESC LIMIT ?`)
-		params = append(params, 0)
-	case paginationParams.After > 0:
-		userOrdersQuery.WriteString(`AND uo.id <= ? ORDER BY uo.id DESC LIMIT ?`)
-		params = append(params, paginationParams.After)
-	case paginationParams.Before > 0:
-		userOrdersQuery.WriteString(`AND uo.id >= ? ORDER BY uo.id ASC LIMIT ?`)
-		params = append(params, paginationParams.Before)
+	active, err := s.userIsActive(ctx, userID)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	params = append(params, paginationParams.PerPage+1)
-
 	var data []UserOrderFrontResponse
-	if err := db.Debug().Raw(userOrdersQuery.String(), params...).Scan(&data).Error; err != nil {
-		log.Printf("error while getting user orders: %v\n", err)
-		return nil, nil, ErrInternal
+	if active {
+		data, err = s.orders.FindByUserID(ctx, userID, queryCompletedOrders, paginationParams)
+	}
+
+	if err != nil {
+		s.log(ctx, LevelError, "error while getting user orders", "user_id", userID, "error", err)
+		return nil, nil, wrapInternal("FindOrdersByUserID: query user orders", err)
 	}
 
 	for i := range data {
@@ -396,128 +598,57 @@ func FindOrdersByUserID(userID int, queryCompletedOrders bool, db *gorm.DB, pagi
 		data = []UserOrderFrontResponse{}
 	}
 
-	pages := PaginationResponse{}
+	ids := make([]int, len(data))
+	for i := range data {
+		ids[i] = data[i].ID
+	}
 
-	parsedURL, err := url.Parse(getCurrentURL(req))
+	pages, from, to, err := pageCursors(req, paginationParams, ids)
 	if err != nil {
-		return nil, nil, ErrParsingURL
+		return nil, nil, err
 	}
 
-	beforeQ := parsedURL.Query()
-	afterQ := parsedURL.Query()
-
-	switch {
-	case len(data) == paginationParams.PerPage+1:
-
-		if paginationParams.After > 0 {
-			beforeId := strconv.Itoa(data[0].ID + 1)
-
-			beforeQ.Del("after")
-			beforeQ.Set("before", beforeId)
-			parsedURL.RawQuery = beforeQ.Encode()
-			cursorBefore := parsedURL.String()
-
-			pages.CursorBefore = &cursorBefore
-			pages.Before = &beforeId
-		} else if paginationParams.Before > 0 {
-			beforeId := strconv.Itoa(data[0].ID)
-
-			beforeQ.Del("after")
-			beforeQ.Set("before", beforeId)
-			parsedURL.RawQuery = beforeQ.Encode()
-			cursorBefore := parsedURL.String()
-
-			pages.Before = &beforeId
-			pages.CursorBefore = &cursorBefore
-		}
+	return data[from:to], pages, nil
+}
 
-		afterId := strconv.Itoa(data[len(data)-1].ID)
+func FindOrderByByIDAndUserID(orderID, userID int, queryCompletedOrder bool, db *gorm.DB, currency, locale string) (*UserOrderFrontResponse, error) {
+	return defaultShop(db).FindOrderByByIDAndUserID(orderID, userID, queryCompletedOrder, currency, locale)
+}
 
-		afterQ.Set("after", afterId)
-		parsedURL.RawQuery = afterQ.Encode()
-		cursorAfter := parsedURL.String()
+func FindOrderByByIDAndUserIDContext(ctx context.Context, orderID, userID int, queryCompletedOrder bool, db *gorm.DB, currency, locale string) (*UserOrderFrontResponse, error) {
+	return defaultShop(db).FindOrderByByIDAndUserIDContext(ctx, orderID, userID, queryCompletedOrder, currency, locale)
+}
 
-		pages.CursorAfter = &cursorAfter
-		pages.After = &afterId
+// FindOrderByByIDAndUserID works like the package-level FindOrderByByIDAndUserID
+func (s *Shop) FindOrderByByIDAndUserID(orderID, userID int, queryCompletedOrder bool, currency, locale string) (*UserOrderFrontResponse, error) {
+	return s.FindOrderByByIDAndUserIDContext(context.Background(), orderID, userID, queryCompletedOrder, currency, locale)
+}
 
-		if paginationParams.Before > 0 {
-			afterId := strconv.Itoa(data[len(data)-1].ID - 1)
+// FindOrderByByIDAndUserIDContext returns the order with its items, whose names and descriptions are translated to
+// locale like in GetShopItemsForFrontend, using the translations the items had when the order was placed
+func (s *Shop) FindOrderByByIDAndUserIDContext(ctx context.Context, orderID, userID int, queryCompletedOrder bool, currency, locale string) (*UserOrderFrontResponse, error) {
+	active, err := s.userIsActive(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
 
-			afterQ.Del("before")
-			afterQ.Set("after", afterId)
-			parsedURL.RawQuery = afterQ.Encode()
-			cursorAfter := parsedURL.String()
+	if !active {
+		return nil, gorm.ErrRecordNotFound
+	}
 
-			pages.After = &afterId
-			pages.CursorAfter = &cursorAfter
-			data = data[1:]
-		} else {
-			data = data[:len(data)-1]
-		}
-	case len(data) <= paginationParams.PerPage && paginationParams.Before > 0:
-		afterId := strconv.Itoa(data[len(data)-1].ID - 1)
-
-		afterQ.Del("before")
-		afterQ.Set("after", afterId)
-		parsedURL.RawQuery = afterQ.Encode()
-		cursorAfter := parsedURL.String()
-
-		pages.After = &afterId
-		pages.CursorAfter = &cursorAfter
-	case len(data) <= paginationParams.PerPage && paginationParams.After > 0:
-		beforeId := strconv.Itoa(data[0].ID + 1)
-
-		beforeQ.Del("after")
-		beforeQ.Set("before", beforeId)
-		parsedURL.RawQuery = beforeQ.Encode()
-		cursorBefore := parsedURL.String()
-
-		pages.Before = &beforeId
-		pages.CursorBefore = &cursorBefore
-	}
-
-	return data, &pages, nil
-}
-
-func FindOrderByByIDAndUserID(orderID, userID int, queryCompletedOrder bool, db *gorm.DB, currency string) (*UserOrderFrontResponse, error) {
-	completedQuery := ""
-	if queryCompletedOrder {
-		completedQuery = "AND uo.is_completed = TRUE"
-	}
-
-	query := fmt.Sprintf(`SELECT 
-			uo.id, uo.total_price AS total_price_int_64, uo.created_at, uo.updated_at, uo.is_completed, json_arrayagg(
-				json_object(
-					'item_id', si.id,
-					'item_name', si.item_name,
-					'item_price_int_64', uoi.item_price,
-					'item_picture', si.item_picture,
-					'item_description', si.item_description,
-					'quantity', uoi.quantity
-				)
-			) AS raw_items 
-		FROM user_orders uo
-		INNER JOIN users u ON u.id = uo.user_id AND u.deleted_at IS NULL
-		INNER JOIN user_order_items uoi ON uoi.user_order_id = uo.id
-		INNER JOIN shop_items si ON si.id = uoi.shop_item_id
-		WHERE uo.user_id = ? AND uo.id = ? %s
-		GROUP BY uo.id`, completedQuery)
-
-	data := UserOrderFrontResponse{}
-	if err := db.Debug().Raw(query, userID, orderID).Take(&data).Error; err != nil {
+	data, err := s.orders.FindOneByIDAndUserID(ctx, orderID, userID, queryCompletedOrder)
+	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, err
 		}
 
-		log.Printf("error while getting user orders: %v\n", err)
-		return nil, ErrInternal
+		s.log(ctx, LevelError, "error while getting user order", "user_order_id", orderID, "user_id", userID, "error", err)
+		return nil, wrapInternal("FindOrderByByIDAndUserID: query user order", err)
 	}
 
 	data.Currency = currency
-	if err := json.Unmarshal(data.RawItems, &data.Items); err != nil {
-		log.Printf("error while unmarshalling user order items: %v\n", err)
-		return nil, ErrInternal
-	}
+
+	s.translateOrderItems(data.Items, locale)
 
 	if data.TotalPriceInt64 != nil && *data.TotalPriceInt64 != 0 {
 		price, _ := decimal.New(*data.TotalPriceInt64, -2).Float64()
@@ -525,6 +656,18 @@ func FindOrderByByIDAndUserID(orderID, userID int, queryCompletedOrder bool, db
 		data.TotalPriceInt64 = nil
 	}
 
+	if data.ShippingPriceInt64 != nil {
+		price, _ := decimal.New(*data.ShippingPriceInt64, -2).Float64()
+		data.ShippingPrice = &price
+		data.ShippingPriceInt64 = nil
+	}
+
+	if data.TaxAmountInt64 != nil {
+		amount, _ := decimal.New(*data.TaxAmountInt64, -2).Float64()
+		data.TaxAmount = &amount
+		data.TaxAmountInt64 = nil
+	}
+
 	for i := range data.Items {
 		if data.Items[i].ItemPriceInt64 != nil && *data.Items[i].ItemPriceInt64 != 0 {
 			price, _ := decimal.New(*data.Items[i].ItemPriceInt64, -2).Float64()
@@ -533,7 +676,5 @@ func FindOrderByByIDAndUserID(orderID, userID int, queryCompletedOrder bool, db
 		}
 	}
 
-	data.RawItems = nil
-
-	return &data, nil
+	return data, nil
 }
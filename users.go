@@ -0,0 +1,67 @@
+package mop_shop
+
+import (
+	"context"
+	"fmt"
+)
+
+// UserResolver tells order queries how to check that a user exists and isn't deleted. Orders are either joined
+// with the users table described by Table and its columns, or, when users live in another service, IsActive is
+// called before orders are queried. The zero value doesn't check users at all.
+type UserResolver struct {
+	Table    string
+	IDColumn string
+	// DeletedAtColumn may be left empty when users aren't soft-deleted
+	DeletedAtColumn string
+	// IsActive takes precedence over Table when set
+	IsActive func(ctx context.Context, userID int) (bool, error)
+}
+
+// DefaultUserResolver joins the users table with soft-deletes on deleted_at. The table belongs to the host
+// application and Migrate doesn't create it, it needs at least an id column matching user_orders.user_id and a
+// nullable deleted_at column.
+func DefaultUserResolver() UserResolver {
+	return UserTableResolver("users", "id", "deleted_at")
+}
+
+// UserTableResolver joins orders with the given table. Names are put into queries as they are so they must never
+// come from user input.
+func UserTableResolver(table, idColumn, deletedAtColumn string) UserResolver {
+	return UserResolver{Table: table, IDColumn: idColumn, DeletedAtColumn: deletedAtColumn}
+}
+
+// UserCallbackResolver asks isActive whether the user exists and is active instead of joining a table
+func UserCallbackResolver(isActive func(ctx context.Context, userID int) (bool, error)) UserResolver {
+	return UserResolver{IsActive: isActive}
+}
+
+// joinClause returns the join restricting orders aliased as uo to active users, or an empty string when users
+// aren't checked in SQL
+func (r UserResolver) joinClause() string {
+	if r.IsActive != nil || len(r.Table) == 0 {
+		return ""
+	}
+
+	join := fmt.Sprintf("INNER JOIN %s u ON u.%s = uo.user_id", r.Table, r.IDColumn)
+	if len(r.DeletedAtColumn) > 0 {
+		join += fmt.Sprintf(" AND u.%s IS NULL", r.DeletedAtColumn)
+	}
+
+	return join
+}
+
+// userIsActive reports whether orders of the user may be returned, only IsActive is consulted here since table
+// based resolvers are applied by the repository
+func (s *Shop) userIsActive(ctx context.Context, userID int) (bool, error) {
+	if s.users.IsActive == nil {
+		return true, nil
+	}
+
+	active, err := s.users.IsActive(ctx, userID)
+	if err != nil {
+		s.log(ctx, LevelError, "error while resolving user", "user_id", userID, "error", err)
+		return false, wrapInternal("UserResolver.IsActive", err)
+	}
+
+	return active, nil
+}
@@ -0,0 +1,101 @@
+package mop_shop
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"regexp"
+	"testing"
+)
+
+func TestUserResolver_joinClause(t *testing.T) {
+	tests := []struct {
+		name  string
+		users UserResolver
+		want  string
+	}{
+		{
+			name:  "Default",
+			users: DefaultUserResolver(),
+			want:  "INNER JOIN users u ON u.id = uo.user_id AND u.deleted_at IS NULL",
+		},
+		{
+			name:  "Custom table without soft-deletes",
+			users: UserTableResolver("accounts", "account_id", ""),
+			want:  "INNER JOIN accounts u ON u.account_id = uo.user_id",
+		},
+		{
+			name: "Callback",
+			users: UserCallbackResolver(func(ctx context.Context, userID int) (bool, error) {
+				return true, nil
+			}),
+			want: "",
+		},
+		{
+			name:  "Zero value",
+			users: UserResolver{},
+			want:  "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.users.joinClause())
+		})
+	}
+}
+
+func TestShop_FindOrderByByIDAndUserIDContext_userCallback(t *testing.T) {
+	orderQuery := "SELECT uo.id, uo.total_price, uo.created_at, uo.updated_at, uo.is_completed, uo.shipping_method, uo.shipping_price, uo.shipping_address, uo.billing_address, uo.tax_amount, uo.tax_lines, uo.vat_id, uo.reverse_charge FROM user_orders uo " +
+		"WHERE uo.user_id = ? AND uo.is_completed = ? AND uo.id = ? LIMIT 1"
+	errResolver := errors.New("user service is down")
+
+	tests := []struct {
+		name       string
+		isActive   func(ctx context.Context, userID int) (bool, error)
+		expectMock func(mock sqlmock.Sqlmock)
+		wantErr    error
+	}{
+		{
+			name: "Inactive user",
+			isActive: func(ctx context.Context, userID int) (bool, error) {
+				return false, nil
+			},
+			expectMock: func(mock sqlmock.Sqlmock) {},
+			wantErr:    gorm.ErrRecordNotFound,
+		},
+		{
+			name: "Resolver fails",
+			isActive: func(ctx context.Context, userID int) (bool, error) {
+				return false, errResolver
+			},
+			expectMock: func(mock sqlmock.Sqlmock) {},
+			wantErr:    errResolver,
+		},
+		{
+			name: "Active user is queried without join",
+			isActive: func(ctx context.Context, userID int) (bool, error) {
+				return true, nil
+			},
+			expectMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(orderQuery)).WithArgs(3, true, 8).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "total_price", "created_at", "updated_at", "is_completed"}))
+			},
+			wantErr: gorm.ErrRecordNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database, mock := newGormForTest(t)
+			tt.expectMock(mock)
+
+			shop := NewShop(database, "", WithUserResolver(UserCallbackResolver(tt.isActive)))
+			_, err := shop.FindOrderByByIDAndUserIDContext(context.Background(), 8, 3, true, "eur", "")
+			assert.True(t, errors.Is(err, tt.wantErr), "FindOrderByByIDAndUserIDContext() error = %v, wantErr %v", err, tt.wantErr)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}